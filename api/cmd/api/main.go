@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
@@ -11,6 +12,7 @@ import (
 	"redrawn/internal/app"
 	"redrawn/internal/config"
 	"redrawn/internal/handlers"
+	"redrawn/internal/middleware"
 )
 
 const version = "0.1.0"
@@ -78,9 +80,23 @@ func runServer() error {
 		fuego.WithAddr(fmt.Sprintf(":%d", cfg.API.Port)),
 	)
 
+	// Work out client addresses, from X-Forwarded-For behind trusted proxies
+	fuego.Use(s, middleware.ClientIP(cfg.API.TrustedProxies))
+
+	// Resolve the user of bearer tokens and API keys; requests without a valid one go
+	// through anonymously
+	fuego.Use(s, middleware.AuthMiddleware(cfg.API.JWTSecret, application.SessionService, application.APIKeyService))
+
 	// Register routes
 	registerRoutes(s, application)
 
+	// Relay database notifications to event stream subscribers
+	go func() {
+		if err := application.EventBroker.Run(context.Background()); err != nil {
+			slog.Error("Event broker stopped", "error", err)
+		}
+	}()
+
 	// Run server
 	return s.Run()
 }
@@ -95,6 +111,22 @@ func registerRoutes(s *fuego.Server, a *app.App) {
 		authHandler := handlers.NewAuthHandler(a)
 		authHandler.RegisterRoutes(s)
 
+		// Identity provider sign-in routes
+		oidcHandler := handlers.NewOIDCHandler(a)
+		oidcHandler.RegisterRoutes(s)
+
+		// Session routes
+		sessionHandler := handlers.NewSessionHandler(a)
+		sessionHandler.RegisterRoutes(s)
+
+		// Two-factor authentication routes
+		twoFactorHandler := handlers.NewTwoFactorHandler(a)
+		twoFactorHandler.RegisterRoutes(s)
+
+		// API key routes
+		apiKeyHandler := handlers.NewAPIKeyHandler(a)
+		apiKeyHandler.RegisterRoutes(s)
+
 		// Album routes
 		albumHandler := handlers.NewAlbumHandler(a)
 		albumHandler.RegisterRoutes(s)
@@ -111,6 +143,10 @@ func registerRoutes(s *fuego.Server, a *app.App) {
 		generatedPhotoHandler := handlers.NewGeneratedPhotoHandler(a)
 		generatedPhotoHandler.RegisterRoutes(s)
 
+		// Batch generation routes
+		generationBatchHandler := handlers.NewGenerationBatchHandler(a)
+		generationBatchHandler.RegisterRoutes(s)
+
 		// Credit routes
 		creditHandler := handlers.NewCreditHandler(a)
 		creditHandler.RegisterRoutes(s)
@@ -119,8 +155,16 @@ func registerRoutes(s *fuego.Server, a *app.App) {
 		paymentHandler := handlers.NewPaymentHandler(a)
 		paymentHandler.RegisterRoutes(s)
 
+		// Real-time event stream
+		eventHandler := handlers.NewEventHandler(a)
+		eventHandler.RegisterRoutes(s)
+
 		// Storage routes
 		storageHandler := handlers.NewStorageHandler(a)
 		storageHandler.RegisterRoutes(s)
+
+		// Media routes
+		mediaHandler := handlers.NewMediaHandler(a)
+		mediaHandler.RegisterRoutes(s)
 	}
 }
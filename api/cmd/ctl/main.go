@@ -0,0 +1,139 @@
+// Command ctl runs one-off maintenance tasks against the database and object storage.
+//
+// Usage:
+//
+//	ctl migrate-storage-keys [-dry-run]
+//	ctl reconcile-storage [-delete] [-grace 72h]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"redrawn/internal/app"
+	"redrawn/internal/config"
+	"redrawn/internal/services"
+)
+
+const usage = `Usage: ctl <command> [flags]
+
+Commands:
+  migrate-storage-keys   Move objects stored under legacy keys to the per-user layout
+  reconcile-storage      Report (or delete) orphaned objects and flag rows whose object is missing
+`
+
+func main() {
+	// Load .env file if present
+	_ = godotenv.Load("../.env")
+
+	// Setup logger
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+	slog.SetDefault(logger)
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := run(os.Args[1], os.Args[2:]); err != nil {
+		slog.Error("Command failed", "command", os.Args[1], "error", err)
+		os.Exit(1)
+	}
+}
+
+func run(command string, args []string) error {
+	var runCommand func(ctx context.Context, application *app.App) error
+	switch command {
+	case "migrate-storage-keys":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		dryRun := flags.Bool("dry-run", false, "log the objects that would be moved without moving them")
+		_ = flags.Parse(args)
+		runCommand = func(ctx context.Context, application *app.App) error {
+			return migrateStorageKeys(ctx, application, *dryRun)
+		}
+	case "reconcile-storage":
+		flags := flag.NewFlagSet(command, flag.ExitOnError)
+		deleteOrphans := flags.Bool("delete", false, "delete orphaned objects instead of only reporting them")
+		grace := flags.Duration("grace", 0, "only treat objects older than this as orphans (default STORAGE_ORPHAN_GRACE_PERIOD)")
+		_ = flags.Parse(args)
+		runCommand = func(ctx context.Context, application *app.App) error {
+			opts := services.ReconcileOptions{
+				GracePeriod:   application.Config.Storage.OrphanGracePeriod,
+				DeleteOrphans: *deleteOrphans,
+			}
+			if *grace > 0 {
+				opts.GracePeriod = *grace
+			}
+			return reconcileStorage(ctx, application, opts)
+		}
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		return fmt.Errorf("unknown command %q", command)
+	}
+
+	// Load config
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Create app
+	application, err := app.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create app: %w", err)
+	}
+	defer application.Close()
+
+	// Stop between objects on SIGINT/SIGTERM; every command can be re-run
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return runCommand(ctx, application)
+}
+
+func migrateStorageKeys(ctx context.Context, application *app.App, dryRun bool) error {
+	report, err := application.StorageMaintenance.MigrateKeyLayout(ctx, dryRun)
+	if report != nil {
+		slog.Info("Storage key migration finished",
+			"dry_run", dryRun,
+			"photos", report.Photos,
+			"generated_photos", report.GeneratedPhotos,
+			"derivatives", report.Derivatives,
+			"missing", report.Missing,
+			"failed", report.Failed,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Failed > 0 {
+		return fmt.Errorf("%d objects could not be moved", report.Failed)
+	}
+	return nil
+}
+
+func reconcileStorage(ctx context.Context, application *app.App, opts services.ReconcileOptions) error {
+	report, err := application.StorageMaintenance.Reconcile(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Storage reconciliation finished",
+		"grace_period", opts.GracePeriod,
+		"objects", report.Objects,
+		"orphans", report.Orphans,
+		"orphan_bytes", report.OrphanBytes,
+		"deleted", report.Deleted,
+		"missing_photos", report.MissingPhotos,
+		"missing_generated_photos", report.MissingGeneratedPhotos,
+	)
+	return nil
+}
@@ -0,0 +1,298 @@
+// Command mockoidc is an OpenID Connect issuer for local development. Its sign-in page
+// asks for the email and name to sign in as, so social login can be tried without
+// registering an app with a real provider. Keys and codes only live in memory.
+//
+// Usage:
+//
+//	mockoidc [-addr :9400] [-issuer http://localhost:9400] [-client-id redrawn]
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"html/template"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mockoidc"
+
+// authorization is a code issued to a client, waiting to be exchanged
+type authorization struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+	email         string
+	name          string
+	emailVerified bool
+	expiresAt     time.Time
+}
+
+type issuer struct {
+	url      string
+	clientID string
+	key      *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]authorization
+	tokens map[string]authorization // access tokens, for the userinfo endpoint
+}
+
+func main() {
+	addr := flag.String("addr", ":9400", "address to listen on")
+	issuerURL := flag.String("issuer", "http://localhost:9400", "issuer URL, as clients reach it")
+	clientID := flag.String("client-id", "redrawn", "the only client ID accepted; any client secret is")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		slog.Error("Failed to generate signing key", "error", err)
+		os.Exit(1)
+	}
+
+	iss := &issuer{
+		url:      strings.TrimSuffix(*issuerURL, "/"),
+		clientID: *clientID,
+		key:      key,
+		codes:    make(map[string]authorization),
+		tokens:   make(map[string]authorization),
+	}
+
+	slog.Info("Mock OIDC issuer listening", "addr", *addr, "issuer", iss.url, "client_id", iss.clientID)
+	if err := http.ListenAndServe(*addr, iss.routes()); err != nil {
+		slog.Error("Server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func (iss *issuer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", iss.discovery)
+	mux.HandleFunc("GET /jwks", iss.jwks)
+	mux.HandleFunc("GET /authorize", iss.authorizeForm)
+	mux.HandleFunc("POST /authorize", iss.authorize)
+	mux.HandleFunc("POST /token", iss.token)
+	mux.HandleFunc("GET /userinfo", iss.userinfo)
+	return mux
+}
+
+func (iss *issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss.url,
+		"authorization_endpoint":                iss.url + "/authorize",
+		"token_endpoint":                        iss.url + "/token",
+		"userinfo_endpoint":                     iss.url + "/userinfo",
+		"jwks_uri":                              iss.url + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *issuer) jwks(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+var signInPage = template.Must(template.New("signin").Parse(`<!doctype html>
+<title>Mock OIDC sign-in</title>
+<h1>Sign in to the mock issuer</h1>
+<form method="post">
+  {{range $k, $v := .Params}}<input type="hidden" name="{{$k}}" value="{{index $v 0}}">
+  {{end}}<p><label>Email <input name="email" type="email" value="user@example.com" required></label></p>
+  <p><label>Name <input name="name" value="Mock User"></label></p>
+  <p><label><input name="email_verified" type="checkbox" value="true" checked> Email verified</label></p>
+  <p><button>Sign in</button> <button name="deny" value="true">Deny</button></p>
+</form>`))
+
+// authorizeForm shows the sign-in page after checking the authorization request
+func (iss *issuer) authorizeForm(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if msg := iss.checkAuthorizationRequest(q); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	_ = signInPage.Execute(w, map[string]any{"Params": q})
+}
+
+// authorize issues a code for the user entered on the sign-in page and redirects back
+func (iss *issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	form := r.PostForm
+	if msg := iss.checkAuthorizationRequest(form); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	redirect, _ := url.Parse(form.Get("redirect_uri"))
+	params := redirect.Query()
+	params.Set("state", form.Get("state"))
+	if form.Get("deny") == "true" {
+		params.Set("error", "access_denied")
+	} else {
+		code := randomToken()
+		iss.mu.Lock()
+		iss.codes[code] = authorization{
+			clientID:      form.Get("client_id"),
+			redirectURI:   form.Get("redirect_uri"),
+			codeChallenge: form.Get("code_challenge"),
+			nonce:         form.Get("nonce"),
+			email:         form.Get("email"),
+			name:          form.Get("name"),
+			emailVerified: form.Get("email_verified") == "true",
+			expiresAt:     time.Now().Add(time.Minute),
+		}
+		iss.mu.Unlock()
+		params.Set("code", code)
+	}
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// checkAuthorizationRequest describes what's wrong with an authorization request, if anything
+func (iss *issuer) checkAuthorizationRequest(params url.Values) string {
+	switch {
+	case params.Get("response_type") != "code":
+		return "response_type must be code"
+	case params.Get("client_id") != iss.clientID:
+		return "unknown client_id"
+	case params.Get("redirect_uri") == "":
+		return "redirect_uri is required"
+	case params.Get("code_challenge") == "" || params.Get("code_challenge_method") != "S256":
+		return "a PKCE S256 code_challenge is required"
+	}
+	if _, err := url.Parse(params.Get("redirect_uri")); err != nil {
+		return "invalid redirect_uri"
+	}
+	return ""
+}
+
+// token exchanges a code for an ID token and access token
+func (iss *issuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, "invalid_request", err.Error())
+		return
+	}
+	form := r.PostForm
+	if form.Get("grant_type") != "authorization_code" {
+		tokenError(w, "unsupported_grant_type", "only authorization_code is supported")
+		return
+	}
+
+	code := form.Get("code")
+	iss.mu.Lock()
+	auth, ok := iss.codes[code]
+	delete(iss.codes, code)
+	iss.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(form.Get("code_verifier")))
+	switch {
+	case !ok || time.Now().After(auth.expiresAt):
+		tokenError(w, "invalid_grant", "unknown or expired code")
+		return
+	case form.Get("client_id") != auth.clientID || form.Get("redirect_uri") != auth.redirectURI:
+		tokenError(w, "invalid_grant", "client_id or redirect_uri doesn't match the authorization request")
+		return
+	case base64.RawURLEncoding.EncodeToString(verifier[:]) != auth.codeChallenge:
+		tokenError(w, "invalid_grant", "code_verifier doesn't match the code_challenge")
+		return
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            iss.url,
+		"sub":            subject(auth.email),
+		"aud":            auth.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+	})
+	idToken.Header["kid"] = keyID
+	signed, err := idToken.SignedString(iss.key)
+	if err != nil {
+		tokenError(w, "server_error", err.Error())
+		return
+	}
+
+	accessToken := randomToken()
+	iss.mu.Lock()
+	iss.tokens[accessToken] = auth
+	iss.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     signed,
+	})
+}
+
+func (iss *issuer) userinfo(w http.ResponseWriter, r *http.Request) {
+	iss.mu.Lock()
+	auth, ok := iss.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")]
+	iss.mu.Unlock()
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sub":            subject(auth.email),
+		"email":          auth.email,
+		"email_verified": auth.emailVerified,
+		"name":           auth.name,
+	})
+}
+
+// subject derives a stable user ID from an email, so signing in with the same address
+// again is the same provider account
+func subject(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(email)))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+func randomToken() string {
+	b := make([]byte, 24)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
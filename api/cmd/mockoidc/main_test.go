@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "redrawn"
+	testRedirectURI = "http://app.test/auth/callback"
+	testVerifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+func newTestIssuer(t *testing.T) (*issuer, *httptest.Server) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &issuer{
+		clientID: testClientID,
+		key:      key,
+		codes:    make(map[string]authorization),
+		tokens:   make(map[string]authorization),
+	}
+	srv := httptest.NewServer(iss.routes())
+	t.Cleanup(srv.Close)
+	iss.url = srv.URL
+	return iss, srv
+}
+
+// authorizeCode signs in on the issuer's form and returns the code it redirects back with
+func authorizeCode(t *testing.T, srv *httptest.Server, email string) string {
+	t.Helper()
+	challenge := sha256.Sum256([]byte(testVerifier))
+	form := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {testClientID},
+		"redirect_uri":          {testRedirectURI},
+		"state":                 {"state-1"},
+		"nonce":                 {"nonce-1"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+		"email":                 {email},
+		"name":                  {"Ada"},
+		"email_verified":        {"true"},
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.PostForm(srv.URL+"/authorize", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := location.Query().Get("state"); got != "state-1" {
+		t.Fatalf("redirect state = %q, want state-1", got)
+	}
+	return location.Query().Get("code")
+}
+
+func exchange(t *testing.T, srv *httptest.Server, code, verifier, redirectURI string) (int, map[string]any) {
+	t.Helper()
+	resp, err := http.PostForm(srv.URL+"/token", url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"code_verifier": {verifier},
+		"client_id":     {testClientID},
+		"redirect_uri":  {redirectURI},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	iss, srv := newTestIssuer(t)
+	code := authorizeCode(t, srv, "Ada@Example.com")
+
+	status, body := exchange(t, srv, code, testVerifier, testRedirectURI)
+	if status != http.StatusOK {
+		t.Fatalf("token: status %d, body %v", status, body)
+	}
+
+	idToken, _ := body["id_token"].(string)
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(*jwt.Token) (any, error) { return &iss.key.PublicKey, nil },
+		jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(iss.url), jwt.WithAudience(testClientID))
+	if err != nil {
+		t.Fatalf("ID token doesn't verify: %v", err)
+	}
+	for name, want := range map[string]any{
+		"sub":            subject("ada@example.com"),
+		"nonce":          "nonce-1",
+		"email":          "Ada@Example.com",
+		"email_verified": true,
+	} {
+		if claims[name] != want {
+			t.Errorf("claim %s = %v, want %v", name, claims[name], want)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/userinfo", nil)
+	req.Header.Set("Authorization", "Bearer "+body["access_token"].(string))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("userinfo: status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestTokenRejectsBadExchanges(t *testing.T) {
+	_, srv := newTestIssuer(t)
+
+	tests := []struct {
+		name        string
+		code        func(code string) string
+		verifier    string
+		redirectURI string
+	}{
+		{name: "wrong verifier", verifier: strings.Repeat("a", 43), redirectURI: testRedirectURI},
+		{name: "other redirect URI", verifier: testVerifier, redirectURI: "http://evil.test/callback"},
+		{name: "unknown code", code: func(string) string { return "nope" }, verifier: testVerifier, redirectURI: testRedirectURI},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code := authorizeCode(t, srv, "ada@example.com")
+			if tt.code != nil {
+				code = tt.code(code)
+			}
+			status, body := exchange(t, srv, code, tt.verifier, tt.redirectURI)
+			if status != http.StatusBadRequest || body["error"] != "invalid_grant" {
+				t.Errorf("token: status %d, body %v, want invalid_grant", status, body)
+			}
+		})
+	}
+
+	t.Run("code used twice", func(t *testing.T) {
+		code := authorizeCode(t, srv, "ada@example.com")
+		if status, body := exchange(t, srv, code, testVerifier, testRedirectURI); status != http.StatusOK {
+			t.Fatalf("first exchange: status %d, body %v", status, body)
+		}
+		if status, body := exchange(t, srv, code, testVerifier, testRedirectURI); body["error"] != "invalid_grant" {
+			t.Errorf("second exchange: status %d, body %v, want invalid_grant", status, body)
+		}
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/joho/godotenv"
+	"redrawn/internal/app"
+	"redrawn/internal/config"
+	"redrawn/internal/worker"
+)
+
+func main() {
+	// Load .env file if present
+	_ = godotenv.Load("../.env")
+
+	// Setup logger
+	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+		Level: slog.LevelInfo,
+	}))
+	slog.SetDefault(logger)
+
+	if err := run(); err != nil {
+		slog.Error("Worker failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	// Load config
+	cfg, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Create app
+	application, err := app.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create app: %w", err)
+	}
+	defer application.Close()
+
+	// Stop claiming new jobs on SIGINT/SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return worker.New(application).Run(ctx)
+}
@@ -14,18 +14,35 @@ import (
 
 // App holds application-wide dependencies
 type App struct {
-	Config                *config.Config
-	DB                    *sql.DB
-	Logger                *slog.Logger
-	UserService           *services.UserService
-	AuthService           *services.AuthService
-	AlbumService          *services.AlbumService
-	PhotoService          *services.PhotoService
-	ThemeService          *services.ThemeService
-	GeneratedPhotoService *services.GeneratedPhotoService
-	CreditService         *services.CreditService
-	PaymentService        *services.PaymentService
-	StorageService        *services.StorageService
+	Config                 *config.Config
+	DB                     *sql.DB
+	Logger                 *slog.Logger
+	UserService            *services.UserService
+	AuthService            *services.AuthService
+	SessionService         *services.SessionService
+	TwoFactorService       *services.TwoFactorService
+	RateLimiter            *services.RateLimiter
+	LoginLockoutService    *services.LoginLockoutService
+	AccountService         *services.AccountService
+	OIDCService            *services.OIDCService
+	APIKeyService          *services.APIKeyService
+	Mailer                 services.Mailer
+	AlbumService           *services.AlbumService
+	PhotoService           *services.PhotoService
+	UploadService          *services.UploadService
+	UsageService           *services.UsageService
+	ThemeService           *services.ThemeService
+	GeneratedPhotoService  *services.GeneratedPhotoService
+	GenerationBatchService *services.GenerationBatchService
+	CreditService          *services.CreditService
+	PaymentService         *services.PaymentService
+	Storage                services.Storage
+	DerivativeService      *services.DerivativeService
+	MediaService           *services.MediaService
+	TransformService       *services.TransformService
+	StorageMaintenance     *services.StorageMaintenanceService
+	ImageGenerator         services.ImageGenerator
+	EventBroker            *services.EventBroker
 }
 
 // New creates a new App instance
@@ -45,49 +62,213 @@ func New(cfg *config.Config) (*App, error) {
 
 	logger.Info("Connected to database")
 
+	// Initialize object storage
+	storage, err := newStorage(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage: %w", err)
+	}
+
 	// Initialize services
 	userService := services.NewUserService(db)
-	authService := services.NewAuthService(userService, cfg.API.JWTSecret)
+	sessionService := services.NewSessionService(db, cfg.Auth.RefreshTokenTTL)
+	twoFactorService := services.NewTwoFactorService(db, cfg.Auth.TOTPIssuer)
+	mailer, err := newMailer(cfg, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mailer: %w", err)
+	}
+	accountService := services.NewAccountService(userService, sessionService, services.NewAccountTokenService(db), mailer,
+		cfg.API.FrontendURL, cfg.Auth.PasswordResetTTL, cfg.Auth.EmailVerificationTTL, cfg.Auth.UnlockTTL)
+	rateLimitStore, err := newRateLimitStore(cfg, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rate limit store: %w", err)
+	}
+	rateLimiter := services.NewRateLimiter(rateLimitStore, rateLimitGroups(cfg))
+	lockouts := services.NewLoginLockoutService(db, accountService,
+		cfg.Auth.LockoutThreshold, cfg.Auth.LockoutDuration, cfg.Auth.LockoutMaxDuration)
+	authService := services.NewAuthService(userService, sessionService, twoFactorService, lockouts, rateLimiter,
+		cfg.API.JWTSecret, cfg.Auth.AccessTokenTTL, cfg.Auth.MFAChallengeTTL, cfg.AdminUserIDs)
+	oidcService, err := services.NewOIDCService(db, userService, sessionService, oidcProviders(cfg),
+		cfg.OIDC.RedirectURL, cfg.OIDC.StateTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OIDC service: %w", err)
+	}
 	albumService := services.NewAlbumService(db)
-	photoService := services.NewPhotoService(db)
+	usageService := services.NewUsageService(db, quotaTiers(cfg))
+	uploadService := services.NewUploadService(db, storage, usageService)
+	photoService := services.NewPhotoService(db, uploadService)
 	themeService := services.NewThemeService(db)
-	generatedPhotoService := services.NewGeneratedPhotoService(db)
 	creditService := services.NewCreditService(db)
+	generatedPhotoService := services.NewGeneratedPhotoService(db, creditService, cfg.Generation.CreditCost)
+	generationBatchService := services.NewGenerationBatchService(db, themeService, generatedPhotoService, creditService)
 	paymentService := services.NewPaymentService(cfg.Stripe.SecretKey, cfg.Stripe.WebhookSecret, creditService)
-	
-	// Initialize storage service
-	storageService, err := services.NewStorageService(
-		cfg.Storage.Endpoint,
-		cfg.Storage.AccessKey,
-		cfg.Storage.SecretKey,
-		cfg.Storage.Bucket,
-		cfg.Storage.UseSSL,
-	)
+
+	// Initialize image generator
+	imageGenerator, err := newImageGenerator(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create storage service: %w", err)
-	}
-	
-	// Ensure bucket exists
-	if err := storageService.EnsureBucket(context.Background()); err != nil {
-		logger.Warn("Failed to ensure storage bucket exists", "error", err)
+		return nil, err
 	}
 
 	return &App{
-		Config:                cfg,
-		DB:                    db,
-		Logger:                logger,
-		UserService:           userService,
-		AuthService:           authService,
-		AlbumService:          albumService,
-		PhotoService:          photoService,
-		ThemeService:          themeService,
-		GeneratedPhotoService: generatedPhotoService,
-		CreditService:         creditService,
-		PaymentService:        paymentService,
-		StorageService:        storageService,
+		Config:                 cfg,
+		DB:                     db,
+		Logger:                 logger,
+		UserService:            userService,
+		AuthService:            authService,
+		SessionService:         sessionService,
+		TwoFactorService:       twoFactorService,
+		RateLimiter:            rateLimiter,
+		LoginLockoutService:    lockouts,
+		AccountService:         accountService,
+		OIDCService:            oidcService,
+		APIKeyService:          services.NewAPIKeyService(db),
+		Mailer:                 mailer,
+		AlbumService:           albumService,
+		PhotoService:           photoService,
+		UploadService:          uploadService,
+		UsageService:           usageService,
+		ThemeService:           themeService,
+		GeneratedPhotoService:  generatedPhotoService,
+		GenerationBatchService: generationBatchService,
+		CreditService:          creditService,
+		PaymentService:         paymentService,
+		Storage:                storage,
+		DerivativeService:      services.NewDerivativeService(db, storage),
+		MediaService:           services.NewMediaService(db),
+		TransformService:       newTransformService(cfg, storage),
+		StorageMaintenance:     services.NewStorageMaintenanceService(db, storage, logger),
+		ImageGenerator:         imageGenerator,
+		EventBroker:            services.NewEventBroker(cfg.Database.URL, logger),
 	}, nil
 }
 
+// quotaTiers converts the configured storage quota tiers
+func quotaTiers(cfg *config.Config) []services.QuotaTier {
+	tiers := make([]services.QuotaTier, len(cfg.Quota.Tiers))
+	for i, t := range cfg.Quota.Tiers {
+		tiers[i] = services.QuotaTier{
+			Name:                t.Name,
+			MinCreditsPurchased: t.MinCreditsPurchased,
+			QuotaBytes:          t.QuotaBytes,
+		}
+	}
+	return tiers
+}
+
+// rateLimitGroups converts the configured rate limits of each route group
+func rateLimitGroups(cfg *config.Config) map[string]services.RateLimitGroup {
+	group := func(g config.RateLimitGroupConfig) services.RateLimitGroup {
+		return services.RateLimitGroup{
+			PerIP:      services.RateLimit{Requests: g.PerIP.Requests, Period: g.PerIP.Period},
+			PerAccount: services.RateLimit{Requests: g.PerAccount.Requests, Period: g.PerAccount.Period},
+		}
+	}
+	return map[string]services.RateLimitGroup{
+		services.RateLimitAuth:       group(cfg.RateLimit.Auth),
+		services.RateLimitGeneration: group(cfg.RateLimit.Generation),
+		services.RateLimitUploads:    group(cfg.RateLimit.Uploads),
+	}
+}
+
+// oidcProviders converts the configured identity providers
+func oidcProviders(cfg *config.Config) []services.OIDCProviderConfig {
+	providers := make([]services.OIDCProviderConfig, len(cfg.OIDC.Providers))
+	for i, p := range cfg.OIDC.Providers {
+		providers[i] = services.OIDCProviderConfig{
+			Name:         p.Name,
+			Kind:         p.Kind,
+			Issuer:       p.Issuer,
+			ClientID:     p.ClientID,
+			ClientSecret: p.ClientSecret,
+			Scopes:       p.Scopes,
+		}
+	}
+	return providers
+}
+
+// newTransformService creates the image transform service. Transform URLs are signed with
+// the same secret as storage URLs unless one is set for them.
+func newTransformService(cfg *config.Config, storage services.Storage) *services.TransformService {
+	secret := cfg.Transforms.SigningSecret
+	if secret == "" {
+		secret = cfg.Storage.SigningSecret
+	}
+	if secret == "" {
+		secret = cfg.API.JWTSecret
+	}
+	return services.NewTransformService(storage, cfg.API.BaseURL, []byte(secret),
+		cfg.Transforms.MaxDimension, cfg.Ingestion.MaxPixels)
+}
+
+// newImageGenerator selects the image generation provider from config
+func newImageGenerator(cfg *config.Config) (services.ImageGenerator, error) {
+	switch cfg.Generation.Provider {
+	case "openai":
+		return services.NewOpenAIImageGenerator(cfg.OpenAI.APIKey, cfg.OpenAI.ImageModel, cfg.OpenAI.ImageSize), nil
+	case "fake":
+		return services.NewFakeImageGenerator(), nil
+	default:
+		return nil, fmt.Errorf("unknown image generator %q", cfg.Generation.Provider)
+	}
+}
+
+// newRateLimitStore selects where rate limit buckets are kept from config
+func newRateLimitStore(cfg *config.Config, db *sql.DB) (services.RateLimitStore, error) {
+	switch cfg.RateLimit.Store {
+	case "memory":
+		return services.NewMemoryRateLimitStore(), nil
+	case "postgres":
+		return services.NewPostgresRateLimitStore(db), nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", cfg.RateLimit.Store)
+	}
+}
+
+// newMailer selects how emails are sent from config
+func newMailer(cfg *config.Config, logger *slog.Logger) (services.Mailer, error) {
+	switch cfg.Mail.Backend {
+	case "smtp":
+		return services.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort, cfg.Mail.SMTPUsername, cfg.Mail.SMTPPassword, cfg.Mail.From), nil
+	case "log":
+		return services.NewLogMailer(cfg.Mail.SinkPath, cfg.Mail.From, logger)
+	default:
+		return nil, fmt.Errorf("unknown mail backend %q", cfg.Mail.Backend)
+	}
+}
+
+// newStorage selects the object storage backend from config
+func newStorage(cfg *config.Config, logger *slog.Logger) (services.Storage, error) {
+	secret := cfg.Storage.SigningSecret
+	if secret == "" {
+		secret = cfg.API.JWTSecret
+	}
+
+	switch cfg.Storage.Backend {
+	case "s3":
+		storage, err := services.NewS3Storage(
+			cfg.Storage.Endpoint,
+			cfg.Storage.AccessKey,
+			cfg.Storage.SecretKey,
+			cfg.Storage.Bucket,
+			cfg.Storage.UseSSL,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Ensure bucket exists
+		if err := storage.EnsureBucket(context.Background()); err != nil {
+			logger.Warn("Failed to ensure storage bucket exists", "error", err)
+		}
+		return storage, nil
+	case "local":
+		return services.NewLocalStorage(cfg.Storage.LocalPath, cfg.API.BaseURL, []byte(secret))
+	case "memory":
+		return services.NewMemoryStorage(cfg.API.BaseURL, []byte(secret)), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage.Backend)
+	}
+}
+
 // Close cleans up resources
 func (a *App) Close() error {
 	if a.DB != nil {
@@ -23,62 +23,52 @@ func NewAlbumHandler(a *app.App) *AlbumHandler {
 // RegisterRoutes registers album routes
 func (h *AlbumHandler) RegisterRoutes(s *fuego.Server) {
 	// Album CRUD
-	fuego.Get(s, "/albums", h.List,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("listAlbums"),
-		fuego.OptionDescription("List all albums for the current user"),
-	)
-	fuego.Post(s, "/albums", h.Create,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("createAlbum"),
-		fuego.OptionDescription("Create a new album"),
-	)
-	fuego.Get(s, "/albums/{id}", h.Get,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("getAlbum"),
-		fuego.OptionDescription("Get an album by ID"),
-	)
-	fuego.Put(s, "/albums/{id}", h.Update,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("updateAlbum"),
-		fuego.OptionDescription("Update an album"),
-	)
-	fuego.Delete(s, "/albums/{id}", h.Delete,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("deleteAlbum"),
-		fuego.OptionDescription("Delete an album"),
-	)
+	fuego.Get(s, "/albums", h.List, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Albums").
+		OperationID("listAlbums").
+		Description("List all albums for the current user")
+	fuego.Post(s, "/albums", h.Create, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("createAlbum").
+		Description("Create a new album")
+	fuego.Get(s, "/albums/{id}", h.Get, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Albums").
+		OperationID("getAlbum").
+		Description("Get an album by ID")
+	fuego.Put(s, "/albums/{id}", h.Update, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("updateAlbum").
+		Description("Update an album")
+	fuego.Delete(s, "/albums/{id}", h.Delete, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("deleteAlbum").
+		Description("Delete an album")
 
 	// Album actions
-	fuego.Post(s, "/albums/{id}/confirm", h.Confirm,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("confirmAlbum"),
-		fuego.OptionDescription("Confirm a staged album"),
-	)
+	fuego.Post(s, "/albums/{id}/confirm", h.Confirm, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("confirmAlbum").
+		Description("Confirm a staged album")
 
 	// Public album access
-	fuego.Get(s, "/public/albums/{slug}", h.GetBySlug,
-		fuego.OptionTags("Public"),
-		fuego.OptionOperationID("getPublicAlbum"),
-		fuego.OptionDescription("Get a public album by slug"),
-	)
+	fuego.Get(s, "/public/albums/{slug}", h.GetBySlug).
+		Tags("Public").
+		OperationID("getPublicAlbum").
+		Description("Get a public album by slug")
 
 	// Album members
-	fuego.Get(s, "/albums/{id}/members", h.ListMembers,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("listAlbumMembers"),
-		fuego.OptionDescription("List album members"),
-	)
-	fuego.Post(s, "/albums/{id}/members", h.AddMember,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("addAlbumMember"),
-		fuego.OptionDescription("Add a member to an album"),
-	)
-	fuego.Delete(s, "/albums/{id}/members/{userID}", h.RemoveMember,
-		fuego.OptionTags("Albums"),
-		fuego.OptionOperationID("removeAlbumMember"),
-		fuego.OptionDescription("Remove a member from an album"),
-	)
+	fuego.Get(s, "/albums/{id}/members", h.ListMembers, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Albums").
+		OperationID("listAlbumMembers").
+		Description("List album members")
+	fuego.Post(s, "/albums/{id}/members", h.AddMember, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("addAlbumMember").
+		Description("Add a member to an album")
+	fuego.Delete(s, "/albums/{id}/members/{userID}", h.RemoveMember, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Albums").
+		OperationID("removeAlbumMember").
+		Description("Remove a member from an album")
 }
 
 // ListAlbumsResponse is the response for listing albums
@@ -103,10 +93,11 @@ func (h *AlbumHandler) List(c *fuego.ContextNoBody) (ListAlbumsResponse, error)
 
 // CreateAlbumRequest is the request for creating an album
 type CreateAlbumRequest struct {
-	Name        string  `json:"name" validate:"required"`
-	Slug        *string `json:"slug,omitempty"`
-	Description *string `json:"description,omitempty"`
-	IsPublic    bool    `json:"is_public"`
+	Name         string  `json:"name" validate:"required"`
+	Slug         *string `json:"slug,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	IsPublic     bool    `json:"is_public"`
+	KeepLocation bool    `json:"keep_location"` // keep photos' GPS position, shown to members; images never carry it
 }
 
 // CreateAlbumResponse is the response for creating an album
@@ -127,11 +118,12 @@ func (h *AlbumHandler) Create(c *fuego.ContextWithBody[CreateAlbumRequest]) (Cre
 	}
 
 	album, err := h.app.AlbumService.Create(c.Context(), services.CreateAlbumInput{
-		UserID:      userID,
-		Name:        input.Name,
-		Slug:        input.Slug,
-		Description: input.Description,
-		IsPublic:    input.IsPublic,
+		UserID:       userID,
+		Name:         input.Name,
+		Slug:         input.Slug,
+		Description:  input.Description,
+		IsPublic:     input.IsPublic,
+		KeepLocation: input.KeepLocation,
 	})
 	if err != nil {
 		return CreateAlbumResponse{}, err
@@ -169,10 +161,11 @@ func (h *AlbumHandler) Get(c *fuego.ContextNoBody) (GetAlbumResponse, error) {
 
 // UpdateAlbumRequest is the request for updating an album
 type UpdateAlbumRequest struct {
-	Name        *string `json:"name,omitempty"`
-	Slug        *string `json:"slug,omitempty"`
-	Description *string `json:"description,omitempty"`
-	IsPublic    *bool   `json:"is_public,omitempty"`
+	Name         *string `json:"name,omitempty"`
+	Slug         *string `json:"slug,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	IsPublic     *bool   `json:"is_public,omitempty"`
+	KeepLocation *bool   `json:"keep_location,omitempty"` // turning it off clears the locations already kept
 }
 
 // UpdateAlbumResponse is the response for updating an album
@@ -204,11 +197,12 @@ func (h *AlbumHandler) Update(c *fuego.ContextWithBody[UpdateAlbumRequest]) (Upd
 	}
 
 	album, err := h.app.AlbumService.Update(c.Context(), services.UpdateAlbumInput{
-		ID:          id,
-		Name:        input.Name,
-		Slug:        input.Slug,
-		Description: input.Description,
-		IsPublic:    input.IsPublic,
+		ID:           id,
+		Name:         input.Name,
+		Slug:         input.Slug,
+		Description:  input.Description,
+		IsPublic:     input.IsPublic,
+		KeepLocation: input.KeepLocation,
 	})
 	if err != nil {
 		return UpdateAlbumResponse{}, err
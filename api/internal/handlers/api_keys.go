@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/services"
+)
+
+// APIKeyHandler handles the current user's API keys. The routes only accept sessions, so
+// an API key can't create or revoke keys.
+type APIKeyHandler struct {
+	app *app.App
+}
+
+// NewAPIKeyHandler creates a new APIKeyHandler
+func NewAPIKeyHandler(a *app.App) *APIKeyHandler {
+	return &APIKeyHandler{app: a}
+}
+
+// RegisterRoutes registers API key routes
+func (h *APIKeyHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.Get(s, "/me/api-keys", h.List).
+		Tags("Auth").
+		OperationID("listAPIKeys").
+		Description("List the current user's API keys, including expired ones, and the scopes keys can be granted")
+	fuego.Post(s, "/me/api-keys", h.Create).
+		Tags("Auth").
+		OperationID("createAPIKey").
+		Description("Create an API key for scripts, sent as Authorization: Bearer. " +
+			"It's only returned now; it works on the routes its scopes cover until it expires or is revoked.")
+	fuego.Delete(s, "/me/api-keys/{id}", h.Revoke).
+		Tags("Auth").
+		OperationID("revokeAPIKey").
+		Description("Revoke an API key; it stops working immediately")
+}
+
+// ListAPIKeysResponse is the response for listing API keys
+type ListAPIKeysResponse struct {
+	APIKeys []services.APIKey `json:"api_keys"`
+	Scopes  []string          `json:"scopes"` // every scope a key can be granted
+}
+
+// List lists the current user's API keys
+func (h *APIKeyHandler) List(c *fuego.ContextNoBody) (ListAPIKeysResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return ListAPIKeysResponse{}, errors.New("unauthorized")
+	}
+
+	keys, err := h.app.APIKeyService.List(c.Context(), userID)
+	if err != nil {
+		return ListAPIKeysResponse{}, err
+	}
+	return ListAPIKeysResponse{APIKeys: keys, Scopes: services.APIKeyScopes}, nil
+}
+
+// CreateAPIKeyResponse is a new API key, with the key itself
+type CreateAPIKeyResponse struct {
+	services.APIKey
+	Key string `json:"key"` // shown once; only a hash is kept
+}
+
+// Create creates an API key for the current user
+func (h *APIKeyHandler) Create(c *fuego.ContextWithBody[services.CreateAPIKeyInput]) (CreateAPIKeyResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return CreateAPIKeyResponse{}, errors.New("unauthorized")
+	}
+
+	input, err := c.Body()
+	if err != nil {
+		return CreateAPIKeyResponse{}, err
+	}
+
+	apiKey, key, err := h.app.APIKeyService.Create(c.Context(), userID, input)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAPIKeyInput) {
+			return CreateAPIKeyResponse{}, fuego.BadRequestError{Detail: err.Error()}
+		}
+		return CreateAPIKeyResponse{}, err
+	}
+	return CreateAPIKeyResponse{APIKey: *apiKey, Key: key}, nil
+}
+
+// Revoke revokes one of the current user's API keys
+func (h *APIKeyHandler) Revoke(c *fuego.ContextNoBody) (any, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return nil, errors.New("unauthorized")
+	}
+
+	if err := h.app.APIKeyService.Revoke(c.Context(), userID, c.PathParam("id")); err != nil {
+		if errors.Is(err, services.ErrAPIKeyNotFound) {
+			return nil, fuego.NotFoundError{Detail: err.Error()}
+		}
+		return nil, err
+	}
+	return map[string]string{"status": "revoked"}, nil
+}
@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"errors"
+	"net/http"
+	"time"
+
 	"github.com/go-fuego/fuego"
 	"redrawn/internal/app"
+	"redrawn/internal/middleware"
 	"redrawn/internal/services"
 )
 
@@ -18,17 +23,55 @@ func NewAuthHandler(a *app.App) *AuthHandler {
 
 // RegisterRoutes registers auth routes
 func (h *AuthHandler) RegisterRoutes(s *fuego.Server) {
-	fuego.Post(s, "/auth/login", h.Login,
-		fuego.OptionTags("Auth"),
-		fuego.OptionOperationID("login"),
-		fuego.OptionDescription("Login with email and password"),
-	)
+	fuego.Post(s, "/auth/login", h.Login, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("login").
+		Description("Login with email and password; failed attempts in a row lock the account for a while and email an unlock link")
+
+	fuego.Post(s, "/auth/login/mfa", h.LoginMFA, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("loginMFA").
+		Description("Finish signing in with the mfa_token from login and a code from an authenticator app or a recovery code")
+
+	fuego.Post(s, "/auth/register", h.Register, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("register").
+		Description("Register a new user")
+
+	fuego.Post(s, "/auth/refresh", h.Refresh, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("refreshToken").
+		Description("Exchange a refresh token for a new access token and refresh token; the one presented stops working")
+
+	fuego.Post(s, "/auth/logout", h.Logout).
+		Tags("Auth").
+		OperationID("logout").
+		Description("End the session of the access token, or of the refresh token in the body")
+
+	fuego.Post(s, "/auth/forgot-password", h.ForgotPassword, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("forgotPassword").
+		Description("Email a password reset link; the response is the same whether or not the address has an account")
+
+	fuego.Post(s, "/auth/reset-password", h.ResetPassword, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("resetPassword").
+		Description("Set a new password with the token from a reset link; every session is signed out")
 
-	fuego.Post(s, "/auth/register", h.Register,
-		fuego.OptionTags("Auth"),
-		fuego.OptionOperationID("register"),
-		fuego.OptionDescription("Register a new user"),
-	)
+	fuego.Post(s, "/auth/verify-email", h.VerifyEmail, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("verifyEmail").
+		Description("Verify the current email address with the token from a verification link")
+
+	fuego.Post(s, "/auth/verify-email/resend", h.ResendVerification, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("resendVerificationEmail").
+		Description("Email the current user a new verification link")
+
+	fuego.Post(s, "/auth/unlock", h.Unlock, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("unlockAccount").
+		Description("Unlock an account locked after failed sign-ins with the token from the emailed unlock link")
 }
 
 // LoginRequest represents a login request
@@ -37,10 +80,32 @@ type LoginRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
-// LoginResponse represents a login response
+// LoginResponse represents a login response. Users with two-factor authentication first
+// get only mfa_required and an mfa_token to send to /auth/login/mfa with a code.
 type LoginResponse struct {
-	Token string         `json:"token"`
-	User  services.User `json:"user"`
+	Token        string         `json:"token,omitempty"` // access token, sent as a bearer token
+	ExpiresAt    time.Time      `json:"expires_at"`      // of the access token, or of the MFA token
+	RefreshToken string         `json:"refresh_token,omitempty"`
+	SessionID    string         `json:"session_id,omitempty"`
+	User         *services.User `json:"user,omitempty"`
+
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"` // admins must enable 2FA to use admin routes
+}
+
+// newLoginResponse converts the tokens issued by the auth service
+func newLoginResponse(resp *services.LoginResponse) LoginResponse {
+	return LoginResponse{
+		Token:                 resp.Token,
+		ExpiresAt:             resp.ExpiresAt,
+		RefreshToken:          resp.RefreshToken,
+		SessionID:             resp.SessionID,
+		User:                  resp.User,
+		MFARequired:           resp.MFARequired,
+		MFAToken:              resp.MFAToken,
+		MFAEnrollmentRequired: resp.MFAEnrollmentRequired,
+	}
 }
 
 // Login handles user login
@@ -53,15 +118,48 @@ func (h *AuthHandler) Login(c *fuego.ContextWithBody[LoginRequest]) (LoginRespon
 	resp, err := h.app.AuthService.Login(c.Context(), services.LoginInput{
 		Email:    input.Email,
 		Password: input.Password,
+		Client:   sessionClient(c.Request()),
 	})
 	if err != nil {
+		var limited *services.RateLimitError
+		if errors.As(err, &limited) {
+			return LoginResponse{}, tooManyRequests(c.Response(), limited)
+		}
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			return LoginResponse{}, fuego.UnauthorizedError{Detail: err.Error()}
+		}
 		return LoginResponse{}, err
 	}
 
-	return LoginResponse{
-		Token: resp.Token,
-		User:  *resp.User,
-	}, nil
+	return newLoginResponse(resp), nil
+}
+
+// LoginMFARequest represents the second step of signing in with two-factor authentication
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required"` // from an authenticator app, or a recovery code
+}
+
+// LoginMFA finishes signing in a user with two-factor authentication
+func (h *AuthHandler) LoginMFA(c *fuego.ContextWithBody[LoginMFARequest]) (LoginResponse, error) {
+	input, err := c.Body()
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	resp, err := h.app.AuthService.CompleteMFA(c.Context(), input.MFAToken, input.Code, sessionClient(c.Request()))
+	if err != nil {
+		var limited *services.RateLimitError
+		if errors.As(err, &limited) {
+			return LoginResponse{}, tooManyRequests(c.Response(), limited)
+		}
+		if errors.Is(err, services.ErrInvalidMFAToken) || errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			return LoginResponse{}, fuego.UnauthorizedError{Detail: err.Error()}
+		}
+		return LoginResponse{}, err
+	}
+
+	return newLoginResponse(resp), nil
 }
 
 // RegisterRequest represents a registration request
@@ -72,10 +170,7 @@ type RegisterRequest struct {
 }
 
 // RegisterResponse represents a registration response
-type RegisterResponse struct {
-	Token string         `json:"token"`
-	User  services.User `json:"user"`
-}
+type RegisterResponse = LoginResponse
 
 // Register handles user registration
 func (h *AuthHandler) Register(c *fuego.ContextWithBody[RegisterRequest]) (RegisterResponse, error) {
@@ -93,17 +188,186 @@ func (h *AuthHandler) Register(c *fuego.ContextWithBody[RegisterRequest]) (Regis
 		return RegisterResponse{}, err
 	}
 
-	// Generate token for the new user
-	loginResp, err := h.app.AuthService.Login(c.Context(), services.LoginInput{
-		Email:    input.Email,
-		Password: input.Password,
-	})
+	// A failed email doesn't fail the registration; the user can ask for another one
+	if err := h.app.AccountService.SendVerificationEmail(c.Context(), user); err != nil {
+		h.app.Logger.Error("Failed to send verification email", "user_id", user.ID, "error", err)
+	}
+
+	// Sign the new user in; the password was just set, so it isn't checked again
+	loginResp, err := h.app.AuthService.SignIn(c.Context(), user, sessionClient(c.Request()))
 	if err != nil {
 		return RegisterResponse{}, err
 	}
 
-	return RegisterResponse{
-		Token: loginResp.Token,
-		User:  *user,
-	}, nil
+	loginResp.User = user
+	return newLoginResponse(loginResp), nil
+}
+
+// RefreshRequest represents a token refresh request
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh rotates a session's refresh token and issues a new access token
+func (h *AuthHandler) Refresh(c *fuego.ContextWithBody[RefreshRequest]) (LoginResponse, error) {
+	input, err := c.Body()
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	resp, err := h.app.AuthService.Refresh(c.Context(), input.RefreshToken, sessionClient(c.Request()))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) {
+			return LoginResponse{}, fuego.UnauthorizedError{Detail: err.Error()}
+		}
+		return LoginResponse{}, err
+	}
+
+	return newLoginResponse(resp), nil
+}
+
+// LogoutRequest represents a logout request. The refresh token is only needed when the
+// access token has already expired.
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// Logout revokes the caller's session
+func (h *AuthHandler) Logout(c *fuego.ContextWithBody[LogoutRequest]) (any, error) {
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	userID := getUserIDFromContext(c.Context())
+	sessionID := middleware.GetSessionIDFromContext(c.Context())
+	switch {
+	case sessionID != "":
+		err = h.app.SessionService.Revoke(c.Context(), userID, sessionID)
+	case input.RefreshToken != "":
+		err = h.app.SessionService.RevokeByRefreshToken(c.Context(), input.RefreshToken)
+	default:
+		return nil, fuego.UnauthorizedError{Detail: "an access token or refresh token is required"}
+	}
+	if err != nil && !errors.Is(err, services.ErrSessionNotFound) {
+		return nil, err
+	}
+
+	return map[string]string{"status": "logged_out"}, nil
+}
+
+// ForgotPasswordRequest represents a request for a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword emails a password reset link
+func (h *AuthHandler) ForgotPassword(c *fuego.ContextWithBody[ForgotPasswordRequest]) (map[string]string, error) {
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.app.AccountService.RequestPasswordReset(c.Context(), input.Email); err != nil {
+		return nil, err
+	}
+	return map[string]string{"status": "sent"}, nil
+}
+
+// ResetPasswordRequest represents a password reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=8"`
+}
+
+// ResetPassword sets a new password from a reset link
+func (h *AuthHandler) ResetPassword(c *fuego.ContextWithBody[ResetPasswordRequest]) (map[string]string, error) {
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.app.AccountService.ResetPassword(c.Context(), input.Token, input.Password); err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			return nil, fuego.BadRequestError{Detail: err.Error()}
+		}
+		return nil, err
+	}
+	return map[string]string{"status": "password_reset"}, nil
+}
+
+// VerifyEmailRequest represents an email verification
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmail verifies an email address from a verification link
+func (h *AuthHandler) VerifyEmail(c *fuego.ContextWithBody[VerifyEmailRequest]) (map[string]string, error) {
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.app.AccountService.VerifyEmail(c.Context(), input.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			return nil, fuego.BadRequestError{Detail: err.Error()}
+		}
+		return nil, err
+	}
+	return map[string]string{"status": "verified"}, nil
+}
+
+// UnlockAccountRequest carries the token of an unlock link
+type UnlockAccountRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// Unlock unlocks an account from an unlock link
+func (h *AuthHandler) Unlock(c *fuego.ContextWithBody[UnlockAccountRequest]) (map[string]string, error) {
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.app.AccountService.UnlockAccount(c.Context(), input.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidAccountToken) {
+			return nil, fuego.BadRequestError{Detail: err.Error()}
+		}
+		return nil, err
+	}
+	return map[string]string{"status": "unlocked"}, nil
+}
+
+// ResendVerification emails the current user a new verification link
+func (h *AuthHandler) ResendVerification(c *fuego.ContextNoBody) (map[string]string, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return nil, errors.New("unauthorized")
+	}
+
+	user, err := h.app.UserService.GetByID(c.Context(), userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerified {
+		return map[string]string{"status": "already_verified"}, nil
+	}
+	if err := h.app.AccountService.SendVerificationEmail(c.Context(), user); err != nil {
+		return nil, err
+	}
+	return map[string]string{"status": "sent"}, nil
+}
+
+// tooManyRequests turns a rate limit error into a 429 telling the client when to retry
+func tooManyRequests(w http.ResponseWriter, err *services.RateLimitError) error {
+	middleware.SetRetryAfter(w, err.RetryAfter)
+	return fuego.HTTPError{Status: http.StatusTooManyRequests, Title: "Too Many Requests", Detail: err.Error()}
+}
+
+// sessionClient describes the device a request comes from, for listing sessions
+func sessionClient(r *http.Request) services.SessionClient {
+	return services.SessionClient{
+		UserAgent: r.UserAgent(),
+		IPAddress: middleware.GetClientIP(r),
+	}
 }
@@ -1,22 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"slices"
 	"strconv"
 
 	"github.com/go-fuego/fuego"
 
 	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
 )
 
-// isAdmin checks if a user ID is in the admin list
-func (h *CreditHandler) isAdmin(userID string) bool {
-	for _, id := range h.app.Config.AdminUserIDs {
-		if id == userID {
-			return true
-		}
+// requireAdmin checks that the current user is in the admin list and has two-factor
+// authentication on
+func (h *CreditHandler) requireAdmin(ctx context.Context) error {
+	userID := getUserIDFromContext(ctx)
+	if userID == "" || !slices.Contains(h.app.Config.AdminUserIDs, userID) {
+		return fuego.ForbiddenError{Detail: "admin access required"}
+	}
+
+	enabled, err := h.app.TwoFactorService.IsEnabled(ctx, userID)
+	if err != nil {
+		return err
 	}
-	return false
+	if !enabled {
+		return fuego.ForbiddenError{Detail: "enroll in two-factor authentication to use admin endpoints"}
+	}
+	return nil
 }
 
 // CreditHandler handles credit-related HTTP requests
@@ -55,8 +68,11 @@ type TransactionHistoryResponse struct {
 }
 
 // GetBalance returns the current user's credit balance
-func (h *CreditHandler) GetBalance(c fuego.ContextNoBody) (CreditResponse, error) {
-	userID := c.Context().Value("user_id").(string)
+func (h *CreditHandler) GetBalance(c *fuego.ContextNoBody) (CreditResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return CreditResponse{}, errors.New("unauthorized")
+	}
 
 	credit, err := h.app.CreditService.GetBalance(c.Context(), userID)
 	if err != nil {
@@ -73,8 +89,11 @@ func (h *CreditHandler) GetBalance(c fuego.ContextNoBody) (CreditResponse, error
 }
 
 // GetTransactionHistory returns the user's credit transaction history
-func (h *CreditHandler) GetTransactionHistory(c fuego.ContextNoBody) (TransactionHistoryResponse, error) {
-	userID := c.Context().Value("user_id").(string)
+func (h *CreditHandler) GetTransactionHistory(c *fuego.ContextNoBody) (TransactionHistoryResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return TransactionHistoryResponse{}, errors.New("unauthorized")
+	}
 
 	// Parse limit parameter
 	limitStr := c.QueryParam("limit")
@@ -118,10 +137,9 @@ type AdminAddCreditsRequest struct {
 }
 
 // AdminAddCredits adds credits to a user (admin only)
-func (h *CreditHandler) AdminAddCredits(c fuego.ContextWithBody[AdminAddCreditsRequest]) (CreditResponse, error) {
-	userID := c.Context().Value("user_id").(string)
-	if !h.isAdmin(userID) {
-		return CreditResponse{}, fuego.ForbiddenError{Detail: "admin access required"}
+func (h *CreditHandler) AdminAddCredits(c *fuego.ContextWithBody[AdminAddCreditsRequest]) (CreditResponse, error) {
+	if err := h.requireAdmin(c.Context()); err != nil {
+		return CreditResponse{}, err
 	}
 
 	targetUserID := c.PathParam("user_id")
@@ -157,10 +175,9 @@ func (h *CreditHandler) AdminAddCredits(c fuego.ContextWithBody[AdminAddCreditsR
 }
 
 // AdminGetUserBalance returns a specific user's balance (admin only)
-func (h *CreditHandler) AdminGetUserBalance(c fuego.ContextNoBody) (CreditResponse, error) {
-	userID := c.Context().Value("user_id").(string)
-	if !h.isAdmin(userID) {
-		return CreditResponse{}, fuego.ForbiddenError{Detail: "admin access required"}
+func (h *CreditHandler) AdminGetUserBalance(c *fuego.ContextNoBody) (CreditResponse, error) {
+	if err := h.requireAdmin(c.Context()); err != nil {
+		return CreditResponse{}, err
 	}
 
 	targetUserID := c.PathParam("user_id")
@@ -183,10 +200,9 @@ func (h *CreditHandler) AdminGetUserBalance(c fuego.ContextNoBody) (CreditRespon
 }
 
 // AdminGetUserTransactions returns a specific user's transaction history (admin only)
-func (h *CreditHandler) AdminGetUserTransactions(c fuego.ContextNoBody) (TransactionHistoryResponse, error) {
-	userID := c.Context().Value("user_id").(string)
-	if !h.isAdmin(userID) {
-		return TransactionHistoryResponse{}, fuego.ForbiddenError{Detail: "admin access required"}
+func (h *CreditHandler) AdminGetUserTransactions(c *fuego.ContextNoBody) (TransactionHistoryResponse, error) {
+	if err := h.requireAdmin(c.Context()); err != nil {
+		return TransactionHistoryResponse{}, err
 	}
 
 	targetUserID := c.PathParam("user_id")
@@ -229,33 +245,28 @@ func (h *CreditHandler) AdminGetUserTransactions(c fuego.ContextNoBody) (Transac
 // RegisterRoutes registers credit routes with the server
 func (h *CreditHandler) RegisterRoutes(s *fuego.Server) {
 	// User routes
-	fuego.Get(s, "/credits/balance", h.GetBalance,
-		fuego.OptionTags("Credits"),
-		fuego.OptionOperationID("get_credit_balance"),
-		fuego.OptionDescription("Get current user's credit balance"),
-	)
-	fuego.Get(s, "/credits/transactions", h.GetTransactionHistory,
-		fuego.OptionTags("Credits"),
-		fuego.OptionOperationID("get_credit_transactions"),
-		fuego.OptionDescription("Get current user's credit transaction history"),
-		fuego.OptionQuery("limit", "Maximum number of transactions to return (default 50, max 100)"),
-	)
+	fuego.Get(s, "/credits/balance", h.GetBalance, middleware.RequireScope(services.ScopeCreditsRead)).
+		Tags("Credits").
+		OperationID("get_credit_balance").
+		Description("Get current user's credit balance")
+	fuego.Get(s, "/credits/transactions", h.GetTransactionHistory, middleware.RequireScope(services.ScopeCreditsRead)).
+		Tags("Credits").
+		OperationID("get_credit_transactions").
+		Description("Get current user's credit transaction history").
+		QueryParam("limit", "Maximum number of transactions to return (default 50, max 100)")
 
 	// Admin routes
-	fuego.Get(s, "/admin/users/{user_id}/credits", h.AdminGetUserBalance,
-		fuego.OptionTags("Admin"),
-		fuego.OptionOperationID("admin_get_user_credits"),
-		fuego.OptionDescription("Get a specific user's credit balance (admin only)"),
-	)
-	fuego.Post(s, "/admin/users/{user_id}/credits", h.AdminAddCredits,
-		fuego.OptionTags("Admin"),
-		fuego.OptionOperationID("admin_add_credits"),
-		fuego.OptionDescription("Add credits to a user (admin only)"),
-	)
-	fuego.Get(s, "/admin/users/{user_id}/credit-transactions", h.AdminGetUserTransactions,
-		fuego.OptionTags("Admin"),
-		fuego.OptionOperationID("admin_get_user_transactions"),
-		fuego.OptionDescription("Get a specific user's transaction history (admin only)"),
-		fuego.OptionQuery("limit", "Maximum number of transactions to return (default 50, max 100)"),
-	)
+	fuego.Get(s, "/admin/users/{user_id}/credits", h.AdminGetUserBalance).
+		Tags("Admin").
+		OperationID("admin_get_user_credits").
+		Description("Get a specific user's credit balance (admin only)")
+	fuego.Post(s, "/admin/users/{user_id}/credits", h.AdminAddCredits).
+		Tags("Admin").
+		OperationID("admin_add_credits").
+		Description("Add credits to a user (admin only)")
+	fuego.Get(s, "/admin/users/{user_id}/credit-transactions", h.AdminGetUserTransactions).
+		Tags("Admin").
+		OperationID("admin_get_user_transactions").
+		Description("Get a specific user's transaction history (admin only)").
+		QueryParam("limit", "Maximum number of transactions to return (default 50, max 100)")
 }
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// eventsKeepAlive is how often a comment is sent on idle streams so proxies keep them open.
+// The stream's session is re-checked at the same interval.
+const eventsKeepAlive = 25 * time.Second
+
+// EventHandler handles the real-time event stream
+type EventHandler struct {
+	app *app.App
+}
+
+// NewEventHandler creates a new EventHandler
+func NewEventHandler(a *app.App) *EventHandler {
+	return &EventHandler{app: a}
+}
+
+// RegisterRoutes registers event routes
+func (h *EventHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.GetStd(s, "/events", h.Stream, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Events").
+		OperationID("streamEvents").
+		Description("Server-Sent Events stream of generation status, photo processing and credit balance changes for the current user")
+}
+
+// Stream writes the current user's events as Server-Sent Events until the client disconnects.
+// Streams opened with a session's access token end once that session is revoked or expires;
+// the client's reconnect then fails authentication instead of receiving events.
+func (h *EventHandler) Stream(w http.ResponseWriter, r *http.Request) {
+	userID := getUserIDFromContext(r.Context())
+	if userID == "" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	sessionID := middleware.GetSessionIDFromContext(r.Context())
+
+	events, unsubscribe := h.app.EventBroker.Subscribe(userID)
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if sessionID != "" {
+				active, err := h.app.SessionService.IsActive(r.Context(), sessionID)
+				if err != nil {
+					h.app.Logger.Warn("Failed to check event stream session", "session_id", sessionID, "error", err)
+					return
+				}
+				if !active {
+					return
+				}
+			}
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event := <-events:
+			data := event.Data
+			if len(data) == 0 {
+				data = []byte("{}")
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-fuego/fuego"
 	"redrawn/internal/app"
+	"redrawn/internal/middleware"
 	"redrawn/internal/services"
 )
 
@@ -21,52 +22,34 @@ func NewGeneratedPhotoHandler(a *app.App) *GeneratedPhotoHandler {
 // RegisterRoutes registers generated photo routes
 func (h *GeneratedPhotoHandler) RegisterRoutes(s *fuego.Server) {
 	// Generated photo CRUD
-	fuego.Get(s, "/generated-photos", h.List,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("listGeneratedPhotos"),
-		fuego.OptionDescription("List all generated photos for the current user"),
-	)
-	fuego.Post(s, "/generated-photos", h.Create,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("createGeneratedPhoto"),
-		fuego.OptionDescription("Queue a new themed photo generation"),
-	)
-	fuego.Get(s, "/generated-photos/{id}", h.Get,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("getGeneratedPhoto"),
-		fuego.OptionDescription("Get a generated photo by ID"),
-	)
-	fuego.Put(s, "/generated-photos/{id}", h.Update,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("updateGeneratedPhoto"),
-		fuego.OptionDescription("Update generated photo metadata"),
-	)
-	fuego.Delete(s, "/generated-photos/{id}", h.Delete,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("deleteGeneratedPhoto"),
-		fuego.OptionDescription("Delete a generated photo"),
-	)
+	fuego.Get(s, "/generated-photos", h.List, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Generated Photos").
+		OperationID("listGeneratedPhotos").
+		Description("List all generated photos for the current user")
+	fuego.Post(s, "/generated-photos", h.Create, middleware.RequireScope(services.ScopeGenerateWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitGeneration)).
+		Tags("Generated Photos").
+		OperationID("createGeneratedPhoto").
+		Description("Queue a new themed photo generation")
+	fuego.Get(s, "/generated-photos/{id}", h.Get, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Generated Photos").
+		OperationID("getGeneratedPhoto").
+		Description("Get a generated photo by ID")
+	fuego.Delete(s, "/generated-photos/{id}", h.Delete, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Generated Photos").
+		OperationID("deleteGeneratedPhoto").
+		Description("Delete a generated photo. Queued generations are refunded; one being processed can only be deleted once it has finished")
 
 	// Original photo specific routes
-	fuego.Get(s, "/photos/{photoID}/generated", h.ListByOriginalPhoto,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("listGeneratedByOriginal"),
-		fuego.OptionDescription("List all generated variants for an original photo"),
-	)
+	fuego.Get(s, "/photos/{photoID}/generated", h.ListByOriginalPhoto, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Generated Photos").
+		OperationID("listGeneratedByOriginal").
+		Description("List all generated variants for an original photo")
 
 	// Theme specific routes
-	fuego.Get(s, "/themes/{themeID}/generated", h.ListByTheme,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("listGeneratedByTheme"),
-		fuego.OptionDescription("List all generated photos using a theme"),
-	)
-
-	// Status management (for background workers)
-	fuego.Post(s, "/generated-photos/{id}/status", h.UpdateStatus,
-		fuego.OptionTags("Generated Photos"),
-		fuego.OptionOperationID("updateGeneratedPhotoStatus"),
-		fuego.OptionDescription("Update generation status (queued/processing/completed/error)"),
-	)
+	fuego.Get(s, "/themes/{themeID}/generated", h.ListByTheme, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Generated Photos").
+		OperationID("listGeneratedByTheme").
+		Description("List all generated photos using a theme")
 }
 
 // ListGeneratedPhotosResponse is the response for listing generated photos
@@ -85,16 +68,18 @@ func (h *GeneratedPhotoHandler) List(c *fuego.ContextNoBody) (ListGeneratedPhoto
 	if err != nil {
 		return ListGeneratedPhotosResponse{}, err
 	}
+	if err := h.app.DerivativeService.AttachGeneratedPhotoVariants(c.Context(), generated); err != nil {
+		return ListGeneratedPhotosResponse{}, err
+	}
 
 	return ListGeneratedPhotosResponse{GeneratedPhotos: generated}, nil
 }
 
 // CreateGeneratedPhotoRequest is the request for creating a generated photo
 type CreateGeneratedPhotoRequest struct {
-	OriginalPhotoID string `json:"original_photo_id" validate:"required"`
-	ThemeID         string `json:"theme_id" validate:"required"`
-	StorageKey      string `json:"storage_key" validate:"required"`
-	CreditsUsed     int    `json:"credits_used,omitempty"`
+	OriginalPhotoID string            `json:"original_photo_id" validate:"required"`
+	ThemeID         string            `json:"theme_id" validate:"required"`
+	Params          map[string]string `json:"params,omitempty"` // values for the theme's prompt parameters
 }
 
 // Create queues a new photo generation
@@ -139,11 +124,25 @@ func (h *GeneratedPhotoHandler) Create(c *fuego.ContextWithBody[CreateGeneratedP
 		return services.GeneratedPhoto{}, errors.New("cannot use private theme")
 	}
 
+	// Render the prompt now so a broken template or bad parameters fail before credits are held
+	album, err := h.app.AlbumService.GetByID(c.Context(), photo.AlbumID)
+	if err != nil {
+		return services.GeneratedPhoto{}, err
+	}
+	prompt, err := h.app.ThemeService.RenderPrompt(theme, services.PromptVariables{
+		Photo:  photo,
+		Album:  album,
+		Params: req.Params,
+	})
+	if err != nil {
+		return services.GeneratedPhoto{}, promptError(err)
+	}
+
 	input := services.CreateGeneratedPhotoInput{
 		OriginalPhotoID: req.OriginalPhotoID,
 		ThemeID:         req.ThemeID,
-		StorageKey:      req.StorageKey,
-		CreditsUsed:     req.CreditsUsed,
+		UserID:          userID,
+		Prompt:          prompt,
 	}
 
 	generated, err := h.app.GeneratedPhotoService.Create(c.Context(), input)
@@ -188,66 +187,12 @@ func (h *GeneratedPhotoHandler) Get(c *fuego.ContextNoBody) (services.GeneratedP
 		return services.GeneratedPhoto{}, errors.New("access denied")
 	}
 
-	return *generated, nil
-}
-
-// UpdateGeneratedPhotoRequest is the request for updating a generated photo
-type UpdateGeneratedPhotoRequest struct {
-	StorageKey   *string `json:"storage_key,omitempty"`
-	Status       *string `json:"status,omitempty"`
-	CreditsUsed  *int    `json:"credits_used,omitempty"`
-	ErrorMessage *string `json:"error_message,omitempty"`
-}
-
-// Update updates a generated photo
-func (h *GeneratedPhotoHandler) Update(c *fuego.ContextWithBody[UpdateGeneratedPhotoRequest]) (services.GeneratedPhoto, error) {
-	userID := getUserIDFromContext(c.Context())
-	if userID == "" {
-		return services.GeneratedPhoto{}, errors.New("unauthorized")
-	}
-
-	id := c.PathParam("id")
-	if id == "" {
-		return services.GeneratedPhoto{}, errors.New("generated photo ID required")
-	}
-
-	// Get current generated photo
-	generated, err := h.app.GeneratedPhotoService.GetByID(c.Context(), id)
-	if err != nil {
-		return services.GeneratedPhoto{}, err
-	}
-	if generated == nil {
-		return services.GeneratedPhoto{}, errors.New("generated photo not found")
-	}
-
-	// Check user owns the original photo
-	photoOwnerID, err := h.app.GeneratedPhotoService.GetOriginalPhotoUserID(c.Context(), id)
-	if err != nil {
-		return services.GeneratedPhoto{}, err
-	}
-	if photoOwnerID != userID {
-		return services.GeneratedPhoto{}, errors.New("only the photo owner can update generated variants")
-	}
-
-	req, err := c.Body()
-	if err != nil {
-		return services.GeneratedPhoto{}, err
-	}
-
-	input := services.UpdateGeneratedPhotoInput{
-		ID:           id,
-		StorageKey:   req.StorageKey,
-		Status:       req.Status,
-		CreditsUsed:  req.CreditsUsed,
-		ErrorMessage: req.ErrorMessage,
-	}
-
-	updated, err := h.app.GeneratedPhotoService.Update(c.Context(), input)
-	if err != nil {
+	list := []services.GeneratedPhoto{*generated}
+	if err := h.app.DerivativeService.AttachGeneratedPhotoVariants(c.Context(), list); err != nil {
 		return services.GeneratedPhoto{}, err
 	}
 
-	return *updated, nil
+	return list[0], nil
 }
 
 // Delete deletes a generated photo
@@ -272,6 +217,9 @@ func (h *GeneratedPhotoHandler) Delete(c *fuego.ContextNoBody) (any, error) {
 	}
 
 	if err := h.app.GeneratedPhotoService.Delete(c.Context(), id); err != nil {
+		if errors.Is(err, services.ErrGenerationInProgress) {
+			return nil, fuego.ConflictError{Detail: err.Error()}
+		}
 		return nil, err
 	}
 
@@ -312,6 +260,9 @@ func (h *GeneratedPhotoHandler) ListByOriginalPhoto(c *fuego.ContextNoBody) (Lis
 	if err != nil {
 		return ListGeneratedPhotosResponse{}, err
 	}
+	if err := h.app.DerivativeService.AttachGeneratedPhotoVariants(c.Context(), generated); err != nil {
+		return ListGeneratedPhotosResponse{}, err
+	}
 
 	return ListGeneratedPhotosResponse{GeneratedPhotos: generated}, nil
 }
@@ -348,41 +299,9 @@ func (h *GeneratedPhotoHandler) ListByTheme(c *fuego.ContextNoBody) (ListGenerat
 	if err != nil {
 		return ListGeneratedPhotosResponse{}, err
 	}
-
-	return ListGeneratedPhotosResponse{GeneratedPhotos: generated}, nil
-}
-
-// UpdateStatusRequest is the request for updating generation status
-type UpdateStatusRequest struct {
-	Status       string  `json:"status" validate:"required,oneof=queued processing completed error"`
-	ErrorMessage *string `json:"error_message,omitempty"`
-}
-
-// UpdateStatus updates the processing status
-func (h *GeneratedPhotoHandler) UpdateStatus(c *fuego.ContextWithBody[UpdateStatusRequest]) (services.GeneratedPhoto, error) {
-	// This endpoint is typically called by background workers
-	// In production, you'd add additional authentication for workers
-	
-	id := c.PathParam("id")
-	if id == "" {
-		return services.GeneratedPhoto{}, errors.New("generated photo ID required")
-	}
-
-	req, err := c.Body()
-	if err != nil {
-		return services.GeneratedPhoto{}, err
-	}
-
-	input := services.UpdateGeneratedPhotoStatusInput{
-		ID:           id,
-		Status:       req.Status,
-		ErrorMessage: req.ErrorMessage,
-	}
-
-	updated, err := h.app.GeneratedPhotoService.UpdateStatus(c.Context(), input)
-	if err != nil {
-		return services.GeneratedPhoto{}, err
+	if err := h.app.DerivativeService.AttachGeneratedPhotoVariants(c.Context(), generated); err != nil {
+		return ListGeneratedPhotosResponse{}, err
 	}
 
-	return *updated, nil
+	return ListGeneratedPhotosResponse{GeneratedPhotos: generated}, nil
 }
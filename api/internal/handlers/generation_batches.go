@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// GenerationBatchHandler handles batch generation routes
+type GenerationBatchHandler struct {
+	app *app.App
+}
+
+// NewGenerationBatchHandler creates a new GenerationBatchHandler
+func NewGenerationBatchHandler(a *app.App) *GenerationBatchHandler {
+	return &GenerationBatchHandler{app: a}
+}
+
+// RegisterRoutes registers batch generation routes
+func (h *GenerationBatchHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.Post(s, "/albums/{id}/apply-theme", h.ApplyTheme, middleware.RequireScope(services.ScopeGenerateWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitGeneration)).
+		Tags("Generated Photos").
+		OperationID("applyThemeToAlbum").
+		Description("Queue a generation for every ready photo in an album, or estimate the cost with dry_run")
+	fuego.Get(s, "/generation-batches/{id}", h.Get, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Generated Photos").
+		OperationID("getGenerationBatch").
+		Description("Get a generation batch with its aggregate progress")
+}
+
+// ApplyThemeRequest is the request for applying a theme to an album
+type ApplyThemeRequest struct {
+	ThemeID string            `json:"theme_id" validate:"required"`
+	Params  map[string]string `json:"params,omitempty"`
+	DryRun  bool              `json:"dry_run"`
+}
+
+// ApplyThemeResponse is the response for applying a theme to an album.
+// Batch is omitted for dry runs.
+type ApplyThemeResponse struct {
+	Plan  services.ApplyThemePlan   `json:"plan"`
+	Batch *services.GenerationBatch `json:"batch,omitempty"`
+}
+
+// ApplyTheme queues generations for every ready photo in an album
+func (h *GenerationBatchHandler) ApplyTheme(c *fuego.ContextWithBody[ApplyThemeRequest]) (ApplyThemeResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return ApplyThemeResponse{}, errors.New("unauthorized")
+	}
+
+	albumID := c.PathParam("id")
+
+	role, err := h.app.AlbumService.GetUserRole(c.Context(), albumID, userID)
+	if err != nil {
+		return ApplyThemeResponse{}, errors.New("access denied to album")
+	}
+	if role != "owner" && role != "admin" && role != "editor" {
+		return ApplyThemeResponse{}, errors.New("insufficient permissions")
+	}
+
+	req, err := c.Body()
+	if err != nil {
+		return ApplyThemeResponse{}, err
+	}
+
+	album, err := h.app.AlbumService.GetByID(c.Context(), albumID)
+	if err != nil {
+		return ApplyThemeResponse{}, err
+	}
+
+	theme, err := h.app.ThemeService.GetByID(c.Context(), req.ThemeID)
+	if err != nil {
+		return ApplyThemeResponse{}, errors.New("theme not found")
+	}
+	if !theme.IsPublic && theme.UserID != nil && *theme.UserID != userID {
+		return ApplyThemeResponse{}, errors.New("cannot use private theme")
+	}
+
+	input := services.ApplyThemeInput{
+		UserID: userID,
+		Album:  album,
+		Theme:  theme,
+		Params: req.Params,
+	}
+
+	if req.DryRun {
+		plan, err := h.app.GenerationBatchService.Plan(c.Context(), input)
+		if err != nil {
+			return ApplyThemeResponse{}, promptError(err)
+		}
+		return ApplyThemeResponse{Plan: *plan}, nil
+	}
+
+	batch, plan, err := h.app.GenerationBatchService.Apply(c.Context(), input)
+	if errors.Is(err, services.ErrNothingToGenerate) {
+		return ApplyThemeResponse{}, fuego.ConflictError{Err: err, Detail: err.Error()}
+	}
+	if err != nil {
+		return ApplyThemeResponse{}, promptError(err)
+	}
+
+	return ApplyThemeResponse{Plan: *plan, Batch: batch}, nil
+}
+
+// Get gets a generation batch and its progress
+func (h *GenerationBatchHandler) Get(c *fuego.ContextNoBody) (services.GenerationBatch, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return services.GenerationBatch{}, errors.New("unauthorized")
+	}
+
+	batch, err := h.app.GenerationBatchService.GetByID(c.Context(), c.PathParam("id"))
+	if err != nil {
+		return services.GenerationBatch{}, err
+	}
+
+	// The requester and anyone who can see the album may follow progress
+	if batch.UserID != userID {
+		role, err := h.app.AlbumService.GetUserRole(c.Context(), batch.AlbumID, userID)
+		if err != nil || role == "" {
+			return services.GenerationBatch{}, errors.New("access denied")
+		}
+	}
+
+	return *batch, nil
+}
@@ -1,17 +1,15 @@
 package handlers
 
 import (
-	"net/http"
-
 	"github.com/go-fuego/fuego"
 	"redrawn/internal/app"
 )
 
 // HealthResponse represents the health check response
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Version   string `json:"version"`
-	Database  string `json:"database"`
+	Status   string `json:"status"`
+	Version  string `json:"version"`
+	Database string `json:"database"`
 }
 
 // HealthHandler handles health check requests
@@ -26,17 +24,16 @@ func NewHealthHandler(version string) *HealthHandler {
 
 // RegisterRoutes registers health routes
 func (h *HealthHandler) RegisterRoutes(s *fuego.Server) {
-	fuego.Get(s, "/health", h.GetHealth,
-		fuego.OptionTags("System"),
-		fuego.OptionOperationID("get_health"),
-		fuego.OptionDescription("Health check endpoint"),
-	)
+	fuego.Get(s, "/health", h.GetHealth).
+		Tags("System").
+		OperationID("get_health").
+		Description("Health check endpoint")
 }
 
 // GetHealth returns the health status
 func (h *HealthHandler) GetHealth(c *fuego.ContextWithBody[any]) (HealthResponse, error) {
 	a := app.FromContext(c.Context())
-	
+
 	dbStatus := "connected"
 	if a != nil && a.DB != nil {
 		if err := a.DB.Ping(); err != nil {
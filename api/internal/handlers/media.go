@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// mediaMaxAge is how long clients may reuse media without revalidating. Media of a given
+// ID doesn't change, and revalidation is cheap thanks to ETags.
+const mediaMaxAge = time.Hour
+
+// MediaHandler serves photos and generated photos to the users allowed to see them
+type MediaHandler struct {
+	app        *app.App
+	transforms chan struct{} // one slot per transform allowed to run at once
+}
+
+// NewMediaHandler creates a new MediaHandler
+func NewMediaHandler(a *app.App) *MediaHandler {
+	return &MediaHandler{
+		app:        a,
+		transforms: make(chan struct{}, max(1, a.Config.Transforms.Concurrency)),
+	}
+}
+
+// RegisterRoutes registers media routes
+func (h *MediaHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.GetStd(s, "/media/{id}", h.Get, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Media").
+		OperationID("getMedia").
+		Description("Download a photo or generated photo, or one of its variants with ?variant=webp_1024. " +
+			"Members of the album and, for public albums, anyone may download. Supports Range and conditional requests.")
+
+	// Resized copies at any size, through URLs signed by the API
+	fuego.Post(s, "/media/{id}/transform-url", h.GetTransformURL, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Media").
+		OperationID("getTransformURL").
+		Description("Get a signed URL for a resized copy of a photo or generated photo. " +
+			"Fit is contain (default), cover or fill; format is webp (default), jpeg or png; quality applies to jpeg.")
+	fuego.GetStd(s, "/media/{id}/transform", h.Transform).
+		Tags("Media").
+		OperationID("getTransformedMedia").
+		Description("Download a resized copy of a photo or generated photo through a URL from getTransformURL. " +
+			"Copies are made on the first request and cached; a 503 with Retry-After means the server is busy.")
+}
+
+// Get streams a photo or generated photo from storage
+func (h *MediaHandler) Get(w http.ResponseWriter, r *http.Request) {
+	media, err := h.app.MediaService.Resolve(r.Context(), r.PathValue("id"), r.URL.Query().Get("variant"))
+	if err != nil {
+		if errors.Is(err, services.ErrMediaNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cacheControl, ok := h.authorize(r.Context(), media)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, info, err := h.app.Storage.OpenObject(r.Context(), media.StorageKey)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	cacheControl += ", max-age=" + strconv.Itoa(int(mediaMaxAge.Seconds()))
+	serveObject(w, r, content, info, cacheControl)
+}
+
+// Transform streams a resized, re-encoded copy of a photo or generated photo, making it
+// on the first request. Only URLs signed by the API are served, so clients can't make the
+// server render arbitrary sizes.
+func (h *MediaHandler) Transform(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	params, err := h.app.TransformService.ParseQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := h.app.TransformService.Verify(id, params, r.URL.Query().Get("sig")); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	media, err := h.app.MediaService.Resolve(r.Context(), id, "")
+	if err != nil {
+		if errors.Is(err, services.ErrMediaNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	cacheControl, ok := h.authorize(r.Context(), media)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cacheControl += ", max-age=" + strconv.Itoa(int(mediaMaxAge.Seconds()))
+
+	key := services.TransformKey(media.StorageKey, params)
+	if h.serveCached(w, r, key, cacheControl) {
+		return
+	}
+
+	// Transforms are CPU and memory heavy, so only a few run at once
+	timer := time.NewTimer(h.app.Config.Transforms.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case h.transforms <- struct{}{}:
+		defer func() { <-h.transforms }()
+	case <-timer.C:
+		w.Header().Set("Retry-After", strconv.Itoa(max(1, int(h.app.Config.Transforms.QueueTimeout.Seconds()))))
+		http.Error(w, "too many transforms in progress", http.StatusServiceUnavailable)
+		return
+	case <-r.Context().Done():
+		return
+	}
+
+	// Another request for the same transform may have finished while this one waited
+	if h.serveCached(w, r, key, cacheControl) {
+		return
+	}
+
+	data, contentType, err := h.app.TransformService.Transform(r.Context(), media.StorageKey, params)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	info := &services.ObjectInfo{
+		Size:         int64(len(data)),
+		ContentType:  contentType,
+		ETag:         fmt.Sprintf("%x", md5.Sum(data)),
+		LastModified: time.Now(),
+	}
+	serveObject(w, r, bytes.NewReader(data), info, cacheControl)
+}
+
+// serveCached serves the object at key if it exists, and reports whether it did
+func (h *MediaHandler) serveCached(w http.ResponseWriter, r *http.Request, key, cacheControl string) bool {
+	content, info, err := h.app.Storage.OpenObject(r.Context(), key)
+	if err != nil {
+		if !errors.Is(err, services.ErrObjectNotFound) {
+			h.app.Logger.Warn("Failed to open cached transform", "key", key, "error", err)
+		}
+		return false
+	}
+	defer content.Close()
+
+	serveObject(w, r, content, info, cacheControl)
+	return true
+}
+
+// TransformURLResponse is the response for getting a transform URL
+type TransformURLResponse struct {
+	URL string `json:"url"`
+}
+
+// GetTransformURL signs a transform URL for a photo or generated photo the user can see
+func (h *MediaHandler) GetTransformURL(c *fuego.ContextWithBody[services.TransformParams]) (TransformURLResponse, error) {
+	req, err := c.Body()
+	if err != nil {
+		return TransformURLResponse{}, err
+	}
+	params, err := h.app.TransformService.Normalize(req)
+	if err != nil {
+		return TransformURLResponse{}, fuego.BadRequestError{Detail: err.Error()}
+	}
+
+	id := c.PathParam("id")
+	media, err := h.app.MediaService.Resolve(c.Context(), id, "")
+	if err != nil {
+		if errors.Is(err, services.ErrMediaNotFound) {
+			return TransformURLResponse{}, fuego.NotFoundError{Detail: err.Error()}
+		}
+		return TransformURLResponse{}, err
+	}
+	if _, ok := h.authorize(c.Context(), media); !ok {
+		return TransformURLResponse{}, fuego.NotFoundError{Detail: services.ErrMediaNotFound.Error()}
+	}
+
+	return TransformURLResponse{URL: h.app.TransformService.SignedURL(id, params)}, nil
+}
+
+// authorize reports whether the current user may see media, with the Cache-Control
+// directive it may be served with. Media of private albums is reported as missing to
+// non-members, so IDs can't be probed.
+func (h *MediaHandler) authorize(ctx context.Context, media *services.Media) (string, bool) {
+	if media.PublicAlbum {
+		return "public", true
+	}
+	userID := middleware.GetUserIDFromContext(ctx)
+	if userID == "" {
+		return "", false
+	}
+	if _, err := h.app.AlbumService.GetUserRole(ctx, media.AlbumID, userID); err != nil {
+		return "", false
+	}
+	return "private", true
+}
+
+// serveObject writes an object, answering Range, If-None-Match and If-Modified-Since
+// requests from its metadata
+func serveObject(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, info *services.ObjectInfo, cacheControl string) {
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", strconv.Quote(info.ETag))
+	}
+	if cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+	// Content types come from uploads, so browsers must not second-guess them
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	http.ServeContent(w, r, "", info.LastModified, content)
+}
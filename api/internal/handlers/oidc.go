@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// OIDCHandler handles signing in with external identity providers
+type OIDCHandler struct {
+	app *app.App
+}
+
+// NewOIDCHandler creates a new OIDCHandler
+func NewOIDCHandler(a *app.App) *OIDCHandler {
+	return &OIDCHandler{app: a}
+}
+
+// RegisterRoutes registers identity provider sign-in routes
+func (h *OIDCHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.Get(s, "/auth/oidc/providers", h.ListProviders).
+		Tags("Auth").
+		OperationID("listOIDCProviders").
+		Description("List the identity providers users can sign in with")
+
+	fuego.Post(s, "/auth/oidc/{provider}/authorize", h.Authorize, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("startOIDCLogin").
+		Description("Start signing in with a provider; send the user to the returned URL and keep the state to check on the way back")
+
+	fuego.Post(s, "/auth/oidc/callback", h.Callback, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("finishOIDCLogin").
+		Description("Finish signing in with the code and state the provider redirected back with; creates or links the account by verified email")
+}
+
+// ListOIDCProvidersResponse lists the configured identity providers
+type ListOIDCProvidersResponse struct {
+	Providers []string `json:"providers"`
+}
+
+// ListProviders lists the configured identity providers
+func (h *OIDCHandler) ListProviders(c *fuego.ContextNoBody) (ListOIDCProvidersResponse, error) {
+	return ListOIDCProvidersResponse{Providers: h.app.OIDCService.Providers()}, nil
+}
+
+// Authorize starts a sign-in with a provider
+func (h *OIDCHandler) Authorize(c *fuego.ContextNoBody) (services.OIDCAuthorization, error) {
+	auth, err := h.app.OIDCService.Start(c.Context(), c.PathParam("provider"))
+	if err != nil {
+		if errors.Is(err, services.ErrUnknownOIDCProvider) {
+			return services.OIDCAuthorization{}, fuego.NotFoundError{Detail: err.Error()}
+		}
+		return services.OIDCAuthorization{}, err
+	}
+	return *auth, nil
+}
+
+// OIDCCallbackRequest holds the query parameters a provider redirected back with
+type OIDCCallbackRequest struct {
+	State string `json:"state" validate:"required"`
+	Code  string `json:"code" validate:"required"`
+}
+
+// Callback finishes a sign-in with a provider and starts a session, or returns an MFA
+// challenge for users with 2FA
+func (h *OIDCHandler) Callback(c *fuego.ContextWithBody[OIDCCallbackRequest]) (LoginResponse, error) {
+	input, err := c.Body()
+	if err != nil {
+		return LoginResponse{}, err
+	}
+
+	user, err := h.app.OIDCService.Finish(c.Context(), input.State, input.Code)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOIDCState):
+			return LoginResponse{}, fuego.BadRequestError{Detail: err.Error()}
+		case errors.Is(err, services.ErrOIDCEmailNotVerified):
+			return LoginResponse{}, fuego.ForbiddenError{Detail: err.Error()}
+		case errors.Is(err, services.ErrOIDCLoginFailed):
+			// The details stay in the log; they may describe the provider's responses
+			h.app.Logger.Warn("Identity provider sign-in failed", "error", err)
+			return LoginResponse{}, fuego.UnauthorizedError{Detail: services.ErrOIDCLoginFailed.Error()}
+		}
+		return LoginResponse{}, err
+	}
+
+	resp, err := h.app.AuthService.SignIn(c.Context(), user, sessionClient(c.Request()))
+	if err != nil {
+		return LoginResponse{}, err
+	}
+	return newLoginResponse(resp), nil
+}
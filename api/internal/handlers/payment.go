@@ -2,8 +2,8 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"io"
-	"net/http"
 	"os"
 
 	"github.com/go-fuego/fuego"
@@ -36,8 +36,20 @@ type PurchaseCreditsResponse struct {
 }
 
 // PurchaseCredits initiates a credit purchase
-func (h *PaymentHandler) PurchaseCredits(c fuego.ContextWithBody[PurchaseCreditsRequest]) (PurchaseCreditsResponse, error) {
-	userID := c.Context().Value("user_id").(string)
+func (h *PaymentHandler) PurchaseCredits(c *fuego.ContextWithBody[PurchaseCreditsRequest]) (PurchaseCreditsResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return PurchaseCreditsResponse{}, errors.New("unauthorized")
+	}
+
+	// Payments need an address that reaches the buyer
+	user, err := h.app.UserService.GetByID(c.Context(), userID)
+	if err != nil {
+		return PurchaseCreditsResponse{}, err
+	}
+	if !user.EmailVerified {
+		return PurchaseCreditsResponse{}, fuego.ForbiddenError{Detail: "verify your email address before buying credits"}
+	}
 
 	req, err := c.Body()
 	if err != nil {
@@ -71,7 +83,7 @@ func (h *PaymentHandler) PurchaseCredits(c fuego.ContextWithBody[PurchaseCredits
 }
 
 // StripeWebhook handles Stripe webhook events
-func (h *PaymentHandler) StripeWebhook(c fuego.ContextNoBody) (map[string]string, error) {
+func (h *PaymentHandler) StripeWebhook(c *fuego.ContextNoBody) (map[string]string, error) {
 	payload, err := io.ReadAll(c.Request().Body)
 	if err != nil {
 		return nil, fuego.BadRequestError{Detail: "failed to read body"}
@@ -94,7 +106,7 @@ func (h *PaymentHandler) StripeWebhook(c fuego.ContextNoBody) (map[string]string
 		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
 			return nil, fuego.BadRequestError{Detail: "failed to parse session"}
 		}
-		
+
 		if err := h.app.PaymentService.ProcessCheckoutCompleted(c.Context(), &session); err != nil {
 			// Log error but return 200 to prevent Stripe retries for unrecoverable errors
 			return map[string]string{"status": "error", "message": err.Error()}, nil
@@ -106,7 +118,7 @@ func (h *PaymentHandler) StripeWebhook(c fuego.ContextNoBody) (map[string]string
 		if err := json.Unmarshal(event.Data.Raw, &session); err != nil {
 			return nil, fuego.BadRequestError{Detail: "failed to parse session"}
 		}
-		
+
 		if err := h.app.PaymentService.ProcessCheckoutCompleted(c.Context(), &session); err != nil {
 			return map[string]string{"status": "error", "message": err.Error()}, nil
 		}
@@ -124,18 +136,16 @@ func (h *PaymentHandler) StripeWebhook(c fuego.ContextNoBody) (map[string]string
 // RegisterRoutes registers payment routes with the server
 func (h *PaymentHandler) RegisterRoutes(s *fuego.Server) {
 	// User routes
-	fuego.Post(s, "/credits/purchase", h.PurchaseCredits,
-		fuego.OptionTags("Payments"),
-		fuego.OptionOperationID("purchase_credits"),
-		fuego.OptionDescription("Initiate a credit purchase via Stripe"),
-	)
+	fuego.Post(s, "/credits/purchase", h.PurchaseCredits).
+		Tags("Payments").
+		OperationID("purchase_credits").
+		Description("Initiate a credit purchase via Stripe")
 
 	// Webhook route (no auth required)
-	fuego.Post(s, "/webhooks/stripe", h.StripeWebhook,
-		fuego.OptionTags("Webhooks"),
-		fuego.OptionOperationID("stripe_webhook"),
-		fuego.OptionDescription("Handle Stripe webhook events"),
-	)
+	fuego.Post(s, "/webhooks/stripe", h.StripeWebhook).
+		Tags("Webhooks").
+		OperationID("stripe_webhook").
+		Description("Handle Stripe webhook events")
 }
 
 // getEnv returns environment variable or default value
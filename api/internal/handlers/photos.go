@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"context"
 	"errors"
 
 	"github.com/go-fuego/fuego"
@@ -23,45 +22,42 @@ func NewPhotoHandler(a *app.App) *PhotoHandler {
 // RegisterRoutes registers photo routes
 func (h *PhotoHandler) RegisterRoutes(s *fuego.Server) {
 	// Photo CRUD
-	fuego.Get(s, "/photos", h.List,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("listPhotos"),
-		fuego.OptionDescription("List all photos for the current user"),
-	)
-	fuego.Post(s, "/photos", h.Create,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("createPhoto"),
-		fuego.OptionDescription("Create a new photo record (after upload)"),
-	)
-	fuego.Get(s, "/photos/{id}", h.Get,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("getPhoto"),
-		fuego.OptionDescription("Get a photo by ID"),
-	)
-	fuego.Put(s, "/photos/{id}", h.Update,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("updatePhoto"),
-		fuego.OptionDescription("Update photo metadata"),
-	)
-	fuego.Delete(s, "/photos/{id}", h.Delete,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("deletePhoto"),
-		fuego.OptionDescription("Delete a photo"),
-	)
+	fuego.Get(s, "/photos", h.List, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Photos").
+		OperationID("listPhotos").
+		Description("List all photos for the current user")
+	fuego.Post(s, "/photos", h.Create, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Photos").
+		OperationID("createPhoto").
+		Description("Create a new photo record after upload; the type and dimensions are read from the stored file")
+	fuego.Get(s, "/photos/{id}", h.Get, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Photos").
+		OperationID("getPhoto").
+		Description("Get a photo by ID")
+	fuego.Put(s, "/photos/{id}", h.Update, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Photos").
+		OperationID("updatePhoto").
+		Description("Update photo metadata")
+	fuego.Delete(s, "/photos/{id}", h.Delete, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Photos").
+		OperationID("deletePhoto").
+		Description("Delete a photo")
 
 	// Album-specific photo routes
-	fuego.Get(s, "/albums/{albumID}/photos", h.ListByAlbum,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("listAlbumPhotos"),
-		fuego.OptionDescription("List all photos in an album"),
-	)
+	fuego.Get(s, "/albums/{albumID}/photos", h.ListByAlbum, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Photos").
+		OperationID("listAlbumPhotos").
+		Description("List all photos in an album")
+	fuego.Get(s, "/albums/{albumID}/duplicates", h.ListDuplicates, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Photos").
+		OperationID("listAlbumDuplicates").
+		Description("List groups of near-duplicate photos in an album, such as burst shots or re-encoded copies")
 
 	// Photo status management
-	fuego.Post(s, "/photos/{id}/status", h.UpdateStatus,
-		fuego.OptionTags("Photos"),
-		fuego.OptionOperationID("updatePhotoStatus"),
-		fuego.OptionDescription("Update photo processing status"),
-	)
+	fuego.Post(s, "/photos/{id}/status", h.UpdateStatus).
+		Tags("Photos").
+		OperationID("updatePhotoStatus").
+		Description("Re-queue a photo for ingestion by setting its status back to uploaded")
 }
 
 // ListPhotosResponse is the response for listing photos
@@ -80,6 +76,9 @@ func (h *PhotoHandler) List(c *fuego.ContextNoBody) (ListPhotosResponse, error)
 	if err != nil {
 		return ListPhotosResponse{}, err
 	}
+	if err := h.app.DerivativeService.AttachPhotoVariants(c.Context(), photos); err != nil {
+		return ListPhotosResponse{}, err
+	}
 
 	return ListPhotosResponse{Photos: photos}, nil
 }
@@ -89,13 +88,10 @@ type CreatePhotoRequest struct {
 	AlbumID    string  `json:"album_id" validate:"required"`
 	StorageKey string  `json:"storage_key" validate:"required"`
 	Filename   *string `json:"filename,omitempty"`
-	MimeType   *string `json:"mime_type,omitempty"`
-	SizeBytes  *int64  `json:"size_bytes,omitempty"`
-	Width      *int    `json:"width,omitempty"`
-	Height     *int    `json:"height,omitempty"`
 }
 
-// Create creates a new photo record
+// Create creates a new photo record. It starts as uploaded until the worker has
+// validated the stored file.
 func (h *PhotoHandler) Create(c *fuego.ContextWithBody[CreatePhotoRequest]) (services.Photo, error) {
 	userID := getUserIDFromContext(c.Context())
 	if userID == "" {
@@ -121,13 +117,17 @@ func (h *PhotoHandler) Create(c *fuego.ContextWithBody[CreatePhotoRequest]) (ser
 		UserID:     userID,
 		StorageKey: req.StorageKey,
 		Filename:   req.Filename,
-		MimeType:   req.MimeType,
-		SizeBytes:  req.SizeBytes,
-		Width:      req.Width,
-		Height:     req.Height,
 	}
 
-	return h.app.PhotoService.Create(c.Context(), input)
+	photo, err := h.app.PhotoService.Create(c.Context(), input)
+	if err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			return services.Photo{}, fuego.ForbiddenError{Detail: err.Error()}
+		}
+		return services.Photo{}, err
+	}
+
+	return *photo, nil
 }
 
 // Get gets a photo by ID
@@ -151,14 +151,17 @@ func (h *PhotoHandler) Get(c *fuego.ContextNoBody) (services.Photo, error) {
 	}
 	_ = role // role checked implicitly by GetUserRole success
 
-	return *photo, nil
+	photos := []services.Photo{*photo}
+	if err := h.app.DerivativeService.AttachPhotoVariants(c.Context(), photos); err != nil {
+		return services.Photo{}, err
+	}
+
+	return photos[0], nil
 }
 
 // UpdatePhotoRequest is the request for updating a photo
 type UpdatePhotoRequest struct {
 	Filename *string `json:"filename,omitempty"`
-	Width    *int    `json:"width,omitempty"`
-	Height   *int    `json:"height,omitempty"`
 }
 
 // Update updates a photo
@@ -190,11 +193,13 @@ func (h *PhotoHandler) Update(c *fuego.ContextWithBody[UpdatePhotoRequest]) (ser
 	input := services.UpdatePhotoInput{
 		ID:       id,
 		Filename: req.Filename,
-		Width:    req.Width,
-		Height:   req.Height,
 	}
 
-	return h.app.PhotoService.Update(c.Context(), input)
+	photo, err = h.app.PhotoService.Update(c.Context(), input)
+	if err != nil {
+		return services.Photo{}, err
+	}
+	return *photo, nil
 }
 
 // Delete deletes a photo
@@ -248,16 +253,55 @@ func (h *PhotoHandler) ListByAlbum(c *fuego.ContextNoBody) (ListPhotosResponse,
 	if err != nil {
 		return ListPhotosResponse{}, err
 	}
+	if err := h.app.DerivativeService.AttachPhotoVariants(c.Context(), photos); err != nil {
+		return ListPhotosResponse{}, err
+	}
 
 	return ListPhotosResponse{Photos: photos}, nil
 }
 
+// ListDuplicatesResponse is the response for listing near-duplicate photos
+type ListDuplicatesResponse struct {
+	Clusters []services.DuplicateCluster `json:"clusters"`
+}
+
+// ListDuplicates lists the groups of photos in an album that look alike
+func (h *PhotoHandler) ListDuplicates(c *fuego.ContextNoBody) (ListDuplicatesResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return ListDuplicatesResponse{}, errors.New("unauthorized")
+	}
+
+	albumID := c.PathParam("albumID")
+
+	// Check user has access to album
+	_, err := h.app.AlbumService.GetUserRole(c.Context(), albumID, userID)
+	if err != nil {
+		album, err := h.app.AlbumService.GetByID(c.Context(), albumID)
+		if err != nil || !album.IsPublic {
+			return ListDuplicatesResponse{}, errors.New("access denied to album")
+		}
+	}
+
+	clusters, err := h.app.PhotoService.ListDuplicateClusters(c.Context(), albumID, h.app.Config.Ingestion.DuplicateDistance)
+	if err != nil {
+		return ListDuplicatesResponse{}, err
+	}
+	for _, cluster := range clusters {
+		if err := h.app.DerivativeService.AttachPhotoVariants(c.Context(), cluster.Photos); err != nil {
+			return ListDuplicatesResponse{}, err
+		}
+	}
+
+	return ListDuplicatesResponse{Clusters: clusters}, nil
+}
+
 // UpdatePhotoStatusRequest is the request for updating photo status
 type UpdatePhotoStatusRequest struct {
-	Status string `json:"status" validate:"required,oneof=uploaded processing ready error"`
+	Status string `json:"status" validate:"required,oneof=uploaded"`
 }
 
-// UpdateStatus updates photo processing status
+// UpdateStatus re-queues a photo for ingestion. Other statuses are set by the worker only.
 func (h *PhotoHandler) UpdateStatus(c *fuego.ContextWithBody[UpdatePhotoStatusRequest]) (services.Photo, error) {
 	userID := getUserIDFromContext(c.Context())
 	if userID == "" {
@@ -283,9 +327,16 @@ func (h *PhotoHandler) UpdateStatus(c *fuego.ContextWithBody[UpdatePhotoStatusRe
 		return services.Photo{}, err
 	}
 
-	if err := h.app.PhotoService.UpdateStatus(c.Context(), id, req.Status); err != nil {
+	if req.Status != "uploaded" {
+		return services.Photo{}, errors.New("status can only be set to uploaded")
+	}
+	if err := h.app.PhotoService.Reingest(c.Context(), id); err != nil {
 		return services.Photo{}, err
 	}
 
-	return h.app.PhotoService.GetByID(c.Context(), id)
+	photo, err = h.app.PhotoService.GetByID(c.Context(), id)
+	if err != nil {
+		return services.Photo{}, err
+	}
+	return *photo, nil
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// SessionHandler handles the current user's sessions
+type SessionHandler struct {
+	app *app.App
+}
+
+// NewSessionHandler creates a new SessionHandler
+func NewSessionHandler(a *app.App) *SessionHandler {
+	return &SessionHandler{app: a}
+}
+
+// RegisterRoutes registers session routes
+func (h *SessionHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.Get(s, "/me/sessions", h.List).
+		Tags("Auth").
+		OperationID("listSessions").
+		Description("List the devices signed in to the current user's account")
+	fuego.Delete(s, "/me/sessions", h.RevokeOthers).
+		Tags("Auth").
+		OperationID("revokeOtherSessions").
+		Description("Sign out every device except the current one")
+	fuego.Delete(s, "/me/sessions/{id}", h.Revoke).
+		Tags("Auth").
+		OperationID("revokeSession").
+		Description("Sign out a device; its refresh token and access tokens stop working")
+}
+
+// SessionResponse is a session as listed to its user
+type SessionResponse struct {
+	services.Session
+	Current bool `json:"current"` // the session of the access token making the request
+}
+
+// ListSessionsResponse is the response for listing sessions
+type ListSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// List lists the current user's active sessions
+func (h *SessionHandler) List(c *fuego.ContextNoBody) (ListSessionsResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return ListSessionsResponse{}, errors.New("unauthorized")
+	}
+
+	sessions, err := h.app.SessionService.ListActive(c.Context(), userID)
+	if err != nil {
+		return ListSessionsResponse{}, err
+	}
+
+	currentID := middleware.GetSessionIDFromContext(c.Context())
+	resp := ListSessionsResponse{Sessions: make([]SessionResponse, len(sessions))}
+	for i, session := range sessions {
+		resp.Sessions[i] = SessionResponse{Session: session, Current: session.ID == currentID}
+	}
+	return resp, nil
+}
+
+// RevokeOthers revokes every session of the current user but the one making the request
+func (h *SessionHandler) RevokeOthers(c *fuego.ContextNoBody) (map[string]int64, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return nil, errors.New("unauthorized")
+	}
+
+	n, err := h.app.SessionService.RevokeAll(c.Context(), userID, middleware.GetSessionIDFromContext(c.Context()))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]int64{"revoked": n}, nil
+}
+
+// Revoke revokes one of the current user's sessions
+func (h *SessionHandler) Revoke(c *fuego.ContextNoBody) (any, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return nil, errors.New("unauthorized")
+	}
+
+	if err := h.app.SessionService.Revoke(c.Context(), userID, c.PathParam("id")); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return nil, fuego.NotFoundError{Detail: err.Error()}
+		}
+		return nil, err
+	}
+	return map[string]string{"status": "revoked"}, nil
+}
@@ -2,6 +2,10 @@ package handlers
 
 import (
 	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/go-fuego/fuego"
 	"redrawn/internal/app"
@@ -22,29 +26,61 @@ func NewStorageHandler(a *app.App) *StorageHandler {
 // RegisterRoutes registers storage routes
 func (h *StorageHandler) RegisterRoutes(s *fuego.Server) {
 	// Presigned upload URL
-	fuego.Post(s, "/storage/upload-url", h.GetUploadURL,
-		fuego.OptionTags("Storage"),
-		fuego.OptionOperationID("getUploadURL"),
-		fuego.OptionDescription("Get a presigned URL for direct file upload"),
-	)
-
-	// Presigned download URL
-	fuego.Post(s, "/storage/download-url", h.GetDownloadURL,
-		fuego.OptionTags("Storage"),
-		fuego.OptionOperationID("getDownloadURL"),
-		fuego.OptionDescription("Get a presigned URL for downloading a file"),
-	)
-
-	// Delete file
-	fuego.Delete(s, "/storage/{storageKey}", h.Delete,
-		fuego.OptionTags("Storage"),
-		fuego.OptionOperationID("deleteFile"),
-		fuego.OptionDescription("Delete a file from storage"),
-	)
+	fuego.Post(s, "/storage/upload-url", h.GetUploadURL, middleware.RequireScope(services.ScopeAlbumsWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitUploads)).
+		Tags("Storage").
+		OperationID("getUploadURL").
+		Description("Get a presigned URL for uploading a photo to an album; the returned storage key can be used once to create the photo")
+
+	// Multipart uploads, for large files and unreliable connections
+	fuego.Post(s, "/storage/multipart", h.CreateMultipartUpload, middleware.RequireScope(services.ScopeAlbumsWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitUploads)).
+		Tags("Storage").
+		OperationID("createMultipartUpload").
+		Description("Start a multipart upload of a photo to an album; parts are uploaded to presigned URLs and the upload completed to get the storage key")
+	fuego.Post(s, "/storage/multipart/{uploadId}/parts/{partNumber}", h.GetPartUploadURL, middleware.RequireScope(services.ScopeAlbumsWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitUploads)).
+		Tags("Storage").
+		OperationID("getPartUploadURL").
+		Description("Get a presigned URL for uploading one part; the ETag header of the upload response is needed to complete")
+	fuego.Get(s, "/storage/multipart/{uploadId}/parts", h.ListParts, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Storage").
+		OperationID("listUploadParts").
+		Description("List the parts uploaded so far, to resume an interrupted upload")
+	fuego.Post(s, "/storage/multipart/{uploadId}/complete", h.CompleteMultipartUpload, middleware.RequireScope(services.ScopeAlbumsWrite), middleware.RateLimit(h.app.RateLimiter, services.RateLimitUploads)).
+		Tags("Storage").
+		OperationID("completeMultipartUpload").
+		Description("Assemble the uploaded parts; the returned storage key can be used once to create the photo")
+	fuego.Delete(s, "/storage/multipart/{uploadId}", h.AbortMultipartUpload, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Storage").
+		OperationID("abortMultipartUpload").
+		Description("Abort a multipart upload and discard its parts")
+
+	// Storage usage and quota
+	fuego.Get(s, "/me/usage", h.GetUsage, middleware.RequireScope(services.ScopeAlbumsRead)).
+		Tags("Storage").
+		OperationID("getUsage").
+		Description("Get the storage used per album and in total, and the quota granted by lifetime credit purchases")
+
+	// Delete a photo's files
+	fuego.Delete(s, "/storage/photos/{id}", h.Delete, middleware.RequireScope(services.ScopeAlbumsWrite)).
+		Tags("Storage").
+		OperationID("deleteFile").
+		Description("Delete a photo you uploaded along with its stored original and display copy")
+
+	// Backends without their own endpoint (local disk, memory) serve signed URLs here
+	if _, ok := h.app.Storage.(services.ServedStorage); ok {
+		fuego.PutStd(s, services.ObjectRoutePrefix+"{key...}", h.PutObject).
+			Tags("Storage").
+			OperationID("putObject").
+			Description("Upload an object to a signed upload URL")
+		fuego.GetStd(s, services.ObjectRoutePrefix+"{key...}", h.GetObject).
+			Tags("Storage").
+			OperationID("getObject").
+			Description("Download an object from a signed download URL")
+	}
 }
 
 // GetUploadURLRequest is the request for getting an upload URL
 type GetUploadURLRequest struct {
+	AlbumID  string `json:"album_id" validate:"required"`
 	Filename string `json:"filename" validate:"required"`
 	MimeType string `json:"mime_type" validate:"required"`
 	Size     int64  `json:"size" validate:"required,min=1,max=104857600"` // Max 100MB
@@ -69,14 +105,26 @@ func (h *StorageHandler) GetUploadURL(c *fuego.ContextWithBody[GetUploadURLReque
 		return GetUploadURLResponse{}, err
 	}
 
-	uploadReq := services.UploadURLRequest{
+	// Only members who can add photos may upload to an album
+	role, err := h.app.AlbumService.GetUserRole(c.Context(), req.AlbumID, userID)
+	if err != nil {
+		return GetUploadURLResponse{}, errors.New("access denied to album")
+	}
+	if role != "owner" && role != "admin" && role != "editor" {
+		return GetUploadURLResponse{}, errors.New("insufficient permissions")
+	}
+
+	result, err := h.app.UploadService.CreateUploadURL(c.Context(), services.CreateUploadInput{
+		UserID:   userID,
+		AlbumID:  req.AlbumID,
 		Filename: req.Filename,
 		MimeType: req.MimeType,
 		Size:     req.Size,
-	}
-
-	result, err := h.app.StorageService.GenerateUploadURL(c.Context(), uploadReq)
+	})
 	if err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			return GetUploadURLResponse{}, fuego.ForbiddenError{Detail: err.Error()}
+		}
 		return GetUploadURLResponse{}, err
 	}
 
@@ -87,38 +135,19 @@ func (h *StorageHandler) GetUploadURL(c *fuego.ContextWithBody[GetUploadURLReque
 	}, nil
 }
 
-// GetDownloadURLRequest is the request for getting a download URL
-type GetDownloadURLRequest struct {
-	StorageKey string `json:"storage_key" validate:"required"`
-}
-
-// GetDownloadURLResponse is the response for getting a download URL
-type GetDownloadURLResponse struct {
-	DownloadURL string `json:"download_url"`
-	ExpiresAt   int64  `json:"expires_at"` // Unix timestamp
-}
-
-// GetDownloadURL generates a presigned URL for downloading a file
-func (h *StorageHandler) GetDownloadURL(c *fuego.ContextWithBody[GetDownloadURLRequest]) (GetDownloadURLResponse, error) {
+// GetUsage returns the current user's storage usage and quota
+func (h *StorageHandler) GetUsage(c *fuego.ContextNoBody) (services.UserUsage, error) {
 	userID := middleware.GetUserIDFromContext(c.Context())
 	if userID == "" {
-		return GetDownloadURLResponse{}, errors.New("unauthorized")
-	}
-
-	req, err := c.Body()
-	if err != nil {
-		return GetDownloadURLResponse{}, err
+		return services.UserUsage{}, errors.New("unauthorized")
 	}
 
-	result, err := h.app.StorageService.GenerateDownloadURL(c.Context(), req.StorageKey)
+	usage, err := h.app.UsageService.GetUsage(c.Context(), userID)
 	if err != nil {
-		return GetDownloadURLResponse{}, err
+		return services.UserUsage{}, err
 	}
 
-	return GetDownloadURLResponse{
-		DownloadURL: result.DownloadURL,
-		ExpiresAt:   result.ExpiresAt,
-	}, nil
+	return *usage, nil
 }
 
 // DeleteFileResponse is the response for deleting a file
@@ -126,20 +155,17 @@ type DeleteFileResponse struct {
 	Status string `json:"status"`
 }
 
-// Delete deletes a file from storage
+// Delete deletes a photo and its stored original and display copy. The row goes first so
+// nothing references the objects once they are deleted; objects a failed delete leaves
+// behind, and the photo's derivatives and generated images, are unreferenced and removed
+// by storage reconciliation.
 func (h *StorageHandler) Delete(c *fuego.ContextNoBody) (DeleteFileResponse, error) {
 	userID := middleware.GetUserIDFromContext(c.Context())
 	if userID == "" {
 		return DeleteFileResponse{}, errors.New("unauthorized")
 	}
 
-	storageKey := c.PathParam("storageKey")
-	if storageKey == "" {
-		return DeleteFileResponse{}, errors.New("storage key required")
-	}
-
-	// Check if user owns the photo with this storage key
-	photo, err := h.app.PhotoService.GetByStorageKey(c.Context(), storageKey)
+	photo, err := h.app.PhotoService.GetByID(c.Context(), c.PathParam("id"))
 	if err != nil {
 		return DeleteFileResponse{}, fuego.NotFoundError{Detail: "photo not found"}
 	}
@@ -148,9 +174,138 @@ func (h *StorageHandler) Delete(c *fuego.ContextNoBody) (DeleteFileResponse, err
 		return DeleteFileResponse{}, fuego.ForbiddenError{Detail: "you do not own this file"}
 	}
 
-	if err := h.app.StorageService.DeleteObject(c.Context(), storageKey); err != nil {
+	if err := h.app.PhotoService.Delete(c.Context(), photo.ID); err != nil {
 		return DeleteFileResponse{}, err
 	}
 
+	keys := []string{photo.StorageKey}
+	if photo.DisplayStorageKey != nil {
+		keys = append(keys, *photo.DisplayStorageKey)
+	}
+	for _, key := range keys {
+		if err := h.app.Storage.DeleteObject(c.Context(), key); err != nil {
+			h.app.Logger.Warn("Failed to delete photo object", "photo_id", photo.ID, "key", key, "error", err)
+		}
+	}
+
 	return DeleteFileResponse{Status: "deleted"}, nil
 }
+
+// maxObjectUploadBytes matches the size limit of upload URL requests, and bounds parts too
+const maxObjectUploadBytes = 100 << 20
+
+// PutObject stores the body of a request made to a signed upload URL
+func (h *StorageHandler) PutObject(w http.ResponseWriter, r *http.Request) {
+	served := h.app.Storage.(services.ServedStorage)
+	key := r.PathValue("key")
+
+	if err := served.VerifySignedURL(http.MethodPut, key, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// The content type is part of the signature, so it must match what was requested
+	contentType := r.URL.Query().Get("content_type")
+	if contentType != "" && r.Header.Get("Content-Type") != contentType {
+		http.Error(w, "content type does not match the upload URL", http.StatusBadRequest)
+		return
+	}
+
+	// So is the declared size, which the upload must match exactly
+	limit := int64(maxObjectUploadBytes)
+	size, err := signedSize(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if size > 0 {
+		limit = min(limit, size)
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		http.Error(w, "upload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if size > 0 && int64(len(body)) != size {
+		http.Error(w, "size does not match the upload URL", http.StatusBadRequest)
+		return
+	}
+
+	// Part URLs carry the upload ID and part number, which are covered by the signature
+	if uploadID := r.URL.Query().Get("upload_id"); uploadID != "" {
+		h.putPart(w, r, served, key, uploadID, body)
+		return
+	}
+
+	// A key can't be written again once a photo was created from it
+	if err := h.app.UploadService.CheckWritable(r.Context(), key); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	if err := served.PutObject(r.Context(), key, body, contentType); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// signedSize returns the size a signed upload URL was issued for, or 0 if it has none
+func signedSize(query url.Values) (int64, error) {
+	if query.Get("size") == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size <= 0 {
+		return 0, errors.New("invalid size")
+	}
+	return size, nil
+}
+
+// putPart stores one part of a multipart upload and returns its ETag in a header, like S3
+func (h *StorageHandler) putPart(w http.ResponseWriter, r *http.Request, served services.ServedStorage, key, uploadID string, body []byte) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("part_number"))
+	if err != nil {
+		http.Error(w, "invalid part number", http.StatusBadRequest)
+		return
+	}
+
+	etag, err := served.UploadPart(r.Context(), key, uploadID, partNumber, body)
+	if err != nil {
+		if errors.Is(err, services.ErrUploadNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetObject writes the object behind a signed download URL
+func (h *StorageHandler) GetObject(w http.ResponseWriter, r *http.Request) {
+	served := h.app.Storage.(services.ServedStorage)
+	key := r.PathValue("key")
+
+	if err := served.VerifySignedURL(http.MethodGet, key, r.URL.Query()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	content, info, err := served.OpenObject(r.Context(), key)
+	if err != nil {
+		if errors.Is(err, services.ErrObjectNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer content.Close()
+
+	serveObject(w, r, content, info, "private")
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// CreateMultipartUploadRequest is the request for starting a multipart upload
+type CreateMultipartUploadRequest struct {
+	AlbumID  string `json:"album_id" validate:"required"`
+	Filename string `json:"filename" validate:"required"`
+	MimeType string `json:"mime_type" validate:"required"`
+	Size     int64  `json:"size" validate:"required,min=1,max=1073741824"` // Max 1GB
+}
+
+// CreateMultipartUpload starts a multipart upload of a photo to an album
+func (h *StorageHandler) CreateMultipartUpload(c *fuego.ContextWithBody[CreateMultipartUploadRequest]) (services.MultipartUpload, error) {
+	userID := middleware.GetUserIDFromContext(c.Context())
+	if userID == "" {
+		return services.MultipartUpload{}, errors.New("unauthorized")
+	}
+
+	req, err := c.Body()
+	if err != nil {
+		return services.MultipartUpload{}, err
+	}
+
+	// Only members who can add photos may upload to an album
+	role, err := h.app.AlbumService.GetUserRole(c.Context(), req.AlbumID, userID)
+	if err != nil {
+		return services.MultipartUpload{}, errors.New("access denied to album")
+	}
+	if role != "owner" && role != "admin" && role != "editor" {
+		return services.MultipartUpload{}, errors.New("insufficient permissions")
+	}
+
+	upload, err := h.app.UploadService.CreateMultipartUpload(c.Context(), services.CreateUploadInput{
+		UserID:   userID,
+		AlbumID:  req.AlbumID,
+		Filename: req.Filename,
+		MimeType: req.MimeType,
+		Size:     req.Size,
+	})
+	if err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			return services.MultipartUpload{}, fuego.ForbiddenError{Detail: err.Error()}
+		}
+		return services.MultipartUpload{}, err
+	}
+
+	return *upload, nil
+}
+
+// GetPartUploadURL generates a presigned URL for uploading one part of a multipart upload
+func (h *StorageHandler) GetPartUploadURL(c *fuego.ContextNoBody) (GetUploadURLResponse, error) {
+	userID := middleware.GetUserIDFromContext(c.Context())
+	if userID == "" {
+		return GetUploadURLResponse{}, errors.New("unauthorized")
+	}
+
+	partNumber, err := strconv.Atoi(c.PathParam("partNumber"))
+	if err != nil {
+		return GetUploadURLResponse{}, errors.New("invalid part number")
+	}
+
+	result, err := h.app.UploadService.GeneratePartUploadURL(c.Context(), userID, c.PathParam("uploadId"), partNumber)
+	if err != nil {
+		return GetUploadURLResponse{}, err
+	}
+
+	return GetUploadURLResponse{
+		UploadURL:  result.UploadURL,
+		StorageKey: result.StorageKey,
+		ExpiresAt:  result.ExpiresAt,
+	}, nil
+}
+
+// ListPartsResponse is the response for listing the parts of a multipart upload
+type ListPartsResponse struct {
+	Parts []services.UploadedPart `json:"parts"`
+}
+
+// ListParts lists the parts uploaded so far, so an interrupted upload can be resumed
+func (h *StorageHandler) ListParts(c *fuego.ContextNoBody) (ListPartsResponse, error) {
+	userID := middleware.GetUserIDFromContext(c.Context())
+	if userID == "" {
+		return ListPartsResponse{}, errors.New("unauthorized")
+	}
+
+	parts, err := h.app.UploadService.ListParts(c.Context(), userID, c.PathParam("uploadId"))
+	if err != nil {
+		return ListPartsResponse{}, err
+	}
+	if parts == nil {
+		parts = []services.UploadedPart{}
+	}
+
+	return ListPartsResponse{Parts: parts}, nil
+}
+
+// CompleteMultipartUploadRequest is the request for completing a multipart upload
+type CompleteMultipartUploadRequest struct {
+	Parts []services.CompletedPart `json:"parts" validate:"required,min=1,max=10000,dive"`
+}
+
+// CompleteMultipartUploadResponse is the response for completing a multipart upload
+type CompleteMultipartUploadResponse struct {
+	StorageKey string `json:"storage_key"` // use to create the photo
+}
+
+// CompleteMultipartUpload assembles the uploaded parts into the photo's file
+func (h *StorageHandler) CompleteMultipartUpload(c *fuego.ContextWithBody[CompleteMultipartUploadRequest]) (CompleteMultipartUploadResponse, error) {
+	userID := middleware.GetUserIDFromContext(c.Context())
+	if userID == "" {
+		return CompleteMultipartUploadResponse{}, errors.New("unauthorized")
+	}
+
+	req, err := c.Body()
+	if err != nil {
+		return CompleteMultipartUploadResponse{}, err
+	}
+
+	storageKey, err := h.app.UploadService.CompleteMultipartUpload(c.Context(), userID, c.PathParam("uploadId"), req.Parts)
+	if err != nil {
+		return CompleteMultipartUploadResponse{}, err
+	}
+
+	return CompleteMultipartUploadResponse{StorageKey: storageKey}, nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (h *StorageHandler) AbortMultipartUpload(c *fuego.ContextNoBody) (DeleteFileResponse, error) {
+	userID := middleware.GetUserIDFromContext(c.Context())
+	if userID == "" {
+		return DeleteFileResponse{}, errors.New("unauthorized")
+	}
+
+	if err := h.app.UploadService.AbortMultipartUpload(c.Context(), userID, c.PathParam("uploadId")); err != nil {
+		return DeleteFileResponse{}, err
+	}
+
+	return DeleteFileResponse{Status: "aborted"}, nil
+}
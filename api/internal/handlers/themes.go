@@ -6,6 +6,7 @@ import (
 
 	"github.com/go-fuego/fuego"
 	"redrawn/internal/app"
+	"redrawn/internal/middleware"
 	"redrawn/internal/services"
 )
 
@@ -22,43 +23,40 @@ func NewThemeHandler(a *app.App) *ThemeHandler {
 // RegisterRoutes registers theme routes
 func (h *ThemeHandler) RegisterRoutes(s *fuego.Server) {
 	// Theme CRUD
-	fuego.Get(s, "/themes", h.List,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("listThemes"),
-		fuego.OptionDescription("List all themes for the current user (including public themes)"),
-	)
-	fuego.Get(s, "/themes/public", h.ListPublic,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("listPublicThemes"),
-		fuego.OptionDescription("List all public themes"),
-	)
-	fuego.Post(s, "/themes", h.Create,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("createTheme"),
-		fuego.OptionDescription("Create a new theme"),
-	)
-	fuego.Get(s, "/themes/{id}", h.Get,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("getTheme"),
-		fuego.OptionDescription("Get a theme by ID"),
-	)
-	fuego.Put(s, "/themes/{id}", h.Update,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("updateTheme"),
-		fuego.OptionDescription("Update a theme"),
-	)
-	fuego.Delete(s, "/themes/{id}", h.Delete,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("deleteTheme"),
-		fuego.OptionDescription("Delete a theme"),
-	)
+	fuego.Get(s, "/themes", h.List, middleware.RequireScope(services.ScopeThemesRead)).
+		Tags("Themes").
+		OperationID("listThemes").
+		Description("List all themes for the current user (including public themes)")
+	fuego.Get(s, "/themes/public", h.ListPublic, middleware.RequireScope(services.ScopeThemesRead)).
+		Tags("Themes").
+		OperationID("listPublicThemes").
+		Description("List all public themes")
+	fuego.Post(s, "/themes", h.Create).
+		Tags("Themes").
+		OperationID("createTheme").
+		Description("Create a new theme")
+	fuego.Get(s, "/themes/{id}", h.Get, middleware.RequireScope(services.ScopeThemesRead)).
+		Tags("Themes").
+		OperationID("getTheme").
+		Description("Get a theme by ID")
+	fuego.Put(s, "/themes/{id}", h.Update).
+		Tags("Themes").
+		OperationID("updateTheme").
+		Description("Update a theme")
+	fuego.Delete(s, "/themes/{id}", h.Delete).
+		Tags("Themes").
+		OperationID("deleteTheme").
+		Description("Delete a theme")
 
 	// Theme actions
-	fuego.Post(s, "/themes/{id}/confirm", h.Confirm,
-		fuego.OptionTags("Themes"),
-		fuego.OptionOperationID("confirmTheme"),
-		fuego.OptionDescription("Confirm a staged theme"),
-	)
+	fuego.Post(s, "/themes/{id}/confirm", h.Confirm).
+		Tags("Themes").
+		OperationID("confirmTheme").
+		Description("Confirm a staged theme")
+	fuego.Post(s, "/themes/{id}/preview", h.Preview).
+		Tags("Themes").
+		OperationID("previewThemePrompt").
+		Description("Render a theme's prompt for a photo without generating or spending credits")
 }
 
 // ListThemesResponse is the response for listing themes
@@ -93,11 +91,12 @@ func (h *ThemeHandler) ListPublic(c *fuego.ContextNoBody) (ListThemesResponse, e
 
 // CreateThemeRequest is the request for creating a theme
 type CreateThemeRequest struct {
-	Name           string          `json:"name" validate:"required"`
-	Description    *string         `json:"description,omitempty"`
-	CSSTokens      json.RawMessage `json:"css_tokens,omitempty"`
-	PromptTemplate *string         `json:"prompt_template,omitempty"`
-	IsPublic       bool            `json:"is_public"`
+	Name           string                 `json:"name" validate:"required"`
+	Description    *string                `json:"description,omitempty"`
+	CSSTokens      json.RawMessage        `json:"css_tokens,omitempty"`
+	PromptTemplate *string                `json:"prompt_template,omitempty"`
+	PromptParams   []services.PromptParam `json:"prompt_params,omitempty"`
+	IsPublic       bool                   `json:"is_public"`
 }
 
 // CreateThemeResponse is the response for creating a theme
@@ -123,10 +122,11 @@ func (h *ThemeHandler) Create(c *fuego.ContextWithBody[CreateThemeRequest]) (Cre
 		Description:    input.Description,
 		CSSTokens:      input.CSSTokens,
 		PromptTemplate: input.PromptTemplate,
+		PromptParams:   input.PromptParams,
 		IsPublic:       input.IsPublic,
 	})
 	if err != nil {
-		return CreateThemeResponse{}, err
+		return CreateThemeResponse{}, promptError(err)
 	}
 
 	return CreateThemeResponse{Theme: *theme}, nil
@@ -160,11 +160,12 @@ func (h *ThemeHandler) Get(c *fuego.ContextNoBody) (GetThemeResponse, error) {
 
 // UpdateThemeRequest is the request for updating a theme
 type UpdateThemeRequest struct {
-	Name           *string         `json:"name,omitempty"`
-	Description    *string         `json:"description,omitempty"`
-	CSSTokens      json.RawMessage `json:"css_tokens,omitempty"`
-	PromptTemplate *string         `json:"prompt_template,omitempty"`
-	IsPublic       *bool           `json:"is_public,omitempty"`
+	Name           *string                `json:"name,omitempty"`
+	Description    *string                `json:"description,omitempty"`
+	CSSTokens      json.RawMessage        `json:"css_tokens,omitempty"`
+	PromptTemplate *string                `json:"prompt_template,omitempty"`
+	PromptParams   []services.PromptParam `json:"prompt_params,omitempty"`
+	IsPublic       *bool                  `json:"is_public,omitempty"`
 }
 
 // UpdateThemeResponse is the response for updating a theme
@@ -201,10 +202,11 @@ func (h *ThemeHandler) Update(c *fuego.ContextWithBody[UpdateThemeRequest]) (Upd
 		Description:    input.Description,
 		CSSTokens:      input.CSSTokens,
 		PromptTemplate: input.PromptTemplate,
+		PromptParams:   input.PromptParams,
 		IsPublic:       input.IsPublic,
 	})
 	if err != nil {
-		return UpdateThemeResponse{}, err
+		return UpdateThemeResponse{}, promptError(err)
 	}
 
 	return UpdateThemeResponse{Theme: *theme}, nil
@@ -265,3 +267,88 @@ func (h *ThemeHandler) Confirm(c *fuego.ContextNoBody) (ConfirmThemeResponse, er
 
 	return ConfirmThemeResponse{Theme: *theme}, nil
 }
+
+// PreviewPromptRequest is the request for previewing a theme's prompt
+type PreviewPromptRequest struct {
+	PhotoID string            `json:"photo_id" validate:"required"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// PreviewPromptResponse is the response for previewing a theme's prompt
+type PreviewPromptResponse struct {
+	Prompt string `json:"prompt"`
+}
+
+// Preview renders a theme's prompt for a photo the user can access
+func (h *ThemeHandler) Preview(c *fuego.ContextWithBody[PreviewPromptRequest]) (PreviewPromptResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return PreviewPromptResponse{}, errors.New("unauthorized")
+	}
+
+	id := c.PathParam("id")
+	theme, err := h.app.ThemeService.GetByID(c.Context(), id)
+	if err != nil {
+		return PreviewPromptResponse{}, err
+	}
+	if !theme.IsPublic && (theme.UserID == nil || *theme.UserID != userID) {
+		return PreviewPromptResponse{}, errors.New("access denied")
+	}
+
+	req, err := c.Body()
+	if err != nil {
+		return PreviewPromptResponse{}, err
+	}
+
+	photo, err := h.app.PhotoService.GetByID(c.Context(), req.PhotoID)
+	if err != nil {
+		return PreviewPromptResponse{}, errors.New("photo not found")
+	}
+
+	role, err := h.app.AlbumService.GetUserRole(c.Context(), photo.AlbumID, userID)
+	if err != nil || role == "" {
+		return PreviewPromptResponse{}, errors.New("access denied")
+	}
+
+	album, err := h.app.AlbumService.GetByID(c.Context(), photo.AlbumID)
+	if err != nil {
+		return PreviewPromptResponse{}, err
+	}
+
+	prompt, err := h.app.ThemeService.RenderPrompt(theme, services.PromptVariables{
+		Photo:  photo,
+		Album:  album,
+		Params: req.Params,
+	})
+	if err != nil {
+		return PreviewPromptResponse{}, promptError(err)
+	}
+
+	return PreviewPromptResponse{Prompt: prompt}, nil
+}
+
+// promptError turns prompt template and parameter errors into bad requests. Template errors
+// carry their line and column so editors can point at the offending tag.
+func promptError(err error) error {
+	var templateErr *services.PromptTemplateError
+	if errors.As(err, &templateErr) {
+		return fuego.BadRequestError{
+			Err:    err,
+			Detail: err.Error(),
+			Errors: []fuego.ErrorItem{{
+				Name:   "prompt_template",
+				Reason: templateErr.Message,
+				More:   map[string]any{"line": templateErr.Line, "column": templateErr.Column},
+			}},
+		}
+	}
+	var paramErr *services.PromptParamError
+	if errors.As(err, &paramErr) {
+		return fuego.BadRequestError{
+			Err:    err,
+			Detail: err.Error(),
+			Errors: []fuego.ErrorItem{{Name: "params." + paramErr.Name, Reason: paramErr.Message}},
+		}
+	}
+	return err
+}
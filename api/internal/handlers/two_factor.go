@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/go-fuego/fuego"
+	"redrawn/internal/app"
+	"redrawn/internal/middleware"
+	"redrawn/internal/services"
+)
+
+// TwoFactorHandler handles the current user's two-factor authentication. The routes only
+// accept sessions, so an API key can't turn 2FA off, and routes taking a code are rate
+// limited and count wrong codes towards locking the account like signing in does.
+type TwoFactorHandler struct {
+	app *app.App
+}
+
+// NewTwoFactorHandler creates a new TwoFactorHandler
+func NewTwoFactorHandler(a *app.App) *TwoFactorHandler {
+	return &TwoFactorHandler{app: a}
+}
+
+// RegisterRoutes registers two-factor authentication routes
+func (h *TwoFactorHandler) RegisterRoutes(s *fuego.Server) {
+	fuego.Get(s, "/me/2fa", h.Status).
+		Tags("Auth").
+		OperationID("getTwoFactorStatus").
+		Description("Get whether two-factor authentication is on and how many recovery codes are left")
+	fuego.Post(s, "/me/2fa/enroll", h.Enroll).
+		Tags("Auth").
+		OperationID("enrollTwoFactor").
+		Description("Generate a TOTP secret to add to an authenticator app; 2FA stays off until it's enabled with a code")
+	fuego.Post(s, "/me/2fa/enable", h.Enable).
+		Tags("Auth").
+		OperationID("enableTwoFactor").
+		Description("Turn on two-factor authentication with a code from the enrolled app; returns recovery codes, which aren't shown again")
+	fuego.Post(s, "/me/2fa/disable", h.Disable, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("disableTwoFactor").
+		Description("Turn off two-factor authentication with a current code or a recovery code; admins can't")
+	fuego.Post(s, "/me/2fa/recovery-codes", h.RegenerateRecoveryCodes, middleware.RateLimit(h.app.RateLimiter, services.RateLimitAuth)).
+		Tags("Auth").
+		OperationID("regenerateRecoveryCodes").
+		Description("Replace the recovery codes, given a current code or a recovery code")
+}
+
+// TwoFactorStatusResponse is the current user's two-factor authentication status
+type TwoFactorStatusResponse struct {
+	services.TwoFactorStatus
+	Required bool `json:"required"` // admins must have 2FA on to use admin routes
+}
+
+// TwoFactorCodeRequest carries a code from an authenticator app, or a recovery code
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RecoveryCodesResponse holds new recovery codes
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Status returns the current user's two-factor authentication status
+func (h *TwoFactorHandler) Status(c *fuego.ContextNoBody) (TwoFactorStatusResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return TwoFactorStatusResponse{}, errors.New("unauthorized")
+	}
+
+	status, err := h.app.TwoFactorService.Status(c.Context(), userID)
+	if err != nil {
+		return TwoFactorStatusResponse{}, err
+	}
+	return TwoFactorStatusResponse{
+		TwoFactorStatus: *status,
+		Required:        slices.Contains(h.app.Config.AdminUserIDs, userID),
+	}, nil
+}
+
+// Enroll generates a TOTP secret for the current user
+func (h *TwoFactorHandler) Enroll(c *fuego.ContextNoBody) (services.TwoFactorEnrollment, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return services.TwoFactorEnrollment{}, errors.New("unauthorized")
+	}
+
+	user, err := h.app.UserService.GetByID(c.Context(), userID)
+	if err != nil {
+		return services.TwoFactorEnrollment{}, err
+	}
+	enrollment, err := h.app.TwoFactorService.BeginEnrollment(c.Context(), user)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+			return services.TwoFactorEnrollment{}, fuego.BadRequestError{Detail: err.Error()}
+		}
+		return services.TwoFactorEnrollment{}, err
+	}
+	return *enrollment, nil
+}
+
+// Enable turns on two-factor authentication for the current user
+func (h *TwoFactorHandler) Enable(c *fuego.ContextWithBody[TwoFactorCodeRequest]) (RecoveryCodesResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return RecoveryCodesResponse{}, errors.New("unauthorized")
+	}
+
+	input, err := c.Body()
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+
+	codes, err := h.app.TwoFactorService.Enable(c.Context(), userID, input.Code)
+	if err != nil {
+		return RecoveryCodesResponse{}, twoFactorError(err)
+	}
+	return RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// Disable turns off two-factor authentication for the current user
+func (h *TwoFactorHandler) Disable(c *fuego.ContextWithBody[TwoFactorCodeRequest]) (any, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return nil, errors.New("unauthorized")
+	}
+	if slices.Contains(h.app.Config.AdminUserIDs, userID) {
+		return nil, fuego.ForbiddenError{Detail: "admins must keep two-factor authentication on"}
+	}
+
+	input, err := c.Body()
+	if err != nil {
+		return nil, err
+	}
+
+	err = h.withLockout(c, userID, func() error {
+		return h.app.TwoFactorService.Disable(c.Context(), userID, input.Code)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"status": "disabled"}, nil
+}
+
+// RegenerateRecoveryCodes replaces the current user's recovery codes
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *fuego.ContextWithBody[TwoFactorCodeRequest]) (RecoveryCodesResponse, error) {
+	userID := getUserIDFromContext(c.Context())
+	if userID == "" {
+		return RecoveryCodesResponse{}, errors.New("unauthorized")
+	}
+
+	input, err := c.Body()
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+
+	var codes []string
+	err = h.withLockout(c, userID, func() error {
+		var err error
+		codes, err = h.app.TwoFactorService.RegenerateRecoveryCodes(c.Context(), userID, input.Code)
+		return err
+	})
+	if err != nil {
+		return RecoveryCodesResponse{}, err
+	}
+	return RecoveryCodesResponse{RecoveryCodes: codes}, nil
+}
+
+// withLockout runs verify, which checks a code from the current user, under the lockout of
+// signing in: locked users get a 429, and wrong codes count towards locking the account
+func (h *TwoFactorHandler) withLockout(c *fuego.ContextWithBody[TwoFactorCodeRequest], userID string, verify func() error) error {
+	lockouts := h.app.LoginLockoutService
+	if err := lockouts.Check(c.Context(), userID); err != nil {
+		var limited *services.RateLimitError
+		if errors.As(err, &limited) {
+			return tooManyRequests(c.Response(), limited)
+		}
+		return err
+	}
+
+	if err := verify(); err != nil {
+		if errors.Is(err, services.ErrInvalidTwoFactorCode) {
+			user, err := h.app.UserService.GetByID(c.Context(), userID)
+			if err != nil {
+				return err
+			}
+			if err := lockouts.RecordFailure(c.Context(), user); err != nil {
+				return err
+			}
+		}
+		return twoFactorError(err)
+	}
+	return lockouts.RecordSuccess(c.Context(), userID)
+}
+
+// twoFactorError turns the two-factor service's errors about the request into bad requests
+func twoFactorError(err error) error {
+	if errors.Is(err, services.ErrInvalidTwoFactorCode) ||
+		errors.Is(err, services.ErrTwoFactorNotEnabled) ||
+		errors.Is(err, services.ErrTwoFactorAlreadyEnabled) ||
+		errors.Is(err, services.ErrTwoFactorNotEnrolled) {
+		return fuego.BadRequestError{Detail: err.Error()}
+	}
+	return err
+}
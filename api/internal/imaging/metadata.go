@@ -0,0 +1,405 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+	"golang.org/x/image/draw"
+)
+
+// Metadata holds the EXIF properties ingestion keeps from an image
+type Metadata struct {
+	TakenAt     *time.Time // camera wall-clock time, in UTC for lack of a time zone
+	CameraMake  string
+	CameraModel string
+	Orientation int // EXIF orientation, 1 (upright) to 8
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// exifTimeLayout is the format of EXIF date and time tags
+const exifTimeLayout = "2006:01:02 15:04:05"
+
+// ReadMetadata reads the EXIF of a JPEG, PNG or WebP image. Images without EXIF, or
+// with EXIF that can't be parsed, give empty Metadata with an upright orientation.
+func ReadMetadata(data []byte, mimeType string) Metadata {
+	meta := Metadata{Orientation: 1}
+
+	payload := exifPayload(data, mimeType)
+	if payload == nil || checkEXIF(payload) != nil {
+		return meta
+	}
+	// Errors in optional directories (GPS, interoperability) still leave the rest usable
+	x, err := exif.Decode(bytes.NewReader(payload))
+	if x == nil || (err != nil && exif.IsCriticalError(err)) {
+		return meta
+	}
+
+	if tag, err := x.Get(exif.Orientation); err == nil {
+		if o, err := tag.Int(0); err == nil && o >= 1 && o <= 8 {
+			meta.Orientation = o
+		}
+	}
+	meta.CameraMake = exifString(x, exif.Make)
+	meta.CameraModel = exifString(x, exif.Model)
+
+	for _, field := range []exif.FieldName{exif.DateTimeOriginal, exif.DateTime} {
+		if t, err := time.Parse(exifTimeLayout, exifString(x, field)); err == nil {
+			meta.TakenAt = &t
+			break
+		}
+	}
+
+	if lat, long, err := x.LatLong(); err == nil && lat >= -90 && lat <= 90 && long >= -180 && long <= 180 {
+		meta.Latitude, meta.Longitude = &lat, &long
+	}
+
+	return meta
+}
+
+var (
+	// errBadEXIF is returned for EXIF structure goexif fails to decode
+	errBadEXIF = errors.New("malformed EXIF")
+	// errUnsafeEXIF is returned for EXIF goexif would loop on or run out of memory decoding
+	errUnsafeEXIF = errors.New("unsafe EXIF")
+)
+
+// maxEXIFDirs bounds the directories checkEXIF visits
+const maxEXIFDirs = 64
+
+// TIFF tags pointing at the EXIF, GPS and interoperability sub-directories
+var exifSubDirTags = map[uint16]bool{0x8769: true, 0x8825: true, 0xa005: true}
+
+// exifTypeSizes holds the size of each TIFF value type; unknown types have none
+var exifTypeSizes = map[uint16]uint64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// checkEXIF walks the directories of an EXIF block before goexif decodes it. goexif only
+// notices a directory linking to itself and loops forever on longer cycles, and sizes tag
+// values in 32 bits, so a large count can wrap and make it allocate gigabytes. Broken
+// sub-directories are let through, since goexif skips them and keeps the main directory.
+func checkEXIF(payload []byte) error {
+	data := bytes.TrimPrefix(payload, []byte("Exif\x00\x00"))
+	if len(data) < 8 {
+		return errBadEXIF
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return errBadEXIF
+	}
+	if order.Uint16(data[2:]) != 42 {
+		return errBadEXIF
+	}
+	size := uint64(len(data))
+
+	visited := make(map[uint32]bool)
+	// dir checks the directory at offset the way goexif reads it, stopping at the first bad
+	// entry, and returns the offset of the next directory in its chain and of the
+	// sub-directories it points at
+	dir := func(offset uint32) (uint32, []uint32, error) {
+		if visited[offset] || len(visited) >= maxEXIFDirs {
+			return 0, nil, errUnsafeEXIF
+		}
+		visited[offset] = true
+
+		start := uint64(offset)
+		if start+2 > size {
+			return 0, nil, errBadEXIF
+		}
+		end := start + 2 + uint64(order.Uint16(data[start:]))*12
+		if end+4 > size {
+			return 0, nil, errBadEXIF
+		}
+
+		var subDirs []uint32
+		for entry := start + 2; entry < end; entry += 12 {
+			tag, typ := order.Uint16(data[entry:]), order.Uint16(data[entry+2:])
+			valueSize := exifTypeSizes[typ] * uint64(order.Uint32(data[entry+4:]))
+			value := order.Uint32(data[entry+8:])
+			if valueSize >= 1<<32 {
+				return 0, nil, errUnsafeEXIF
+			}
+			if valueSize > 4 && uint64(value)+valueSize > size {
+				return 0, nil, errBadEXIF
+			}
+			if exifSubDirTags[tag] {
+				subDirs = append(subDirs, value)
+			}
+		}
+		return order.Uint32(data[end:]), subDirs, nil
+	}
+
+	var pending []uint32
+	for offset := order.Uint32(data[4:]); offset != 0; {
+		next, subDirs, err := dir(offset)
+		if err != nil {
+			return err
+		}
+		pending = append(pending, subDirs...)
+		offset = next
+	}
+	// goexif decodes each sub-directory once, without following its chain
+	for len(pending) > 0 {
+		offset := pending[0]
+		pending = pending[1:]
+		if visited[offset] {
+			continue
+		}
+		_, subDirs, err := dir(offset)
+		if errors.Is(err, errUnsafeEXIF) {
+			return err
+		}
+		pending = append(pending, subDirs...)
+	}
+	return nil
+}
+
+func exifString(x *exif.Exif, field exif.FieldName) string {
+	tag, err := x.Get(field)
+	if err != nil || tag.Format() != tiff.StringVal {
+		return ""
+	}
+	s, _ := tag.StringVal()
+	return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+}
+
+// Orient rotates and flips img as described by an EXIF orientation, so it displays upright
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap width and height
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180°
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90° clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90° counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			i, j := src.PixOffset(x, y), dst.PixOffset(dx, dy)
+			copy(dst.Pix[j:j+4], src.Pix[i:i+4])
+		}
+	}
+	return dst
+}
+
+// errMalformed is returned when an image's container structure can't be walked
+var errMalformed = errors.New("malformed image container")
+
+// StripMetadata returns a copy of a JPEG, PNG or WebP image without EXIF, XMP, IPTC or
+// text comments. Pixel data and what affects rendering, such as ICC profiles, are kept
+// byte for byte. It reports false when there was nothing to strip.
+func StripMetadata(data []byte, mimeType string) ([]byte, bool, error) {
+	var (
+		stripped []byte
+		err      error
+	)
+	switch mimeType {
+	case "image/jpeg":
+		stripped, err = stripJPEG(data)
+	case "image/png":
+		stripped, err = stripPNG(data)
+	case "image/webp":
+		stripped, err = stripWebP(data)
+	default:
+		return data, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return stripped, len(stripped) != len(data), nil
+}
+
+// exifPayload returns the raw EXIF block of an image, or nil if it has none
+func exifPayload(data []byte, mimeType string) []byte {
+	var payload []byte
+	find := func(id string, body []byte) bool {
+		switch {
+		case mimeType == "image/jpeg" && id == "\xe1" && bytes.HasPrefix(body, []byte("Exif\x00\x00")),
+			mimeType == "image/png" && id == "eXIf",
+			mimeType == "image/webp" && id == "EXIF":
+			payload = body
+		}
+		return true
+	}
+
+	switch mimeType {
+	case "image/jpeg":
+		_, _ = walkJPEG(data, find)
+	case "image/png":
+		_, _ = walkPNG(data, find)
+	case "image/webp":
+		_, _ = walkWebP(data, find)
+	}
+	return payload
+}
+
+// JPEG segments carrying metadata: APP1 (EXIF, XMP), APP13 (IPTC) and comments
+var jpegMetadataMarkers = map[byte]bool{0xe1: true, 0xed: true, 0xfe: true}
+
+func stripJPEG(data []byte) ([]byte, error) {
+	return walkJPEG(data, func(id string, _ []byte) bool { return !jpegMetadataMarkers[id[0]] })
+}
+
+// walkJPEG calls keep with the marker and body of every segment before the image data,
+// and returns the image rebuilt from the segments it kept
+func walkJPEG(data []byte, keep func(id string, body []byte) bool) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errMalformed
+	}
+	out := append(make([]byte, 0, len(data)), data[:2]...)
+
+	for i := 2; i < len(data); {
+		if data[i] != 0xff {
+			return nil, errMalformed
+		}
+		// Markers may be preceded by any number of fill bytes
+		for i < len(data) && data[i] == 0xff {
+			i++
+		}
+		if i >= len(data) {
+			return nil, errMalformed
+		}
+		marker := data[i]
+		start := i - 1
+		i++
+
+		// Start of scan: the rest is entropy-coded data and trailing segments, kept as is
+		if marker == 0xda {
+			return append(out, data[start:]...), nil
+		}
+		// Standalone markers carry no length
+		if marker == 0x01 || (marker >= 0xd0 && marker <= 0xd9) {
+			out = append(out, 0xff, marker)
+			continue
+		}
+
+		if i+2 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, errMalformed
+		}
+		if keep(string([]byte{marker}), data[i+2:i+length]) {
+			out = append(out, 0xff, marker)
+			out = append(out, data[i:i+length]...)
+		}
+		i += length
+	}
+	return out, nil
+}
+
+// PNG chunks carrying metadata: EXIF, text (which holds XMP) and the modification time
+var pngMetadataChunks = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(data []byte) ([]byte, error) {
+	return walkPNG(data, func(id string, _ []byte) bool { return !pngMetadataChunks[id] })
+}
+
+// pngSignature starts every PNG file
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// walkPNG calls keep with the type and body of every chunk and returns the image rebuilt
+// from the chunks it kept
+func walkPNG(data []byte, keep func(id string, body []byte) bool) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errMalformed
+	}
+	out := append(make([]byte, 0, len(data)), pngSignature...)
+
+	for i := len(pngSignature); i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length // length, type, body and CRC
+		if end > len(data) {
+			return nil, errMalformed
+		}
+		if keep(string(data[i+4:i+8]), data[i+8:i+8+length]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// WebP chunks carrying metadata, and the VP8X flags announcing them
+var webpMetadataChunks = map[string]byte{"EXIF": 0x08, "XMP ": 0x04}
+
+func stripWebP(data []byte) ([]byte, error) {
+	out, err := walkWebP(data, func(id string, _ []byte) bool { return webpMetadataChunks[id] == 0 })
+	if err != nil {
+		return nil, err
+	}
+
+	// The extended header must stop announcing the chunks that were removed
+	if len(out) >= 21 && string(out[12:16]) == "VP8X" {
+		for _, flag := range webpMetadataChunks {
+			out[20] &^= flag
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// walkWebP calls keep with the FourCC and body of every chunk and returns the image
+// rebuilt from the chunks it kept
+func walkWebP(data []byte, keep func(id string, body []byte) bool) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, errMalformed
+	}
+	out := append(make([]byte, 0, len(data)), data[:12]...)
+
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, errMalformed
+		}
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // chunks are padded to an even size
+		if i+8+size > len(data) {
+			return nil, errMalformed
+		}
+		end = min(end, len(data))
+		if keep(string(data[i:i+4]), data[i+8:i+8+size]) {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
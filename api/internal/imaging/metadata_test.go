@@ -0,0 +1,323 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testEntry is a directory entry of a test EXIF block. A non-zero dir replaces the value
+// with the offset of that directory, counting from 1.
+type testEntry struct {
+	tag, typ     uint16
+	count, value uint32
+	dir          int
+}
+
+// testIFD is a directory of a test EXIF block, linking to the next directory in its chain
+// (counting from 1) or to none with 0
+type testIFD struct {
+	entries []testEntry
+	next    int
+}
+
+// orientationEntry is the EXIF orientation tag, a single SHORT
+func orientationEntry(o uint32) testEntry {
+	return testEntry{tag: 0x0112, typ: 3, count: 1, value: o}
+}
+
+// buildEXIF lays out little-endian TIFF directories one after the other behind the header
+// and returns them as the body of a JPEG APP1 segment
+func buildEXIF(ifds ...testIFD) []byte {
+	offsets := make([]uint32, len(ifds)+1)
+	offset := uint32(8)
+	for i, ifd := range ifds {
+		offsets[i+1] = offset
+		offset += 2 + 12*uint32(len(ifd.entries)) + 4
+	}
+
+	le := binary.LittleEndian
+	data := []byte("II*\x00")
+	data = le.AppendUint32(data, offsets[min(1, len(ifds))])
+	for _, ifd := range ifds {
+		data = le.AppendUint16(data, uint16(len(ifd.entries)))
+		for _, e := range ifd.entries {
+			value := e.value
+			if e.dir != 0 {
+				value = offsets[e.dir]
+			}
+			data = le.AppendUint16(data, e.tag)
+			data = le.AppendUint16(data, e.typ)
+			data = le.AppendUint32(data, e.count)
+			data = le.AppendUint32(data, value)
+		}
+		next := uint32(0)
+		if ifd.next != 0 {
+			next = offsets[ifd.next]
+		}
+		data = le.AppendUint32(data, next)
+	}
+	return append([]byte("Exif\x00\x00"), data...)
+}
+
+// withAPP1 inserts an APP1 segment with body right after the start of a JPEG
+func withAPP1(jpegData, body []byte) []byte {
+	segment := []byte{0xff, 0xe1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(body)+2))
+	segment = append(segment, body...)
+	out := append([]byte{}, jpegData[:2]...)
+	out = append(out, segment...)
+	return append(out, jpegData[2:]...)
+}
+
+// withPNGChunk inserts a chunk right after the IHDR chunk of a PNG
+func withPNGChunk(pngData []byte, typ string, body []byte) []byte {
+	const afterIHDR = 8 + 12 + 13
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(body)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+	out := append([]byte{}, pngData[:afterIHDR]...)
+	out = append(out, chunk...)
+	return append(out, pngData[afterIHDR:]...)
+}
+
+func TestReadMetadataOrientation(t *testing.T) {
+	jpegData := encodeJPEG(t, testImage(4, 4))
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{name: "no EXIF", data: jpegData, want: 1},
+		{name: "no orientation tag", data: withAPP1(jpegData, buildEXIF(testIFD{entries: []testEntry{{tag: 0x010f, typ: 2, count: 2, value: 'X'}}})), want: 1},
+		{name: "out of range", data: withAPP1(jpegData, buildEXIF(testIFD{entries: []testEntry{orientationEntry(9)}})), want: 1},
+	}
+	for o := 1; o <= 8; o++ {
+		tests = append(tests, struct {
+			name string
+			data []byte
+			want int
+		}{
+			name: "orientation " + string(rune('0'+o)),
+			data: withAPP1(jpegData, buildEXIF(testIFD{entries: []testEntry{orientationEntry(uint32(o))}})),
+			want: o,
+		})
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReadMetadata(tt.data, "image/jpeg").Orientation; got != tt.want {
+				t.Errorf("Orientation = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrient(t *testing.T) {
+	// A 3×2 image stored as
+	//
+	//	a b c
+	//	d e f
+	//
+	// and the rows it displays as for each orientation
+	palette := map[byte]color.RGBA{
+		'a': {255, 0, 0, 255}, 'b': {0, 255, 0, 255}, 'c': {0, 0, 255, 255},
+		'd': {255, 255, 0, 255}, 'e': {0, 255, 255, 255}, 'f': {255, 0, 255, 255},
+	}
+	stored := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	for i, p := range []byte("abcdef") {
+		stored.Set(i%3, i/3, palette[p])
+	}
+
+	tests := []struct {
+		orientation int
+		want        []string
+	}{
+		{0, []string{"abc", "def"}}, // missing, treated as upright
+		{1, []string{"abc", "def"}},
+		{2, []string{"cba", "fed"}},
+		{3, []string{"fed", "cba"}},
+		{4, []string{"def", "abc"}},
+		{5, []string{"ad", "be", "cf"}},
+		{6, []string{"da", "eb", "fc"}},
+		{7, []string{"fc", "eb", "da"}},
+		{8, []string{"cf", "be", "ad"}},
+		{9, []string{"abc", "def"}}, // invalid, left as is
+	}
+	for _, tt := range tests {
+		got := Orient(stored, tt.orientation)
+		b := got.Bounds()
+		if b.Dx() != len(tt.want[0]) || b.Dy() != len(tt.want) {
+			t.Errorf("orientation %d: size %dx%d, want %dx%d", tt.orientation, b.Dx(), b.Dy(), len(tt.want[0]), len(tt.want))
+			continue
+		}
+		for y, row := range tt.want {
+			for x := range row {
+				if c := color.RGBAModel.Convert(got.At(b.Min.X+x, b.Min.Y+y)); c != palette[row[x]] {
+					t.Errorf("orientation %d: pixel (%d, %d) = %v, want %c", tt.orientation, x, y, c, row[x])
+				}
+			}
+		}
+	}
+}
+
+func TestCheckEXIF(t *testing.T) {
+	orientation := orientationEntry(6)
+	gpsPointer := func(dir int) testEntry { return testEntry{tag: 0x8825, typ: 4, count: 1, dir: dir} }
+	// A GPS latitude reference, one ASCII value stored inline
+	gpsEntry := testEntry{tag: 0x0001, typ: 2, count: 2, value: 'N'}
+
+	tests := []struct {
+		name            string
+		exif            []byte
+		wantErr         error
+		wantOrientation int
+	}{
+		{
+			name:            "valid",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "chain of two",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation}, next: 2}, testIFD{entries: []testEntry{{tag: 0x0103, typ: 3, count: 1, value: 6}}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "GPS sub-directory",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, gpsPointer(2)}}, testIFD{entries: []testEntry{gpsEntry}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "sub-directory pointing back at the main one",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, gpsPointer(1)}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "sub-directory past the end",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, {tag: 0x8825, typ: 4, count: 1, value: 1 << 20}}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "sub-directory value past the end",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, gpsPointer(2)}}, testIFD{entries: []testEntry{{tag: 0x0002, typ: 5, count: 3, value: 1 << 20}}}),
+			wantOrientation: 6,
+		},
+		{
+			name:            "too short",
+			exif:            []byte("Exif\x00\x00II*\x00"),
+			wantErr:         errBadEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "unknown byte order",
+			exif:            append([]byte("Exif\x00\x00XX*\x00"), buildEXIF(testIFD{entries: []testEntry{orientation}})[10:]...),
+			wantErr:         errBadEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "directory past the end",
+			exif:            append([]byte("Exif\x00\x00II*\x00"), 0xff, 0xff, 0, 0),
+			wantErr:         errBadEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name: "truncated directory",
+			exif: func() []byte {
+				data := buildEXIF(testIFD{entries: []testEntry{orientation, orientation}})
+				return data[:len(data)-10]
+			}(),
+			wantErr:         errBadEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "value past the end",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, {tag: 0x010f, typ: 2, count: 64, value: 1 << 20}}}),
+			wantErr:         errBadEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "directory linking to itself",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation}, next: 1}),
+			wantErr:         errUnsafeEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "chain looping back",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation}, next: 2}, testIFD{next: 1}),
+			wantErr:         errUnsafeEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "count wrapping in 32 bits",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, {tag: 0x0110, typ: 4, count: 1<<30 + 1, value: 0}}}),
+			wantErr:         errUnsafeEXIF,
+			wantOrientation: 1,
+		},
+		{
+			name:            "count wrapping in a sub-directory",
+			exif:            buildEXIF(testIFD{entries: []testEntry{orientation, gpsPointer(2)}}, testIFD{entries: []testEntry{{tag: 0x0002, typ: 5, count: 1 << 29, value: 0}}}),
+			wantErr:         errUnsafeEXIF,
+			wantOrientation: 1,
+		},
+	}
+
+	jpegData := encodeJPEG(t, testImage(4, 4))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkEXIF(tt.exif); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkEXIF() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := ReadMetadata(withAPP1(jpegData, tt.exif), "image/jpeg").Orientation; got != tt.wantOrientation {
+				t.Errorf("Orientation = %d, want %d", got, tt.wantOrientation)
+			}
+		})
+	}
+}
+
+func TestStripMetadata(t *testing.T) {
+	img := testImage(4, 4)
+	jpegData := encodeJPEG(t, img)
+	pngData := encodePNG(t, img)
+	exifBlock := buildEXIF(testIFD{entries: []testEntry{orientationEntry(6)}})
+
+	tests := []struct {
+		name         string
+		data         []byte
+		mimeType     string
+		want         []byte
+		wantStripped bool
+		wantErr      bool
+	}{
+		{name: "jpeg with EXIF", data: withAPP1(jpegData, exifBlock), mimeType: "image/jpeg", want: jpegData, wantStripped: true},
+		{name: "jpeg without metadata", data: jpegData, mimeType: "image/jpeg", want: jpegData},
+		{name: "png with text", data: withPNGChunk(pngData, "tEXt", []byte("Comment\x00hello")), mimeType: "image/png", want: pngData, wantStripped: true},
+		{name: "png with EXIF", data: withPNGChunk(pngData, "eXIf", exifBlock[6:]), mimeType: "image/png", want: pngData, wantStripped: true},
+		{name: "png without metadata", data: pngData, mimeType: "image/png", want: pngData},
+		{name: "unsupported type", data: []byte("GIF89a"), mimeType: "image/gif", want: []byte("GIF89a")},
+		{name: "truncated jpeg segment", data: withAPP1(jpegData, exifBlock)[:20], mimeType: "image/jpeg", wantErr: true},
+		{name: "not a png", data: jpegData, mimeType: "image/png", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, stripped, err := StripMetadata(tt.data, tt.mimeType)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StripMetadata() error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if stripped != tt.wantStripped {
+				t.Errorf("stripped = %v, want %v", stripped, tt.wantStripped)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("StripMetadata() returned %d bytes, want the %d bytes without metadata", len(got), len(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+)
+
+// hashSampleEdge is the size images are reduced to before hashing, large enough for the
+// grid averages to be stable and small enough to be cheap
+const hashSampleEdge = 256
+
+// PerceptualHash computes a 64-bit difference hash (dHash) of img: the image is reduced to
+// a 9×8 grid of average brightness, and each bit records whether a cell is brighter than
+// its right neighbour. Re-encoded, resized or slightly edited copies of an image get
+// hashes a few bits apart; compare them with HashDistance.
+func PerceptualHash(img image.Image) uint64 {
+	small := Fit(img, hashSampleEdge)
+	b := small.Bounds()
+
+	const cols, rows = 9, 8
+	var sums [rows][cols]float64
+	var counts [rows][cols]int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := (y - b.Min.Y) * rows / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			col := (x - b.Min.X) * cols / b.Dx()
+			sums[row][col] += float64(color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y)
+			counts[row][col]++
+		}
+	}
+
+	var hash uint64
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols-1; col++ {
+			left := sums[row][col] / float64(max(1, counts[row][col]))
+			right := sums[row][col+1] / float64(max(1, counts[row][col+1]))
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HashDistance is the number of bits two perceptual hashes differ by, from 0 (same image)
+// to 64
+func HashDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
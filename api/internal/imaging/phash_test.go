@@ -0,0 +1,79 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+// testScene draws a w×h image of soft shapes: a dark disc on a diagonal gradient, or with
+// alt set, bright bands on a darker background
+func testScene(w, h int, alt bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			var v float64
+			if alt {
+				v = 0.3 + 0.5*math.Max(0, math.Sin(fy*3*math.Pi)*math.Cos(fx*2*math.Pi))
+			} else {
+				v = 0.2 + 0.6*(fx+fy)/2
+				if math.Hypot(fx-0.35, fy-0.5) < 0.2 {
+					v = 0.05
+				}
+			}
+			g := uint8(v * 255)
+			img.Set(x, y, color.RGBA{g, uint8(float64(g) * 0.9), uint8(float64(g) * 0.8), 255})
+		}
+	}
+	return img
+}
+
+func TestPerceptualHash(t *testing.T) {
+	original := testScene(400, 300, false)
+	reencoded, err := Decode(encodeJPEG(t, Fit(original, 120)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := PerceptualHash(original)
+
+	tests := []struct {
+		name        string
+		img         image.Image
+		maxDistance int
+		minDistance int
+	}{
+		{name: "same image", img: original, maxDistance: 0},
+		{name: "resized copy", img: Fit(original, 160), maxDistance: 4},
+		{name: "resized and re-encoded copy", img: reencoded, maxDistance: 6},
+		{name: "mirrored", img: Orient(original, 2), minDistance: 16, maxDistance: 64},
+		{name: "different image", img: testScene(400, 300, true), minDistance: 16, maxDistance: 64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := HashDistance(hash, PerceptualHash(tt.img))
+			if d < tt.minDistance || d > tt.maxDistance {
+				t.Errorf("distance = %d, want %d to %d", d, tt.minDistance, tt.maxDistance)
+			}
+		})
+	}
+}
+
+func TestHashDistance(t *testing.T) {
+	tests := []struct {
+		a, b uint64
+		want int
+	}{
+		{0, 0, 0},
+		{0xff, 0xff, 0},
+		{0, 1, 1},
+		{0xf0, 0x0f, 8},
+		{0, math.MaxUint64, 64},
+	}
+	for _, tt := range tests {
+		if got := HashDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("HashDistance(%#x, %#x) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
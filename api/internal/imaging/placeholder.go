@@ -0,0 +1,161 @@
+package imaging
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Placeholder is what clients show in place of an image while it loads
+type Placeholder struct {
+	BlurHash      string // see https://blurha.sh
+	DominantColor string // "#rrggbb"
+}
+
+// placeholderSampleEdge is the size images are reduced to before computing their
+// placeholder; both are blurry by design, so more pixels only cost time
+const placeholderSampleEdge = 64
+
+// BlurHash components along the width and height of an image
+const blurHashComponentsX, blurHashComponentsY = 4, 3
+
+// ComputePlaceholder computes the BlurHash and dominant color of img
+func ComputePlaceholder(img image.Image) Placeholder {
+	small := Fit(img, placeholderSampleEdge)
+	return Placeholder{
+		BlurHash:      blurHash(small, blurHashComponentsX, blurHashComponentsY),
+		DominantColor: dominantColor(small),
+	}
+}
+
+// blurHash encodes img as a BlurHash of componentsX×componentsY cosine components
+func blurHash(img image.Image, componentsX, componentsY int) string {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// Pixels in linear RGB, read once for every component
+	linear := make([][3]float64, 0, w*h)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			linear = append(linear, [3]float64{srgbToLinear(c.R), srgbToLinear(c.G), srgbToLinear(c.B)})
+		}
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(w)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(h))
+					p := linear[y*w+x]
+					f[0] += basis * p[0]
+					f[1] += basis * p[1]
+					f[2] += basis * p[2]
+				}
+			}
+			scale := 1 / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	writeBase83(&sb, (componentsX-1)+(componentsY-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maximum := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := int(max(0, min(82, math.Floor(actualMax*166-0.5))))
+		maximum = float64(quantisedMax+1) / 166
+		writeBase83(&sb, quantisedMax, 1)
+	} else {
+		writeBase83(&sb, 0, 1)
+	}
+
+	writeBase83(&sb, int(linearToSRGB(dc[0]))<<16|int(linearToSRGB(dc[1]))<<8|int(linearToSRGB(dc[2])), 4)
+	for _, f := range ac {
+		quantise := func(v float64) int {
+			return int(max(0, min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		writeBase83(&sb, quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2)
+	}
+	return sb.String()
+}
+
+const base83Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// writeBase83 writes value as length base83 digits, most significant first
+func writeBase83(sb *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		sb.WriteByte(base83Alphabet[digit])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(f float64) uint8 {
+	v := max(0, min(1, f))
+	if v <= 0.0031308 {
+		return uint8(v*12.92*255 + 0.5)
+	}
+	return uint8((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+// dominantColor returns the average of the most common group of similar colors in img,
+// ignoring mostly transparent pixels. An image with no opaque pixel gives black.
+func dominantColor(img image.Image) string {
+	// Colors are grouped by the top 3 bits of each channel
+	type bucket struct {
+		r, g, b, n int
+	}
+	var buckets [512]bucket
+	best := -1
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A < 128 {
+				continue
+			}
+			i := int(c.R>>5)<<6 | int(c.G>>5)<<3 | int(c.B>>5)
+			buckets[i].r += int(c.R)
+			buckets[i].g += int(c.G)
+			buckets[i].b += int(c.B)
+			buckets[i].n++
+			if best < 0 || buckets[i].n > buckets[best].n {
+				best = i
+			}
+		}
+	}
+
+	if best < 0 {
+		return "#000000"
+	}
+	top := buckets[best]
+	return fmt.Sprintf("#%02x%02x%02x", top.r/top.n, top.g/top.n, top.b/top.n)
+}
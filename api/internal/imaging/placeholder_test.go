@@ -0,0 +1,89 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// fillImage returns a w×h image colored by f
+func fillImage(w, h int, f func(x, y int) color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, f(x, y))
+		}
+	}
+	return img
+}
+
+func TestComputePlaceholder(t *testing.T) {
+	// Expected hashes come from the reference encoder (github.com/woltapp/blurhash, C/encode.c)
+	tests := []struct {
+		name         string
+		img          image.Image
+		wantBlurHash string
+		wantDominant string
+	}{
+		{
+			name: "gradient",
+			img: fillImage(6, 4, func(x, y int) color.Color {
+				return color.RGBA{uint8(x * 40), uint8(y * 60), uint8(200 - x*20 - y*10), 255}
+			}),
+			wantBlurHash: "LiEL]o7jSR%4*[NOWsrxeIe?fRe?",
+			wantDominant: "#0000c8",
+		},
+		{
+			name: "two halves",
+			img: fillImage(8, 4, func(x, y int) color.Color {
+				if x < 4 {
+					return color.RGBA{220, 40, 40, 255}
+				}
+				return color.RGBA{30, 60, 200, 255}
+			}),
+			wantBlurHash: "L~IwkR|C#%Sk$m#+sSbJfQfQfQfQ",
+			// Both colors cover half of the image, and the first to reach that count wins
+			wantDominant: "#dc2828",
+		},
+		{
+			name:         "solid white",
+			img:          fillImage(4, 3, func(x, y int) color.Color { return color.White }),
+			wantBlurHash: "L~TSUA~qfQ~q~q%MfQ%MfQfQfQfQ",
+			wantDominant: "#ffffff",
+		},
+		{
+			// Without an opaque pixel the dominant color falls back to black
+			name:         "transparent",
+			img:          image.NewNRGBA(image.Rect(0, 0, 4, 3)),
+			wantBlurHash: "L00000fQfQfQfQfQfQfQfQfQfQfQ",
+			wantDominant: "#000000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ComputePlaceholder(tt.img)
+			if got.BlurHash != tt.wantBlurHash {
+				t.Errorf("BlurHash = %q, want %q", got.BlurHash, tt.wantBlurHash)
+			}
+			if got.DominantColor != tt.wantDominant {
+				t.Errorf("DominantColor = %q, want %q", got.DominantColor, tt.wantDominant)
+			}
+		})
+	}
+}
+
+func TestBlurHashComponents(t *testing.T) {
+	img := fillImage(6, 4, func(x, y int) color.Color {
+		return color.RGBA{uint8(x * 40), uint8(y * 60), uint8(200 - x*20 - y*10), 255}
+	})
+	// A single component is the average color alone: size flag, an unused maximum and the DC
+	if got, want := blurHash(img, 1, 1), "00EL]o"; got != want {
+		t.Errorf("blurHash(1×1) = %q, want %q", got, want)
+	}
+	// Every AC component adds two characters after the six of the header and DC
+	for _, c := range []struct{ x, y int }{{4, 3}, {3, 4}, {9, 9}} {
+		if got, want := len(blurHash(img, c.x, c.y)), 6+2*(c.x*c.y-1); got != want {
+			t.Errorf("blurHash(%d×%d) has %d characters, want %d", c.x, c.y, got, want)
+		}
+	}
+}
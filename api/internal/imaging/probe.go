@@ -0,0 +1,79 @@
+// Package imaging inspects and transforms uploaded images
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF decoder
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"net/http"
+
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+var (
+	// ErrUnsupportedType is returned for content that is not a supported image format
+	ErrUnsupportedType = errors.New("unsupported image type")
+	// ErrCorrupt is returned when an image cannot be decoded
+	ErrCorrupt = errors.New("corrupt image")
+	// ErrTooLarge is returned when an image exceeds the pixel limit
+	ErrTooLarge = errors.New("image too large")
+)
+
+// supportedTypes maps sniffed content types to the decoder format name
+var supportedTypes = map[string]string{
+	"image/jpeg": "jpeg",
+	"image/png":  "png",
+	"image/gif":  "gif",
+	"image/webp": "webp",
+}
+
+// Info describes a validated image
+type Info struct {
+	MimeType  string
+	SizeBytes int64
+	Width     int
+	Height    int
+	Image     image.Image // decoded pixels, for deriving resized copies
+}
+
+// Probe sniffs the real content type of data, reads its dimensions and fully decodes
+// it to make sure it is not truncated or corrupt. Images with more than maxPixels
+// pixels are rejected before the full decode, so oversized uploads can't exhaust memory.
+func Probe(data []byte, maxPixels int) (*Info, error) {
+	mimeType := http.DetectContentType(data)
+	format, ok := supportedTypes[mimeType]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, mimeType)
+	}
+
+	cfg, decodedFormat, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if decodedFormat != format {
+		return nil, fmt.Errorf("%w: content looks like %s but decodes as %s", ErrCorrupt, format, decodedFormat)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 {
+		return nil, fmt.Errorf("%w: invalid dimensions %dx%d", ErrCorrupt, cfg.Width, cfg.Height)
+	}
+	if maxPixels > 0 && cfg.Width*cfg.Height > maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds the %d pixel limit", ErrTooLarge, cfg.Width, cfg.Height, maxPixels)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	return &Info{
+		MimeType:  mimeType,
+		SizeBytes: int64(len(data)),
+		Width:     cfg.Width,
+		Height:    cfg.Height,
+		Image:     img,
+	}, nil
+}
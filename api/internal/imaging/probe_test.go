@@ -0,0 +1,108 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// webp1x1 is a 1×1 lossless WebP
+const webp1x1 = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+// testImage returns a w×h image with a different color in every pixel
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 255 / max(1, w-1)), uint8(y * 255 / max(1, h-1)), 128, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := gif.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProbe(t *testing.T) {
+	img := testImage(3, 2)
+	pngData := encodePNG(t, img)
+	webpData, err := base64.StdEncoding.DecodeString(webp1x1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		data       []byte
+		maxPixels  int
+		wantType   string
+		wantWidth  int
+		wantHeight int
+		wantErr    error
+	}{
+		{name: "png", data: pngData, wantType: "image/png", wantWidth: 3, wantHeight: 2},
+		{name: "jpeg", data: encodeJPEG(t, img), wantType: "image/jpeg", wantWidth: 3, wantHeight: 2},
+		{name: "gif", data: encodeGIF(t, img), wantType: "image/gif", wantWidth: 3, wantHeight: 2},
+		{name: "webp", data: webpData, wantType: "image/webp", wantWidth: 1, wantHeight: 1},
+		{name: "at the pixel limit", data: pngData, maxPixels: 6, wantType: "image/png", wantWidth: 3, wantHeight: 2},
+		{name: "over the pixel limit", data: pngData, maxPixels: 5, wantErr: ErrTooLarge},
+		{name: "text", data: []byte("definitely not an image"), wantErr: ErrUnsupportedType},
+		{name: "bmp", data: []byte("BM\x1e\x00\x00\x00\x00\x00\x00\x00\x1a\x00\x00\x00"), wantErr: ErrUnsupportedType},
+		{name: "empty", data: nil, wantErr: ErrUnsupportedType},
+		{name: "truncated png", data: pngData[:len(pngData)-20], wantErr: ErrCorrupt},
+		{name: "png signature only", data: pngData[:8], wantErr: ErrCorrupt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := Probe(tt.data, tt.maxPixels)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Probe() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.MimeType != tt.wantType || info.Width != tt.wantWidth || info.Height != tt.wantHeight {
+				t.Errorf("Probe() = %s %dx%d, want %s %dx%d", info.MimeType, info.Width, info.Height, tt.wantType, tt.wantWidth, tt.wantHeight)
+			}
+			if info.SizeBytes != int64(len(tt.data)) {
+				t.Errorf("SizeBytes = %d, want %d", info.SizeBytes, len(tt.data))
+			}
+			if b := info.Image.Bounds(); b.Dx() != tt.wantWidth || b.Dy() != tt.wantHeight {
+				t.Errorf("decoded image is %dx%d", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
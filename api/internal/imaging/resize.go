@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+)
+
+// Output formats for encoded derivatives
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatPNG  = "png"
+)
+
+// DefaultQuality balances size and quality for gallery-sized images
+const DefaultQuality = 82
+
+// Decode decodes an image in any of the supported formats
+func Decode(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return img, nil
+}
+
+// Fit scales img down so its longest edge is at most maxEdge, keeping the aspect ratio.
+// Images that already fit are returned unchanged; nothing is ever upscaled.
+func Fit(img image.Image, maxEdge int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxEdge && h <= maxEdge {
+		return img
+	}
+
+	if w >= h {
+		h = max(1, h*maxEdge/w)
+		w = maxEdge
+	} else {
+		w = max(1, w*maxEdge/h)
+		h = maxEdge
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Encode encodes img in the given format at the default quality and returns the bytes and MIME type
+func Encode(img image.Image, format string) ([]byte, string, error) {
+	return EncodeQuality(img, format, DefaultQuality)
+}
+
+// EncodeQuality encodes img in the given format and returns the bytes and MIME type.
+// quality (1-100) applies to JPEG; WebP and PNG are encoded losslessly.
+func EncodeQuality(img image.Image, format string, quality int) ([]byte, string, error) {
+	var buf bytes.Buffer
+	switch format {
+	case FormatJPEG:
+		if err := jpeg.Encode(&buf, flatten(img), &jpeg.Options{Quality: quality}); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/jpeg", nil
+	case FormatWebP:
+		if err := nativewebp.Encode(&buf, img, nil); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/webp", nil
+	case FormatPNG:
+		if err := png.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	default:
+		return nil, "", fmt.Errorf("unknown image format %q", format)
+	}
+}
+
+// flatten composites img onto white, since JPEG has no alpha channel
+// and transparent pixels would otherwise come out black
+func flatten(img image.Image) image.Image {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return img
+	}
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
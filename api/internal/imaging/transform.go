@@ -0,0 +1,65 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"golang.org/x/image/draw"
+)
+
+// Fit modes for Resize
+const (
+	FitContain = "contain" // scale to fit inside the box, keeping the aspect ratio
+	FitCover   = "cover"   // scale to fill the box, cropping the overflow around the center
+	FitFill    = "fill"    // stretch to the box, ignoring the aspect ratio
+)
+
+// Resize scales img to a width×height box according to fit. A width or height of 0
+// leaves that side free, derived from the aspect ratio. Nothing is ever upscaled: boxes
+// larger than the image are clamped to it.
+func Resize(img image.Image, width, height int, fit string) (image.Image, error) {
+	b := img.Bounds()
+	srcW, srcH := float64(b.Dx()), float64(b.Dy())
+	if width <= 0 && height <= 0 {
+		return nil, errors.New("resize needs a width or a height")
+	}
+
+	// A free side follows the aspect ratio, which makes every mode a plain contain
+	boxW, boxH := float64(width), float64(height)
+	if width <= 0 {
+		boxW, fit = math.Inf(1), FitContain
+	}
+	if height <= 0 {
+		boxH, fit = math.Inf(1), FitContain
+	}
+
+	src := b
+	var dstW, dstH float64
+	switch fit {
+	case FitContain:
+		scale := math.Min(1, math.Min(boxW/srcW, boxH/srcH))
+		dstW, dstH = srcW*scale, srcH*scale
+	case FitCover:
+		scale := math.Min(1, math.Max(boxW/srcW, boxH/srcH))
+		cropW, cropH := math.Min(srcW, boxW/scale), math.Min(srcH, boxH/scale)
+		x0 := b.Min.X + int((srcW-cropW)/2)
+		y0 := b.Min.Y + int((srcH-cropH)/2)
+		src = image.Rect(x0, y0, x0+int(math.Round(cropW)), y0+int(math.Round(cropH)))
+		dstW, dstH = cropW*scale, cropH*scale
+	case FitFill:
+		dstW, dstH = math.Min(srcW, boxW), math.Min(srcH, boxH)
+	default:
+		return nil, fmt.Errorf("unknown fit %q", fit)
+	}
+
+	w, h := max(1, int(math.Round(dstW))), max(1, int(math.Round(dstH)))
+	if src == b && w == b.Dx() && h == b.Dy() {
+		return img, nil
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Src, nil)
+	return dst, nil
+}
@@ -2,27 +2,51 @@ package middleware
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"slices"
 	"strings"
 
-	"github.com/go-fuego/fuego"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // contextKey is a type for context keys
 type contextKey string
 
-const userIDKey contextKey = "userID"
+const (
+	userIDKey       contextKey = "userID"
+	sessionIDKey    contextKey = "sessionID"
+	apiKeyUserIDKey contextKey = "apiKeyUserID"
+	scopesKey       contextKey = "scopes"
+)
+
+// apiKeyPrefix starts every API key (duplicated from services.APIKeyPrefix)
+const apiKeyPrefix = "rdk_"
 
 // Claims represents JWT claims (duplicated from auth service to avoid circular import)
 type Claims struct {
-	UserID string `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// AuthMiddleware creates a middleware that validates JWT tokens
-func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
+// SessionChecker reports whether the session an access token was issued for is still
+// active, so revoking a session locks out its access tokens before they expire
+type SessionChecker interface {
+	IsActive(ctx context.Context, sessionID string) (bool, error)
+}
+
+// APIKeyAuthenticator resolves API keys to the user they act as and the scopes they grant
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (userID string, scopes []string, err error)
+}
+
+// AuthMiddleware creates a middleware that validates JWT tokens and API keys. Tokens whose
+// session was revoked or has expired, and tokens issued without a session, are ignored.
+// API keys only act as their user on routes wrapped with RequireScope for a scope they
+// were granted; elsewhere the request is anonymous.
+func AuthMiddleware(jwtSecret string, sessions SessionChecker, apiKeys APIKeyAuthenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
@@ -40,6 +64,18 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 
 			tokenString := parts[1]
 
+			if strings.HasPrefix(tokenString, apiKeyPrefix) {
+				userID, scopes, err := apiKeys.AuthenticateAPIKey(r.Context(), tokenString)
+				if err != nil {
+					next.ServeHTTP(w, r)
+					return
+				}
+				ctx := context.WithValue(r.Context(), apiKeyUserIDKey, userID)
+				ctx = context.WithValue(ctx, scopesKey, scopes)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+
 			// Validate JWT token and extract user ID
 			claims, err := validateToken(tokenString, jwtSecret)
 			if err != nil {
@@ -48,8 +84,20 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 				return
 			}
 
-			// Add user ID to context
+			// Check the token's session against revocations
+			if claims.SessionID == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			active, err := sessions.IsActive(r.Context(), claims.SessionID)
+			if err != nil || !active {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Add user and session IDs to context
 			ctx := WithUserID(r.Context(), claims.UserID)
+			ctx = WithSessionID(ctx, claims.SessionID)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -59,8 +107,8 @@ func AuthMiddleware(jwtSecret string) func(http.Handler) http.Handler {
 func validateToken(tokenString string, jwtSecret string) (*Claims, error) {
 	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(jwtSecret), nil
-	})
-	
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+
 	if err != nil {
 		return nil, err
 	}
@@ -85,9 +133,71 @@ func WithUserID(ctx context.Context, userID string) context.Context {
 	return context.WithValue(ctx, userIDKey, userID)
 }
 
+// GetSessionIDFromContext extracts the session ID of the access token from context
+func GetSessionIDFromContext(ctx context.Context) string {
+	if sessionID, ok := ctx.Value(sessionIDKey).(string); ok {
+		return sessionID
+	}
+	return ""
+}
+
+// WithSessionID adds the session ID of the access token to context
+func WithSessionID(ctx context.Context, sessionID string) context.Context {
+	return context.WithValue(ctx, sessionIDKey, sessionID)
+}
+
+// RequireScope creates a middleware for routes API keys may use with the given scope. A
+// key with the scope acts as its user; one without gets a 403. Other requests, signed in
+// with a session or anonymous, go through unchanged.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userID, ok := r.Context().Value(apiKeyUserIDKey).(string)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !HasScope(r.Context(), scope) {
+				writeProblem(w, http.StatusForbidden, "Forbidden", "this API key lacks the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+		})
+	}
+}
+
+// GetScopesFromContext returns the scopes of the API key making the request, or nil for
+// requests signed in with a session, which may do anything their user can
+func GetScopesFromContext(ctx context.Context) []string {
+	scopes, _ := ctx.Value(scopesKey).([]string)
+	return scopes
+}
+
+// IsAPIKeyRequest reports whether the request is authenticated with an API key
+func IsAPIKeyRequest(ctx context.Context) bool {
+	_, ok := ctx.Value(apiKeyUserIDKey).(string)
+	return ok
+}
+
+// HasScope reports whether the request may act with scope: API keys need to have been
+// granted it, while sessions have every scope
+func HasScope(ctx context.Context, scope string) bool {
+	if !IsAPIKeyRequest(ctx) {
+		return true
+	}
+	return slices.Contains(GetScopesFromContext(ctx), scope)
+}
+
+// writeProblem writes an error in the same shape as Fuego's HTTP errors
+func writeProblem(w http.ResponseWriter, status int, title, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{"title": title, "status": status, "detail": detail})
+}
+
 // FuegoAuthMiddleware is a Fuego-compatible auth middleware
-func FuegoAuthMiddleware(jwtSecret string) fuego.Middleware {
+func FuegoAuthMiddleware(jwtSecret string, sessions SessionChecker, apiKeys APIKeyAuthenticator) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
-		return AuthMiddleware(jwtSecret)(next)
+		return AuthMiddleware(jwtSecret, sessions, apiKeys)(next)
 	}
 }
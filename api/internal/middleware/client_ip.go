@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+const clientIPKey contextKey = "clientIP"
+
+// ClientIP creates a middleware that works out the address each request comes from. For
+// requests from one of the trusted proxies, that's the last address in X-Forwarded-For that
+// isn't a trusted proxy too; for any other request it's the peer address, so clients can't
+// pick their own rate limit buckets by sending the header.
+func ClientIP(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := forwardedFor(r, trustedProxies)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+		})
+	}
+}
+
+// GetClientIP returns the address a request came from, as worked out by ClientIP, or the
+// peer address of requests it didn't handle
+func GetClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok && ip != "" {
+		return ip
+	}
+	return peerIP(r)
+}
+
+// forwardedFor walks X-Forwarded-For back from the peer while the addresses are trusted
+// proxies, and returns the first one that isn't
+func forwardedFor(r *http.Request, trustedProxies []netip.Prefix) string {
+	peer := peerIP(r)
+	addr, err := netip.ParseAddr(peer)
+	if err != nil {
+		return peer
+	}
+	trusted := func(a netip.Addr) bool {
+		return slices.ContainsFunc(trustedProxies, func(p netip.Prefix) bool { return p.Contains(a.Unmap()) })
+	}
+	if !trusted(addr) {
+		return peer
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Whatever sent a malformed address can't be trusted for the ones before it
+			break
+		}
+		addr = hop.Unmap()
+		if !trusted(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// peerIP returns the address of the other end of a request's connection
+func peerIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
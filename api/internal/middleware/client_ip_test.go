@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/8")}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		trusted    []netip.Prefix
+		want       string
+	}{
+		{name: "direct", remoteAddr: "203.0.113.7:4242", trusted: trusted, want: "203.0.113.7"},
+		{name: "header ignored without trusted proxies", remoteAddr: "10.0.0.1:4242", forwarded: []string{"198.51.100.1"}, want: "10.0.0.1"},
+		{name: "header ignored from an untrusted peer", remoteAddr: "203.0.113.7:4242", forwarded: []string{"198.51.100.1"}, trusted: trusted, want: "203.0.113.7"},
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:4242", forwarded: []string{"198.51.100.1"}, trusted: trusted, want: "198.51.100.1"},
+		{name: "trusted proxy without header", remoteAddr: "10.0.0.1:4242", trusted: trusted, want: "10.0.0.1"},
+		{name: "spoofed first hop", remoteAddr: "10.0.0.1:4242", forwarded: []string{"1.2.3.4, 198.51.100.1"}, trusted: trusted, want: "198.51.100.1"},
+		{name: "chain of trusted proxies", remoteAddr: "10.0.0.1:4242", forwarded: []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, trusted: trusted, want: "198.51.100.1"},
+		{name: "only trusted proxies", remoteAddr: "10.0.0.1:4242", forwarded: []string{"10.0.0.2"}, trusted: trusted, want: "10.0.0.2"},
+		{name: "malformed hop", remoteAddr: "10.0.0.1:4242", forwarded: []string{"198.51.100.1, bogus, 10.0.0.2"}, trusted: trusted, want: "10.0.0.2"},
+		{name: "ipv6", remoteAddr: "[fd00::1]:4242", forwarded: []string{"2001:db8::1"}, trusted: trusted, want: "2001:db8::1"},
+		{name: "ipv4 mapped peer", remoteAddr: "[::ffff:10.0.0.1]:4242", forwarded: []string{"198.51.100.1"}, trusted: trusted, want: "198.51.100.1"},
+		{name: "peer without port", remoteAddr: "pipe", forwarded: []string{"198.51.100.1"}, trusted: trusted, want: "pipe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := ClientIP(tt.trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = GetClientIP(r)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("GetClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
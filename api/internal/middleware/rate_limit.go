@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RateLimiter takes tokens from the per-IP and per-account buckets of route groups
+type RateLimiter interface {
+	AllowIP(ctx context.Context, group, ip string) (time.Duration, error)
+	AllowAccount(ctx context.Context, group, account string) (time.Duration, error)
+}
+
+// RateLimit creates a middleware that limits requests to the routes of a group by client
+// IP, as worked out by ClientIP, and, for signed-in users and API keys, by account.
+// Requests over a limit get a 429 with Retry-After. Like every rate limit check, it fails
+// open: if the limiter's store fails, requests are let through and a warning is logged.
+func RateLimit(limiter RateLimiter, group string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wait, err := limiter.AllowIP(r.Context(), group, GetClientIP(r))
+			if err == nil && wait == 0 {
+				if userID := rateLimitAccount(r.Context()); userID != "" {
+					wait, err = limiter.AllowAccount(r.Context(), group, userID)
+				}
+			}
+			if err != nil {
+				slog.Warn("Rate limit check failed", "group", group, "error", err)
+			}
+			if wait > 0 {
+				WriteTooManyRequests(w, wait, "too many requests, please slow down")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteTooManyRequests writes a 429 telling the client how long to wait
+func WriteTooManyRequests(w http.ResponseWriter, retryAfter time.Duration, detail string) {
+	SetRetryAfter(w, retryAfter)
+	writeProblem(w, http.StatusTooManyRequests, "Too Many Requests", detail)
+}
+
+// SetRetryAfter sets the Retry-After header in whole seconds, rounded up
+func SetRetryAfter(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := max(1, int(math.Ceil(retryAfter.Seconds())))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// rateLimitAccount returns the user a request acts as, whether by session or API key
+func rateLimitAccount(ctx context.Context) string {
+	if userID, ok := ctx.Value(userIDKey).(string); ok && userID != "" {
+		return userID
+	}
+	userID, _ := ctx.Value(apiKeyUserIDKey).(string)
+	return userID
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeLimiter makes clients wait for fixed durations, keyed by IP and by account
+type fakeLimiter struct {
+	ipWait      time.Duration
+	accountWait time.Duration
+	err         error
+	accounts    []string
+}
+
+func (l *fakeLimiter) AllowIP(ctx context.Context, group, ip string) (time.Duration, error) {
+	return l.ipWait, l.err
+}
+
+func (l *fakeLimiter) AllowAccount(ctx context.Context, group, account string) (time.Duration, error) {
+	l.accounts = append(l.accounts, account)
+	return l.accountWait, l.err
+}
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name           string
+		limiter        *fakeLimiter
+		userID         string
+		wantStatus     int
+		wantRetryAfter string
+		wantAccounts   int
+	}{
+		{name: "allowed", limiter: &fakeLimiter{}, wantStatus: http.StatusOK},
+		{name: "allowed signed in", limiter: &fakeLimiter{}, userID: "u1", wantStatus: http.StatusOK, wantAccounts: 1},
+		{name: "ip limited", limiter: &fakeLimiter{ipWait: 1500 * time.Millisecond}, userID: "u1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "2"},
+		{name: "account limited", limiter: &fakeLimiter{accountWait: time.Millisecond}, userID: "u1", wantStatus: http.StatusTooManyRequests, wantRetryAfter: "1", wantAccounts: 1},
+		{name: "store failing lets requests through", limiter: &fakeLimiter{err: errors.New("down")}, wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RateLimit(tt.limiter, "auth")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/login", nil)
+			if tt.userID != "" {
+				req = req.WithContext(context.WithValue(req.Context(), userIDKey, tt.userID))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetryAfter)
+			}
+			if len(tt.limiter.accounts) != tt.wantAccounts {
+				t.Errorf("account buckets taken from = %v, want %d", tt.limiter.accounts, tt.wantAccounts)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// AccountService handles password resets, email verification and unlocking accounts, all
+// done through single-use links sent by email
+type AccountService struct {
+	userService     *UserService
+	sessionService  *SessionService
+	tokens          *AccountTokenService
+	mailer          Mailer
+	frontendURL     string
+	resetTTL        time.Duration
+	verificationTTL time.Duration
+	unlockTTL       time.Duration
+}
+
+// NewAccountService creates a new AccountService. Links point to pages of the frontend at
+// frontendURL; reset links are valid for resetTTL, verification links for verificationTTL
+// and unlock links for unlockTTL.
+func NewAccountService(userService *UserService, sessionService *SessionService, tokens *AccountTokenService, mailer Mailer,
+	frontendURL string, resetTTL, verificationTTL, unlockTTL time.Duration) *AccountService {
+	return &AccountService{
+		userService:     userService,
+		sessionService:  sessionService,
+		tokens:          tokens,
+		mailer:          mailer,
+		frontendURL:     strings.TrimSuffix(frontendURL, "/"),
+		resetTTL:        resetTTL,
+		verificationTTL: verificationTTL,
+		unlockTTL:       unlockTTL,
+	}
+}
+
+// RequestPasswordReset emails a password reset link to the account with the given email.
+// Unknown addresses are ignored without an error, so the response doesn't reveal which
+// addresses have accounts.
+func (s *AccountService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.userService.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token, err := s.tokens.Issue(ctx, user.ID, TokenPurposePasswordReset, s.resetTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Reset your Redrawn password",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Someone asked to reset the password of your Redrawn account. To choose a new one, open this link within %s:\n\n"+
+			"%s\n\n"+
+			"If it wasn't you, ignore this email; your password stays the same.\n",
+			greetingName(user), formatTTL(s.resetTTL), s.link("/auth/reset-password", token)),
+	})
+}
+
+// ResetPassword sets a new password with a token from a reset link and signs out every
+// session, including any an attacker may hold. Following the link also proves the user
+// owns the address, so it verifies it, and unlocks the account.
+func (s *AccountService) ResetPassword(ctx context.Context, token, password string) error {
+	userID, err := s.tokens.Redeem(ctx, token, TokenPurposePasswordReset, func(tx *sql.Tx, userID string) error {
+		if err := s.userService.setPasswordTx(ctx, tx, userID, password); err != nil {
+			return err
+		}
+		if err := s.userService.clearLockoutTx(ctx, tx, userID); err != nil {
+			return err
+		}
+		return s.userService.markEmailVerifiedTx(ctx, tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.sessionService.RevokeAll(ctx, userID, "")
+	return err
+}
+
+// SendVerificationEmail emails a user a link to verify their address. Verified users get
+// nothing.
+func (s *AccountService) SendVerificationEmail(ctx context.Context, user *User) error {
+	if user.EmailVerified {
+		return nil
+	}
+
+	token, err := s.tokens.Issue(ctx, user.ID, TokenPurposeEmailVerification, s.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Verify your email address for Redrawn",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"Please confirm this is your email address by opening this link within %s:\n\n"+
+			"%s\n\n"+
+			"You need a verified address to buy credits. If you didn't sign up for Redrawn, ignore this email.\n",
+			greetingName(user), formatTTL(s.verificationTTL), s.link("/auth/verify-email", token)),
+	})
+}
+
+// VerifyEmail marks the address of a user verified with a token from a verification link
+func (s *AccountService) VerifyEmail(ctx context.Context, token string) error {
+	_, err := s.tokens.Redeem(ctx, token, TokenPurposeEmailVerification, func(tx *sql.Tx, userID string) error {
+		return s.userService.markEmailVerifiedTx(ctx, tx, userID)
+	})
+	return err
+}
+
+// SendUnlockEmail emails a user whose account was locked after failed sign-ins a link to
+// unlock it
+func (s *AccountService) SendUnlockEmail(ctx context.Context, user *User) error {
+	token, err := s.tokens.Issue(ctx, user.ID, TokenPurposeAccountUnlock, s.unlockTTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, Email{
+		To:      user.Email,
+		Subject: "Your Redrawn account was locked",
+		Body: fmt.Sprintf("Hi %s,\n\n"+
+			"There were several failed attempts to sign in to your Redrawn account, so we locked it for a while. "+
+			"If it was you, open this link within %s to unlock it now:\n\n"+
+			"%s\n\n"+
+			"If it wasn't you, someone may be guessing your password; consider resetting it.\n",
+			greetingName(user), formatTTL(s.unlockTTL), s.link("/auth/unlock", token)),
+	})
+}
+
+// UnlockAccount resets the failed sign-ins of a user with a token from an unlock link
+func (s *AccountService) UnlockAccount(ctx context.Context, token string) error {
+	_, err := s.tokens.Redeem(ctx, token, TokenPurposeAccountUnlock, func(tx *sql.Tx, userID string) error {
+		return s.userService.clearLockoutTx(ctx, tx, userID)
+	})
+	return err
+}
+
+// link returns the frontend URL of a page that redeems token
+func (s *AccountService) link(path, token string) string {
+	return s.frontendURL + path + "?" + url.Values{"token": {token}}.Encode()
+}
+
+// greetingName is how emails address a user
+func greetingName(user *User) string {
+	if user.Name != "" {
+		return user.Name
+	}
+	return "there"
+}
+
+// formatTTL describes a link's lifetime in hours or minutes, e.g. "1 hour"
+func formatTTL(d time.Duration) string {
+	if d >= time.Hour && d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", max(1, int(d/time.Minute)))
+}
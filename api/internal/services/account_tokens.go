@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Purposes of account tokens
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+	TokenPurposeAccountUnlock     = "account_unlock"
+)
+
+// ErrInvalidAccountToken is returned for unknown, expired or already used account tokens
+var ErrInvalidAccountToken = errors.New("invalid or expired link")
+
+// AccountTokenService issues the single-use tokens sent in password reset and email
+// verification links. Tokens are random and only their hash is stored.
+type AccountTokenService struct {
+	db *sql.DB
+}
+
+// NewAccountTokenService creates a new AccountTokenService
+func NewAccountTokenService(db *sql.DB) *AccountTokenService {
+	return &AccountTokenService{db: db}
+}
+
+// Issue creates a token for a user that is valid for ttl, invalidating the user's unused
+// tokens for the same purpose, so only the latest link works
+func (s *AccountTokenService) Issue(ctx context.Context, userID, purpose string, ttl time.Duration) (string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return "", err
+	}
+
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE account_tokens SET used_at = NOW()
+			 WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL`,
+			userID, purpose,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO account_tokens (id, user_id, purpose, token_hash, expires_at)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			uuid.New().String(), userID, purpose, hash, time.Now().Add(ttl),
+		)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// Redeem uses up a token and calls apply with its user inside the same transaction, so the
+// token stays valid if apply fails. It returns the user ID.
+func (s *AccountTokenService) Redeem(ctx context.Context, token, purpose string, apply func(tx *sql.Tx, userID string) error) (string, error) {
+	var userID string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`UPDATE account_tokens SET used_at = NOW()
+			 WHERE token_hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > NOW()
+			 RETURNING user_id`,
+			hashSecretToken(token), purpose,
+		).Scan(&userID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidAccountToken
+			}
+			return err
+		}
+		return apply(tx, userID)
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
@@ -11,16 +11,17 @@ import (
 
 // Album represents a photo album
 type Album struct {
-	ID          string     `json:"id"`
-	GroupID     string     `json:"group_id"`
-	UserID      string     `json:"user_id"`
-	Name        string     `json:"name"`
-	Slug        *string    `json:"slug,omitempty"`
-	Description *string    `json:"description,omitempty"`
-	Status      string     `json:"status"`
-	IsPublic    bool       `json:"is_public"`
-	CreatedAt   time.Time  `json:"created_at"`
-	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	ID           string     `json:"id"`
+	GroupID      string     `json:"group_id"`
+	UserID       string     `json:"user_id"`
+	Name         string     `json:"name"`
+	Slug         *string    `json:"slug,omitempty"`
+	Description  *string    `json:"description,omitempty"`
+	Status       string     `json:"status"`
+	IsPublic     bool       `json:"is_public"`
+	KeepLocation bool       `json:"keep_location"` // keep the GPS position read from photos' EXIF
+	CreatedAt    time.Time  `json:"created_at"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
 }
 
 // AlbumMember represents a user's membership in an album
@@ -34,20 +35,22 @@ type AlbumMember struct {
 
 // CreateAlbumInput holds data for creating an album
 type CreateAlbumInput struct {
-	UserID      string  `json:"user_id" validate:"required"`
-	Name        string  `json:"name" validate:"required"`
-	Slug        *string `json:"slug,omitempty"`
-	Description *string `json:"description,omitempty"`
-	IsPublic    bool    `json:"is_public"`
+	UserID       string  `json:"user_id" validate:"required"`
+	Name         string  `json:"name" validate:"required"`
+	Slug         *string `json:"slug,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	IsPublic     bool    `json:"is_public"`
+	KeepLocation bool    `json:"keep_location"`
 }
 
 // UpdateAlbumInput holds data for updating an album
 type UpdateAlbumInput struct {
-	ID          string  `json:"id" validate:"required"`
-	Name        *string `json:"name,omitempty"`
-	Slug        *string `json:"slug,omitempty"`
-	Description *string `json:"description,omitempty"`
-	IsPublic    *bool   `json:"is_public,omitempty"`
+	ID           string  `json:"id" validate:"required"`
+	Name         *string `json:"name,omitempty"`
+	Slug         *string `json:"slug,omitempty"`
+	Description  *string `json:"description,omitempty"`
+	IsPublic     *bool   `json:"is_public,omitempty"`
+	KeepLocation *bool   `json:"keep_location,omitempty"`
 }
 
 // AlbumService handles album business logic
@@ -67,22 +70,23 @@ func (s *AlbumService) Create(ctx context.Context, input CreateAlbumInput) (*Alb
 	now := time.Now()
 
 	album := &Album{
-		ID:          albumID,
-		GroupID:     groupID,
-		UserID:      input.UserID,
-		Name:        input.Name,
-		Slug:        input.Slug,
-		Description: input.Description,
-		Status:      "staged",
-		IsPublic:    input.IsPublic,
-		CreatedAt:   now,
+		ID:           albumID,
+		GroupID:      groupID,
+		UserID:       input.UserID,
+		Name:         input.Name,
+		Slug:         input.Slug,
+		Description:  input.Description,
+		Status:       "staged",
+		IsPublic:     input.IsPublic,
+		KeepLocation: input.KeepLocation,
+		CreatedAt:    now,
 	}
 
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO albums (id, group_id, user_id, name, slug, description, status, is_public, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		`INSERT INTO albums (id, group_id, user_id, name, slug, description, status, is_public, keep_location, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
 		album.ID, album.GroupID, album.UserID, album.Name, album.Slug, album.Description,
-		album.Status, album.IsPublic, album.CreatedAt,
+		album.Status, album.IsPublic, album.KeepLocation, album.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -108,12 +112,12 @@ func (s *AlbumService) GetByID(ctx context.Context, id string) (*Album, error) {
 	var slug, description sql.NullString
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, group_id, user_id, name, slug, description, status, is_public, created_at, confirmed_at
+		`SELECT id, group_id, user_id, name, slug, description, status, is_public, keep_location, created_at, confirmed_at
 		 FROM albums WHERE id = $1 AND status != 'deleted'`,
 		id,
 	).Scan(
 		&album.ID, &album.GroupID, &album.UserID, &album.Name,
-		&slug, &description, &album.Status, &album.IsPublic,
+		&slug, &description, &album.Status, &album.IsPublic, &album.KeepLocation,
 		&album.CreatedAt, &confirmedAt,
 	)
 
@@ -144,12 +148,12 @@ func (s *AlbumService) GetBySlug(ctx context.Context, slug string) (*Album, erro
 	var description sql.NullString
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, group_id, user_id, name, slug, description, status, is_public, created_at, confirmed_at
+		`SELECT id, group_id, user_id, name, slug, description, status, is_public, keep_location, created_at, confirmed_at
 		 FROM albums WHERE slug = $1 AND status = 'confirmed' AND is_public = true`,
 		slug,
 	).Scan(
 		&album.ID, &album.GroupID, &album.UserID, &album.Name,
-		&album.Slug, &description, &album.Status, &album.IsPublic,
+		&album.Slug, &description, &album.Status, &album.IsPublic, &album.KeepLocation,
 		&album.CreatedAt, &confirmedAt,
 	)
 
@@ -173,7 +177,7 @@ func (s *AlbumService) GetBySlug(ctx context.Context, slug string) (*Album, erro
 // ListByUser lists all albums for a user (as owner or member)
 func (s *AlbumService) ListByUser(ctx context.Context, userID string) ([]Album, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT a.id, a.group_id, a.user_id, a.name, a.slug, a.description, a.status, a.is_public, a.created_at, a.confirmed_at
+		`SELECT a.id, a.group_id, a.user_id, a.name, a.slug, a.description, a.status, a.is_public, a.keep_location, a.created_at, a.confirmed_at
 		 FROM albums a
 		 JOIN album_users au ON a.id = au.album_id
 		 WHERE au.user_id = $1 AND a.status != 'deleted'
@@ -193,7 +197,7 @@ func (s *AlbumService) ListByUser(ctx context.Context, userID string) ([]Album,
 
 		err := rows.Scan(
 			&album.ID, &album.GroupID, &album.UserID, &album.Name,
-			&slug, &description, &album.Status, &album.IsPublic,
+			&slug, &description, &album.Status, &album.IsPublic, &album.KeepLocation,
 			&album.CreatedAt, &confirmedAt,
 		)
 		if err != nil {
@@ -250,15 +254,25 @@ func (s *AlbumService) updateInPlace(ctx context.Context, current *Album, input
 	if input.IsPublic != nil {
 		isPublic = *input.IsPublic
 	}
+	keepLocation := current.KeepLocation
+	if input.KeepLocation != nil {
+		keepLocation = *input.KeepLocation
+	}
 
 	_, err := s.db.ExecContext(ctx,
-		`UPDATE albums SET name = $1, slug = $2, description = $3, is_public = $4 WHERE id = $5`,
-		name, slug, description, isPublic, current.ID,
+		`UPDATE albums SET name = $1, slug = $2, description = $3, is_public = $4, keep_location = $5 WHERE id = $6`,
+		name, slug, description, isPublic, keepLocation, current.ID,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if current.KeepLocation && !keepLocation {
+		if err := s.forgetLocations(ctx, current.GroupID); err != nil {
+			return nil, err
+		}
+	}
+
 	return s.GetByID(ctx, current.ID)
 }
 
@@ -282,6 +296,10 @@ func (s *AlbumService) createNewVersion(ctx context.Context, current *Album, inp
 	if input.IsPublic != nil {
 		isPublic = *input.IsPublic
 	}
+	keepLocation := current.KeepLocation
+	if input.KeepLocation != nil {
+		keepLocation = *input.KeepLocation
+	}
 
 	// Mark old as deleted (soft delete via status)
 	_, err := s.db.ExecContext(ctx,
@@ -294,28 +312,35 @@ func (s *AlbumService) createNewVersion(ctx context.Context, current *Album, inp
 
 	// Create new version
 	album := &Album{
-		ID:          newID,
-		GroupID:     current.GroupID,
-		UserID:      current.UserID,
-		Name:        name,
-		Slug:        slug,
-		Description: description,
-		Status:      "confirmed",
-		IsPublic:    isPublic,
-		CreatedAt:   now,
-		ConfirmedAt: &now,
+		ID:           newID,
+		GroupID:      current.GroupID,
+		UserID:       current.UserID,
+		Name:         name,
+		Slug:         slug,
+		Description:  description,
+		Status:       "confirmed",
+		IsPublic:     isPublic,
+		KeepLocation: keepLocation,
+		CreatedAt:    now,
+		ConfirmedAt:  &now,
 	}
 
 	_, err = s.db.ExecContext(ctx,
-		`INSERT INTO albums (id, group_id, user_id, name, slug, description, status, is_public, created_at, confirmed_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		`INSERT INTO albums (id, group_id, user_id, name, slug, description, status, is_public, keep_location, created_at, confirmed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
 		album.ID, album.GroupID, album.UserID, album.Name, album.Slug, album.Description,
-		album.Status, album.IsPublic, album.CreatedAt, album.ConfirmedAt,
+		album.Status, album.IsPublic, album.KeepLocation, album.CreatedAt, album.ConfirmedAt,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if current.KeepLocation && !keepLocation {
+		if err := s.forgetLocations(ctx, current.GroupID); err != nil {
+			return nil, err
+		}
+	}
+
 	// Copy members to new version
 	_, err = s.db.ExecContext(ctx,
 		`INSERT INTO album_users (id, album_id, user_id, role, created_at)
@@ -329,6 +354,33 @@ func (s *AlbumService) createNewVersion(ctx context.Context, current *Album, inp
 	return album, nil
 }
 
+// forgetLocations clears the location of the photos in every version of an album, once
+// it stops keeping them
+func (s *AlbumService) forgetLocations(ctx context.Context, groupID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE photos SET latitude = NULL, longitude = NULL
+		 WHERE album_id IN (SELECT id FROM albums WHERE group_id = $1) AND latitude IS NOT NULL`,
+		groupID,
+	)
+	return err
+}
+
+// KeepsLocation reports whether photos added to an album keep their EXIF location
+func (s *AlbumService) KeepsLocation(ctx context.Context, albumID string) (bool, error) {
+	var keep bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT keep_location FROM albums WHERE id = $1`,
+		albumID,
+	).Scan(&keep)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, errors.New("album not found")
+		}
+		return false, err
+	}
+	return keep, nil
+}
+
 // Confirm confirms a staged album
 func (s *AlbumService) Confirm(ctx context.Context, id string) (*Album, error) {
 	now := time.Now()
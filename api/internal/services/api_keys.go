@@ -0,0 +1,196 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key, telling them apart from access tokens
+const APIKeyPrefix = "rdk_"
+
+// Scopes an API key can be granted
+const (
+	ScopeAlbumsRead    = "albums:read"    // albums, photos, generated photos and media
+	ScopeAlbumsWrite   = "albums:write"   // create and change albums and photos, upload
+	ScopeThemesRead    = "themes:read"    // list themes to generate with
+	ScopeGenerateWrite = "generate:write" // start generations, which spends credits
+	ScopeCreditsRead   = "credits:read"   // balance and transactions
+)
+
+// APIKeyScopes lists every scope, in the order they're documented
+var APIKeyScopes = []string{ScopeAlbumsRead, ScopeAlbumsWrite, ScopeThemesRead, ScopeGenerateWrite, ScopeCreditsRead}
+
+// apiKeyLastUsedResolution is how stale last_used_at may get, so busy keys don't cost a
+// write on every request
+const apiKeyLastUsedResolution = time.Minute
+
+// APIKey is an API key as listed to its user; the key itself is only shown when created
+type APIKey struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // the first characters of the key
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"` // never expires when empty
+}
+
+// CreateAPIKeyInput holds data for creating an API key
+type CreateAPIKeyInput struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+var (
+	// ErrAPIKeyNotFound is returned when an API key doesn't exist or belongs to another user
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	// ErrInvalidAPIKey is returned for unknown, expired or revoked API keys
+	ErrInvalidAPIKey = errors.New("invalid or expired API key")
+	// ErrInvalidAPIKeyInput wraps the reasons an API key can't be created as requested
+	ErrInvalidAPIKeyInput = errors.New("invalid API key request")
+)
+
+// apiKeyColumns is the column list scanAPIKey expects, in order
+const apiKeyColumns = `id, name, key_prefix, scopes, created_at, last_used_at, expires_at`
+
+// APIKeyService handles API keys
+type APIKeyService struct {
+	db *sql.DB
+}
+
+// NewAPIKeyService creates a new APIKeyService
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// Create creates an API key for a user and returns it with the key, which isn't stored
+func (s *APIKeyService) Create(ctx context.Context, userID string, input CreateAPIKeyInput) (*APIKey, string, error) {
+	if len(input.Scopes) == 0 {
+		return nil, "", fmt.Errorf("%w: at least one scope is required", ErrInvalidAPIKeyInput)
+	}
+	for _, scope := range input.Scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return nil, "", fmt.Errorf("%w: unknown scope %q", ErrInvalidAPIKeyInput, scope)
+		}
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, "", fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAPIKeyInput)
+	}
+
+	secret, _, err := newSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+	key := APIKeyPrefix + secret
+
+	scopes := slices.Clone(input.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	apiKey, err := scanAPIKey(s.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (id, user_id, name, key_prefix, key_hash, scopes, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)
+		 RETURNING `+apiKeyColumns,
+		uuid.New().String(), userID, input.Name, key[:len(APIKeyPrefix)+6], hashSecretToken(key), pq.Array(scopes), input.ExpiresAt,
+	))
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// List lists a user's API keys that haven't been revoked, newest first. Expired keys are
+// included so users can see why a script stopped working.
+func (s *APIKeyService) List(ctx context.Context, userID string) ([]APIKey, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+apiKeyColumns+`
+		 FROM api_keys
+		 WHERE user_id = $1 AND revoked_at IS NULL
+		 ORDER BY created_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Revoke revokes one of a user's API keys; it stops working immediately
+func (s *APIKeyService) Revoke(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// AuthenticateAPIKey returns the user and scopes of a valid API key and records its use
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (string, []string, error) {
+	var id, userID string
+	var scopes []string
+	var stale bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, user_id, scopes, last_used_at IS NULL OR last_used_at < $2
+		 FROM api_keys
+		 WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())`,
+		hashSecretToken(key), time.Now().Add(-apiKeyLastUsedResolution),
+	).Scan(&id, &userID, pq.Array(&scopes), &stale)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil, ErrInvalidAPIKey
+		}
+		return "", nil, err
+	}
+
+	if stale {
+		if _, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = NOW() WHERE id = $1`, id); err != nil {
+			return "", nil, err
+		}
+	}
+	return userID, scopes, nil
+}
+
+// scanAPIKey scans a single row selected with apiKeyColumns
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	key := &APIKey{}
+	var lastUsedAt, expiresAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.CreatedAt, &lastUsedAt, &expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	return key, nil
+}
@@ -3,102 +3,280 @@ package services
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// mfaAudience is the audience of MFA challenge tokens
+const mfaAudience = "mfa"
+
+// ErrInvalidMFAToken is returned for expired or forged MFA challenge tokens
+var ErrInvalidMFAToken = errors.New("sign-in expired, please sign in again")
+
+// ErrInvalidCredentials is returned alike for wrong passwords, unknown emails and locked
+// accounts
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// dummyPasswordHash is compared against when an account has no hash to check, so failed
+// sign-ins take as long whether or not it exists
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a password"), bcrypt.DefaultCost)
+
 // AuthService handles authentication
 type AuthService struct {
-	userService *UserService
-	jwtSecret   string
+	userService      *UserService
+	sessionService   *SessionService
+	twoFactorService *TwoFactorService
+	lockouts         *LoginLockoutService
+	rateLimiter      *RateLimiter
+	jwtSecret        string
+	accessTokenTTL   time.Duration
+	mfaChallengeTTL  time.Duration
+	adminUserIDs     []string // admins are told to enroll in 2FA until they do
 }
 
-// NewAuthService creates a new AuthService
-func NewAuthService(userService *UserService, jwtSecret string) *AuthService {
+// NewAuthService creates a new AuthService. Access tokens are valid for accessTokenTTL;
+// clients get new ones from the session's refresh token. Users with 2FA get an MFA
+// challenge token valid for mfaChallengeTTL instead, to exchange along with a code.
+// Password attempts take from the per-account bucket of the auth rate limit group.
+func NewAuthService(userService *UserService, sessionService *SessionService, twoFactorService *TwoFactorService,
+	lockouts *LoginLockoutService, rateLimiter *RateLimiter,
+	jwtSecret string, accessTokenTTL, mfaChallengeTTL time.Duration, adminUserIDs []string) *AuthService {
 	return &AuthService{
-		userService: userService,
-		jwtSecret:   jwtSecret,
+		userService:      userService,
+		sessionService:   sessionService,
+		twoFactorService: twoFactorService,
+		lockouts:         lockouts,
+		rateLimiter:      rateLimiter,
+		jwtSecret:        jwtSecret,
+		accessTokenTTL:   accessTokenTTL,
+		mfaChallengeTTL:  mfaChallengeTTL,
+		adminUserIDs:     adminUserIDs,
 	}
 }
 
 // Claims represents JWT claims
 type Claims struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"` // access tokens stop working when their session is revoked
+	jwt.RegisteredClaims
+}
+
+// mfaClaims are the claims of MFA challenge tokens. They carry no session, so they can't
+// be used as access tokens.
+type mfaClaims struct {
 	UserID string `json:"user_id"`
-	Email  string `json:"email"`
 	jwt.RegisteredClaims
 }
 
 // LoginInput holds login credentials
 type LoginInput struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required"`
+	Email    string        `json:"email" validate:"required,email"`
+	Password string        `json:"password" validate:"required"`
+	Client   SessionClient `json:"-"`
 }
 
-// LoginResponse holds the login response
+// LoginResponse holds the login response. When MFARequired is set, only MFAToken and
+// ExpiresAt are, and the sign-in is finished with CompleteMFA.
 type LoginResponse struct {
-	Token string `json:"token"`
-	User  *User  `json:"user"`
+	Token        string    `json:"token"`      // access token
+	ExpiresAt    time.Time `json:"expires_at"` // of the access token, or of the MFA token
+	RefreshToken string    `json:"refresh_token"`
+	SessionID    string    `json:"session_id"`
+	User         *User     `json:"user"`
+
+	MFARequired           bool   `json:"mfa_required,omitempty"`
+	MFAToken              string `json:"mfa_token,omitempty"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required,omitempty"` // admins without 2FA
 }
 
-// Login authenticates a user and returns a JWT token
+// Login authenticates a user and returns a JWT token, or an MFA challenge if the user has
+// two-factor authentication on. Too many attempts return a *RateLimitError, and locked
+// accounts ErrInvalidCredentials like wrong passwords.
 func (s *AuthService) Login(ctx context.Context, input LoginInput) (*LoginResponse, error) {
-	// Get user with password hash
-	userWithPassword, err := s.userService.GetByEmailWithPassword(ctx, input.Email)
+	// Limit guesses at one account wherever they come from, including accounts that don't
+	// exist. Like the rate limit middleware, this fails open if the store fails: the account
+	// lockout, kept with the user, still applies.
+	wait, err := s.rateLimiter.AllowAccount(ctx, RateLimitAuth, strings.ToLower(strings.TrimSpace(input.Email)))
 	if err != nil {
-		if errors.Is(err, errors.New("user not found")) {
-			return nil, errors.New("invalid credentials")
+		slog.Warn("Rate limit check failed", "group", RateLimitAuth, "error", err)
+	}
+	if wait > 0 {
+		return nil, &RateLimitError{RetryAfter: wait, Reason: "too many sign-in attempts for this account"}
+	}
+
+	// Unknown emails, accounts without a password and locked accounts fail like a wrong
+	// password, after as long a comparison, so sign-in doesn't reveal which accounts exist
+	// or are locked. Locked users have been emailed an unlock link.
+	userWithPassword, err := s.userService.GetByEmailWithPassword(ctx, input.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	var user *User
+	hash := dummyPasswordHash
+	locked := false
+	if err == nil {
+		if userWithPassword.PasswordHash != "" {
+			user = &userWithPassword.User
+			hash = []byte(userWithPassword.PasswordHash)
+		}
+		if locked, err = s.lockouts.Locked(ctx, userWithPassword.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(input.Password)); err != nil || user == nil {
+		if user != nil && !locked {
+			if err := s.lockouts.RecordFailure(ctx, user); err != nil {
+				return nil, err
+			}
 		}
+		return nil, ErrInvalidCredentials
+	}
+	if locked {
+		return nil, ErrInvalidCredentials
+	}
+
+	return s.SignIn(ctx, user, input.Client)
+}
+
+// SignIn signs in a user whose first factor was checked, such as a password or an identity
+// provider. Users with 2FA get an MFA challenge instead of a session.
+func (s *AuthService) SignIn(ctx context.Context, user *User, client SessionClient) (*LoginResponse, error) {
+	mfaEnabled, err := s.twoFactorService.IsEnabled(ctx, user.ID)
+	if err != nil {
 		return nil, err
 	}
+	if mfaEnabled {
+		return s.issueMFAChallenge(user)
+	}
+
+	if err := s.lockouts.RecordSuccess(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	resp, err := s.StartSession(ctx, user, client)
+	if err != nil {
+		return nil, err
+	}
+	resp.MFAEnrollmentRequired = slices.Contains(s.adminUserIDs, user.ID)
+	return resp, nil
+}
+
+// CompleteMFA finishes a sign-in with the MFA token from SignIn and a code from the user's
+// authenticator app or a recovery code. Wrong codes count towards locking the account.
+func (s *AuthService) CompleteMFA(ctx context.Context, mfaToken, code string, client SessionClient) (*LoginResponse, error) {
+	claims := &mfaClaims{}
+	_, err := jwt.ParseWithClaims(mfaToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(s.jwtSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithAudience(mfaAudience), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
 
-	// Verify password hash against stored hash
-	if userWithPassword.PasswordHash == "" {
-		return nil, errors.New("invalid credentials")
+	if err := s.lockouts.Check(ctx, claims.UserID); err != nil {
+		return nil, err
 	}
-	
-	err = bcrypt.CompareHashAndPassword([]byte(userWithPassword.PasswordHash), []byte(input.Password))
+
+	user, err := s.userService.GetByID(ctx, claims.UserID)
 	if err != nil {
-		return nil, errors.New("invalid credentials")
+		return nil, err
+	}
+	if err := s.twoFactorService.Verify(ctx, user.ID, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			if err := s.lockouts.RecordFailure(ctx, user); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
 	}
 
-	// Generate JWT token
-	user := &userWithPassword.User
-	token, err := s.generateToken(user)
+	if err := s.lockouts.RecordSuccess(ctx, user.ID); err != nil {
+		return nil, err
+	}
+	return s.StartSession(ctx, user, client)
+}
+
+// issueMFAChallenge signs a token proving the user passed the first factor
+func (s *AuthService) issueMFAChallenge(user *User) (*LoginResponse, error) {
+	now := time.Now()
+	expiresAt := now.Add(s.mfaChallengeTTL)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, mfaClaims{
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.New().String(),
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+	})
+	signed, err := token.SignedString([]byte(s.jwtSecret))
 	if err != nil {
 		return nil, err
 	}
 
 	return &LoginResponse{
-		Token: token,
-		User:  user,
+		ExpiresAt:   expiresAt,
+		MFARequired: true,
+		MFAToken:    signed,
 	}, nil
 }
 
-// ValidateToken validates a JWT token and returns the claims
-func (s *AuthService) ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(s.jwtSecret), nil
-	})
-	
+// StartSession signs in a user who has already been authenticated, such as by an identity
+// provider, starting a session and issuing its tokens
+func (s *AuthService) StartSession(ctx context.Context, user *User, client SessionClient) (*LoginResponse, error) {
+	session, refreshToken, err := s.sessionService.Create(ctx, user.ID, client)
 	if err != nil {
 		return nil, err
 	}
+	return s.issueTokens(user, session, refreshToken)
+}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+// Refresh exchanges a refresh token for a new access token and a new refresh token.
+// The refresh token presented can't be used again.
+func (s *AuthService) Refresh(ctx context.Context, refreshToken string, client SessionClient) (*LoginResponse, error) {
+	session, newRefreshToken, err := s.sessionService.Rotate(ctx, refreshToken, client)
+	if err != nil {
+		return nil, err
 	}
 
-	return nil, errors.New("invalid token")
+	user, err := s.userService.GetByID(ctx, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	return s.issueTokens(user, session, newRefreshToken)
 }
 
-func (s *AuthService) generateToken(user *User) (string, error) {
+// issueTokens signs an access token for a session and bundles it with the refresh token
+func (s *AuthService) issueTokens(user *User, session *Session, refreshToken string) (*LoginResponse, error) {
+	expiresAt := time.Now().Add(s.accessTokenTTL)
+	token, err := s.generateToken(user, session.ID, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResponse{
+		Token:        token,
+		ExpiresAt:    expiresAt,
+		RefreshToken: refreshToken,
+		SessionID:    session.ID,
+		User:         user,
+	}, nil
+}
+
+func (s *AuthService) generateToken(user *User, sessionID string, expiresAt time.Time) (string, error) {
 	claims := Claims{
-		UserID: user.ID,
-		Email:  user.Email,
+		UserID:    user.ID,
+		Email:     user.Email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
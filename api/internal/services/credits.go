@@ -171,7 +171,7 @@ func (s *CreditService) DeductCredits(ctx context.Context, userID string, amount
 		return nil, err
 	}
 	if currentBalance < amount {
-		return nil, errors.New("insufficient credits")
+		return nil, ErrInsufficientCredits
 	}
 
 	// Deduct balance
@@ -202,6 +202,121 @@ func (s *CreditService) DeductCredits(ctx context.Context, userID string, amount
 	return s.GetBalance(ctx, userID)
 }
 
+// ErrInsufficientCredits is returned when a user cannot afford a charge
+var ErrInsufficientCredits = errors.New("insufficient credits")
+
+// ReserveTx holds credits for an entity inside the caller's transaction, so the hold
+// and the entity it pays for are created atomically. The balance is debited and a
+// usage transaction is recorded immediately; the hold is later committed or released.
+func (s *CreditService) ReserveTx(ctx context.Context, tx *sql.Tx, userID string, amount int, relatedEntityType, relatedEntityID, description string) error {
+	if amount <= 0 {
+		return errors.New("amount must be positive")
+	}
+
+	now := time.Now()
+
+	// Ensure credit record exists (inside tx so a concurrent insert can't slip past the lock)
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO credits (id, user_id, balance, created_at, updated_at)
+		 VALUES ($1, $2, 0, $3, $3)
+		 ON CONFLICT (user_id) DO NOTHING`,
+		uuid.New().String(), userID, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	var currentBalance int
+	err = tx.QueryRowContext(ctx,
+		`SELECT balance FROM credits WHERE user_id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&currentBalance)
+	if err != nil {
+		return err
+	}
+	if currentBalance < amount {
+		return ErrInsufficientCredits
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE credits SET balance = balance - $1, updated_at = $2 WHERE user_id = $3`,
+		amount, now, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO credit_transactions (id, user_id, amount, type, description, related_entity_type, related_entity_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		uuid.New().String(), userID, -amount, "usage", description, relatedEntityType, relatedEntityID, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO credit_holds (id, user_id, amount, status, related_entity_type, related_entity_id, created_at)
+		 VALUES ($1, $2, $3, 'held', $4, $5, $6)`,
+		uuid.New().String(), userID, amount, relatedEntityType, relatedEntityID, now,
+	)
+	return err
+}
+
+// CommitTx finalizes the hold for an entity, keeping the credits spent.
+// It is a no-op if there is no outstanding hold.
+func (s *CreditService) CommitTx(ctx context.Context, tx *sql.Tx, relatedEntityType, relatedEntityID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE credit_holds SET status = 'committed', settled_at = NOW()
+		 WHERE related_entity_type = $1 AND related_entity_id = $2 AND status = 'held'`,
+		relatedEntityType, relatedEntityID,
+	)
+	return err
+}
+
+// ReleaseTx cancels the hold for an entity and refunds its credits with a refund
+// transaction linked to the entity. It is a no-op if there is no outstanding hold.
+func (s *CreditService) ReleaseTx(ctx context.Context, tx *sql.Tx, relatedEntityType, relatedEntityID, description string) error {
+	var holdID, userID string
+	var amount int
+	err := tx.QueryRowContext(ctx,
+		`SELECT id, user_id, amount FROM credit_holds
+		 WHERE related_entity_type = $1 AND related_entity_id = $2 AND status = 'held'
+		 FOR UPDATE`,
+		relatedEntityType, relatedEntityID,
+	).Scan(&holdID, &userID, &amount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		return err
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx,
+		`UPDATE credits SET balance = balance + $1, updated_at = $2 WHERE user_id = $3`,
+		amount, now, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO credit_transactions (id, user_id, amount, type, description, related_entity_type, related_entity_id, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		uuid.New().String(), userID, amount, "refund", description, relatedEntityType, relatedEntityID, now,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE credit_holds SET status = 'released', settled_at = $1 WHERE id = $2`,
+		now, holdID,
+	)
+	return err
+}
+
 // GetTransactionHistory retrieves credit transaction history for a user
 func (s *CreditService) GetTransactionHistory(ctx context.Context, userID string, limit int) ([]CreditTransaction, error) {
 	if limit <= 0 {
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestCreditHolds(t *testing.T) {
+	db := openTestDB(t)
+	credits := NewCreditService(db)
+	ctx := context.Background()
+
+	type op func(userID string) error
+	reserve := func(amount int) op {
+		return func(userID string) error {
+			return withTx(ctx, db, func(tx *sql.Tx) error {
+				return credits.ReserveTx(ctx, tx, userID, amount, "test", "entity-1", "Test hold")
+			})
+		}
+	}
+	commit := func(userID string) error {
+		return withTx(ctx, db, func(tx *sql.Tx) error {
+			return credits.CommitTx(ctx, tx, "test", "entity-1")
+		})
+	}
+	release := func(userID string) error {
+		return withTx(ctx, db, func(tx *sql.Tx) error {
+			return credits.ReleaseTx(ctx, tx, "test", "entity-1", "Test refund")
+		})
+	}
+
+	tests := []struct {
+		name        string
+		ops         []op
+		wantErrs    []bool // whether each op fails
+		wantBalance int
+		wantStatus  string
+		wantRefunds int
+	}{
+		{
+			name:        "reserve",
+			ops:         []op{reserve(3)},
+			wantErrs:    []bool{false},
+			wantBalance: 7,
+			wantStatus:  "held",
+		},
+		{
+			name:        "reserve twice for one entity",
+			ops:         []op{reserve(3), reserve(3)},
+			wantErrs:    []bool{false, true},
+			wantBalance: 7,
+			wantStatus:  "held",
+		},
+		{
+			name:        "reserve more than the balance",
+			ops:         []op{reserve(11)},
+			wantErrs:    []bool{true},
+			wantBalance: 10,
+		},
+		{
+			name:        "commit",
+			ops:         []op{reserve(3), commit},
+			wantErrs:    []bool{false, false},
+			wantBalance: 7,
+			wantStatus:  "committed",
+		},
+		{
+			name:        "commit twice",
+			ops:         []op{reserve(3), commit, commit},
+			wantErrs:    []bool{false, false, false},
+			wantBalance: 7,
+			wantStatus:  "committed",
+		},
+		{
+			name:        "release",
+			ops:         []op{reserve(3), release},
+			wantErrs:    []bool{false, false},
+			wantBalance: 10,
+			wantStatus:  "released",
+			wantRefunds: 1,
+		},
+		{
+			name:        "release twice refunds once",
+			ops:         []op{reserve(3), release, release},
+			wantErrs:    []bool{false, false, false},
+			wantBalance: 10,
+			wantStatus:  "released",
+			wantRefunds: 1,
+		},
+		{
+			name:        "release after commit refunds nothing",
+			ops:         []op{reserve(3), commit, release},
+			wantErrs:    []bool{false, false, false},
+			wantBalance: 7,
+			wantStatus:  "committed",
+		},
+		{
+			name:        "commit after release keeps the refund",
+			ops:         []op{reserve(3), release, commit},
+			wantErrs:    []bool{false, false, false},
+			wantBalance: 10,
+			wantStatus:  "released",
+			wantRefunds: 1,
+		},
+		{
+			name:        "settle without a hold",
+			ops:         []op{commit, release},
+			wantErrs:    []bool{false, false},
+			wantBalance: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t, db, 10)
+			// Holds are unique per entity, so each case starts without the previous one's
+			if _, err := db.Exec(`DELETE FROM credit_holds WHERE related_entity_type = 'test'`); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, op := range tt.ops {
+				if err := op(userID); (err != nil) != tt.wantErrs[i] {
+					t.Fatalf("op %d: error = %v, want error %v", i, err, tt.wantErrs[i])
+				}
+			}
+
+			balance, err := credits.GetBalance(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance.Balance, tt.wantBalance)
+			}
+
+			var status string
+			err = db.QueryRow(
+				`SELECT status FROM credit_holds WHERE related_entity_type = 'test' AND related_entity_id = 'entity-1'`,
+			).Scan(&status)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("hold status = %q, want %q", status, tt.wantStatus)
+			}
+
+			var refunds int
+			err = db.QueryRow(
+				`SELECT COUNT(*) FROM credit_transactions WHERE user_id = $1 AND type = 'refund'`,
+				userID,
+			).Scan(&refunds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if refunds != tt.wantRefunds {
+				t.Errorf("refunds = %d, want %d", refunds, tt.wantRefunds)
+			}
+		})
+	}
+}
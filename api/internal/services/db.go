@@ -0,0 +1,33 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// expectOneRow returns notFoundMsg as an error when an UPDATE/DELETE matched no rows
+func expectOneRow(result sql.Result, notFoundMsg string) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return errors.New(notFoundMsg)
+	}
+	return nil
+}
+
+// withTx runs fn inside a transaction, committing if it returns nil and rolling back otherwise
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// openTestDB returns a database with every migration applied, in a schema of its own that
+// is dropped when the test ends. Tests using it are skipped unless TEST_DATABASE_URL points
+// at a Postgres database they may create schemas in.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	schema := "test_" + hex.EncodeToString(b)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("drop test schema: %v", err)
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	files, err := filepath.Glob(filepath.Join("..", "..", "..", "db", "migrations", "*.sql"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		migration, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(string(migration)); err != nil {
+			t.Fatalf("apply %s: %v", filepath.Base(file), err)
+		}
+	}
+	return db
+}
+
+// createTestUser inserts a user with the given credit balance and returns their ID
+func createTestUser(t *testing.T, db *sql.DB, balance int) string {
+	t.Helper()
+	id := "user-" + t.Name()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, `INSERT INTO users (id, email) VALUES ($1, $2)`, id, id+"@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := db.ExecContext(ctx,
+		`INSERT INTO credits (id, user_id, balance) VALUES ($1, $2, $3)`,
+		"credits-"+id, id, balance,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
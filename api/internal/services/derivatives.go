@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// DerivativeSizes are the longest-edge sizes, in pixels, generated for every image
+var DerivativeSizes = []int{256, 1024, 2048}
+
+// DerivativeFormats are the encodings generated at every size
+var DerivativeFormats = []string{"webp", "jpeg"}
+
+// Derivative is a resized, re-encoded copy of an original or generated photo
+type Derivative struct {
+	ID               string
+	PhotoID          *string
+	GeneratedPhotoID *string
+	Size             int
+	Format           string
+	StorageKey       string
+	Width            int
+	Height           int
+	SizeBytes        int64
+	CreatedAt        time.Time
+}
+
+// VariantKey is the key a derivative is exposed under in a variants map, e.g. "webp_256"
+func (d Derivative) VariantKey() string {
+	return fmt.Sprintf("%s_%d", d.Format, d.Size)
+}
+
+// DerivativeService tracks derivatives and turns them into download URLs
+type DerivativeService struct {
+	db      *sql.DB
+	storage Storage
+}
+
+// NewDerivativeService creates a new DerivativeService
+func NewDerivativeService(db *sql.DB, storage Storage) *DerivativeService {
+	return &DerivativeService{db: db, storage: storage}
+}
+
+// Record stores a derivative, replacing any previous one of the same size and format
+func (s *DerivativeService) Record(ctx context.Context, d Derivative) error {
+	if (d.PhotoID == nil) == (d.GeneratedPhotoID == nil) {
+		return fmt.Errorf("derivative must belong to exactly one photo or generated photo")
+	}
+
+	conflict := "(photo_id, size, format) WHERE photo_id IS NOT NULL"
+	if d.GeneratedPhotoID != nil {
+		conflict = "(generated_photo_id, size, format) WHERE generated_photo_id IS NOT NULL"
+	}
+
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO derivatives (id, photo_id, generated_photo_id, size, format, storage_key, width, height, size_bytes, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW())
+		 ON CONFLICT `+conflict+` DO UPDATE
+		 SET storage_key = EXCLUDED.storage_key, width = EXCLUDED.width, height = EXCLUDED.height,
+		     size_bytes = EXCLUDED.size_bytes, created_at = EXCLUDED.created_at`,
+		uuid.New().String(), d.PhotoID, d.GeneratedPhotoID, d.Size, d.Format, d.StorageKey, d.Width, d.Height, d.SizeBytes,
+	)
+	return err
+}
+
+// AttachPhotoVariants fills in the variants map of each photo
+func (s *DerivativeService) AttachPhotoVariants(ctx context.Context, photos []Photo) error {
+	ids := make([]string, len(photos))
+	for i := range photos {
+		ids[i] = photos[i].ID
+	}
+
+	variants, err := s.variants(ctx, "photo_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range photos {
+		photos[i].Variants = variants[photos[i].ID]
+	}
+	return nil
+}
+
+// AttachGeneratedPhotoVariants fills in the variants map of each generated photo
+func (s *DerivativeService) AttachGeneratedPhotoVariants(ctx context.Context, generated []GeneratedPhoto) error {
+	ids := make([]string, len(generated))
+	for i := range generated {
+		ids[i] = generated[i].ID
+	}
+
+	variants, err := s.variants(ctx, "generated_photo_id", ids)
+	if err != nil {
+		return err
+	}
+	for i := range generated {
+		generated[i].Variants = variants[generated[i].ID]
+	}
+	return nil
+}
+
+// variants loads the derivatives owned through column by any of ids and presigns them,
+// keyed by owner ID and then by variant key
+func (s *DerivativeService) variants(ctx context.Context, column string, ids []string) (map[string]map[string]string, error) {
+	result := make(map[string]map[string]string)
+	if len(ids) == 0 {
+		return result, nil
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+column+`, size, format, storage_key
+		 FROM derivatives WHERE `+column+` = ANY($1)`,
+		pq.Array(ids),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ownerID string
+		var d Derivative
+		if err := rows.Scan(&ownerID, &d.Size, &d.Format, &d.StorageKey); err != nil {
+			return nil, err
+		}
+
+		// Presigning is a local signature computation, so this stays cheap for large albums
+		url, err := s.storage.GenerateDownloadURL(ctx, d.StorageKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if result[ownerID] == nil {
+			result[ownerID] = make(map[string]string)
+		}
+		result[ownerID][d.VariantKey()] = url.DownloadURL
+	}
+	return result, rows.Err()
+}
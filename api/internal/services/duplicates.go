@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"sort"
+
+	"redrawn/internal/imaging"
+)
+
+// DuplicateCluster is a group of ready photos of an album that look alike
+type DuplicateCluster struct {
+	Photos []Photo `json:"photos"` // oldest first
+	// Exact is set when every photo in the cluster has the same content, e.g. photos
+	// ingested before exact duplicates were rejected
+	Exact bool `json:"exact"`
+}
+
+// ListDuplicateClusters groups the ready photos of an album whose perceptual hashes are at
+// most maxDistance bits apart, directly or through other photos of the group. Photos
+// without a look-alike are left out.
+func (s *PhotoService) ListDuplicateClusters(ctx context.Context, albumID string, maxDistance int) ([]DuplicateCluster, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+photoColumns+`, perceptual_hash
+		 FROM photos
+		 WHERE album_id = $1 AND status = 'ready' AND perceptual_hash IS NOT NULL
+		 ORDER BY created_at`,
+		albumID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []Photo
+	var hashes []uint64
+	for rows.Next() {
+		var hash int64
+		photo, err := scanPhoto(scanWith(rows, &hash))
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, *photo)
+		hashes = append(hashes, uint64(hash))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Union-find over every pair close enough; albums are small enough for the quadratic scan
+	parent := make([]int, len(photos))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range photos {
+		for j := i + 1; j < len(photos); j++ {
+			if imaging.HashDistance(hashes[i], hashes[j]) <= maxDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range photos {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	clusters := []DuplicateCluster{}
+	for _, indexes := range members {
+		if len(indexes) < 2 {
+			continue
+		}
+		cluster := DuplicateCluster{Exact: true}
+		for _, i := range indexes {
+			photo := photos[i]
+			cluster.Photos = append(cluster.Photos, photo)
+			first := cluster.Photos[0]
+			if photo.ContentSHA256 == nil || first.ContentSHA256 == nil || *photo.ContentSHA256 != *first.ContentSHA256 {
+				cluster.Exact = false
+			}
+		}
+		clusters = append(clusters, cluster)
+	}
+
+	// Clusters come in the order of their oldest photo, like the photos within them
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].Photos[0].CreatedAt.Before(clusters[j].Photos[0].CreatedAt)
+	})
+	return clusters, nil
+}
+
+// scanWith wraps a row so that scanPhoto also fills extra, the columns selected after
+// photoColumns
+func scanWith(row rowScanner, extra ...any) rowScanner {
+	return extraColumnsScanner{row: row, extra: extra}
+}
+
+type extraColumnsScanner struct {
+	row   rowScanner
+	extra []any
+}
+
+func (s extraColumnsScanner) Scan(dest ...any) error {
+	return s.row.Scan(append(dest, s.extra...)...)
+}
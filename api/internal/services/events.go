@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// eventsChannel is the Postgres NOTIFY channel the database triggers publish on
+const eventsChannel = "redrawn_events"
+
+// EventTypeResync tells subscribers that notifications may have been missed
+// (e.g. the database connection dropped) and they should refetch their state
+const EventTypeResync = "resync"
+
+// Event is a change pushed to a user's event stream
+type Event struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data,omitempty"`
+}
+
+// eventNotification is the payload written by the notify_* database triggers
+type eventNotification struct {
+	Type    string          `json:"type"`
+	UserIDs []string        `json:"user_ids"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// EventBroker relays database notifications to per-user subscribers.
+// Every API replica runs its own broker, so events reach a user whichever
+// replica their stream is connected to.
+type EventBroker struct {
+	dsn    string
+	logger *slog.Logger
+
+	mu          sync.Mutex
+	subscribers map[string]map[chan Event]struct{}
+}
+
+// NewEventBroker creates a new EventBroker listening on the given database
+func NewEventBroker(dsn string, logger *slog.Logger) *EventBroker {
+	return &EventBroker{
+		dsn:         dsn,
+		logger:      logger.With("component", "events"),
+		subscribers: make(map[string]map[chan Event]struct{}),
+	}
+}
+
+// Subscribe returns a channel of events for the user and a function that ends the subscription
+func (b *EventBroker) Subscribe(userID string) (<-chan Event, func()) {
+	ch := make(chan Event, 32)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan Event]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	return ch, func() {
+		b.mu.Lock()
+		delete(b.subscribers[userID], ch)
+		if len(b.subscribers[userID]) == 0 {
+			delete(b.subscribers, userID)
+		}
+		b.mu.Unlock()
+	}
+}
+
+// Run listens for notifications and dispatches them until ctx is cancelled
+func (b *EventBroker) Run(ctx context.Context) error {
+	listener := pq.NewListener(b.dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			b.logger.Warn("Event listener connection problem", "error", err)
+		}
+	})
+	defer listener.Close()
+
+	if err := listener.Listen(eventsChannel); err != nil {
+		return err
+	}
+	b.logger.Info("Listening for events", "channel", eventsChannel)
+
+	ticker := time.NewTicker(90 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case n := <-listener.Notify:
+			if n == nil {
+				// The listener reconnected; anything sent in between is lost
+				b.broadcast(Event{Type: EventTypeResync})
+				continue
+			}
+			b.dispatch(n.Extra)
+		case <-ticker.C:
+			// Detect dead connections that would otherwise go unnoticed while idle
+			go listener.Ping()
+		}
+	}
+}
+
+func (b *EventBroker) dispatch(payload string) {
+	var n eventNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		b.logger.Warn("Ignoring malformed event", "error", err)
+		return
+	}
+
+	event := Event{Type: n.Type, Data: n.Data}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, userID := range n.UserIDs {
+		for ch := range b.subscribers[userID] {
+			b.send(ch, event)
+		}
+	}
+}
+
+func (b *EventBroker) broadcast(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, chans := range b.subscribers {
+		for ch := range chans {
+			b.send(ch, event)
+		}
+	}
+}
+
+// send never blocks: a subscriber that stops reading loses events rather than
+// stalling delivery for everyone else
+func (b *EventBroker) send(ch chan Event, event Event) {
+	select {
+	case ch <- event:
+	default:
+		b.logger.Warn("Dropping event for slow subscriber", "type", event.Type)
+	}
+}
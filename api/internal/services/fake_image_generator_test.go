@@ -0,0 +1,130 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testPNG encodes a small gradient, so the fake generator has shades to posterize
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 128, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFakeImageGenerator(t *testing.T) {
+	g := NewFakeImageGenerator()
+	ctx := context.Background()
+	source := testPNG(t, 16, 8)
+	base := ImageGenerationInput{Image: source, Prompt: "watercolor", Options: map[string]string{"style": "soft", "mood": "calm"}}
+
+	generate := func(input ImageGenerationInput) *ImageGenerationResult {
+		t.Helper()
+		result, err := g.Generate(ctx, input)
+		if err != nil {
+			t.Fatalf("Generate(%q): %v", input.Prompt, err)
+		}
+		return result
+	}
+	want := generate(base)
+
+	tests := []struct {
+		name     string
+		input    ImageGenerationInput
+		wantSame bool
+	}{
+		{"same input", base, true},
+		{"options in another order", ImageGenerationInput{Image: source, Prompt: "watercolor", Options: map[string]string{"mood": "calm", "style": "soft"}}, true},
+		{"other prompt", ImageGenerationInput{Image: source, Prompt: "pixel art", Options: base.Options}, false},
+		{"other option", ImageGenerationInput{Image: source, Prompt: "watercolor", Options: map[string]string{"style": "bold", "mood": "calm"}}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := generate(tt.input)
+			if same := bytes.Equal(got.Image, want.Image); same != tt.wantSame {
+				t.Errorf("output identical = %v, want %v", same, tt.wantSame)
+			}
+		})
+	}
+
+	if want.MimeType != "image/png" || want.Metadata["provider"] != "fake" || want.Metadata["size"] != "16x8" {
+		t.Errorf("result = %s %v, want a 16x8 PNG from the fake provider", want.MimeType, want.Metadata)
+	}
+}
+
+func TestFakeImageGeneratorSize(t *testing.T) {
+	g := NewFakeImageGenerator()
+	result, err := g.Generate(context.Background(), ImageGenerationInput{Image: testPNG(t, 16, 8), Prompt: "p", Size: "32x20"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(result.Image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 32 || b.Dy() != 20 {
+		t.Errorf("output is %dx%d, want 32x20", b.Dx(), b.Dy())
+	}
+}
+
+func TestFakeImageGeneratorErrors(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name  string
+		ctx   context.Context
+		input ImageGenerationInput
+	}{
+		{"canceled", canceled, ImageGenerationInput{Image: testPNG(t, 4, 4), Prompt: "p"}},
+		{"not an image", context.Background(), ImageGenerationInput{Image: []byte("hello"), Prompt: "p"}},
+		{"bad size", context.Background(), ImageGenerationInput{Image: testPNG(t, 4, 4), Prompt: "p", Size: "large"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewFakeImageGenerator().Generate(tt.ctx, tt.input); err == nil {
+				t.Error("Generate() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestParseImageSize(t *testing.T) {
+	tests := []struct {
+		size       string
+		wantWidth  int
+		wantHeight int
+		wantErr    bool
+	}{
+		{size: "1024x1024", wantWidth: 1024, wantHeight: 1024},
+		{size: "1792x1024", wantWidth: 1792, wantHeight: 1024},
+		{size: "1024", wantErr: true},
+		{size: "0x10", wantErr: true},
+		{size: "10x-1", wantErr: true},
+		{size: "axb", wantErr: true},
+		{size: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		width, height, err := parseImageSize(tt.size)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseImageSize(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			continue
+		}
+		if width != tt.wantWidth || height != tt.wantHeight {
+			t.Errorf("parseImageSize(%q) = %d, %d, want %d, %d", tt.size, width, height, tt.wantWidth, tt.wantHeight)
+		}
+	}
+}
@@ -3,97 +3,128 @@ package services
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"redrawn/internal/imaging"
 )
 
 // GeneratedPhoto represents a themed/generated variant of an original photo
 type GeneratedPhoto struct {
-	ID              string     `json:"id"`
-	OriginalPhotoID string     `json:"original_photo_id"`
-	ThemeID         string     `json:"theme_id"`
-	StorageKey      string     `json:"storage_key"`
-	Status          string     `json:"status"`
-	CreditsUsed     int        `json:"credits_used"`
-	ErrorMessage    *string    `json:"error_message,omitempty"`
-	CreatedAt       time.Time  `json:"created_at"`
-	CompletedAt     *time.Time `json:"completed_at,omitempty"`
+	ID               string            `json:"id"`
+	OriginalPhotoID  string            `json:"original_photo_id"`
+	ThemeID          string            `json:"theme_id"`
+	StorageKey       string            `json:"storage_key"`
+	Status           string            `json:"status"`
+	CreditsUsed      int               `json:"credits_used"`
+	ErrorMessage     *string           `json:"error_message,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	CompletedAt      *time.Time        `json:"completed_at,omitempty"`
+	ProviderMetadata json.RawMessage   `json:"provider_metadata,omitempty"`
+	Prompt           *string           `json:"prompt,omitempty"`
+	BatchID          *string           `json:"batch_id,omitempty"`
+	BlurHash         *string           `json:"blurhash,omitempty"`       // placeholder shown while the image loads
+	DominantColor    *string           `json:"dominant_color,omitempty"` // "#rrggbb"
+	Variants         map[string]string `json:"variants,omitempty"`       // resized copies, e.g. "webp_256" -> URL
 }
 
 // CreateGeneratedPhotoInput holds data for creating a generated photo
 type CreateGeneratedPhotoInput struct {
-	OriginalPhotoID string `json:"original_photo_id" validate:"required"`
-	ThemeID         string `json:"theme_id" validate:"required"`
-	StorageKey      string `json:"storage_key" validate:"required"`
-	CreditsUsed     int    `json:"credits_used,omitempty"`
+	OriginalPhotoID string  `json:"original_photo_id" validate:"required"`
+	ThemeID         string  `json:"theme_id" validate:"required"`
+	UserID          string  `json:"user_id" validate:"required"` // user whose credits pay for the generation
+	Prompt          string  `json:"prompt" validate:"required"`  // rendered from the theme's prompt template
+	BatchID         *string `json:"batch_id,omitempty"`
 }
 
-// UpdateGeneratedPhotoInput holds data for updating a generated photo
-type UpdateGeneratedPhotoInput struct {
-	ID           string  `json:"id" validate:"required"`
-	StorageKey   *string `json:"storage_key,omitempty"`
-	Status       *string `json:"status,omitempty"`
-	CreditsUsed  *int    `json:"credits_used,omitempty"`
-	ErrorMessage *string `json:"error_message,omitempty"`
-}
-
-// UpdateStatusInput holds data for updating status
-type UpdateGeneratedPhotoStatusInput struct {
-	ID           string  `json:"id" validate:"required"`
-	Status       string  `json:"status" validate:"required,oneof=queued processing completed error"`
-	ErrorMessage *string `json:"error_message,omitempty"`
-}
+// creditEntityGeneratedPhoto is the related_entity_type used for generation credit holds
+const creditEntityGeneratedPhoto = "generated_photo"
 
 // GeneratedPhotoService handles generated photo business logic
 type GeneratedPhotoService struct {
-	db *sql.DB
+	db            *sql.DB
+	creditService *CreditService
+	creditCost    int
 }
 
-// NewGeneratedPhotoService creates a new GeneratedPhotoService
-func NewGeneratedPhotoService(db *sql.DB) *GeneratedPhotoService {
-	return &GeneratedPhotoService{db: db}
+// NewGeneratedPhotoService creates a new GeneratedPhotoService.
+// creditCost is the number of credits held for each queued generation.
+func NewGeneratedPhotoService(db *sql.DB, creditService *CreditService, creditCost int) *GeneratedPhotoService {
+	return &GeneratedPhotoService{db: db, creditService: creditService, creditCost: creditCost}
 }
 
-// Create creates a new generated photo record (queues for processing)
+// Create creates a new generated photo record (queues for processing).
+// The generation cost is held from the user's balance in the same transaction,
+// so a job is never queued without being paid for.
 func (s *GeneratedPhotoService) Create(ctx context.Context, input CreateGeneratedPhotoInput) (*GeneratedPhoto, error) {
+	var generated *GeneratedPhoto
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		generated, err = s.createTx(ctx, tx, input)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return generated, nil
+}
+
+// CreditCost returns the number of credits held for each generation
+func (s *GeneratedPhotoService) CreditCost() int {
+	return s.creditCost
+}
+
+// createTx inserts a queued generated photo and holds its credits inside tx. The storage
+// key is a placeholder until the worker stores the result under services.GeneratedKey.
+func (s *GeneratedPhotoService) createTx(ctx context.Context, tx *sql.Tx, input CreateGeneratedPhotoInput) (*GeneratedPhoto, error) {
+	id := uuid.New().String()
 	generated := &GeneratedPhoto{
-		ID:              uuid.New().String(),
+		ID:              id,
 		OriginalPhotoID: input.OriginalPhotoID,
 		ThemeID:         input.ThemeID,
-		StorageKey:      input.StorageKey,
+		StorageKey:      "generated/" + id,
 		Status:          "queued",
-		CreditsUsed:     input.CreditsUsed,
+		CreditsUsed:     s.creditCost,
 		CreatedAt:       time.Now(),
+		Prompt:          &input.Prompt,
+		BatchID:         input.BatchID,
 	}
-
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO generated_photos (id, original_photo_id, theme_id, storage_key, status, credits_used, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO generated_photos (id, original_photo_id, theme_id, storage_key, status, credits_used, created_at, prompt, batch_id)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
 		generated.ID, generated.OriginalPhotoID, generated.ThemeID, generated.StorageKey,
-		generated.Status, generated.CreditsUsed, generated.CreatedAt,
+		generated.Status, generated.CreditsUsed, generated.CreatedAt, generated.Prompt, generated.BatchID,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	err = s.creditService.ReserveTx(ctx, tx, input.UserID, generated.CreditsUsed,
+		creditEntityGeneratedPhoto, generated.ID, "Photo generation")
+	if err != nil {
+		return nil, err
+	}
+
 	return generated, nil
 }
 
 // GetByID retrieves a generated photo by ID
 func (s *GeneratedPhotoService) GetByID(ctx context.Context, id string) (*GeneratedPhoto, error) {
 	generated := &GeneratedPhoto{}
-	var errorMessage sql.NullString
+	var errorMessage, blurHash, dominantColor sql.NullString
 	var completedAt sql.NullTime
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at
+		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at, provider_metadata, prompt, batch_id, blurhash, dominant_color
 		 FROM generated_photos WHERE id = $1`,
 		id,
 	).Scan(
 		&generated.ID, &generated.OriginalPhotoID, &generated.ThemeID, &generated.StorageKey,
 		&generated.Status, &generated.CreditsUsed, &errorMessage, &generated.CreatedAt, &completedAt,
+		&generated.ProviderMetadata, &generated.Prompt, &generated.BatchID, &blurHash, &dominantColor,
 	)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
@@ -108,6 +139,12 @@ func (s *GeneratedPhotoService) GetByID(ctx context.Context, id string) (*Genera
 	if completedAt.Valid {
 		generated.CompletedAt = &completedAt.Time
 	}
+	if blurHash.Valid {
+		generated.BlurHash = &blurHash.String
+	}
+	if dominantColor.Valid {
+		generated.DominantColor = &dominantColor.String
+	}
 
 	return generated, nil
 }
@@ -115,7 +152,7 @@ func (s *GeneratedPhotoService) GetByID(ctx context.Context, id string) (*Genera
 // ListByOriginalPhoto lists all generated variants for an original photo
 func (s *GeneratedPhotoService) ListByOriginalPhoto(ctx context.Context, originalPhotoID string) ([]GeneratedPhoto, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at
+		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at, provider_metadata, prompt, batch_id, blurhash, dominant_color
 		 FROM generated_photos WHERE original_photo_id = $1 ORDER BY created_at DESC`,
 		originalPhotoID,
 	)
@@ -130,7 +167,7 @@ func (s *GeneratedPhotoService) ListByOriginalPhoto(ctx context.Context, origina
 // ListByTheme lists all generated photos using a specific theme
 func (s *GeneratedPhotoService) ListByTheme(ctx context.Context, themeID string) ([]GeneratedPhoto, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at
+		`SELECT id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at, provider_metadata, prompt, batch_id, blurhash, dominant_color
 		 FROM generated_photos WHERE theme_id = $1 ORDER BY created_at DESC`,
 		themeID,
 	)
@@ -145,7 +182,7 @@ func (s *GeneratedPhotoService) ListByTheme(ctx context.Context, themeID string)
 // ListByUser lists all generated photos for photos owned by a user
 func (s *GeneratedPhotoService) ListByUser(ctx context.Context, userID string) ([]GeneratedPhoto, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT g.id, g.original_photo_id, g.theme_id, g.storage_key, g.status, g.credits_used, g.error_message, g.created_at, g.completed_at
+		`SELECT g.id, g.original_photo_id, g.theme_id, g.storage_key, g.status, g.credits_used, g.error_message, g.created_at, g.completed_at, g.provider_metadata, g.prompt, g.batch_id, g.blurhash, g.dominant_color
 		 FROM generated_photos g
 		 JOIN photos p ON g.original_photo_id = p.id
 		 WHERE p.user_id = $1
@@ -160,96 +197,237 @@ func (s *GeneratedPhotoService) ListByUser(ctx context.Context, userID string) (
 	return s.scanGeneratedPhotos(rows)
 }
 
-// Update updates a generated photo's metadata
-func (s *GeneratedPhotoService) Update(ctx context.Context, input UpdateGeneratedPhotoInput) (*GeneratedPhoto, error) {
-	generated, err := s.GetByID(ctx, input.ID)
+// ClaimNext atomically moves the oldest queued job to processing and returns it.
+// SKIP LOCKED lets several workers poll the same table without claiming a job twice.
+// Returns nil when the queue is empty.
+func (s *GeneratedPhotoService) ClaimNext(ctx context.Context) (*GeneratedPhoto, error) {
+	generated := &GeneratedPhoto{}
+	var errorMessage, blurHash, dominantColor sql.NullString
+	var completedAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx,
+		`UPDATE generated_photos
+		 SET status = 'processing', started_at = NOW(), attempts = attempts + 1
+		 WHERE id = (
+			SELECT id FROM generated_photos
+			WHERE status = 'queued'
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING id, original_photo_id, theme_id, storage_key, status, credits_used, error_message, created_at, completed_at, provider_metadata, prompt, batch_id, blurhash, dominant_color`,
+	).Scan(
+		&generated.ID, &generated.OriginalPhotoID, &generated.ThemeID, &generated.StorageKey,
+		&generated.Status, &generated.CreditsUsed, &errorMessage, &generated.CreatedAt, &completedAt,
+		&generated.ProviderMetadata, &generated.Prompt, &generated.BatchID, &blurHash, &dominantColor,
+	)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
 		return nil, err
 	}
-	if generated == nil {
-		return nil, errors.New("generated photo not found")
-	}
 
-	if input.StorageKey != nil {
-		generated.StorageKey = *input.StorageKey
+	if errorMessage.Valid {
+		generated.ErrorMessage = &errorMessage.String
 	}
-	if input.Status != nil {
-		generated.Status = *input.Status
-		if *input.Status == "completed" || *input.Status == "error" {
-			now := time.Now()
-			generated.CompletedAt = &now
-		}
+	if completedAt.Valid {
+		generated.CompletedAt = &completedAt.Time
 	}
-	if input.CreditsUsed != nil {
-		generated.CreditsUsed = *input.CreditsUsed
+	if blurHash.Valid {
+		generated.BlurHash = &blurHash.String
 	}
-	if input.ErrorMessage != nil {
-		generated.ErrorMessage = input.ErrorMessage
+	if dominantColor.Valid {
+		generated.DominantColor = &dominantColor.String
 	}
 
-	var completedAt interface{}
-	if generated.CompletedAt != nil {
-		completedAt = *generated.CompletedAt
-	} else {
-		completedAt = nil
-	}
+	return generated, nil
+}
 
-	_, err = s.db.ExecContext(ctx,
-		`UPDATE generated_photos 
-		 SET storage_key = $2, status = $3, credits_used = $4, error_message = $5, completed_at = $6
-		 WHERE id = $1`,
-		generated.ID, generated.StorageKey, generated.Status, generated.CreditsUsed,
-		generated.ErrorMessage, completedAt,
-	)
+// MarkCompleted records the output of a processing job, its placeholder and the provider
+// that produced it, and commits the job's credit hold
+func (s *GeneratedPhotoService) MarkCompleted(ctx context.Context, id, storageKey string, sizeBytes int64, placeholder imaging.Placeholder, metadata map[string]string) error {
+	metadataJSON, err := json.Marshal(metadata)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return generated, nil
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE generated_photos
+			 SET status = 'completed', storage_key = $2, size_bytes = $3, provider_metadata = $4, error_message = NULL, completed_at = NOW(),
+			     blurhash = NULLIF($5, ''), dominant_color = NULLIF($6, '')
+			 WHERE id = $1 AND status = 'processing'`,
+			id, storageKey, sizeBytes, metadataJSON, placeholder.BlurHash, placeholder.DominantColor,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectOneRow(result, "generated photo is not processing"); err != nil {
+			return err
+		}
+		return s.creditService.CommitTx(ctx, tx, creditEntityGeneratedPhoto, id)
+	})
 }
 
-// UpdateStatus updates the processing status of a generated photo
-func (s *GeneratedPhotoService) UpdateStatus(ctx context.Context, input UpdateGeneratedPhotoStatusInput) (*GeneratedPhoto, error) {
-	generated, err := s.GetByID(ctx, input.ID)
+// FindReusable returns a completed generation that a job can reuse instead of running again:
+// one with the same theme version and prompt, from an original with identical content.
+// It returns nil when there is none.
+func (s *GeneratedPhotoService) FindReusable(ctx context.Context, job *GeneratedPhoto) (*GeneratedPhoto, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT g.id, g.original_photo_id, g.theme_id, g.storage_key, g.status, g.credits_used, g.error_message, g.created_at, g.completed_at, g.provider_metadata, g.prompt, g.batch_id, g.blurhash, g.dominant_color
+		 FROM generated_photos g
+		 JOIN photos p ON p.id = g.original_photo_id
+		 JOIN photos o ON o.content_sha256 = p.content_sha256
+		 WHERE o.id = $1 AND g.id <> $2 AND g.theme_id = $3 AND g.prompt IS NOT DISTINCT FROM $4
+		   AND g.status = 'completed' AND g.storage_key <> '' AND g.object_missing_at IS NULL
+		 ORDER BY g.completed_at DESC
+		 LIMIT 1`,
+		job.OriginalPhotoID, job.ID, job.ThemeID, job.Prompt,
+	)
 	if err != nil {
 		return nil, err
 	}
-	if generated == nil {
-		return nil, errors.New("generated photo not found")
-	}
+	defer rows.Close()
 
-	generated.Status = input.Status
-	if input.ErrorMessage != nil {
-		generated.ErrorMessage = input.ErrorMessage
+	found, err := s.scanGeneratedPhotos(rows)
+	if err != nil || len(found) == 0 {
+		return nil, err
 	}
+	return &found[0], nil
+}
+
+// MarkReused completes a processing job with a copy of an earlier generation's result and
+// refunds the job's credits, since nothing was generated
+func (s *GeneratedPhotoService) MarkReused(ctx context.Context, id, storageKey string, sizeBytes int64, placeholder imaging.Placeholder, sourceID string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE generated_photos
+			 SET status = 'completed', storage_key = $2, size_bytes = $3, credits_used = 0, error_message = NULL, completed_at = NOW(),
+			     provider_metadata = COALESCE((SELECT provider_metadata FROM generated_photos WHERE id = $4), '{}'::jsonb)
+			                         || jsonb_build_object('reused_from', $4::TEXT),
+			     blurhash = NULLIF($5, ''), dominant_color = NULLIF($6, '')
+			 WHERE id = $1 AND status = 'processing'`,
+			id, storageKey, sizeBytes, sourceID, placeholder.BlurHash, placeholder.DominantColor,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectOneRow(result, "generated photo is not processing"); err != nil {
+			return err
+		}
+		return s.creditService.ReleaseTx(ctx, tx, creditEntityGeneratedPhoto, id, "Refund for generation reused from an identical photo")
+	})
+}
+
+// MarkFailed moves a processing job to error with the given reason and refunds its credits
+func (s *GeneratedPhotoService) MarkFailed(ctx context.Context, id, message string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`UPDATE generated_photos
+			 SET status = 'error', error_message = $2, completed_at = NOW()
+			 WHERE id = $1 AND status = 'processing'`,
+			id, message,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectOneRow(result, "generated photo is not processing"); err != nil {
+			return err
+		}
+		return s.creditService.ReleaseTx(ctx, tx, creditEntityGeneratedPhoto, id, "Refund for failed generation")
+	})
+}
+
+// RequeueStale returns jobs stuck in processing (e.g. after a worker crash) to the queue.
+// Jobs that already used up maxAttempts are failed instead and their credits refunded.
+func (s *GeneratedPhotoService) RequeueStale(ctx context.Context, olderThan time.Duration, maxAttempts int) (int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx,
+			`UPDATE generated_photos
+			 SET status = 'error', error_message = 'generation timed out', completed_at = NOW()
+			 WHERE status = 'processing' AND started_at < $1 AND attempts >= $2
+			 RETURNING id`,
+			cutoff, maxAttempts,
+		)
+		if err != nil {
+			return err
+		}
+
+		var failedIDs []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			failedIDs = append(failedIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
 
-	var completedAt interface{}
-	if input.Status == "completed" || input.Status == "error" {
-		now := time.Now()
-		generated.CompletedAt = &now
-		completedAt = now
+		for _, id := range failedIDs {
+			if err := s.creditService.ReleaseTx(ctx, tx, creditEntityGeneratedPhoto, id, "Refund for failed generation"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	_, err = s.db.ExecContext(ctx,
-		`UPDATE generated_photos 
-		 SET status = $2, error_message = $3, completed_at = $4
-		 WHERE id = $1`,
-		generated.ID, generated.Status, generated.ErrorMessage, completedAt,
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE generated_photos
+		 SET status = 'queued', started_at = NULL
+		 WHERE status = 'processing' AND started_at < $1 AND attempts < $2`,
+		cutoff, maxAttempts,
 	)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	return generated, nil
+	return result.RowsAffected()
 }
 
-// Delete soft-deletes a generated photo
+// ErrGenerationInProgress is returned when deleting a generation a worker is running
+var ErrGenerationInProgress = errors.New("generation is in progress, delete it once it has finished")
+
+// Delete removes a generated photo. Deleting a queued job refunds its credit hold; a job
+// a worker is processing can't be deleted until it completes or fails, since the worker
+// would otherwise store its result for a job that no longer exists.
 func (s *GeneratedPhotoService) Delete(ctx context.Context, id string) error {
-	_, err := s.db.ExecContext(ctx,
-		`DELETE FROM generated_photos WHERE id = $1`,
-		id,
-	)
-	return err
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx,
+			`DELETE FROM generated_photos WHERE id = $1 AND status <> 'processing'`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+		deleted, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if deleted == 0 {
+			var exists bool
+			err := tx.QueryRowContext(ctx,
+				`SELECT EXISTS (SELECT 1 FROM generated_photos WHERE id = $1)`,
+				id,
+			).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if exists {
+				return ErrGenerationInProgress
+			}
+			return nil
+		}
+		return s.creditService.ReleaseTx(ctx, tx, creditEntityGeneratedPhoto, id, "Refund for cancelled generation")
+	})
 }
 
 // GetOriginalPhotoUserID gets the user ID of the original photo's uploader
@@ -274,12 +452,13 @@ func (s *GeneratedPhotoService) scanGeneratedPhotos(rows *sql.Rows) ([]Generated
 
 	for rows.Next() {
 		var g GeneratedPhoto
-		var errorMessage sql.NullString
+		var errorMessage, blurHash, dominantColor sql.NullString
 		var completedAt sql.NullTime
 
 		err := rows.Scan(
 			&g.ID, &g.OriginalPhotoID, &g.ThemeID, &g.StorageKey,
 			&g.Status, &g.CreditsUsed, &errorMessage, &g.CreatedAt, &completedAt,
+			&g.ProviderMetadata, &g.Prompt, &g.BatchID, &blurHash, &dominantColor,
 		)
 		if err != nil {
 			return nil, err
@@ -291,6 +470,12 @@ func (s *GeneratedPhotoService) scanGeneratedPhotos(rows *sql.Rows) ([]Generated
 		if completedAt.Valid {
 			g.CompletedAt = &completedAt.Time
 		}
+		if blurHash.Valid {
+			g.BlurHash = &blurHash.String
+		}
+		if dominantColor.Valid {
+			g.DominantColor = &dominantColor.String
+		}
 
 		generatedPhotos = append(generatedPhotos, g)
 	}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// createTestOriginal inserts an album, a photo in it and a theme owned by userID and
+// returns the photo and theme IDs
+func createTestOriginal(t *testing.T, db *sql.DB, userID string) (photoID, themeID string) {
+	t.Helper()
+	ctx := context.Background()
+	albumID, photoID, themeID := "album-"+userID, "photo-"+userID, "theme-"+userID
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO albums (id, group_id, user_id, name) VALUES ($1, $1, $2, 'Test album')`,
+		albumID, userID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO photos (id, album_id, user_id, storage_key) VALUES ($1, $2, $3, $4)`,
+		photoID, albumID, userID, "originals/"+photoID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO themes (id, group_id, name, user_id) VALUES ($1, $1, 'Test theme', $2)`,
+		themeID, userID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	return photoID, themeID
+}
+
+func TestClaimNext(t *testing.T) {
+	db := openTestDB(t)
+	generated := NewGeneratedPhotoService(db, NewCreditService(db), 1)
+	ctx := context.Background()
+	userID := createTestUser(t, db, 10)
+	photoID, themeID := createTestOriginal(t, db, userID)
+
+	const jobs, workers = 5, 8
+	queued := make(map[string]bool)
+	for i := 0; i < jobs; i++ {
+		job, err := generated.Create(ctx, CreateGeneratedPhotoInput{
+			OriginalPhotoID: photoID, ThemeID: themeID, UserID: userID, Prompt: "a prompt",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		queued[job.ID] = true
+	}
+
+	// Every worker claims until the queue is empty; no job may be handed out twice
+	var mu sync.Mutex
+	claimed := make(map[string]int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := generated.ClaimNext(ctx)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if job == nil {
+					return
+				}
+				if job.Status != "processing" {
+					t.Errorf("claimed job status = %q, want processing", job.Status)
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(claimed) != jobs {
+		t.Errorf("claimed %d jobs, want %d", len(claimed), jobs)
+	}
+	for id, n := range claimed {
+		if !queued[id] {
+			t.Errorf("claimed unknown job %s", id)
+		}
+		if n != 1 {
+			t.Errorf("job %s claimed %d times", id, n)
+		}
+	}
+
+	var attempts int
+	if err := db.QueryRow(`SELECT MIN(attempts) FROM generated_photos WHERE status = 'processing'`).Scan(&attempts); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRequeueStale(t *testing.T) {
+	db := openTestDB(t)
+	credits := NewCreditService(db)
+	generated := NewGeneratedPhotoService(db, credits, 1)
+	ctx := context.Background()
+
+	const maxAttempts = 3
+	tests := []struct {
+		name         string
+		startedAgo   string // interval since the job was claimed
+		attempts     int
+		wantStatus   string
+		wantRequeued int64
+		wantBalance  int
+	}{
+		{
+			name:         "stale job is requeued",
+			startedAgo:   "1 hour",
+			attempts:     1,
+			wantStatus:   "queued",
+			wantRequeued: 1,
+			wantBalance:  9,
+		},
+		{
+			name:        "recent job is left alone",
+			startedAgo:  "1 second",
+			attempts:    1,
+			wantStatus:  "processing",
+			wantBalance: 9,
+		},
+		{
+			name:        "stale job out of attempts fails and is refunded",
+			startedAgo:  "1 hour",
+			attempts:    maxAttempts,
+			wantStatus:  "error",
+			wantBalance: 10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t, db, 10)
+			photoID, themeID := createTestOriginal(t, db, userID)
+			job, err := generated.Create(ctx, CreateGeneratedPhotoInput{
+				OriginalPhotoID: photoID, ThemeID: themeID, UserID: userID, Prompt: "a prompt",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec(
+				`UPDATE generated_photos SET status = 'processing', started_at = NOW() - $2::INTERVAL, attempts = $3 WHERE id = $1`,
+				job.ID, tt.startedAgo, tt.attempts,
+			); err != nil {
+				t.Fatal(err)
+			}
+
+			requeued, err := generated.RequeueStale(ctx, time.Minute, maxAttempts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if requeued != tt.wantRequeued {
+				t.Errorf("requeued = %d, want %d", requeued, tt.wantRequeued)
+			}
+
+			got, err := generated.GetByID(ctx, job.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantStatus {
+				t.Errorf("status = %q, want %q", got.Status, tt.wantStatus)
+			}
+
+			balance, err := credits.GetBalance(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance.Balance, tt.wantBalance)
+			}
+		})
+	}
+}
+
+func TestDeleteGeneratedPhoto(t *testing.T) {
+	db := openTestDB(t)
+	credits := NewCreditService(db)
+	generated := NewGeneratedPhotoService(db, credits, 1)
+	ctx := context.Background()
+
+	tests := []struct {
+		name        string
+		status      string
+		wantErr     error
+		wantDeleted bool
+		wantBalance int
+	}{
+		{name: "queued job is deleted and refunded", status: "queued", wantDeleted: true, wantBalance: 10},
+		{name: "processing job is kept", status: "processing", wantErr: ErrGenerationInProgress, wantBalance: 9},
+		{name: "failed job is deleted", status: "error", wantDeleted: true, wantBalance: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID := createTestUser(t, db, 10)
+			photoID, themeID := createTestOriginal(t, db, userID)
+			job, err := generated.Create(ctx, CreateGeneratedPhotoInput{
+				OriginalPhotoID: photoID, ThemeID: themeID, UserID: userID, Prompt: "a prompt",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != "queued" {
+				if _, err := generated.ClaimNext(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.status == "error" {
+				if err := generated.MarkFailed(ctx, job.ID, "provider error"); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := generated.Delete(ctx, job.ID); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Delete() error = %v, want %v", err, tt.wantErr)
+			}
+
+			var exists bool
+			if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM generated_photos WHERE id = $1)`, job.ID).Scan(&exists); err != nil {
+				t.Fatal(err)
+			}
+			if exists == tt.wantDeleted {
+				t.Errorf("row exists = %v, want %v", exists, !tt.wantDeleted)
+			}
+
+			balance, err := credits.GetBalance(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if balance.Balance != tt.wantBalance {
+				t.Errorf("balance = %d, want %d", balance.Balance, tt.wantBalance)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// GenerationBatch groups the generated photos queued by applying a theme to an album
+type GenerationBatch struct {
+	ID              string                  `json:"id"`
+	UserID          string                  `json:"user_id"`
+	AlbumID         string                  `json:"album_id"`
+	ThemeID         string                  `json:"theme_id"`
+	PhotoCount      int                     `json:"photo_count"`
+	SkippedCount    int                     `json:"skipped_count"`
+	CreditsReserved int                     `json:"credits_reserved"`
+	CreatedAt       time.Time               `json:"created_at"`
+	Progress        GenerationBatchProgress `json:"progress"`
+}
+
+// GenerationBatchProgress aggregates the status of a batch's generated photos
+type GenerationBatchProgress struct {
+	Queued     int  `json:"queued"`
+	Processing int  `json:"processing"`
+	Completed  int  `json:"completed"`
+	Error      int  `json:"error"`
+	Done       bool `json:"done"` // true once no job is queued or processing
+}
+
+// ApplyThemeInput holds data for applying a theme to every ready photo in an album
+type ApplyThemeInput struct {
+	UserID string
+	Album  *Album
+	Theme  *Theme
+	Params map[string]string
+}
+
+// ApplyThemePlan describes what applying a theme to an album would queue and cost
+type ApplyThemePlan struct {
+	PhotoCount   int  `json:"photo_count"`
+	SkippedCount int  `json:"skipped_count"` // ready photos already generated with the theme's current prompt
+	CreditCost   int  `json:"credit_cost"`
+	Balance      int  `json:"balance"`
+	Affordable   bool `json:"affordable"`
+}
+
+// GenerationBatchService handles batch generation across an album
+type GenerationBatchService struct {
+	db                    *sql.DB
+	themeService          *ThemeService
+	generatedPhotoService *GeneratedPhotoService
+	creditService         *CreditService
+}
+
+// NewGenerationBatchService creates a new GenerationBatchService
+func NewGenerationBatchService(db *sql.DB, themeService *ThemeService, generatedPhotoService *GeneratedPhotoService, creditService *CreditService) *GenerationBatchService {
+	return &GenerationBatchService{
+		db:                    db,
+		themeService:          themeService,
+		generatedPhotoService: generatedPhotoService,
+		creditService:         creditService,
+	}
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// ErrNothingToGenerate is returned when every ready photo in the album already has a
+// generation for the theme's current prompt
+var ErrNothingToGenerate = errors.New("no photos need generating for this theme")
+
+// Plan computes the cost of applying a theme to an album without queuing anything.
+// Prompts are rendered for every photo so template or parameter errors surface here.
+func (s *GenerationBatchService) Plan(ctx context.Context, input ApplyThemeInput) (*ApplyThemePlan, error) {
+	photos, _, skipped, err := s.eligiblePhotos(ctx, s.db, input)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.creditService.GetBalance(ctx, input.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.plan(len(photos), skipped, balance.Balance), nil
+}
+
+// Apply queues one generation per eligible photo and holds credits for the whole
+// batch in a single transaction: either every job is queued and paid for, or none is.
+// The returned plan is computed inside that transaction, so its counts and balance are
+// the ones the batch was actually queued with.
+func (s *GenerationBatchService) Apply(ctx context.Context, input ApplyThemeInput) (*GenerationBatch, *ApplyThemePlan, error) {
+	var batch *GenerationBatch
+	var plan *ApplyThemePlan
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		// Serialize concurrent applies of the same theme to the same album so a
+		// double submit can't queue (and charge for) the same photos twice
+		_, err := tx.ExecContext(ctx,
+			`SELECT pg_advisory_xact_lock(hashtext($1 || ':' || $2))`,
+			input.Album.ID, input.Theme.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		photos, prompts, skipped, err := s.eligiblePhotos(ctx, tx, input)
+		if err != nil {
+			return err
+		}
+		if len(photos) == 0 {
+			return ErrNothingToGenerate
+		}
+
+		var balance int
+		err = tx.QueryRowContext(ctx,
+			`SELECT COALESCE((SELECT balance FROM credits WHERE user_id = $1 FOR UPDATE), 0)`,
+			input.UserID,
+		).Scan(&balance)
+		if err != nil {
+			return err
+		}
+		plan = s.plan(len(photos), skipped, balance)
+		if !plan.Affordable {
+			return ErrInsufficientCredits
+		}
+
+		batch = &GenerationBatch{
+			ID:              uuid.New().String(),
+			UserID:          input.UserID,
+			AlbumID:         input.Album.ID,
+			ThemeID:         input.Theme.ID,
+			PhotoCount:      plan.PhotoCount,
+			SkippedCount:    plan.SkippedCount,
+			CreditsReserved: plan.CreditCost,
+			CreatedAt:       time.Now(),
+			Progress:        GenerationBatchProgress{Queued: len(photos)},
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO generation_batches (id, user_id, album_id, theme_id, photo_count, skipped_count, credits_reserved, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			batch.ID, batch.UserID, batch.AlbumID, batch.ThemeID,
+			batch.PhotoCount, batch.SkippedCount, batch.CreditsReserved, batch.CreatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		for i, photo := range photos {
+			_, err := s.generatedPhotoService.createTx(ctx, tx, CreateGeneratedPhotoInput{
+				OriginalPhotoID: photo.ID,
+				ThemeID:         input.Theme.ID,
+				UserID:          input.UserID,
+				Prompt:          prompts[i],
+				BatchID:         &batch.ID,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return batch, plan, nil
+}
+
+// plan prices a batch of photoCount generations against the user's balance
+func (s *GenerationBatchService) plan(photoCount, skipped, balance int) *ApplyThemePlan {
+	cost := photoCount * s.generatedPhotoService.CreditCost()
+	return &ApplyThemePlan{
+		PhotoCount:   photoCount,
+		SkippedCount: skipped,
+		CreditCost:   cost,
+		Balance:      balance,
+		Affordable:   balance >= cost,
+	}
+}
+
+// GetByID retrieves a batch with its aggregate progress
+func (s *GenerationBatchService) GetByID(ctx context.Context, id string) (*GenerationBatch, error) {
+	batch := &GenerationBatch{}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT b.id, b.user_id, b.album_id, b.theme_id, b.photo_count, b.skipped_count, b.credits_reserved, b.created_at,
+		        COUNT(g.id) FILTER (WHERE g.status = 'queued'),
+		        COUNT(g.id) FILTER (WHERE g.status = 'processing'),
+		        COUNT(g.id) FILTER (WHERE g.status = 'completed'),
+		        COUNT(g.id) FILTER (WHERE g.status = 'error')
+		 FROM generation_batches b
+		 LEFT JOIN generated_photos g ON g.batch_id = b.id
+		 WHERE b.id = $1
+		 GROUP BY b.id`,
+		id,
+	).Scan(
+		&batch.ID, &batch.UserID, &batch.AlbumID, &batch.ThemeID,
+		&batch.PhotoCount, &batch.SkippedCount, &batch.CreditsReserved, &batch.CreatedAt,
+		&batch.Progress.Queued, &batch.Progress.Processing, &batch.Progress.Completed, &batch.Progress.Error,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("generation batch not found")
+		}
+		return nil, err
+	}
+
+	batch.Progress.Done = batch.Progress.Queued == 0 && batch.Progress.Processing == 0
+	return batch, nil
+}
+
+// eligiblePhotos returns the album's ready photos that have no completed or in-flight
+// generation for the theme's current prompt, with the prompts rendered for them, along
+// with how many ready photos were skipped. Staged themes are edited in place, so a
+// generation only counts when its prompt matches what the template renders now; one made
+// before the template or its parameters changed doesn't.
+func (s *GenerationBatchService) eligiblePhotos(ctx context.Context, q queryer, input ApplyThemeInput) ([]Photo, []string, int, error) {
+	rows, err := q.QueryContext(ctx,
+		`SELECT `+photoColumns+`
+		 FROM photos
+		 WHERE album_id = $1 AND status = 'ready'
+		 ORDER BY created_at`,
+		input.Album.ID,
+	)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer rows.Close()
+
+	ready, err := scanPhotos(rows)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	prompts, err := s.renderPrompts(input, ready)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	existing, err := q.QueryContext(ctx,
+		`SELECT g.original_photo_id, g.prompt
+		 FROM generated_photos g
+		 JOIN photos p ON p.id = g.original_photo_id
+		 WHERE p.album_id = $1 AND g.theme_id = $2 AND g.prompt IS NOT NULL
+		   AND g.status IN ('queued', 'processing', 'completed')`,
+		input.Album.ID, input.Theme.ID,
+	)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer existing.Close()
+
+	type generationKey struct{ photoID, prompt string }
+	generated := make(map[generationKey]bool)
+	for existing.Next() {
+		var key generationKey
+		if err := existing.Scan(&key.photoID, &key.prompt); err != nil {
+			return nil, nil, 0, err
+		}
+		generated[key] = true
+	}
+	if err := existing.Err(); err != nil {
+		return nil, nil, 0, err
+	}
+
+	var photos []Photo
+	var photoPrompts []string
+	for i, photo := range ready {
+		if generated[generationKey{photo.ID, prompts[i]}] {
+			continue
+		}
+		photos = append(photos, photo)
+		photoPrompts = append(photoPrompts, prompts[i])
+	}
+	return photos, photoPrompts, len(ready) - len(photos), nil
+}
+
+// renderPrompts renders the theme's prompt for each photo, in order
+func (s *GenerationBatchService) renderPrompts(input ApplyThemeInput, photos []Photo) ([]string, error) {
+	prompts := make([]string, len(photos))
+	for i := range photos {
+		prompt, err := s.themeService.RenderPrompt(input.Theme, PromptVariables{
+			Photo:  &photos[i],
+			Album:  input.Album,
+			Params: input.Params,
+		})
+		if err != nil {
+			return nil, err
+		}
+		prompts[i] = prompt
+	}
+	return prompts, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestApplyTheme(t *testing.T) {
+	db := openTestDB(t)
+	credits := NewCreditService(db)
+	themes := NewThemeService(db)
+	generated := NewGeneratedPhotoService(db, credits, 1)
+	batches := NewGenerationBatchService(db, themes, generated, credits)
+	ctx := context.Background()
+
+	userID := createTestUser(t, db, 10)
+	photoID, _ := createTestOriginal(t, db, userID)
+	album := &Album{ID: "album-" + userID, Name: "Test album"}
+	for i := 0; i < 3; i++ {
+		_, err := db.Exec(
+			`INSERT INTO photos (id, album_id, user_id, storage_key, status) VALUES ($1, $2, $3, $4, 'ready')`,
+			fmt.Sprintf("%s-%d", photoID, i), album.ID, userID, fmt.Sprintf("originals/%s-%d", photoID, i),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	template := "A {{ album.name }} photo in ink"
+	theme, err := themes.Create(ctx, CreateThemeInput{UserID: userID, Name: "Ink", PromptTemplate: &template})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := ApplyThemeInput{UserID: userID, Album: album, Theme: theme}
+
+	checkPlan := func(t *testing.T, got *ApplyThemePlan, want ApplyThemePlan) {
+		t.Helper()
+		if *got != want {
+			t.Errorf("plan = %+v, want %+v", *got, want)
+		}
+	}
+
+	batch, plan, err := batches.Apply(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, ApplyThemePlan{PhotoCount: 3, CreditCost: 3, Balance: 10, Affordable: true})
+	if batch.PhotoCount != 3 || batch.SkippedCount != 0 || batch.CreditsReserved != 3 {
+		t.Errorf("batch counts = %d/%d/%d, want 3/0/3", batch.PhotoCount, batch.SkippedCount, batch.CreditsReserved)
+	}
+
+	// Applying the same template again has nothing left to queue
+	if _, _, err := batches.Apply(ctx, input); !errors.Is(err, ErrNothingToGenerate) {
+		t.Fatalf("second Apply() error = %v, want %v", err, ErrNothingToGenerate)
+	}
+	plan, err = batches.Plan(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, ApplyThemePlan{SkippedCount: 3, Balance: 7, Affordable: true})
+
+	// Editing the staged theme in place keeps its ID, but earlier generations no longer match
+	template = "A {{ album.name }} photo in oil"
+	theme, err = themes.Update(ctx, UpdateThemeInput{ID: theme.ID, PromptTemplate: &template})
+	if err != nil {
+		t.Fatal(err)
+	}
+	input.Theme = theme
+	plan, err = batches.Plan(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, ApplyThemePlan{PhotoCount: 3, CreditCost: 3, Balance: 7, Affordable: true})
+
+	// A balance spent between the plan and the apply is caught inside the transaction
+	if _, err := db.Exec(`UPDATE credits SET balance = 2 WHERE user_id = $1`, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := batches.Apply(ctx, input); !errors.Is(err, ErrInsufficientCredits) {
+		t.Fatalf("unaffordable Apply() error = %v, want %v", err, ErrInsufficientCredits)
+	}
+
+	if _, err := db.Exec(`UPDATE credits SET balance = 5 WHERE user_id = $1`, userID); err != nil {
+		t.Fatal(err)
+	}
+	batch, plan, err = batches.Apply(ctx, input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPlan(t, plan, ApplyThemePlan{PhotoCount: 3, CreditCost: 3, Balance: 5, Affordable: true})
+
+	var queued int
+	err = db.QueryRow(`SELECT COUNT(*) FROM generated_photos WHERE batch_id = $1 AND prompt = 'A Test album photo in oil'`, batch.ID).Scan(&queued)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != 3 {
+		t.Errorf("queued %d generations with the new prompt, want 3", queued)
+	}
+}
@@ -0,0 +1,25 @@
+package services
+
+import "context"
+
+// ImageGenerationInput holds everything a provider needs to produce a themed image
+type ImageGenerationInput struct {
+	Image    []byte            // original image bytes
+	MimeType string            // content type of Image
+	Prompt   string            // fully rendered prompt
+	Size     string            // output size as WIDTHxHEIGHT; empty uses the provider default
+	Options  map[string]string // provider specific options (e.g. quality, background)
+}
+
+// ImageGenerationResult holds a generated image and what produced it
+type ImageGenerationResult struct {
+	Image    []byte
+	MimeType string
+	Metadata map[string]string // provider, model and any provider specific details
+}
+
+// ImageGenerator produces a themed variant of an image from a prompt.
+// Implementations must be safe for concurrent use.
+type ImageGenerator interface {
+	Generate(ctx context.Context, input ImageGenerationInput) (*ImageGenerationResult, error)
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// accountLockedReason is the error message of locked accounts
+const accountLockedReason = "too many failed sign-in attempts; try again later or use the unlock link sent to your email"
+
+// LoginLockoutService locks accounts after failed sign-ins in a row, so passwords and
+// two-factor codes can't be guessed by spreading attempts across many IPs
+type LoginLockoutService struct {
+	db          *sql.DB
+	accounts    *AccountService
+	threshold   int
+	duration    time.Duration
+	maxDuration time.Duration
+}
+
+// NewLoginLockoutService creates a new LoginLockoutService. The threshold-th failure in a
+// row locks an account for duration, and each failure after that for twice as long as the
+// one before, up to maxDuration. A threshold of 0 turns lockout off.
+func NewLoginLockoutService(db *sql.DB, accounts *AccountService, threshold int, duration, maxDuration time.Duration) *LoginLockoutService {
+	return &LoginLockoutService{
+		db:          db,
+		accounts:    accounts,
+		threshold:   threshold,
+		duration:    duration,
+		maxDuration: maxDuration,
+	}
+}
+
+// Check returns a *RateLimitError if a user is locked out. Only use it once the user has
+// proven who they are, as it tells locked accounts apart.
+func (s *LoginLockoutService) Check(ctx context.Context, userID string) error {
+	lockedUntil, err := s.lockedUntil(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if wait := time.Until(lockedUntil); wait > 0 {
+		return &RateLimitError{RetryAfter: wait, Reason: accountLockedReason}
+	}
+	return nil
+}
+
+// Locked reports whether a user is locked out
+func (s *LoginLockoutService) Locked(ctx context.Context, userID string) (bool, error) {
+	lockedUntil, err := s.lockedUntil(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return time.Now().Before(lockedUntil), nil
+}
+
+// lockedUntil returns when a user's lock ends, or the zero time if they aren't locked
+func (s *LoginLockoutService) lockedUntil(ctx context.Context, userID string) (time.Time, error) {
+	if s.threshold <= 0 {
+		return time.Time{}, nil
+	}
+
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, `SELECT locked_until FROM users WHERE id = $1`, userID).Scan(&lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, ErrUserNotFound
+		}
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+// RecordFailure counts a failed sign-in, locking the user out once there are enough in a
+// row. The first lock emails the user a link to unlock their account.
+func (s *LoginLockoutService) RecordFailure(ctx context.Context, user *User) error {
+	if s.threshold <= 0 {
+		return nil
+	}
+
+	var failures int
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`UPDATE users SET failed_login_count = failed_login_count + 1 WHERE id = $1
+			 RETURNING failed_login_count`,
+			user.ID,
+		).Scan(&failures)
+		if err != nil {
+			return err
+		}
+		if failures < s.threshold {
+			return nil
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`UPDATE users SET locked_until = $2 WHERE id = $1`,
+			user.ID, time.Now().Add(s.lockDuration(failures)),
+		)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if failures == s.threshold {
+		return s.accounts.SendUnlockEmail(ctx, user)
+	}
+	return nil
+}
+
+// RecordSuccess resets a user's failed sign-ins after they signed in
+func (s *LoginLockoutService) RecordSuccess(ctx context.Context, userID string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE users SET failed_login_count = 0, locked_until = NULL
+		 WHERE id = $1 AND failed_login_count > 0`,
+		userID,
+	)
+	return err
+}
+
+// lockDuration is how long the given number of failures in a row locks an account for
+func (s *LoginLockoutService) lockDuration(failures int) time.Duration {
+	d := s.duration
+	for i := s.threshold; i < failures && d < s.maxDuration; i++ {
+		d *= 2
+	}
+	return min(d, s.maxDuration)
+}
@@ -0,0 +1,15 @@
+package services
+
+import "context"
+
+// Email is a plain-text message to a single recipient
+type Email struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers emails. Implementations must be safe for concurrent use.
+type Mailer interface {
+	Send(ctx context.Context, email Email) error
+}
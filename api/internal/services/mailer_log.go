@@ -0,0 +1,44 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// LogMailer is a mailer for development and tests that delivers nothing: each email is
+// logged and, when a directory is set, written there as an .eml file
+type LogMailer struct {
+	dir    string
+	from   string
+	logger *slog.Logger
+}
+
+// NewLogMailer creates a mailer that logs emails and writes them to dir, if not empty
+func NewLogMailer(dir, from string, logger *slog.Logger) (*LogMailer, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("create mail directory: %w", err)
+		}
+	}
+	return &LogMailer{dir: dir, from: from, logger: logger}, nil
+}
+
+// Send logs an email, including its body so links can be followed from the log
+func (m *LogMailer) Send(ctx context.Context, email Email) error {
+	m.logger.Info("Email", "to", email.To, "subject", email.Subject, "body", email.Body)
+	if m.dir == "" {
+		return nil
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405"), uuid.New().String()[:8])
+	if err := os.WriteFile(filepath.Join(m.dir, name), formatEmail(m.from, email), 0o644); err != nil {
+		return fmt.Errorf("write email: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMTPMailer delivers emails through an SMTP server, using STARTTLS when the server offers it
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a mailer for the server at host:port. Without a username it sends
+// without authenticating, as local relays expect.
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	m := &SMTPMailer{addr: net.JoinHostPort(host, strconv.Itoa(port)), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers an email. net/smtp has no context support, so ctx is only checked before
+// connecting.
+func (m *SMTPMailer) Send(ctx context.Context, email Email) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{email.To}, formatEmail(m.from, email)); err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+	return nil
+}
+
+// formatEmail renders an email as an RFC 5322 message
+func formatEmail(from string, email Email) []byte {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "From: %s\r\n", headerValue(from))
+	fmt.Fprintf(&sb, "To: %s\r\n", headerValue(email.To))
+	fmt.Fprintf(&sb, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(email.Subject)))
+	fmt.Fprintf(&sb, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&sb, "Message-ID: <%s@%s>\r\n", uuid.New().String(), emailDomain(from))
+	sb.WriteString("MIME-Version: 1.0\r\n")
+	sb.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	sb.WriteString("\r\n")
+	sb.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+	return []byte(sb.String())
+}
+
+// headerValue removes line breaks, which would let a value add headers of its own
+func headerValue(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// emailDomain returns the domain of an address such as "Redrawn <no-reply@example.com>"
+func emailDomain(address string) string {
+	_, domain, ok := strings.Cut(strings.TrimSuffix(address, ">"), "@")
+	if !ok || domain == "" {
+		return "localhost"
+	}
+	return domain
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerWritesEmails(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	mailer, err := NewLogMailer(dir, "Redrawn <no-reply@redrawn.test>", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = mailer.Send(context.Background(), Email{
+		To:      "ada@example.com",
+		Subject: "Verify your email",
+		Body:    "Follow this link:\nhttps://app.test/verify?token=abc",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("mail directory has %v, want one .eml file", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	msg, err := mail.ReadMessage(f)
+	if err != nil {
+		t.Fatalf("written email doesn't parse: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"From":    "Redrawn <no-reply@redrawn.test>",
+		"To":      "ada@example.com",
+		"Subject": "Verify your email",
+	} {
+		if got := msg.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@redrawn.test>") {
+		t.Errorf("Message-ID = %q, want one at redrawn.test", id)
+	}
+	body, _ := io.ReadAll(msg.Body)
+	if !strings.Contains(string(body), "https://app.test/verify?token=abc") {
+		t.Errorf("body %q is missing the link", body)
+	}
+}
+
+func TestLogMailerWithoutDirectory(t *testing.T) {
+	mailer, err := NewLogMailer("", "no-reply@redrawn.test", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mailer.Send(context.Background(), Email{To: "ada@example.com", Subject: "Hi", Body: "Hello"}); err != nil {
+		t.Errorf("Send() error = %v", err)
+	}
+}
+
+func TestFormatEmailHeaderInjection(t *testing.T) {
+	tests := []struct {
+		name  string
+		email Email
+	}{
+		{"subject", Email{To: "ada@example.com", Subject: "Hi\r\nBcc: eve@example.com", Body: "Hello"}},
+		{"recipient", Email{To: "ada@example.com\nBcc: eve@example.com", Subject: "Hi", Body: "Hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := mail.ReadMessage(strings.NewReader(string(formatEmail("no-reply@redrawn.test", tt.email))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bcc := msg.Header.Get("Bcc"); bcc != "" {
+				t.Errorf("a header value added Bcc: %q", bcc)
+			}
+		})
+	}
+}
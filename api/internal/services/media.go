@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrMediaNotFound is returned for media that doesn't exist or isn't ready to be served
+var ErrMediaNotFound = errors.New("media not found")
+
+// Media is a stored image served by the media endpoint: a ready photo, a completed
+// generated photo, or one of their derivatives
+type Media struct {
+	StorageKey  string
+	AlbumID     string
+	PublicAlbum bool
+}
+
+// MediaService resolves media IDs to stored objects
+type MediaService struct {
+	db *sql.DB
+}
+
+// NewMediaService creates a new MediaService
+func NewMediaService(db *sql.DB) *MediaService {
+	return &MediaService{db: db}
+}
+
+// Resolve finds the object behind a photo or generated photo ID: for photos, the display
+// copy without metadata when there is one. With variant set, such as "webp_1024", the
+// matching derivative is returned instead of the full-size image.
+func (s *MediaService) Resolve(ctx context.Context, id, variant string) (*Media, error) {
+	media := &Media{}
+	var kind string
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT 'photo', COALESCE(p.display_storage_key, p.storage_key), p.album_id, a.is_public
+		 FROM photos p JOIN albums a ON a.id = p.album_id
+		 WHERE p.id = $1 AND p.status = 'ready' AND a.status != 'deleted'
+		 UNION ALL
+		 SELECT 'generated_photo', g.storage_key, p.album_id, a.is_public
+		 FROM generated_photos g
+		 JOIN photos p ON p.id = g.original_photo_id
+		 JOIN albums a ON a.id = p.album_id
+		 WHERE g.id = $1 AND g.status = 'completed' AND a.status != 'deleted'`,
+		id,
+	).Scan(&kind, &media.StorageKey, &media.AlbumID, &media.PublicAlbum)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMediaNotFound
+		}
+		return nil, err
+	}
+
+	if variant == "" {
+		return media, nil
+	}
+
+	column := "photo_id"
+	if kind == "generated_photo" {
+		column = "generated_photo_id"
+	}
+	err = s.db.QueryRowContext(ctx,
+		`SELECT storage_key FROM derivatives
+		 WHERE `+column+` = $1 AND format || '_' || size = $2`,
+		id, variant,
+	).Scan(&media.StorageKey)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMediaNotFound
+		}
+		return nil, err
+	}
+
+	return media, nil
+}
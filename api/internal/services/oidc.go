@@ -0,0 +1,353 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OIDCProviderConfig configures an identity provider users can sign in with
+type OIDCProviderConfig struct {
+	Name         string
+	Kind         string // "oidc" or "github"
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// ExternalIdentity is a user as described by an identity provider
+type ExternalIdentity struct {
+	Subject       string // the provider's stable ID for the user
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// OIDCAuthorization is where to send a user to sign in at a provider
+type OIDCAuthorization struct {
+	URL   string `json:"authorization_url"`
+	State string `json:"state"` // comes back on the redirect; clients check it's the one they started with
+}
+
+var (
+	// ErrUnknownOIDCProvider is returned for providers that aren't configured
+	ErrUnknownOIDCProvider = errors.New("unknown sign-in provider")
+	// ErrInvalidOIDCState is returned when a callback's state is unknown, expired or already used
+	ErrInvalidOIDCState = errors.New("sign-in expired or was already completed, please try again")
+	// ErrOIDCEmailNotVerified is returned when a provider account can't be linked or used to
+	// create an account because the provider hasn't verified its email address
+	ErrOIDCEmailNotVerified = errors.New("the provider account has no verified email address")
+	// ErrOIDCLoginFailed wraps errors talking to a provider or validating what it returned
+	ErrOIDCLoginFailed = errors.New("sign-in with the provider failed")
+)
+
+// identityProvider runs the authorization code flow with one provider
+type identityProvider interface {
+	// authorizationURL is where the user approves the sign-in; the provider then redirects
+	// to redirectURL with a code and state
+	authorizationURL(ctx context.Context, state, nonce, codeChallenge, redirectURL string) (string, error)
+	// exchange redeems a code and returns the user it was issued for
+	exchange(ctx context.Context, code, codeVerifier, nonce, redirectURL string) (*ExternalIdentity, error)
+}
+
+// OIDCService signs users in with external identity providers using the authorization
+// code flow with PKCE, creating or linking accounts as needed
+type OIDCService struct {
+	db             *sql.DB
+	userService    *UserService
+	sessionService *SessionService
+	providers      map[string]identityProvider
+	redirectURL    string
+	stateTTL       time.Duration
+}
+
+// NewOIDCService creates a new OIDCService. Providers redirect users back to redirectURL,
+// which must be registered with each of them, and sign-ins not finished within stateTTL
+// are dropped.
+func NewOIDCService(db *sql.DB, userService *UserService, sessionService *SessionService, configs []OIDCProviderConfig,
+	redirectURL string, stateTTL time.Duration) (*OIDCService, error) {
+	httpClient := &http.Client{Timeout: 15 * time.Second}
+	providers := make(map[string]identityProvider, len(configs))
+	for _, cfg := range configs {
+		switch cfg.Kind {
+		case "oidc":
+			providers[cfg.Name] = newOIDCProvider(cfg, httpClient)
+		case "github":
+			providers[cfg.Name] = newGitHubProvider(cfg, httpClient)
+		default:
+			return nil, fmt.Errorf("provider %s: unknown kind %q", cfg.Name, cfg.Kind)
+		}
+	}
+
+	return &OIDCService{
+		db:             db,
+		userService:    userService,
+		sessionService: sessionService,
+		providers:      providers,
+		redirectURL:    redirectURL,
+		stateTTL:       stateTTL,
+	}, nil
+}
+
+// Providers lists the names of the configured providers
+func (s *OIDCService) Providers() []string {
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Start begins a sign-in with a provider, returning the URL to send the user to
+func (s *OIDCService) Start(ctx context.Context, providerName string) (*OIDCAuthorization, error) {
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+
+	state, stateHash, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	verifier, _, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+	nonce, _, err := newSecretToken()
+	if err != nil {
+		return nil, err
+	}
+
+	authURL, err := provider.authorizationURL(ctx, state, nonce, pkceChallenge(verifier), s.redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCLoginFailed, err)
+	}
+
+	// Sign-ins that were never finished are cleaned up as new ones start
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expires_at < NOW()`); err != nil {
+		return nil, err
+	}
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, expires_at)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		stateHash, providerName, verifier, nonce, time.Now().Add(s.stateTTL),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCAuthorization{URL: authURL, State: state}, nil
+}
+
+// Finish completes a sign-in with the state and code a provider redirected back with, and
+// returns the user to sign in. A provider account that was signed in with before maps to
+// the same user. Otherwise it's linked to the user with the same email address, or a new
+// user is created, but only if the provider verified the address.
+func (s *OIDCService) Finish(ctx context.Context, state, code string) (*User, error) {
+	var providerName, verifier, nonce string
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM oidc_login_states
+		 WHERE state_hash = $1 AND expires_at > NOW()
+		 RETURNING provider, code_verifier, nonce`,
+		hashSecretToken(state),
+	).Scan(&providerName, &verifier, &nonce)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, err
+	}
+
+	provider, ok := s.providers[providerName]
+	if !ok {
+		return nil, ErrUnknownOIDCProvider
+	}
+	identity, err := provider.exchange(ctx, code, verifier, nonce, s.redirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCLoginFailed, err)
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("%w: the provider returned no subject", ErrOIDCLoginFailed)
+	}
+
+	userID, err := s.resolveUser(ctx, providerName, identity)
+	if err != nil {
+		return nil, err
+	}
+	return s.userService.GetByID(ctx, userID)
+}
+
+// resolveUser finds or creates the user a provider account belongs to
+func (s *OIDCService) resolveUser(ctx context.Context, providerName string, identity *ExternalIdentity) (string, error) {
+	var userID string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx,
+			`UPDATE user_identities SET email = NULLIF($3, ''), last_login_at = NOW()
+			 WHERE provider = $1 AND subject = $2
+			 RETURNING user_id`,
+			providerName, identity.Subject, identity.Email,
+		).Scan(&userID)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		var exists, verified bool
+		if identity.Email != "" {
+			err = tx.QueryRowContext(ctx,
+				`SELECT id, email_verified_at IS NOT NULL FROM users WHERE LOWER(email) = LOWER($1) FOR UPDATE`,
+				identity.Email,
+			).Scan(&userID, &verified)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			exists = err == nil
+		}
+
+		link, err := planIdentityLink(identity, exists, verified)
+		if err != nil {
+			return err
+		}
+		switch link {
+		case linkNewUser:
+			user, err := s.userService.createVerifiedTx(ctx, tx, identity.Email, identity.Name)
+			if err != nil {
+				return err
+			}
+			userID = user.ID
+		case linkClaimedUser:
+			if err := s.userService.clearPasswordTx(ctx, tx, userID); err != nil {
+				return err
+			}
+			if err := s.sessionService.revokeAllTx(ctx, tx, userID); err != nil {
+				return err
+			}
+			if err := s.userService.markEmailVerifiedTx(ctx, tx, userID); err != nil {
+				return err
+			}
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO user_identities (id, user_id, provider, subject, email)
+			 VALUES ($1, $2, $3, $4, $5)`,
+			uuid.New().String(), userID, providerName, identity.Subject, identity.Email,
+		)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
+
+// identityLink is what signing in with a provider account that isn't linked yet does
+type identityLink int
+
+const (
+	// linkNewUser creates a user for the provider account
+	linkNewUser identityLink = iota
+	// linkVerifiedUser links the provider account to the user with its email address
+	linkVerifiedUser
+	// linkClaimedUser links the provider account to the user with its email address, who
+	// never verified it. Anyone could have registered that account before the address's
+	// owner signed in with the provider, so its password and sessions go.
+	linkClaimedUser
+)
+
+// planIdentityLink decides how a provider account that isn't linked yet is linked, given
+// whether a user has its email address and whether they verified it. Only addresses the
+// provider verified are trusted, both to create a user and to link to an existing one.
+func planIdentityLink(identity *ExternalIdentity, userExists, userVerified bool) (identityLink, error) {
+	if identity.Email == "" || !identity.EmailVerified {
+		return 0, ErrOIDCEmailNotVerified
+	}
+	switch {
+	case !userExists:
+		return linkNewUser, nil
+	case !userVerified:
+		return linkClaimedUser, nil
+	default:
+		return linkVerifiedUser, nil
+	}
+}
+
+// pkceChallenge derives the S256 code challenge of a PKCE code verifier
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// oauthTokenResponse is the token endpoint response of the authorization code grant
+type oauthTokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeCode redeems an authorization code at a provider's token endpoint
+func exchangeCode(ctx context.Context, client *http.Client, tokenURL string, cfg OIDCProviderConfig,
+	code, codeVerifier, redirectURL string) (*oauthTokenResponse, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {cfg.ClientID},
+		"client_secret": {cfg.ClientSecret},
+		"code_verifier": {codeVerifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var token oauthTokenResponse
+	if err := doJSON(client, req, &token); err != nil && token.Error == "" {
+		return nil, fmt.Errorf("token request: %w", err)
+	}
+	if token.Error != "" {
+		return nil, fmt.Errorf("token request: %s: %s", token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("token request: no access token returned")
+	}
+	return &token, nil
+}
+
+// doJSON sends req, asking for JSON, and decodes the response into v. The body is decoded
+// even for error statuses, as OAuth endpoints describe their errors in it.
+func doJSON(client *http.Client, req *http.Request, v any) error {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", req.URL.Host, resp.Status)
+	}
+	return decodeErr
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// oidcKeyRefreshInterval limits how often the signing keys are fetched again when an ID
+// token names a key we don't have, which happens after the issuer rotates its keys
+const oidcKeyRefreshInterval = time.Minute
+
+// oidcProvider signs users in with an OpenID Connect issuer, configured by discovery
+type oidcProvider struct {
+	cfg        OIDCProviderConfig
+	httpClient *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	keys          map[string]crypto.PublicKey // by key ID
+	keysFetchedAt time.Time
+}
+
+// oidcDiscovery is the part of an issuer's /.well-known/openid-configuration we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// idTokenClaims are the ID token claims we read
+type idTokenClaims struct {
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	Name          string       `json:"name"`
+	Nonce         string       `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool is a boolean claim that some issuers send as the string "true"
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+func newOIDCProvider(cfg OIDCProviderConfig, httpClient *http.Client) *oidcProvider {
+	return &oidcProvider{cfg: cfg, httpClient: httpClient}
+}
+
+func (p *oidcProvider) authorizationURL(ctx context.Context, state, nonce, codeChallenge, redirectURL string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge},
+		"code_challenge_method": {"S256"},
+	}
+	return appendQuery(d.AuthorizationEndpoint, params), nil
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code, codeVerifier, nonce, redirectURL string) (*ExternalIdentity, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := exchangeCode(ctx, p.httpClient, d.TokenEndpoint, p.cfg, code, codeVerifier, redirectURL)
+	if err != nil {
+		return nil, err
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("no ID token returned; is the openid scope requested?")
+	}
+
+	claims, err := p.verifyIDToken(ctx, d, token.IDToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+
+	identity := &ExternalIdentity{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}
+	// Some issuers leave the profile out of the ID token and only serve it from userinfo
+	if identity.Email == "" && d.UserinfoEndpoint != "" {
+		if err := p.fillFromUserinfo(ctx, d.UserinfoEndpoint, token.AccessToken, identity); err != nil {
+			return nil, err
+		}
+	}
+	return identity, nil
+}
+
+// verifyIDToken checks an ID token's signature, issuer, audience and expiry
+func (p *oidcProvider) verifyIDToken(ctx context.Context, d *oidcDiscovery, idToken string) (*idTokenClaims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(idToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.signingKey(ctx, d, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(d.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	return claims, nil
+}
+
+// fillFromUserinfo reads the email and name of the user from the userinfo endpoint. The
+// subject must match the ID token's, so a mixed-up response can't switch users.
+func (p *oidcProvider) fillFromUserinfo(ctx context.Context, endpoint, accessToken string, identity *ExternalIdentity) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	var info struct {
+		Subject       string       `json:"sub"`
+		Email         string       `json:"email"`
+		EmailVerified flexibleBool `json:"email_verified"`
+		Name          string       `json:"name"`
+	}
+	if err := doJSON(p.httpClient, req, &info); err != nil {
+		return fmt.Errorf("userinfo request: %w", err)
+	}
+	if info.Subject != identity.Subject {
+		return errors.New("userinfo subject doesn't match the ID token")
+	}
+
+	identity.Email = info.Email
+	identity.EmailVerified = bool(info.EmailVerified)
+	if identity.Name == "" {
+		identity.Name = info.Name
+	}
+	return nil
+}
+
+// discover fetches the issuer's configuration the first time it's needed
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var d oidcDiscovery
+	if err := doJSON(p.httpClient, req, &d); err != nil {
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if d.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("discovery: issuer is %q, expected %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("discovery: authorization, token or JWKS endpoint missing")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// signingKey returns the issuer's public key with the given ID, fetching the key set
+// again if the key is unknown
+func (p *oidcProvider) signingKey(ctx context.Context, d *oidcDiscovery, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := lookupKey(p.keys, kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetchedAt) < oidcKeyRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := fetchJWKS(ctx, p.httpClient, d.JWKSURI)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+	p.keysFetchedAt = time.Now()
+
+	if key, ok := lookupKey(keys, kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey finds a key by ID. Tokens may leave the ID out when the issuer has one key.
+func lookupKey(keys map[string]crypto.PublicKey, kid string) (crypto.PublicKey, bool) {
+	if key, ok := keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// jsonWebKey is a public key of a JWKS; only RSA and EC signing keys are read
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchJWKS fetches a JSON Web Key Set and returns its signing keys by key ID
+func fetchJWKS(ctx context.Context, client *http.Client, jwksURL string) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := doJSON(client, req, &set); err != nil {
+		return nil, fmt.Errorf("JWKS request: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// Keys of types we don't support can't have signed the tokens we accept
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(v string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// appendQuery adds params to a URL that may already have a query
+func appendQuery(endpoint string, params url.Values) string {
+	if strings.Contains(endpoint, "?") {
+		return endpoint + "&" + params.Encode()
+	}
+	return endpoint + "?" + params.Encode()
+}
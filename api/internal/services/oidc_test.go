@@ -0,0 +1,72 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPlanIdentityLink(t *testing.T) {
+	verified := &ExternalIdentity{Subject: "sub", Email: "ada@example.com", EmailVerified: true}
+	unverified := &ExternalIdentity{Subject: "sub", Email: "ada@example.com"}
+	noEmail := &ExternalIdentity{Subject: "sub", EmailVerified: true}
+
+	tests := []struct {
+		name         string
+		identity     *ExternalIdentity
+		userExists   bool
+		userVerified bool
+		want         identityLink
+		wantErr      error
+	}{
+		{name: "new email", identity: verified, want: linkNewUser},
+		{name: "verified user", identity: verified, userExists: true, userVerified: true, want: linkVerifiedUser},
+		{name: "unverified user is claimed", identity: verified, userExists: true, want: linkClaimedUser},
+		{name: "unverified provider email, new", identity: unverified, wantErr: ErrOIDCEmailNotVerified},
+		{name: "unverified provider email, verified user", identity: unverified, userExists: true, userVerified: true, wantErr: ErrOIDCEmailNotVerified},
+		{name: "unverified provider email, unverified user", identity: unverified, userExists: true, wantErr: ErrOIDCEmailNotVerified},
+		{name: "no email", identity: noEmail, wantErr: ErrOIDCEmailNotVerified},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planIdentityLink(tt.identity, tt.userExists, tt.userVerified)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("planIdentityLink() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("planIdentityLink() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	got := pkceChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Errorf("pkceChallenge() = %q, want %q", got, want)
+	}
+}
+
+func TestFlexibleBool(t *testing.T) {
+	tests := []struct {
+		json string
+		want bool
+	}{
+		{`true`, true},
+		{`"true"`, true},
+		{`false`, false},
+		{`"false"`, false},
+		{`null`, false},
+		{`"yes"`, false},
+	}
+	for _, tt := range tests {
+		var got flexibleBool
+		if err := json.Unmarshal([]byte(tt.json), &got); err != nil {
+			t.Fatalf("unmarshal %s: %v", tt.json, err)
+		}
+		if bool(got) != tt.want {
+			t.Errorf("unmarshal %s = %v, want %v", tt.json, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const openAIImageEditsURL = "https://api.openai.com/v1/images/edits"
+
+// openAIEditOptions are the ImageGenerationInput.Options forwarded to the edits endpoint
+var openAIEditOptions = []string{"quality", "background", "output_format", "input_fidelity"}
+
+// OpenAIImageGenerator generates images with the OpenAI images edit API
+type OpenAIImageGenerator struct {
+	apiKey     string
+	model      string
+	size       string
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewOpenAIImageGenerator creates a new OpenAIImageGenerator
+func NewOpenAIImageGenerator(apiKey, model, size string) *OpenAIImageGenerator {
+	return &OpenAIImageGenerator{
+		apiKey:     apiKey,
+		model:      model,
+		size:       size,
+		endpoint:   openAIImageEditsURL,
+		httpClient: &http.Client{Timeout: 3 * time.Minute},
+	}
+}
+
+// openAIImageResponse is the subset of the images API response we use
+type openAIImageResponse struct {
+	Data []struct {
+		B64JSON       string `json:"b64_json"`
+		RevisedPrompt string `json:"revised_prompt"`
+	} `json:"data"`
+	Usage *struct {
+		TotalTokens int `json:"total_tokens"`
+	} `json:"usage,omitempty"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Generate sends the image and prompt to the images edit endpoint
+func (g *OpenAIImageGenerator) Generate(ctx context.Context, input ImageGenerationInput) (*ImageGenerationResult, error) {
+	if g.apiKey == "" {
+		return nil, errors.New("openai api key not configured")
+	}
+
+	size := input.Size
+	if size == "" {
+		size = g.size
+	}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	_ = writer.WriteField("model", g.model)
+	_ = writer.WriteField("prompt", input.Prompt)
+	_ = writer.WriteField("size", size)
+	_ = writer.WriteField("n", "1")
+	for _, key := range openAIEditOptions {
+		if v, ok := input.Options[key]; ok && v != "" {
+			_ = writer.WriteField(key, v)
+		}
+	}
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="image"; filename="image"`)
+	header.Set("Content-Type", input.MimeType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(input.Image); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := g.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openai request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read openai response: %w", err)
+	}
+
+	var result openAIImageResponse
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return nil, fmt.Errorf("invalid openai response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		if result.Error != nil {
+			return nil, fmt.Errorf("openai error: %s", result.Error.Message)
+		}
+		return nil, fmt.Errorf("openai returned status %d", resp.StatusCode)
+	}
+	if len(result.Data) == 0 || result.Data[0].B64JSON == "" {
+		return nil, errors.New("openai returned no image")
+	}
+
+	image, err := base64.StdEncoding.DecodeString(result.Data[0].B64JSON)
+	if err != nil {
+		return nil, fmt.Errorf("invalid openai image payload: %w", err)
+	}
+
+	metadata := map[string]string{
+		"provider": "openai",
+		"model":    g.model,
+		"size":     size,
+	}
+	if result.Data[0].RevisedPrompt != "" {
+		metadata["revised_prompt"] = result.Data[0].RevisedPrompt
+	}
+	if result.Usage != nil {
+		metadata["total_tokens"] = strconv.Itoa(result.Usage.TotalTokens)
+	}
+
+	mimeType := "image/png"
+	if format := input.Options["output_format"]; format != "" {
+		mimeType = "image/" + format
+	}
+
+	return &ImageGenerationResult{
+		Image:    image,
+		MimeType: mimeType,
+		Metadata: metadata,
+	}, nil
+}
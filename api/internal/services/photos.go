@@ -6,77 +6,138 @@ import (
 	"errors"
 	"time"
 
-	"github.com/google/uuid"
+	"redrawn/internal/imaging"
 )
 
 // Photo represents an uploaded photo
 type Photo struct {
-	ID          string    `json:"id"`
-	AlbumID     string    `json:"album_id"`
-	UserID      string    `json:"user_id"`
-	StorageKey  string    `json:"storage_key"`
-	Filename    *string   `json:"filename,omitempty"`
-	MimeType    *string   `json:"mime_type,omitempty"`
-	SizeBytes   *int64    `json:"size_bytes,omitempty"`
-	Width       *int      `json:"width,omitempty"`
-	Height      *int      `json:"height,omitempty"`
-	Status      string    `json:"status"`
-	CreatedAt   time.Time `json:"created_at"`
+	ID                string            `json:"id"`
+	AlbumID           string            `json:"album_id"`
+	UserID            string            `json:"user_id"`
+	StorageKey        string            `json:"-"` // the original, with its metadata; only served through /media
+	Filename          *string           `json:"filename,omitempty"`
+	MimeType          *string           `json:"mime_type,omitempty"`
+	SizeBytes         *int64            `json:"size_bytes,omitempty"`
+	Width             *int              `json:"width,omitempty"`
+	Height            *int              `json:"height,omitempty"`
+	Status            string            `json:"status"`
+	ErrorMessage      *string           `json:"error_message,omitempty"` // why ingestion rejected the upload
+	TakenAt           *time.Time        `json:"taken_at,omitempty"`      // camera clock time, without a time zone
+	CameraMake        *string           `json:"camera_make,omitempty"`
+	CameraModel       *string           `json:"camera_model,omitempty"`
+	Orientation       *int              `json:"orientation,omitempty"` // EXIF orientation of the original; copies are upright
+	Latitude          *float64          `json:"latitude,omitempty"`    // only kept in albums with keep_location
+	Longitude         *float64          `json:"longitude,omitempty"`
+	DisplayStorageKey *string           `json:"-"` // upright copy without metadata, served instead of the original
+	ContentSHA256     *string           `json:"content_sha256,omitempty"`
+	DuplicateOf       *string           `json:"duplicate_of,omitempty"`   // the photo of the album an identical upload was rejected for
+	BlurHash          *string           `json:"blurhash,omitempty"`       // placeholder shown while the image loads
+	DominantColor     *string           `json:"dominant_color,omitempty"` // "#rrggbb"
+	CreatedAt         time.Time         `json:"created_at"`
+	Variants          map[string]string `json:"variants,omitempty"` // resized copies, e.g. "webp_256" -> URL
 }
 
-// CreatePhotoInput holds data for creating a photo
+// ServedStorageKey is the key of the object served for the photo: its display copy, or
+// the original when it didn't need one
+func (p Photo) ServedStorageKey() string {
+	if p.DisplayStorageKey != nil {
+		return *p.DisplayStorageKey
+	}
+	return p.StorageKey
+}
+
+// CreatePhotoInput holds data for creating a photo from a finished upload
 type CreatePhotoInput struct {
 	AlbumID    string  `json:"album_id" validate:"required"`
 	UserID     string  `json:"user_id" validate:"required"`
-	StorageKey string  `json:"storage_key" validate:"required"`
-	Filename   *string `json:"filename,omitempty"`
-	MimeType   *string `json:"mime_type,omitempty"`
-	SizeBytes  *int64  `json:"size_bytes,omitempty"`
-	Width      *int    `json:"width,omitempty"`
-	Height     *int    `json:"height,omitempty"`
+	StorageKey string  `json:"storage_key" validate:"required"` // key issued by UploadService.CreateUploadURL
+	Filename   *string `json:"filename,omitempty"`              // defaults to the filename declared for the upload
 }
 
-// UpdatePhotoInput holds data for updating a photo
+// UpdatePhotoInput holds data for updating a photo. Status and dimensions are set by
+// ingestion, not by clients.
 type UpdatePhotoInput struct {
-	ID        string  `json:"id" validate:"required"`
-	Filename  *string `json:"filename,omitempty"`
-	Status    *string `json:"status,omitempty"`
-	Width     *int    `json:"width,omitempty"`
-	Height    *int    `json:"height,omitempty"`
+	ID       string  `json:"id" validate:"required"`
+	Filename *string `json:"filename,omitempty"`
 }
 
+// PhotoMetadata holds the properties ingestion reads from the stored object. Width and
+// height are those of the upright image.
+type PhotoMetadata struct {
+	MimeType    string
+	SizeBytes   int64
+	Width       int
+	Height      int
+	TakenAt     *time.Time
+	CameraMake  string
+	CameraModel string
+	Orientation int
+	Latitude    *float64
+	Longitude   *float64
+	// DisplayStorageKey is set when ingestion stored a display copy of DisplaySizeBytes
+	DisplayStorageKey string
+	DisplaySizeBytes  int64
+	ContentSHA256     string // hex SHA-256 of the original
+	PerceptualHash    uint64 // see imaging.PerceptualHash
+	Placeholder       imaging.Placeholder
+}
+
+// ErrDuplicatePhoto is returned when ingestion rejects a photo identical to a ready photo
+// of the same album; the rejected photo's DuplicateOf points at that photo
+var ErrDuplicatePhoto = errors.New("duplicate of a photo already in the album")
+
+// photoColumns is the column list scanPhoto expects, in order
+const photoColumns = `id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, error_message,
+	taken_at, camera_make, camera_model, orientation, latitude, longitude, display_storage_key, content_sha256, duplicate_of,
+	blurhash, dominant_color, created_at`
+
 // PhotoService handles photo business logic
 type PhotoService struct {
-	db *sql.DB
+	db            *sql.DB
+	uploadService *UploadService
 }
 
 // NewPhotoService creates a new PhotoService
-func NewPhotoService(db *sql.DB) *PhotoService {
-	return &PhotoService{db: db}
+func NewPhotoService(db *sql.DB, uploadService *UploadService) *PhotoService {
+	return &PhotoService{db: db, uploadService: uploadService}
 }
 
-// Create creates a new photo record
+// Create creates a new photo record from a finished upload. The storage key must have been
+// issued to the user for the album and not used before, and the stored object must match
+// what was declared. The photo starts as uploaded until ingestion validates its content.
 func (s *PhotoService) Create(ctx context.Context, input CreatePhotoInput) (*Photo, error) {
-	photo := &Photo{
-		ID:         uuid.New().String(),
-		AlbumID:    input.AlbumID,
-		UserID:     input.UserID,
-		StorageKey: input.StorageKey,
-		Filename:   input.Filename,
-		MimeType:   input.MimeType,
-		SizeBytes:  input.SizeBytes,
-		Width:      input.Width,
-		Height:     input.Height,
-		Status:     "uploaded",
-		CreatedAt:  time.Now(),
-	}
+	var photo *Photo
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		upload, err := s.uploadService.consumeTx(ctx, tx, input.UserID, input.AlbumID, input.StorageKey)
+		if err != nil {
+			return err
+		}
 
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO photos (id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
-		photo.ID, photo.AlbumID, photo.UserID, photo.StorageKey, photo.Filename,
-		photo.MimeType, photo.SizeBytes, photo.Width, photo.Height, photo.Status, photo.CreatedAt,
-	)
+		filename := upload.Filename
+		if input.Filename != nil {
+			filename = SanitizeFilename(*input.Filename)
+		}
+
+		photo = &Photo{
+			ID:         upload.PhotoID,
+			AlbumID:    input.AlbumID,
+			UserID:     input.UserID,
+			StorageKey: input.StorageKey,
+			Filename:   &filename,
+			MimeType:   &upload.MimeType,
+			SizeBytes:  &upload.SizeBytes,
+			Status:     "uploaded",
+			CreatedAt:  time.Now(),
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO photos (id, album_id, user_id, storage_key, filename, mime_type, size_bytes, status, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+			photo.ID, photo.AlbumID, photo.UserID, photo.StorageKey, photo.Filename,
+			photo.MimeType, photo.SizeBytes, photo.Status, photo.CreatedAt,
+		)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -86,52 +147,24 @@ func (s *PhotoService) Create(ctx context.Context, input CreatePhotoInput) (*Pho
 
 // GetByID retrieves a photo by ID
 func (s *PhotoService) GetByID(ctx context.Context, id string) (*Photo, error) {
-	photo := &Photo{}
-	var filename, mimeType sql.NullString
-	var sizeBytes sql.NullInt64
-	var width, height sql.NullInt32
-
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, created_at
+	photo, err := scanPhoto(s.db.QueryRowContext(ctx,
+		`SELECT `+photoColumns+`
 		 FROM photos WHERE id = $1`,
 		id,
-	).Scan(
-		&photo.ID, &photo.AlbumID, &photo.UserID, &photo.StorageKey,
-		&filename, &mimeType, &sizeBytes, &width, &height, &photo.Status, &photo.CreatedAt,
-	)
-
+	))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("photo not found")
 		}
 		return nil, err
 	}
-
-	if filename.Valid {
-		photo.Filename = &filename.String
-	}
-	if mimeType.Valid {
-		photo.MimeType = &mimeType.String
-	}
-	if sizeBytes.Valid {
-		photo.SizeBytes = &sizeBytes.Int64
-	}
-	if width.Valid {
-		w := int(width.Int32)
-		photo.Width = &w
-	}
-	if height.Valid {
-		h := int(height.Int32)
-		photo.Height = &h
-	}
-
 	return photo, nil
 }
 
 // ListByAlbum lists all photos in an album
 func (s *PhotoService) ListByAlbum(ctx context.Context, albumID string) ([]Photo, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, created_at
+		`SELECT `+photoColumns+`
 		 FROM photos WHERE album_id = $1 ORDER BY created_at DESC`,
 		albumID,
 	)
@@ -140,49 +173,13 @@ func (s *PhotoService) ListByAlbum(ctx context.Context, albumID string) ([]Photo
 	}
 	defer rows.Close()
 
-	var photos []Photo
-	for rows.Next() {
-		var photo Photo
-		var filename, mimeType sql.NullString
-		var sizeBytes sql.NullInt64
-		var width, height sql.NullInt32
-
-		err := rows.Scan(
-			&photo.ID, &photo.AlbumID, &photo.UserID, &photo.StorageKey,
-			&filename, &mimeType, &sizeBytes, &width, &height, &photo.Status, &photo.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if filename.Valid {
-			photo.Filename = &filename.String
-		}
-		if mimeType.Valid {
-			photo.MimeType = &mimeType.String
-		}
-		if sizeBytes.Valid {
-			photo.SizeBytes = &sizeBytes.Int64
-		}
-		if width.Valid {
-			w := int(width.Int32)
-			photo.Width = &w
-		}
-		if height.Valid {
-			h := int(height.Int32)
-			photo.Height = &h
-		}
-
-		photos = append(photos, photo)
-	}
-
-	return photos, rows.Err()
+	return scanPhotos(rows)
 }
 
 // ListByUser lists all photos uploaded by a user
 func (s *PhotoService) ListByUser(ctx context.Context, userID string) ([]Photo, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, created_at
+		`SELECT `+photoColumns+`
 		 FROM photos WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
@@ -191,43 +188,7 @@ func (s *PhotoService) ListByUser(ctx context.Context, userID string) ([]Photo,
 	}
 	defer rows.Close()
 
-	var photos []Photo
-	for rows.Next() {
-		var photo Photo
-		var filename, mimeType sql.NullString
-		var sizeBytes sql.NullInt64
-		var width, height sql.NullInt32
-
-		err := rows.Scan(
-			&photo.ID, &photo.AlbumID, &photo.UserID, &photo.StorageKey,
-			&filename, &mimeType, &sizeBytes, &width, &height, &photo.Status, &photo.CreatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
-
-		if filename.Valid {
-			photo.Filename = &filename.String
-		}
-		if mimeType.Valid {
-			photo.MimeType = &mimeType.String
-		}
-		if sizeBytes.Valid {
-			photo.SizeBytes = &sizeBytes.Int64
-		}
-		if width.Valid {
-			w := int(width.Int32)
-			photo.Width = &w
-		}
-		if height.Valid {
-			h := int(height.Int32)
-			photo.Height = &h
-		}
-
-		photos = append(photos, photo)
-	}
-
-	return photos, rows.Err()
+	return scanPhotos(rows)
 }
 
 // Update updates a photo
@@ -240,24 +201,13 @@ func (s *PhotoService) Update(ctx context.Context, input UpdatePhotoInput) (*Pho
 
 	filename := current.Filename
 	if input.Filename != nil {
-		filename = input.Filename
-	}
-	status := current.Status
-	if input.Status != nil {
-		status = *input.Status
-	}
-	width := current.Width
-	if input.Width != nil {
-		width = input.Width
-	}
-	height := current.Height
-	if input.Height != nil {
-		height = input.Height
+		sanitized := SanitizeFilename(*input.Filename)
+		filename = &sanitized
 	}
 
 	_, err = s.db.ExecContext(ctx,
-		`UPDATE photos SET filename = $1, status = $2, width = $3, height = $4 WHERE id = $5`,
-		filename, status, width, height, current.ID,
+		`UPDATE photos SET filename = $1 WHERE id = $2`,
+		filename, current.ID,
 	)
 	if err != nil {
 		return nil, err
@@ -286,6 +236,202 @@ func (s *PhotoService) UpdateStatus(ctx context.Context, id, status string) erro
 	return err
 }
 
+// Reingest puts a finished photo back in the ingestion queue, e.g. after its object was replaced
+func (s *PhotoService) Reingest(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE photos
+		 SET status = 'uploaded', error_message = NULL, duplicate_of = NULL, ingest_attempts = 0, processing_started_at = NULL
+		 WHERE id = $1 AND status IN ('ready', 'error')`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "photo is still being processed")
+}
+
+// ClaimNextUploaded atomically moves the oldest uploaded photo to processing and returns it.
+// Returns nil when there is nothing to ingest.
+func (s *PhotoService) ClaimNextUploaded(ctx context.Context) (*Photo, error) {
+	photo, err := scanPhoto(s.db.QueryRowContext(ctx,
+		`UPDATE photos
+		 SET status = 'processing', processing_started_at = NOW(), ingest_attempts = ingest_attempts + 1
+		 WHERE id = (
+			SELECT id FROM photos
+			WHERE status = 'uploaded'
+			ORDER BY created_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+photoColumns,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return photo, nil
+}
+
+// MarkReady records the metadata read from the stored object and makes the photo available.
+// If a ready photo of the album has the same content, the photo is rejected as its duplicate
+// instead and ErrDuplicatePhoto returned.
+func (s *PhotoService) MarkReady(ctx context.Context, id string, metadata PhotoMetadata) error {
+	var duplicateOf string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		duplicateOf, err = s.rejectDuplicateTx(ctx, tx, id, metadata.ContentSHA256)
+		if err != nil || duplicateOf != "" {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			`UPDATE photos
+			 SET status = 'ready', mime_type = $2, size_bytes = $3, width = $4, height = $5, error_message = NULL,
+			     taken_at = $6, camera_make = NULLIF($7, ''), camera_model = NULLIF($8, ''), orientation = $9,
+			     latitude = $10, longitude = $11, display_storage_key = NULLIF($12, ''), display_size_bytes = NULLIF($13::BIGINT, 0),
+			     content_sha256 = NULLIF($14, ''), perceptual_hash = $15, blurhash = NULLIF($16, ''), dominant_color = NULLIF($17, '')
+			 WHERE id = $1 AND status = 'processing'`,
+			id, metadata.MimeType, metadata.SizeBytes, metadata.Width, metadata.Height,
+			metadata.TakenAt, metadata.CameraMake, metadata.CameraModel, metadata.Orientation,
+			metadata.Latitude, metadata.Longitude, metadata.DisplayStorageKey, metadata.DisplaySizeBytes,
+			metadata.ContentSHA256, int64(metadata.PerceptualHash), metadata.Placeholder.BlurHash, metadata.Placeholder.DominantColor,
+		)
+		if err != nil {
+			return err
+		}
+		return expectOneRow(result, "photo is not processing")
+	})
+	if err == nil && duplicateOf != "" {
+		return ErrDuplicatePhoto
+	}
+	return err
+}
+
+// RejectDuplicate rejects a processing photo whose content, identified by its SHA-256, is
+// already a ready photo of the same album, and returns ErrDuplicatePhoto if it did. Ingestion
+// checks before doing any work; MarkReady checks again to catch concurrent uploads.
+func (s *PhotoService) RejectDuplicate(ctx context.Context, id, contentSHA256 string) error {
+	var duplicateOf string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var err error
+		duplicateOf, err = s.rejectDuplicateTx(ctx, tx, id, contentSHA256)
+		return err
+	})
+	if err == nil && duplicateOf != "" {
+		return ErrDuplicatePhoto
+	}
+	return err
+}
+
+// rejectDuplicateTx marks the photo as a duplicate if a ready photo of its album has the
+// same content, and returns the ID of that photo
+func (s *PhotoService) rejectDuplicateTx(ctx context.Context, tx *sql.Tx, id, contentSHA256 string) (string, error) {
+	if contentSHA256 == "" {
+		return "", nil
+	}
+
+	// Locking the album makes concurrent ingestions of one album check one after the other
+	var albumID string
+	err := tx.QueryRowContext(ctx,
+		`SELECT a.id FROM albums a JOIN photos p ON p.album_id = a.id
+		 WHERE p.id = $1
+		 FOR UPDATE OF a`,
+		id,
+	).Scan(&albumID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("photo not found")
+		}
+		return "", err
+	}
+
+	var existingID string
+	err = tx.QueryRowContext(ctx,
+		`SELECT id FROM photos
+		 WHERE album_id = $1 AND content_sha256 = $2 AND status = 'ready' AND id <> $3
+		 ORDER BY created_at LIMIT 1`,
+		albumID, contentSHA256, id,
+	).Scan(&existingID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE photos SET status = 'error', error_message = $2, duplicate_of = $3, content_sha256 = $4
+		 WHERE id = $1 AND status = 'processing'`,
+		id, ErrDuplicatePhoto.Error(), existingID, contentSHA256,
+	)
+	if err != nil {
+		return "", err
+	}
+	if err := expectOneRow(result, "photo is not processing"); err != nil {
+		return "", err
+	}
+	return existingID, nil
+}
+
+// FindByContentHash returns the ready photo of an album with the given SHA-256, or nil
+func (s *PhotoService) FindByContentHash(ctx context.Context, albumID, contentSHA256 string) (*Photo, error) {
+	photo, err := scanPhoto(s.db.QueryRowContext(ctx,
+		`SELECT `+photoColumns+`
+		 FROM photos WHERE album_id = $1 AND content_sha256 = $2 AND status = 'ready'
+		 ORDER BY created_at LIMIT 1`,
+		albumID, contentSHA256,
+	))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return photo, nil
+}
+
+// MarkError rejects a photo with the given reason
+func (s *PhotoService) MarkError(ctx context.Context, id, reason string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE photos SET status = 'error', error_message = $2
+		 WHERE id = $1 AND status = 'processing'`,
+		id, reason,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "photo is not processing")
+}
+
+// RequeueStaleIngestion returns photos stuck in processing (e.g. after a worker crash)
+// to the queue. Photos that already used up maxAttempts are rejected instead.
+func (s *PhotoService) RequeueStaleIngestion(ctx context.Context, olderThan time.Duration, maxAttempts int) (int64, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE photos
+		 SET status = 'error', error_message = 'processing failed after repeated attempts'
+		 WHERE status = 'processing' AND processing_started_at < $1 AND ingest_attempts >= $2`,
+		cutoff, maxAttempts,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE photos
+		 SET status = 'uploaded', processing_started_at = NULL
+		 WHERE status = 'processing' AND processing_started_at < $1 AND ingest_attempts < $2`,
+		cutoff, maxAttempts,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // CountByAlbum counts photos in an album
 func (s *PhotoService) CountByAlbum(ctx context.Context, albumID string) (int, error) {
 	var count int
@@ -296,26 +442,28 @@ func (s *PhotoService) CountByAlbum(ctx context.Context, albumID string) (int, e
 	return count, err
 }
 
-// GetByStorageKey retrieves a photo by its storage key
-func (s *PhotoService) GetByStorageKey(ctx context.Context, storageKey string) (*Photo, error) {
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanPhoto scans a single row selected with photoColumns
+func scanPhoto(row rowScanner) (*Photo, error) {
 	photo := &Photo{}
-	var filename, mimeType sql.NullString
+	var filename, mimeType, errorMessage, cameraMake, cameraModel, displayStorageKey, contentSHA256, duplicateOf sql.NullString
+	var blurHash, dominantColor sql.NullString
 	var sizeBytes sql.NullInt64
-	var width, height sql.NullInt32
+	var width, height, orientation sql.NullInt32
+	var takenAt sql.NullTime
+	var latitude, longitude sql.NullFloat64
 
-	err := s.db.QueryRowContext(ctx,
-		`SELECT id, album_id, user_id, storage_key, filename, mime_type, size_bytes, width, height, status, created_at
-		 FROM photos WHERE storage_key = $1`,
-		storageKey,
-	).Scan(
+	err := row.Scan(
 		&photo.ID, &photo.AlbumID, &photo.UserID, &photo.StorageKey,
-		&filename, &mimeType, &sizeBytes, &width, &height, &photo.Status, &photo.CreatedAt,
+		&filename, &mimeType, &sizeBytes, &width, &height, &photo.Status, &errorMessage,
+		&takenAt, &cameraMake, &cameraModel, &orientation, &latitude, &longitude, &displayStorageKey,
+		&contentSHA256, &duplicateOf, &blurHash, &dominantColor, &photo.CreatedAt,
 	)
-
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("photo not found")
-		}
 		return nil, err
 	}
 
@@ -336,6 +484,53 @@ func (s *PhotoService) GetByStorageKey(ctx context.Context, storageKey string) (
 		h := int(height.Int32)
 		photo.Height = &h
 	}
+	if errorMessage.Valid {
+		photo.ErrorMessage = &errorMessage.String
+	}
+	if takenAt.Valid {
+		photo.TakenAt = &takenAt.Time
+	}
+	if cameraMake.Valid {
+		photo.CameraMake = &cameraMake.String
+	}
+	if cameraModel.Valid {
+		photo.CameraModel = &cameraModel.String
+	}
+	if orientation.Valid {
+		o := int(orientation.Int32)
+		photo.Orientation = &o
+	}
+	if latitude.Valid && longitude.Valid {
+		photo.Latitude, photo.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	if displayStorageKey.Valid {
+		photo.DisplayStorageKey = &displayStorageKey.String
+	}
+	if contentSHA256.Valid {
+		photo.ContentSHA256 = &contentSHA256.String
+	}
+	if duplicateOf.Valid {
+		photo.DuplicateOf = &duplicateOf.String
+	}
+	if blurHash.Valid {
+		photo.BlurHash = &blurHash.String
+	}
+	if dominantColor.Valid {
+		photo.DominantColor = &dominantColor.String
+	}
 
 	return photo, nil
 }
+
+// scanPhotos scans rows selected with photoColumns
+func scanPhotos(rows *sql.Rows) ([]Photo, error) {
+	var photos []Photo
+	for rows.Next() {
+		photo, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, *photo)
+	}
+	return photos, rows.Err()
+}
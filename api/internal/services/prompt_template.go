@@ -0,0 +1,536 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Prompt templates are plain text with {{ ... }} tags. A tag holds a variable path
+// followed by optional filters, e.g.
+//
+//	A {{ params.style | default "watercolor" }} portrait of {{ photo.filename }}
+//
+// Built-in variables are listed in promptVariableTypes; theme authors declare their
+// own parameters in Theme.PromptParams and reference them as {{ params.<name> }}.
+
+// defaultPromptTemplate is used for themes without a prompt template
+const defaultPromptTemplate = "Redraw this photo in the {{ theme.name }} style"
+
+// Value types a template variable can have
+const (
+	promptTypeString  = "string"
+	promptTypeNumber  = "number"
+	promptTypeBoolean = "boolean"
+	promptTypeEnum    = "enum"
+)
+
+// promptVariableTypes lists the built-in template variables and their types
+var promptVariableTypes = map[string]string{
+	"photo.filename":    promptTypeString,
+	"photo.mime_type":   promptTypeString,
+	"photo.width":       promptTypeNumber,
+	"photo.height":      promptTypeNumber,
+	"album.name":        promptTypeString,
+	"album.description": promptTypeString,
+	"theme.name":        promptTypeString,
+}
+
+// PromptParam declares a user-supplied parameter a prompt template can reference
+type PromptParam struct {
+	Name        string   `json:"name" validate:"required"`
+	Type        string   `json:"type" validate:"required,oneof=string number boolean enum"`
+	Description *string  `json:"description,omitempty"`
+	Required    bool     `json:"required"`
+	Default     *string  `json:"default,omitempty"`
+	Options     []string `json:"options,omitempty"` // allowed values for enum parameters
+}
+
+// PromptVariables holds the values a prompt template is rendered with
+type PromptVariables struct {
+	Photo  *Photo
+	Album  *Album
+	Theme  *Theme
+	Params map[string]string
+}
+
+// PromptTemplateError reports a problem at a position in a prompt template
+type PromptTemplateError struct {
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+func (e *PromptTemplateError) Error() string {
+	return fmt.Sprintf("prompt template line %d, column %d: %s", e.Line, e.Column, e.Message)
+}
+
+// PromptParamError reports an invalid prompt parameter declaration or value
+type PromptParamError struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+func (e *PromptParamError) Error() string {
+	return e.Message
+}
+
+func promptParamErrorf(name, format string, args ...any) error {
+	return &PromptParamError{Name: name, Message: fmt.Sprintf(format, args...)}
+}
+
+// PromptTemplate is a parsed and type-checked prompt template
+type PromptTemplate struct {
+	nodes  []promptNode
+	params map[string]PromptParam
+}
+
+// promptNode is either literal text or a variable tag
+type promptNode struct {
+	text    string
+	path    string
+	filters []promptFilter
+}
+
+type promptFilter struct {
+	name string
+	arg  *string
+}
+
+// ParsePromptTemplate parses src and checks every variable and filter against the
+// built-in variables and the declared params
+func ParsePromptTemplate(src string, params []PromptParam) (*PromptTemplate, error) {
+	declared, err := validatePromptParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &promptParser{src: src, params: declared}
+	nodes, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	return &PromptTemplate{nodes: nodes, params: declared}, nil
+}
+
+// Render produces the prompt text. Parameter values are checked against their
+// declared types; missing optional parameters fall back to their default.
+func (t *PromptTemplate) Render(vars PromptVariables) (string, error) {
+	params, err := t.resolveParams(vars.Params)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, node := range t.nodes {
+		if node.path == "" {
+			b.WriteString(node.text)
+			continue
+		}
+
+		value := lookupPromptVariable(node.path, vars, params)
+		for _, f := range node.filters {
+			value = applyPromptFilter(f, value)
+		}
+		b.WriteString(value)
+	}
+
+	return strings.TrimSpace(b.String()), nil
+}
+
+// resolveParams validates user-supplied values and fills in defaults
+func (t *PromptTemplate) resolveParams(values map[string]string) (map[string]string, error) {
+	for name := range values {
+		if _, ok := t.params[name]; !ok {
+			return nil, promptParamErrorf(name, "unknown prompt parameter %q", name)
+		}
+	}
+
+	resolved := make(map[string]string, len(t.params))
+	for name, param := range t.params {
+		value, ok := values[name]
+		if !ok {
+			if param.Required {
+				return nil, promptParamErrorf(name, "prompt parameter %q is required", name)
+			}
+			if param.Default != nil {
+				resolved[name] = *param.Default
+			}
+			continue
+		}
+
+		normalized, err := normalizePromptParamValue(param, value)
+		if err != nil {
+			return nil, promptParamErrorf(name, "prompt parameter %q: %v", name, err)
+		}
+		resolved[name] = normalized
+	}
+	return resolved, nil
+}
+
+// validatePromptParams checks parameter declarations and indexes them by name
+func validatePromptParams(params []PromptParam) (map[string]PromptParam, error) {
+	declared := make(map[string]PromptParam, len(params))
+	for _, param := range params {
+		if !isPromptIdentifier(param.Name) {
+			return nil, promptParamErrorf(param.Name, "invalid prompt parameter name %q", param.Name)
+		}
+		if _, exists := declared[param.Name]; exists {
+			return nil, promptParamErrorf(param.Name, "duplicate prompt parameter %q", param.Name)
+		}
+
+		switch param.Type {
+		case promptTypeString, promptTypeNumber, promptTypeBoolean:
+			if len(param.Options) > 0 {
+				return nil, promptParamErrorf(param.Name, "prompt parameter %q: options are only allowed for enum parameters", param.Name)
+			}
+		case promptTypeEnum:
+			if len(param.Options) == 0 {
+				return nil, promptParamErrorf(param.Name, "prompt parameter %q: enum parameters need at least one option", param.Name)
+			}
+		default:
+			return nil, promptParamErrorf(param.Name, "prompt parameter %q: unknown type %q", param.Name, param.Type)
+		}
+
+		if param.Default != nil {
+			normalized, err := normalizePromptParamValue(param, *param.Default)
+			if err != nil {
+				return nil, promptParamErrorf(param.Name, "prompt parameter %q: invalid default: %v", param.Name, err)
+			}
+			param.Default = &normalized
+		}
+
+		declared[param.Name] = param
+	}
+	return declared, nil
+}
+
+// normalizePromptParamValue checks a value against the parameter type and returns its canonical form
+func normalizePromptParamValue(param PromptParam, value string) (string, error) {
+	switch param.Type {
+	case promptTypeNumber:
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return "", errors.New("must be a number")
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	case promptTypeBoolean:
+		v, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return "", errors.New("must be true or false")
+		}
+		return strconv.FormatBool(v), nil
+	case promptTypeEnum:
+		for _, option := range param.Options {
+			if value == option {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("must be one of %s", strings.Join(param.Options, ", "))
+	default:
+		return value, nil
+	}
+}
+
+// lookupPromptVariable returns the string value of a checked variable path
+func lookupPromptVariable(path string, vars PromptVariables, params map[string]string) string {
+	if name, ok := strings.CutPrefix(path, "params."); ok {
+		return params[name]
+	}
+
+	switch path {
+	case "photo.filename":
+		if vars.Photo != nil && vars.Photo.Filename != nil {
+			return *vars.Photo.Filename
+		}
+	case "photo.mime_type":
+		if vars.Photo != nil && vars.Photo.MimeType != nil {
+			return *vars.Photo.MimeType
+		}
+	case "photo.width":
+		if vars.Photo != nil && vars.Photo.Width != nil {
+			return strconv.Itoa(*vars.Photo.Width)
+		}
+	case "photo.height":
+		if vars.Photo != nil && vars.Photo.Height != nil {
+			return strconv.Itoa(*vars.Photo.Height)
+		}
+	case "album.name":
+		if vars.Album != nil {
+			return vars.Album.Name
+		}
+	case "album.description":
+		if vars.Album != nil && vars.Album.Description != nil {
+			return *vars.Album.Description
+		}
+	case "theme.name":
+		if vars.Theme != nil {
+			return vars.Theme.Name
+		}
+	}
+	return ""
+}
+
+func applyPromptFilter(f promptFilter, value string) string {
+	switch f.name {
+	case "default":
+		if value == "" {
+			return *f.arg
+		}
+		return value
+	case "upper":
+		return strings.ToUpper(value)
+	case "lower":
+		return strings.ToLower(value)
+	case "trim":
+		return strings.TrimSpace(value)
+	default:
+		return value
+	}
+}
+
+// promptParser turns template source into nodes, reporting the first error with its position
+type promptParser struct {
+	src    string
+	params map[string]PromptParam
+}
+
+func (p *promptParser) parse() ([]promptNode, error) {
+	var nodes []promptNode
+	pos := 0
+	for pos < len(p.src) {
+		open := strings.Index(p.src[pos:], "{{")
+		if open < 0 {
+			nodes = append(nodes, promptNode{text: p.src[pos:]})
+			break
+		}
+		open += pos
+		if open > pos {
+			nodes = append(nodes, promptNode{text: p.src[pos:open]})
+		}
+
+		closeIdx := strings.Index(p.src[open+2:], "}}")
+		if closeIdx < 0 {
+			return nil, p.errorAt(open, "unclosed tag, expected }}")
+		}
+		end := open + 2 + closeIdx
+
+		node, err := p.parseTag(open+2, end)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+		pos = end + 2
+	}
+	return nodes, nil
+}
+
+// parseTag parses the contents of a tag between byte offsets start and end
+func (p *promptParser) parseTag(start, end int) (promptNode, error) {
+	tokens, err := p.tokenize(start, end)
+	if err != nil {
+		return promptNode{}, err
+	}
+	if len(tokens) == 0 {
+		return promptNode{}, p.errorAt(start-2, "empty tag")
+	}
+
+	head := tokens[0]
+	if head.kind != tokenIdent {
+		return promptNode{}, p.errorAt(head.offset, fmt.Sprintf("expected a variable, found %s", head.describe()))
+	}
+	valueType, err := p.variableType(head)
+	if err != nil {
+		return promptNode{}, err
+	}
+
+	node := promptNode{path: head.text}
+	rest := tokens[1:]
+	for len(rest) > 0 {
+		if rest[0].kind != tokenPipe {
+			return promptNode{}, p.errorAt(rest[0].offset, fmt.Sprintf("expected | or }}, found %s", rest[0].describe()))
+		}
+		if len(rest) < 2 || rest[1].kind != tokenIdent {
+			return promptNode{}, p.errorAt(rest[0].offset, "expected a filter name after |")
+		}
+
+		nameTok := rest[1]
+		filter := promptFilter{name: nameTok.text}
+		rest = rest[2:]
+		if len(rest) > 0 && rest[0].kind == tokenString {
+			arg := rest[0].text
+			filter.arg = &arg
+			rest = rest[1:]
+		}
+
+		valueType, err = p.checkFilter(nameTok, filter, valueType)
+		if err != nil {
+			return promptNode{}, err
+		}
+		node.filters = append(node.filters, filter)
+	}
+
+	return node, nil
+}
+
+// variableType returns the type of a variable path or an error if it is unknown
+func (p *promptParser) variableType(tok promptToken) (string, error) {
+	if name, ok := strings.CutPrefix(tok.text, "params."); ok {
+		param, ok := p.params[name]
+		if !ok {
+			return "", p.errorAt(tok.offset, fmt.Sprintf("unknown parameter %q", name))
+		}
+		if param.Type == promptTypeEnum {
+			return promptTypeString, nil
+		}
+		return param.Type, nil
+	}
+
+	valueType, ok := promptVariableTypes[tok.text]
+	if !ok {
+		return "", p.errorAt(tok.offset, fmt.Sprintf("unknown variable %q (available: %s, params.<name>)", tok.text, knownPromptVariables()))
+	}
+	return valueType, nil
+}
+
+// checkFilter validates a filter against the type it is applied to and returns the resulting type
+func (p *promptParser) checkFilter(tok promptToken, f promptFilter, valueType string) (string, error) {
+	switch f.name {
+	case "default":
+		if f.arg == nil {
+			return "", p.errorAt(tok.offset, `filter "default" needs a quoted argument`)
+		}
+		return promptTypeString, nil
+	case "upper", "lower", "trim":
+		if f.arg != nil {
+			return "", p.errorAt(tok.offset, fmt.Sprintf("filter %q takes no argument", f.name))
+		}
+		if valueType != promptTypeString {
+			return "", p.errorAt(tok.offset, fmt.Sprintf("filter %q expects a string, got %s", f.name, valueType))
+		}
+		return promptTypeString, nil
+	default:
+		return "", p.errorAt(tok.offset, fmt.Sprintf("unknown filter %q", f.name))
+	}
+}
+
+const (
+	tokenIdent = iota
+	tokenPipe
+	tokenString
+)
+
+type promptToken struct {
+	kind   int
+	text   string
+	offset int
+}
+
+func (t promptToken) describe() string {
+	if t.kind == tokenPipe {
+		return "|"
+	}
+	return strconv.Quote(t.text)
+}
+
+func (p *promptParser) tokenize(start, end int) ([]promptToken, error) {
+	var tokens []promptToken
+	i := start
+	for i < end {
+		c := p.src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '|':
+			tokens = append(tokens, promptToken{kind: tokenPipe, offset: i})
+			i++
+		case c == '"':
+			text, next, err := p.scanString(i, end)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, promptToken{kind: tokenString, text: text, offset: i})
+			i = next
+		case isPromptIdentStart(c):
+			j := i
+			for j < end && (isPromptIdentStart(p.src[j]) || isPromptDigit(p.src[j]) || p.src[j] == '.') {
+				j++
+			}
+			path := p.src[i:j]
+			for _, part := range strings.Split(path, ".") {
+				if !isPromptIdentifier(part) {
+					return nil, p.errorAt(i, fmt.Sprintf("malformed variable %q", path))
+				}
+			}
+			tokens = append(tokens, promptToken{kind: tokenIdent, text: path, offset: i})
+			i = j
+		default:
+			r, _ := utf8.DecodeRuneInString(p.src[i:])
+			return nil, p.errorAt(i, fmt.Sprintf("unexpected character %q", r))
+		}
+	}
+	return tokens, nil
+}
+
+// scanString reads a double-quoted string starting at start, supporting \" and \\ escapes
+func (p *promptParser) scanString(start, end int) (string, int, error) {
+	var b strings.Builder
+	for i := start + 1; i < end; i++ {
+		switch p.src[i] {
+		case '\\':
+			if i+1 < end && (p.src[i+1] == '"' || p.src[i+1] == '\\') {
+				b.WriteByte(p.src[i+1])
+				i++
+				continue
+			}
+			return "", 0, p.errorAt(i, "invalid escape sequence")
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(p.src[i])
+		}
+	}
+	return "", 0, p.errorAt(start, "unterminated string")
+}
+
+// errorAt builds an error for the byte offset, counting lines and columns from 1
+func (p *promptParser) errorAt(offset int, message string) error {
+	before := p.src[:offset]
+	line := strings.Count(before, "\n") + 1
+	lineStart := strings.LastIndex(before, "\n") + 1
+	column := utf8.RuneCountInString(before[lineStart:]) + 1
+	return &PromptTemplateError{Line: line, Column: column, Message: message}
+}
+
+func isPromptIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isPromptDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isPromptIdentifier(s string) bool {
+	if s == "" || !isPromptIdentStart(s[0]) {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		if !isPromptIdentStart(s[i]) && !isPromptDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func knownPromptVariables() string {
+	names := make([]string, 0, len(promptVariableTypes))
+	for name := range promptVariableTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
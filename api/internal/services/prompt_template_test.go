@@ -0,0 +1,198 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func strPtr(s string) *string { return &s }
+
+func TestParsePromptTemplateErrors(t *testing.T) {
+	params := []PromptParam{
+		{Name: "style", Type: promptTypeEnum, Options: []string{"ink", "oil"}},
+		{Name: "strength", Type: promptTypeNumber},
+	}
+
+	tests := []struct {
+		name        string
+		src         string
+		wantLine    int
+		wantColumn  int
+		wantMessage string
+	}{
+		{name: "unclosed tag", src: "A {{ photo.filename", wantLine: 1, wantColumn: 3, wantMessage: "unclosed tag, expected }}"},
+		{name: "empty tag", src: "x {{ }}", wantLine: 1, wantColumn: 3, wantMessage: "empty tag"},
+		{
+			name:        "unknown variable on a later line",
+			src:         "First line\n  {{ nope }}",
+			wantLine:    2,
+			wantColumn:  6,
+			wantMessage: `unknown variable "nope" (available: album.description, album.name, photo.filename, photo.height, photo.mime_type, photo.width, theme.name, params.<name>)`,
+		},
+		{name: "columns count characters", src: "Café {{ params.mood }}", wantLine: 1, wantColumn: 9, wantMessage: `unknown parameter "mood"`},
+		{name: "malformed variable", src: "{{ theme..name }}", wantLine: 1, wantColumn: 4, wantMessage: `malformed variable "theme..name"`},
+		{name: "unexpected character", src: "{{ 'x' }}", wantLine: 1, wantColumn: 4, wantMessage: `unexpected character '\''`},
+		{name: "string instead of variable", src: `{{ "x" }}`, wantLine: 1, wantColumn: 4, wantMessage: `expected a variable, found "x"`},
+		{name: "missing pipe", src: "{{ theme.name album.name }}", wantLine: 1, wantColumn: 15, wantMessage: `expected | or }}, found "album.name"`},
+		{name: "pipe without filter", src: "{{ theme.name | }}", wantLine: 1, wantColumn: 15, wantMessage: "expected a filter name after |"},
+		{name: "unknown filter", src: "{{ theme.name | shout }}", wantLine: 1, wantColumn: 17, wantMessage: `unknown filter "shout"`},
+		{name: "default without argument", src: "{{ theme.name | default }}", wantLine: 1, wantColumn: 17, wantMessage: `filter "default" needs a quoted argument`},
+		{name: "filter with unexpected argument", src: `{{ theme.name | upper "x" }}`, wantLine: 1, wantColumn: 17, wantMessage: `filter "upper" takes no argument`},
+		{name: "string filter on a number", src: "{{ photo.width | upper }}", wantLine: 1, wantColumn: 18, wantMessage: `filter "upper" expects a string, got number`},
+		{name: "string filter on a number param", src: "{{ params.strength | trim }}", wantLine: 1, wantColumn: 22, wantMessage: `filter "trim" expects a string, got number`},
+		{name: "unterminated string", src: `{{ theme.name | default "x }}`, wantLine: 1, wantColumn: 25, wantMessage: "unterminated string"},
+		{name: "invalid escape", src: `{{ theme.name | default "a\nb" }}`, wantLine: 1, wantColumn: 27, wantMessage: "invalid escape sequence"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePromptTemplate(tt.src, params)
+			var templateErr *PromptTemplateError
+			if !errors.As(err, &templateErr) {
+				t.Fatalf("ParsePromptTemplate() error = %v, want a *PromptTemplateError", err)
+			}
+			if templateErr.Line != tt.wantLine || templateErr.Column != tt.wantColumn {
+				t.Errorf("position = %d:%d, want %d:%d", templateErr.Line, templateErr.Column, tt.wantLine, tt.wantColumn)
+			}
+			if templateErr.Message != tt.wantMessage {
+				t.Errorf("message = %q, want %q", templateErr.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func TestParsePromptTemplateParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   []PromptParam
+		wantName string // parameter the error is reported for, "" for no error
+	}{
+		{
+			name: "valid declarations",
+			params: []PromptParam{
+				{Name: "style", Type: promptTypeEnum, Options: []string{"ink", "oil"}, Default: strPtr("ink")},
+				{Name: "strength", Type: promptTypeNumber, Default: strPtr("0.5")},
+				{Name: "vivid", Type: promptTypeBoolean},
+				{Name: "subject", Type: promptTypeString, Required: true},
+			},
+		},
+		{name: "invalid name", params: []PromptParam{{Name: "my-style", Type: promptTypeString}}, wantName: "my-style"},
+		{
+			name:     "duplicate name",
+			params:   []PromptParam{{Name: "style", Type: promptTypeString}, {Name: "style", Type: promptTypeNumber}},
+			wantName: "style",
+		},
+		{name: "unknown type", params: []PromptParam{{Name: "size", Type: "integer"}}, wantName: "size"},
+		{name: "options on a string", params: []PromptParam{{Name: "style", Type: promptTypeString, Options: []string{"ink"}}}, wantName: "style"},
+		{name: "enum without options", params: []PromptParam{{Name: "style", Type: promptTypeEnum}}, wantName: "style"},
+		{name: "default of the wrong type", params: []PromptParam{{Name: "strength", Type: promptTypeNumber, Default: strPtr("strong")}}, wantName: "strength"},
+		{
+			name:     "default outside the options",
+			params:   []PromptParam{{Name: "style", Type: promptTypeEnum, Options: []string{"ink"}, Default: strPtr("oil")}},
+			wantName: "style",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePromptTemplate("{{ theme.name }}", tt.params)
+			if tt.wantName == "" {
+				if err != nil {
+					t.Fatalf("ParsePromptTemplate() error = %v", err)
+				}
+				return
+			}
+			var paramErr *PromptParamError
+			if !errors.As(err, &paramErr) {
+				t.Fatalf("ParsePromptTemplate() error = %v, want a *PromptParamError", err)
+			}
+			if paramErr.Name != tt.wantName {
+				t.Errorf("error for %q, want %q", paramErr.Name, tt.wantName)
+			}
+		})
+	}
+}
+
+func TestPromptTemplateRender(t *testing.T) {
+	params := []PromptParam{
+		{Name: "style", Type: promptTypeEnum, Options: []string{"ink", "oil"}, Default: strPtr("ink")},
+		{Name: "strength", Type: promptTypeNumber},
+		{Name: "vivid", Type: promptTypeBoolean},
+		{Name: "subject", Type: promptTypeString, Required: true},
+	}
+	width := 640
+	vars := PromptVariables{
+		Photo: &Photo{Filename: strPtr("cat.jpg"), Width: &width},
+		Album: &Album{Name: "Pets"},
+		Theme: &Theme{Name: "Noir"},
+	}
+
+	tests := []struct {
+		name     string
+		src      string
+		values   map[string]string
+		want     string
+		wantName string // parameter a value error is reported for
+	}{
+		{
+			name:   "built-in variables",
+			src:    "{{ theme.name }} {{ photo.filename }} from {{ album.name }}, {{ photo.width }}px",
+			values: map[string]string{"subject": "cat"},
+			want:   "Noir cat.jpg from Pets, 640px",
+		},
+		{
+			name:   "filters",
+			src:    `{{ theme.name | upper }} {{ album.name | lower }} {{ album.description | default "no \"description\"" }}`,
+			values: map[string]string{"subject": "cat"},
+			want:   `NOIR pets no "description"`,
+		},
+		{
+			name:   "param default",
+			src:    "{{ params.subject | trim }} in {{ params.style }}",
+			values: map[string]string{"subject": "  cat  "},
+			want:   "cat in ink",
+		},
+		{
+			name:   "values are normalized",
+			src:    "{{ params.strength }} {{ params.vivid }} {{ params.style }}",
+			values: map[string]string{"subject": "cat", "strength": " 1.50 ", "vivid": "TRUE", "style": "oil"},
+			want:   "1.5 true oil",
+		},
+		{
+			name:   "unset optional param renders empty",
+			src:    "[{{ params.strength }}]",
+			values: map[string]string{"subject": "cat"},
+			want:   "[]",
+		},
+		{name: "missing required param", src: "{{ theme.name }}", values: map[string]string{}, wantName: "subject"},
+		{name: "unknown param", src: "{{ theme.name }}", values: map[string]string{"subject": "cat", "mood": "dark"}, wantName: "mood"},
+		{name: "number param", src: "{{ theme.name }}", values: map[string]string{"subject": "cat", "strength": "high"}, wantName: "strength"},
+		{name: "boolean param", src: "{{ theme.name }}", values: map[string]string{"subject": "cat", "vivid": "maybe"}, wantName: "vivid"},
+		{name: "enum param", src: "{{ theme.name }}", values: map[string]string{"subject": "cat", "style": "pencil"}, wantName: "style"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := ParsePromptTemplate(tt.src, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := vars
+			v.Params = tt.values
+			got, err := tmpl.Render(v)
+			if tt.wantName != "" {
+				var paramErr *PromptParamError
+				if !errors.As(err, &paramErr) {
+					t.Fatalf("Render() error = %v, want a *PromptParamError", err)
+				}
+				if paramErr.Name != tt.wantName {
+					t.Errorf("error for %q, want %q", paramErr.Name, tt.wantName)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Render() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"time"
+)
+
+// Route groups with their own rate limits
+const (
+	RateLimitAuth       = "auth"       // signing in, refreshing, registering and account links
+	RateLimitGeneration = "generation" // queuing generations, which cost provider calls
+	RateLimitUploads    = "uploads"    // upload URLs and multipart uploads
+)
+
+// RateLimit lets one client make Requests per Period, in bursts of up to Requests. The
+// zero value allows everything.
+type RateLimit struct {
+	Requests int
+	Period   time.Duration
+}
+
+// RateLimitGroup holds the limits of a route group: each client IP has a bucket, and so
+// does each account
+type RateLimitGroup struct {
+	PerIP      RateLimit
+	PerAccount RateLimit
+}
+
+// RateLimitStore keeps token buckets
+type RateLimitStore interface {
+	// Take takes a token from the bucket with the given key, creating it full, and returns
+	// how long until a token is available if the bucket is empty, or 0
+	Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error)
+}
+
+// RateLimitError is returned when a client has to wait before trying again
+type RateLimitError struct {
+	RetryAfter time.Duration
+	Reason     string
+}
+
+func (e *RateLimitError) Error() string {
+	return e.Reason
+}
+
+// RateLimiter applies the limits of route groups with token buckets kept in a store
+type RateLimiter struct {
+	store  RateLimitStore
+	groups map[string]RateLimitGroup
+}
+
+// NewRateLimiter creates a new RateLimiter. Groups without limits allow everything.
+func NewRateLimiter(store RateLimitStore, groups map[string]RateLimitGroup) *RateLimiter {
+	return &RateLimiter{store: store, groups: groups}
+}
+
+// AllowIP takes a token from the bucket of a client IP in a route group, and returns how
+// long the client has to wait if there was none, or 0
+func (l *RateLimiter) AllowIP(ctx context.Context, group, ip string) (time.Duration, error) {
+	return l.take(ctx, group+":ip:"+ip, l.groups[group].PerIP)
+}
+
+// AllowAccount takes a token from the bucket of an account in a route group, identified by
+// user ID or, before signing in, by email address
+func (l *RateLimiter) AllowAccount(ctx context.Context, group, account string) (time.Duration, error) {
+	return l.take(ctx, group+":account:"+account, l.groups[group].PerAccount)
+}
+
+func (l *RateLimiter) take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	if limit.Requests <= 0 || limit.Period <= 0 {
+		return 0, nil
+	}
+	return l.store.Take(ctx, key, limit)
+}
+
+// takeToken refills a bucket that held tokens elapsed ago and takes a token from it if it
+// has one. It returns the tokens left, how long until a token is available if there was
+// none, and how long until the bucket is full again, after which stores can forget it.
+func takeToken(tokens float64, elapsed time.Duration, limit RateLimit) (left float64, wait, untilFull time.Duration) {
+	capacity := float64(limit.Requests)
+	perSecond := capacity / limit.Period.Seconds()
+
+	tokens = min(capacity, tokens+max(0, elapsed.Seconds())*perSecond)
+	if tokens >= 1 {
+		tokens--
+	} else {
+		wait = time.Duration((1 - tokens) / perSecond * float64(time.Second))
+	}
+	untilFull = time.Duration((capacity - tokens) / perSecond * float64(time.Second))
+	return tokens, wait, untilFull
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// rateLimitSweepInterval is how often stores forget buckets that have refilled
+const rateLimitSweepInterval = time.Minute
+
+// MemoryRateLimitStore keeps token buckets in memory. Each API replica has its own, so
+// with several replicas clients get each limit once per replica.
+type MemoryRateLimitStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	tokens  float64
+	updated time.Time
+	fullAt  time.Time
+}
+
+// NewMemoryRateLimitStore creates a new MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{buckets: make(map[string]*memoryBucket)}
+}
+
+// Take takes a token from a bucket
+func (s *MemoryRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &memoryBucket{tokens: float64(limit.Requests), updated: now}
+		s.buckets[key] = b
+	}
+	tokens, wait, untilFull := takeToken(b.tokens, now.Sub(b.updated), limit)
+	b.tokens = tokens
+	b.updated = now
+	b.fullAt = now.Add(untilFull)
+	return wait, nil
+}
+
+// sweep forgets the buckets that are full again, at most once per interval
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		if b.fullAt.Before(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// PostgresRateLimitStore keeps token buckets in the database, so replicas share them. A
+// bucket's row is locked while a token is taken, and the database clock is used for
+// refills, so replicas' clocks don't need to agree.
+type PostgresRateLimitStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewPostgresRateLimitStore creates a new PostgresRateLimitStore
+func NewPostgresRateLimitStore(db *sql.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db}
+}
+
+// Take takes a token from a bucket
+func (s *PostgresRateLimitStore) Take(ctx context.Context, key string, limit RateLimit) (time.Duration, error) {
+	if err := s.sweep(ctx); err != nil {
+		return 0, err
+	}
+
+	var wait time.Duration
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO rate_limit_buckets (key, tokens, updated_at, full_at)
+			 VALUES ($1, $2, NOW(), NOW())
+			 ON CONFLICT (key) DO NOTHING`,
+			key, float64(limit.Requests),
+		)
+		if err != nil {
+			return err
+		}
+
+		var tokens, elapsed float64
+		err = tx.QueryRowContext(ctx,
+			`SELECT tokens, EXTRACT(EPOCH FROM NOW() - updated_at)
+			 FROM rate_limit_buckets WHERE key = $1 FOR UPDATE`,
+			key,
+		).Scan(&tokens, &elapsed)
+		if err != nil {
+			return err
+		}
+
+		tokens, w, untilFull := takeToken(tokens, time.Duration(elapsed*float64(time.Second)), limit)
+		wait = w
+		_, err = tx.ExecContext(ctx,
+			`UPDATE rate_limit_buckets
+			 SET tokens = $2, updated_at = NOW(), full_at = NOW() + make_interval(secs => $3)
+			 WHERE key = $1`,
+			key, tokens, untilFull.Seconds(),
+		)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return wait, nil
+}
+
+// sweep deletes the buckets that are full again, at most once per interval per replica
+func (s *PostgresRateLimitStore) sweep(ctx context.Context) error {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < rateLimitSweepInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limit_buckets WHERE full_at < NOW()`)
+	return err
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTakeToken(t *testing.T) {
+	limit := RateLimit{Requests: 10, Period: 10 * time.Second} // one token a second
+
+	tests := []struct {
+		name          string
+		tokens        float64
+		elapsed       time.Duration
+		wantLeft      float64
+		wantWait      time.Duration
+		wantUntilFull time.Duration
+	}{
+		{name: "full bucket", tokens: 10, wantLeft: 9, wantUntilFull: time.Second},
+		{name: "last token", tokens: 1, wantLeft: 0, wantUntilFull: 10 * time.Second},
+		{name: "empty bucket", tokens: 0, wantLeft: 0, wantWait: time.Second, wantUntilFull: 10 * time.Second},
+		{name: "half a token", tokens: 0.5, wantLeft: 0.5, wantWait: 500 * time.Millisecond, wantUntilFull: 9500 * time.Millisecond},
+		{name: "refilled", tokens: 0, elapsed: 3 * time.Second, wantLeft: 2, wantUntilFull: 8 * time.Second},
+		{name: "refill stops at capacity", tokens: 5, elapsed: time.Hour, wantLeft: 9, wantUntilFull: time.Second},
+		{name: "clock went back", tokens: 0, elapsed: -time.Minute, wantLeft: 0, wantWait: time.Second, wantUntilFull: 10 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			left, wait, untilFull := takeToken(tt.tokens, tt.elapsed, limit)
+			if math.Abs(left-tt.wantLeft) > 1e-9 {
+				t.Errorf("left = %v, want %v", left, tt.wantLeft)
+			}
+			if !durationNear(wait, tt.wantWait) {
+				t.Errorf("wait = %v, want %v", wait, tt.wantWait)
+			}
+			if !durationNear(untilFull, tt.wantUntilFull) {
+				t.Errorf("untilFull = %v, want %v", untilFull, tt.wantUntilFull)
+			}
+		})
+	}
+}
+
+// durationNear allows for the float rounding of bucket arithmetic
+func durationNear(got, want time.Duration) bool {
+	d := got - want
+	return -time.Microsecond < d && d < time.Microsecond
+}
+
+func TestRateLimiter(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), map[string]RateLimitGroup{
+		RateLimitAuth: {
+			PerIP:      RateLimit{Requests: 3, Period: time.Hour},
+			PerAccount: RateLimit{Requests: 2, Period: time.Hour},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		allow    func() (time.Duration, error)
+		wantWait bool
+	}{
+		{"ip 1", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitAuth, "10.0.0.1") }, false},
+		{"ip 2", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitAuth, "10.0.0.1") }, false},
+		{"ip 3", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitAuth, "10.0.0.1") }, false},
+		{"ip over limit", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitAuth, "10.0.0.1") }, true},
+		{"other ip", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitAuth, "10.0.0.2") }, false},
+		{"account 1", func() (time.Duration, error) { return limiter.AllowAccount(ctx, RateLimitAuth, "u1") }, false},
+		{"account 2", func() (time.Duration, error) { return limiter.AllowAccount(ctx, RateLimitAuth, "u1") }, false},
+		{"account over limit", func() (time.Duration, error) { return limiter.AllowAccount(ctx, RateLimitAuth, "u1") }, true},
+		// An account named like an IP has its own bucket
+		{"account named like the ip", func() (time.Duration, error) { return limiter.AllowAccount(ctx, RateLimitAuth, "10.0.0.1") }, false},
+		{"group without limits", func() (time.Duration, error) { return limiter.AllowIP(ctx, RateLimitUploads, "10.0.0.1") }, false},
+	}
+	for _, tt := range tests {
+		wait, err := tt.allow()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if (wait > 0) != tt.wantWait {
+			t.Errorf("%s: wait = %v, want wait %v", tt.name, wait, tt.wantWait)
+		}
+	}
+}
+
+func TestMemoryRateLimitStoreRefills(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryRateLimitStore()
+	limit := RateLimit{Requests: 1, Period: 50 * time.Millisecond}
+
+	if wait, _ := store.Take(ctx, "k", limit); wait != 0 {
+		t.Fatalf("first take waited %v", wait)
+	}
+	wait, _ := store.Take(ctx, "k", limit)
+	if wait <= 0 || wait > limit.Period {
+		t.Fatalf("second take waited %v, want up to %v", wait, limit.Period)
+	}
+
+	time.Sleep(wait + 10*time.Millisecond)
+	if wait, _ := store.Take(ctx, "k", limit); wait != 0 {
+		t.Errorf("take after refill waited %v", wait)
+	}
+}
+
+func TestLockDuration(t *testing.T) {
+	s := &LoginLockoutService{threshold: 5, duration: time.Minute, maxDuration: 10 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 8 * time.Minute},
+		{9, 10 * time.Minute},
+		{50, 10 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := s.lockDuration(tt.failures); got != tt.want {
+			t.Errorf("lockDuration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
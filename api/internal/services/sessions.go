@@ -0,0 +1,230 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session is a signed-in device: a refresh token, rotated on every use, and the access
+// tokens issued from it
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	UserAgent  *string   `json:"user_agent,omitempty"`
+	IPAddress  *string   `json:"ip_address,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // last sign-in or refresh
+	ExpiresAt  time.Time `json:"expires_at"`   // pushed back on every refresh
+}
+
+// SessionClient describes the device a session is used from
+type SessionClient struct {
+	UserAgent string
+	IPAddress string
+}
+
+var (
+	// ErrInvalidRefreshToken is returned for unknown, expired, revoked or reused refresh tokens
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	// ErrSessionNotFound is returned when a session doesn't exist or belongs to another user
+	ErrSessionNotFound = errors.New("session not found")
+)
+
+// sessionColumns is the column list scanSession expects, in order
+const sessionColumns = `id, user_id, user_agent, ip_address, created_at, last_used_at, expires_at`
+
+// SessionService handles sessions and their refresh tokens
+type SessionService struct {
+	db         *sql.DB
+	refreshTTL time.Duration
+}
+
+// NewSessionService creates a new SessionService. Sessions expire when they go unused
+// for refreshTTL.
+func NewSessionService(db *sql.DB, refreshTTL time.Duration) *SessionService {
+	return &SessionService{db: db, refreshTTL: refreshTTL}
+}
+
+// Create starts a session for a user and returns it with its first refresh token
+func (s *SessionService) Create(ctx context.Context, userID string, client SessionClient) (*Session, string, error) {
+	token, hash, err := newSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	session, err := scanSession(s.db.QueryRowContext(ctx,
+		`INSERT INTO sessions (id, user_id, refresh_token_hash, user_agent, ip_address, expires_at)
+		 VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''), $6)
+		 RETURNING `+sessionColumns,
+		uuid.New().String(), userID, hash, client.UserAgent, client.IPAddress, time.Now().Add(s.refreshTTL),
+	))
+	if err != nil {
+		return nil, "", err
+	}
+	return session, token, nil
+}
+
+// Rotate exchanges a refresh token for a new one and extends the session. A refresh token
+// that was already rotated can only be presented again if it was copied, so the session
+// is revoked, signing out both copies.
+func (s *SessionService) Rotate(ctx context.Context, refreshToken string, client SessionClient) (*Session, string, error) {
+	token, newHash, err := newSecretToken()
+	if err != nil {
+		return nil, "", err
+	}
+	hash := hashSecretToken(refreshToken)
+
+	var session *Session
+	var reused bool
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var id string
+		var current, active bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT id, refresh_token_hash = $1, revoked_at IS NULL AND expires_at > NOW()
+			 FROM sessions
+			 WHERE refresh_token_hash = $1 OR previous_token_hash = $1
+			 FOR UPDATE`,
+			hash,
+		).Scan(&id, &current, &active)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrInvalidRefreshToken
+			}
+			return err
+		}
+		if !active {
+			return ErrInvalidRefreshToken
+		}
+		if !current {
+			reused = true
+			_, err := tx.ExecContext(ctx, `UPDATE sessions SET revoked_at = NOW() WHERE id = $1`, id)
+			return err
+		}
+
+		session, err = scanSession(tx.QueryRowContext(ctx,
+			`UPDATE sessions
+			 SET previous_token_hash = refresh_token_hash, refresh_token_hash = $2,
+			     user_agent = COALESCE(NULLIF($3, ''), user_agent), ip_address = COALESCE(NULLIF($4, ''), ip_address),
+			     last_used_at = NOW(), expires_at = $5
+			 WHERE id = $1
+			 RETURNING `+sessionColumns,
+			id, newHash, client.UserAgent, client.IPAddress, time.Now().Add(s.refreshTTL),
+		))
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if reused {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	return session, token, nil
+}
+
+// IsActive reports whether a session exists and has neither expired nor been revoked
+func (s *SessionService) IsActive(ctx context.Context, id string) (bool, error) {
+	var active bool
+	err := s.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sessions WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW())`,
+		id,
+	).Scan(&active)
+	return active, err
+}
+
+// ListActive lists a user's active sessions, most recently used first
+func (s *SessionService) ListActive(ctx context.Context, userID string) ([]Session, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT `+sessionColumns+`
+		 FROM sessions
+		 WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		 ORDER BY last_used_at DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// Revoke revokes one of a user's sessions
+func (s *SessionService) Revoke(ctx context.Context, userID, id string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`,
+		id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeByRefreshToken revokes the session a refresh token belongs to. Unknown tokens are
+// ignored, as their session is already unusable.
+func (s *SessionService) RevokeByRefreshToken(ctx context.Context, refreshToken string) error {
+	_, err := s.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE refresh_token_hash = $1 AND revoked_at IS NULL`,
+		hashSecretToken(refreshToken),
+	)
+	return err
+}
+
+// RevokeAll revokes every session of a user except exceptID, which may be empty, and
+// returns how many were revoked
+func (s *SessionService) RevokeAll(ctx context.Context, userID, exceptID string) (int64, error) {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`,
+		userID, exceptID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// revokeAllTx revokes every session of a user inside tx
+func (s *SessionService) revokeAllTx(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE sessions SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`,
+		userID,
+	)
+	return err
+}
+
+// scanSession scans a single row selected with sessionColumns
+func scanSession(row rowScanner) (*Session, error) {
+	session := &Session{}
+	var userAgent, ipAddress sql.NullString
+	err := row.Scan(&session.ID, &session.UserID, &userAgent, &ipAddress,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if userAgent.Valid {
+		session.UserAgent = &userAgent.String
+	}
+	if ipAddress.Valid {
+		session.IPAddress = &ipAddress.String
+	}
+	return session, nil
+}
@@ -2,105 +2,109 @@ package services
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
+)
+
+// ErrObjectNotFound is returned when a storage key has no object
+var ErrObjectNotFound = errors.New("object not found")
+
+// Storage is an object store for originals, generated photos and derivatives
+type Storage interface {
+	// GenerateUploadURL returns a short-lived URL the client can PUT the requested object to
+	GenerateUploadURL(ctx context.Context, req UploadURLRequest) (*UploadURLResponse, error)
+	// GenerateDownloadURL returns a short-lived URL the client can GET an object from
+	GenerateDownloadURL(ctx context.Context, storageKey string) (*DownloadURLResponse, error)
+	PutObject(ctx context.Context, storageKey string, body []byte, contentType string) error
+	GetObject(ctx context.Context, storageKey string) ([]byte, error)
+	DeleteObject(ctx context.Context, storageKey string) error
+	// OpenObject returns a reader over an object along with its metadata. The reader only
+	// fetches what is read from where it was seeked to, so ranges can be served cheaply.
+	OpenObject(ctx context.Context, storageKey string) (io.ReadSeekCloser, *ObjectInfo, error)
+	// HeadObject returns an object's metadata without its content
+	HeadObject(ctx context.Context, storageKey string) (*ObjectInfo, error)
+	// ListObjects returns every object whose key starts with prefix
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Multipart uploads let clients send large objects in parts and retry single parts.
+	// The object only appears once the upload is completed.
+
+	// CreateMultipartUpload starts a multipart upload and returns its ID
+	CreateMultipartUpload(ctx context.Context, storageKey, contentType string) (string, error)
+	// GeneratePartUploadURL returns a short-lived URL the client can PUT one part to.
+	// The response to that PUT carries the part's ETag, needed to complete the upload.
+	GeneratePartUploadURL(ctx context.Context, storageKey, uploadID string, partNumber int) (*UploadURLResponse, error)
+	// ListParts returns the parts uploaded so far, in part number order
+	ListParts(ctx context.Context, storageKey, uploadID string) ([]UploadedPart, error)
+	// CompleteMultipartUpload assembles the given parts, in order, into the object
+	CompleteMultipartUpload(ctx context.Context, storageKey, uploadID string, parts []CompletedPart) error
+	// AbortMultipartUpload discards an upload and its parts
+	AbortMultipartUpload(ctx context.Context, storageKey, uploadID string) error
+	// ListMultipartUploads returns every upload that was neither completed nor aborted
+	ListMultipartUploads(ctx context.Context) ([]MultipartUploadInfo, error)
+}
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+// ErrUploadNotFound is returned for multipart upload IDs that don't exist,
+// or no longer do because the upload was completed or aborted
+var ErrUploadNotFound = errors.New("multipart upload not found")
+
+// Multipart limits, which are those of S3 so every backend behaves the same
+const (
+	MinPartSize = 5 << 20 // every part but the last must be at least this large
+	MaxParts    = 10000
 )
 
-// StorageService handles S3-compatible storage operations
-type StorageService struct {
-	client   *s3.Client
-	bucket   string
-	endpoint string
-	useSSL   bool
-}
-
-// NewStorageService creates a new StorageService
-func NewStorageService(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*StorageService, error) {
-	// Create custom resolver for S3-compatible storage (MinIO, R2, etc.)
-	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
-		scheme := "http"
-		if useSSL {
-			scheme = "https"
-		}
-		return aws.Endpoint{
-			URL:               fmt.Sprintf("%s://%s", scheme, endpoint),
-			HostnameImmutable: true,
-			Source:            aws.EndpointSourceCustom,
-		}, nil
-	})
-
-	cfg, err := config.LoadDefaultConfig(context.Background(),
-		config.WithRegion("auto"),
-		config.WithEndpointResolverWithOptions(resolver),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %w", err)
-	}
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// UploadedPart describes a part of a multipart upload
+type UploadedPart struct {
+	PartNumber   int       `json:"part_number"`
+	ETag         string    `json:"etag"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
 
-	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.UsePathStyle = true // Required for MinIO
-	})
+// CompletedPart identifies a part to assemble when completing a multipart upload
+type CompletedPart struct {
+	PartNumber int    `json:"part_number" validate:"required,min=1,max=10000"`
+	ETag       string `json:"etag" validate:"required"`
+}
 
-	return &StorageService{
-		client:   client,
-		bucket:   bucket,
-		endpoint: endpoint,
-		useSSL:   useSSL,
-	}, nil
+// MultipartUploadInfo describes a multipart upload in progress
+type MultipartUploadInfo struct {
+	StorageKey string
+	UploadID   string
+	Initiated  time.Time
 }
 
 // UploadURLRequest holds data for generating an upload URL
 type UploadURLRequest struct {
-	Filename string `json:"filename" validate:"required"`
-	MimeType string `json:"mime_type" validate:"required"`
-	Size     int64  `json:"size" validate:"required,min=1,max=104857600"` // Max 100MB
+	StorageKey string `json:"storage_key" validate:"required"` // see OriginalKey
+	MimeType   string `json:"mime_type" validate:"required"`
+	Size       int64  `json:"size" validate:"required,min=1,max=104857600"` // Max 100MB
 }
 
 // UploadURLResponse holds the generated upload URL
 type UploadURLResponse struct {
-	UploadURL string `json:"upload_url"`
+	UploadURL  string `json:"upload_url"`
 	StorageKey string `json:"storage_key"`
-	ExpiresAt int64  `json:"expires_at"` // Unix timestamp
-}
-
-// GenerateUploadURL creates a presigned URL for direct upload to S3
-func (s *StorageService) GenerateUploadURL(ctx context.Context, req UploadURLRequest) (*UploadURLResponse, error) {
-	// Generate unique storage key
-	storageKey := fmt.Sprintf("uploads/%d-%s", time.Now().Unix(), req.Filename)
-
-	// Create presigned URL for PUT
-	presignClient := s3.NewPresignClient(s.client)
-	
-	putInput := &s3.PutObjectInput{
-		Bucket:      aws.String(s.bucket),
-		Key:         aws.String(storageKey),
-		ContentType: aws.String(req.MimeType),
-	}
-
-	// Set content length if provided
-	if req.Size > 0 {
-		putInput.ContentLength = aws.Int64(req.Size)
-	}
-
-	presignedReq, err := presignClient.PresignPutObject(ctx, putInput,
-		s3.WithPresignExpires(15*time.Minute),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create presigned URL: %w", err)
-	}
-
-	return &UploadURLResponse{
-		UploadURL:  presignedReq.URL,
-		StorageKey: storageKey,
-		ExpiresAt:  time.Now().Add(15 * time.Minute).Unix(),
-	}, nil
+	ExpiresAt  int64  `json:"expires_at"` // Unix timestamp
 }
 
 // DownloadURLResponse holds the generated download URL
@@ -109,92 +113,135 @@ type DownloadURLResponse struct {
 	ExpiresAt   int64  `json:"expires_at"` // Unix timestamp
 }
 
-// GenerateDownloadURL creates a presigned URL for downloading from S3
-func (s *StorageService) GenerateDownloadURL(ctx context.Context, storageKey string) (*DownloadURLResponse, error) {
-	presignClient := s3.NewPresignClient(s.client)
+// Signed URL lifetimes shared by all backends
+const (
+	uploadURLExpiry   = 15 * time.Minute
+	downloadURLExpiry = 1 * time.Hour
+)
 
-	getInput := &s3.GetObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(storageKey),
-	}
+// ServedStorage is a Storage whose signed URLs point back at the API,
+// for backends that clients can't reach directly
+type ServedStorage interface {
+	Storage
+	// VerifySignedURL checks the signature and expiry of a request to a URL the backend issued
+	VerifySignedURL(method, storageKey string, query url.Values) error
+	// UploadPart stores one part of a multipart upload and returns its ETag
+	UploadPart(ctx context.Context, storageKey, uploadID string, partNumber int, body []byte) (string, error)
+}
 
-	presignedReq, err := presignClient.PresignGetObject(ctx, getInput,
-		s3.WithPresignExpires(1*time.Hour),
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create presigned URL: %w", err)
+// ObjectRoutePrefix is the API path signed URLs of served backends point at
+const ObjectRoutePrefix = "/storage/objects/"
+
+// urlSigner issues and verifies HMAC-signed object URLs served by the API
+type urlSigner struct {
+	baseURL string
+	secret  []byte
+}
+
+// signedURL returns a URL allowing method on storageKey until expiresAt. The content type
+// and size, when given, are bound to the signature so uploads can't change them.
+func (s urlSigner) signedURL(method, storageKey, contentType string, size int64, expiresAt time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	if contentType != "" {
+		query.Set("content_type", contentType)
+	}
+	if size > 0 {
+		query.Set("size", strconv.FormatInt(size, 10))
 	}
+	return s.sign(method, storageKey, query)
+}
+
+// signedPartURL returns a URL allowing one part of a multipart upload to be PUT until expiresAt
+func (s urlSigner) signedPartURL(storageKey, uploadID string, partNumber int, expiresAt time.Time) string {
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("upload_id", uploadID)
+	query.Set("part_number", strconv.Itoa(partNumber))
+	return s.sign("PUT", storageKey, query)
+}
 
-	return &DownloadURLResponse{
-		DownloadURL: presignedReq.URL,
-		ExpiresAt:   time.Now().Add(1 * time.Hour).Unix(),
-	}, nil
+func (s urlSigner) sign(method, storageKey string, query url.Values) string {
+	query.Set("signature", s.signature(method, storageKey, query))
+	return strings.TrimSuffix(s.baseURL, "/") + ObjectRoutePrefix + escapeKey(storageKey) + "?" + query.Encode()
 }
 
-// DeleteObject deletes an object from S3
-func (s *StorageService) DeleteObject(ctx context.Context, storageKey string) error {
-	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
-		Bucket: aws.String(s.bucket),
-		Key:    aws.String(storageKey),
-	})
+// verify checks a request made to a URL issued by signedURL
+func (s urlSigner) verify(method, storageKey string, query url.Values) error {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
 	if err != nil {
-		return fmt.Errorf("failed to delete object: %w", err)
+		return errors.New("invalid signed URL")
+	}
+	if time.Now().Unix() > expires {
+		return errors.New("signed URL has expired")
 	}
 
+	expected := s.signature(method, storageKey, query)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return errors.New("invalid signed URL")
+	}
 	return nil
 }
 
-// EnsureBucket creates the bucket if it doesn't exist
-func (s *StorageService) EnsureBucket(ctx context.Context) error {
-	// Check if bucket exists
-	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
-		Bucket: aws.String(s.bucket),
-	})
-	if err == nil {
-		return nil // Bucket exists
+func (s urlSigner) signature(method, storageKey string, query url.Values) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s\n%s\n%s\n%s", method, storageKey, query.Get("expires"), query.Get("content_type"),
+		query.Get("size"), query.Get("upload_id"), query.Get("part_number"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// escapeKey escapes each segment of a storage key for use in a URL path
+func escapeKey(storageKey string) string {
+	segments := strings.Split(storageKey, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
 	}
+	return strings.Join(segments, "/")
+}
 
-	// Create bucket
-	_, err = s.client.CreateBucket(ctx, &s3.CreateBucketInput{
-		Bucket: aws.String(s.bucket),
-		CreateBucketConfiguration: &types.CreateBucketConfiguration{
-			LocationConstraint: types.BucketLocationConstraintEuWest1,
-		},
-	})
-	if err != nil {
-		return fmt.Errorf("failed to create bucket: %w", err)
+// multipartETag computes the ETag S3 gives an object assembled from parts:
+// the MD5 of the parts' binary MD5s, followed by the number of parts
+func multipartETag(partETags []string) (string, error) {
+	hash := md5.New()
+	for _, etag := range partETags {
+		sum, err := hex.DecodeString(etag)
+		if err != nil {
+			return "", fmt.Errorf("invalid part ETag %q", etag)
+		}
+		hash.Write(sum)
 	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(partETags)), nil
+}
 
-	// Set bucket policy for public read (optional - for generated photos)
-	policy := fmt.Sprintf(`{
-		"Version": "2012-10-17",
-		"Statement": [
-			{
-				"Effect": "Allow",
-				"Principal": "*",
-				"Action": ["s3:GetObject"],
-				"Resource": ["arn:aws:s3:::%s/*"]
-			}
-		]
-	}`, s.bucket)
-
-	_, err = s.client.PutBucketPolicy(ctx, &s3.PutBucketPolicyInput{
-		Bucket: aws.String(s.bucket),
-		Policy: aws.String(policy),
-	})
-	if err != nil {
-		// Non-fatal - bucket is created even if policy fails
-		return nil
+// checkCompletedParts validates the parts given to complete an upload against those
+// uploaded, applying the same rules as S3: ascending part numbers, matching ETags and
+// a minimum size for every part but the last. It returns the parts to assemble.
+func checkCompletedParts(completed []CompletedPart, uploaded map[int]UploadedPart) ([]UploadedPart, error) {
+	if len(completed) == 0 {
+		return nil, errors.New("at least one part is required")
 	}
 
-	return nil
+	parts := make([]UploadedPart, len(completed))
+	for i, c := range completed {
+		if i > 0 && c.PartNumber <= completed[i-1].PartNumber {
+			return nil, errors.New("parts must be listed in ascending order")
+		}
+		part, ok := uploaded[c.PartNumber]
+		if !ok || part.ETag != strings.Trim(c.ETag, `"`) {
+			return nil, fmt.Errorf("part %d has not been uploaded or its ETag does not match", c.PartNumber)
+		}
+		if i < len(completed)-1 && part.Size < MinPartSize {
+			return nil, fmt.Errorf("part %d is smaller than the minimum part size", c.PartNumber)
+		}
+		parts[i] = part
+	}
+	return parts, nil
 }
 
-// GetPublicURL returns a public URL for an object (if bucket is public)
-func (s *StorageService) GetPublicURL(storageKey string) string {
-	scheme := "http"
-	if s.useSSL {
-		scheme = "https"
+func partsByNumber(parts []UploadedPart) map[int]UploadedPart {
+	byNumber := make(map[int]UploadedPart, len(parts))
+	for _, part := range parts {
+		byNumber[part.PartNumber] = part
 	}
-	return fmt.Sprintf("%s://%s/%s/%s", scheme, s.endpoint, s.bucket, storageKey)
+	return byNumber
 }
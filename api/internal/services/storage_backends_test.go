@@ -0,0 +1,195 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testBackends returns an empty instance of each backend that runs without external services
+func testBackends(t *testing.T) map[string]Storage {
+	t.Helper()
+	local, err := NewLocalStorage(t.TempDir(), "http://api.test", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]Storage{
+		"memory": NewMemoryStorage("http://api.test", []byte("secret")),
+		"local":  local,
+	}
+}
+
+func TestStorageObjects(t *testing.T) {
+	ctx := context.Background()
+	for name, storage := range testBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			const key = "users/u1/albums/a1/originals/p1.jpg"
+			body := []byte("jpeg bytes")
+			if err := storage.PutObject(ctx, key, body, "image/jpeg"); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.PutObject(ctx, "users/u1/albums/a2/originals/p2.png", []byte("png"), "image/png"); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := storage.GetObject(ctx, key)
+			if err != nil || !bytes.Equal(got, body) {
+				t.Fatalf("GetObject() = %q, %v, want %q", got, err, body)
+			}
+
+			info, err := storage.HeadObject(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sum := md5.Sum(body)
+			if info.Size != int64(len(body)) || info.ContentType != "image/jpeg" || info.ETag != hex.EncodeToString(sum[:]) {
+				t.Errorf("HeadObject() = %+v", info)
+			}
+
+			content, _, err := storage.OpenObject(ctx, key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := content.Seek(5, io.SeekStart); err != nil {
+				t.Fatal(err)
+			}
+			rest, _ := io.ReadAll(content)
+			content.Close()
+			if string(rest) != "bytes" {
+				t.Errorf("read after seeking = %q, want %q", rest, "bytes")
+			}
+
+			listed, err := storage.ListObjects(ctx, "users/u1/albums/a1/")
+			if err != nil || len(listed) != 1 || listed[0].Key != key {
+				t.Errorf("ListObjects() = %+v, %v, want just %s", listed, err, key)
+			}
+
+			if err := storage.DeleteObject(ctx, key); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := storage.GetObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("GetObject() after delete error = %v, want ErrObjectNotFound", err)
+			}
+			if _, err := storage.HeadObject(ctx, key); !errors.Is(err, ErrObjectNotFound) {
+				t.Errorf("HeadObject() after delete error = %v, want ErrObjectNotFound", err)
+			}
+		})
+	}
+}
+
+func TestStorageMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	const key = "users/u1/albums/a1/originals/big.jpg"
+	part1 := bytes.Repeat([]byte("a"), MinPartSize)
+	part2 := []byte("tail")
+
+	tests := []struct {
+		name     string
+		complete func(etags []string) []CompletedPart
+		wantErr  bool
+	}{
+		{
+			name: "in order",
+			complete: func(etags []string) []CompletedPart {
+				return []CompletedPart{{PartNumber: 1, ETag: etags[0]}, {PartNumber: 2, ETag: etags[1]}}
+			},
+		},
+		{
+			name: "wrong etag",
+			complete: func(etags []string) []CompletedPart {
+				return []CompletedPart{{PartNumber: 1, ETag: etags[0]}, {PartNumber: 2, ETag: etags[0]}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing part",
+			complete: func(etags []string) []CompletedPart {
+				return []CompletedPart{{PartNumber: 1, ETag: etags[0]}, {PartNumber: 3, ETag: etags[1]}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "out of order",
+			complete: func(etags []string) []CompletedPart {
+				return []CompletedPart{{PartNumber: 2, ETag: etags[1]}, {PartNumber: 1, ETag: etags[0]}}
+			},
+			wantErr: true,
+		},
+	}
+	for name, storage := range testBackends(t) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				served := storage.(ServedStorage)
+				uploadID, err := storage.CreateMultipartUpload(ctx, key, "image/jpeg")
+				if err != nil {
+					t.Fatal(err)
+				}
+				var etags []string
+				for i, part := range [][]byte{part1, part2} {
+					etag, err := served.UploadPart(ctx, key, uploadID, i+1, part)
+					if err != nil {
+						t.Fatal(err)
+					}
+					etags = append(etags, etag)
+				}
+				if parts, err := storage.ListParts(ctx, key, uploadID); err != nil || len(parts) != 2 {
+					t.Fatalf("ListParts() = %+v, %v, want 2 parts", parts, err)
+				}
+
+				err = storage.CompleteMultipartUpload(ctx, key, uploadID, tt.complete(etags))
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("CompleteMultipartUpload() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					if err := storage.AbortMultipartUpload(ctx, key, uploadID); err != nil {
+						t.Errorf("AbortMultipartUpload() error = %v", err)
+					}
+					if _, err := storage.ListParts(ctx, key, uploadID); !errors.Is(err, ErrUploadNotFound) {
+						t.Errorf("ListParts() after abort error = %v, want ErrUploadNotFound", err)
+					}
+					return
+				}
+
+				got, err := storage.GetObject(ctx, key)
+				if err != nil || !bytes.Equal(got, append(append([]byte(nil), part1...), part2...)) {
+					t.Fatalf("assembled object is %d bytes, %v", len(got), err)
+				}
+				info, err := storage.HeadObject(ctx, key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasSuffix(info.ETag, "-2") || info.ContentType != "image/jpeg" {
+					t.Errorf("HeadObject() = %+v, want a 2-part ETag and the upload's content type", info)
+				}
+				if _, err := storage.ListParts(ctx, key, uploadID); !errors.Is(err, ErrUploadNotFound) {
+					t.Errorf("ListParts() after completing error = %v, want ErrUploadNotFound", err)
+				}
+			})
+		}
+	}
+}
+
+func TestMultipartETag(t *testing.T) {
+	md5hex := func(s string) string {
+		sum := md5.Sum([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	a, b := md5hex("a"), md5hex("b")
+	raw, _ := hex.DecodeString(a + b)
+
+	got, err := multipartETag([]string{a, b})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := md5hex(string(raw)) + "-2"; got != want {
+		t.Errorf("multipartETag() = %q, want %q", got, want)
+	}
+	if _, err := multipartETag([]string{"not hex"}); err == nil {
+		t.Error("multipartETag() accepted an ETag that isn't hex")
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Storage keys are laid out per user and album, so everything a user or album owns
+// shares a prefix:
+//
+//	users/{user_id}/albums/{album_id}/originals/{photo_id}.{ext}
+//	users/{user_id}/albums/{album_id}/generated/{theme_id}/{generated_photo_id}.{ext}
+//
+// Display copies, derivatives and on-the-fly transforms are stored next to the object they
+// are made from.
+//
+// Keys are built from IDs only; the uploaded filename is kept as metadata on the photo.
+
+// OriginalKey returns the storage key of an uploaded original photo
+func OriginalKey(userID, albumID, photoID, mimeType string) string {
+	return fmt.Sprintf("%s/originals/%s.%s", albumKeyPrefix(userID, albumID), photoID, ExtensionForMimeType(mimeType))
+}
+
+// GeneratedKey returns the storage key of a generated photo. userID and albumID are
+// those of the original photo, so generated images live next to their source.
+func GeneratedKey(userID, albumID, themeID, generatedPhotoID, mimeType string) string {
+	return fmt.Sprintf("%s/generated/%s/%s.%s", albumKeyPrefix(userID, albumID), themeID, generatedPhotoID, ExtensionForMimeType(mimeType))
+}
+
+// DerivativeKey returns the storage key of a resized copy of the object at parentKey,
+// e.g. ".../originals/{id}.jpg" becomes ".../originals/{id}_256.webp"
+func DerivativeKey(parentKey string, size int, format string) string {
+	return fmt.Sprintf("%s_%d.%s", strings.TrimSuffix(parentKey, path.Ext(parentKey)), size, format)
+}
+
+// DisplayKey returns the storage key of the display copy of an original photo, an upright
+// copy without metadata, e.g. ".../originals/{id}.jpg" becomes ".../originals/{id}_display.jpg"
+func DisplayKey(originalKey, mimeType string) string {
+	return fmt.Sprintf("%s_display.%s", strings.TrimSuffix(originalKey, path.Ext(originalKey)), ExtensionForMimeType(mimeType))
+}
+
+// TransformKey returns the storage key of a transformed copy of the object at parentKey,
+// e.g. ".../originals/{id}.jpg" becomes ".../originals/{id}.transforms/800x600_cover_q82.webp"
+func TransformKey(parentKey string, p TransformParams) string {
+	return TransformKeyPrefix(parentKey) + p.String()
+}
+
+// TransformKeyPrefix returns the prefix of every transformed copy of the object at parentKey
+func TransformKeyPrefix(parentKey string) string {
+	return strings.TrimSuffix(parentKey, path.Ext(parentKey)) + transformDir
+}
+
+// transformDir separates a parent object from its transformed copies in their keys
+const transformDir = ".transforms/"
+
+// transformParentPrefix returns the TransformKeyPrefix a transform key was built from
+func transformParentPrefix(key string) (string, bool) {
+	i := strings.LastIndex(key, transformDir)
+	if i < 0 {
+		return "", false
+	}
+	return key[:i+len(transformDir)], true
+}
+
+// UserKeyPrefix returns the prefix of every object owned by a user
+func UserKeyPrefix(userID string) string {
+	return fmt.Sprintf("users/%s/", userID)
+}
+
+func albumKeyPrefix(userID, albumID string) string {
+	return fmt.Sprintf("users/%s/albums/%s", userID, albumID)
+}
+
+// ExtensionForMimeType returns the file extension, without the dot, used for a content type
+func ExtensionForMimeType(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return "jpg"
+	case "image/png":
+		return "png"
+	case "image/gif":
+		return "gif"
+	case "image/webp":
+		return "webp"
+	case "image/heic":
+		return "heic"
+	default:
+		return "bin"
+	}
+}
+
+// maxFilenameLength bounds stored filenames, in bytes
+const maxFilenameLength = 255
+
+// SanitizeFilename reduces a client-supplied filename to a safe display name: the base
+// name only, without control characters or path separators, at most 255 bytes
+func SanitizeFilename(name string) string {
+	// Browsers on Windows may send full paths
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+
+	name = strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	name = strings.TrimSpace(name)
+
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	if name == "" || name == "." || name == ".." {
+		return "upload"
+	}
+	return name
+}
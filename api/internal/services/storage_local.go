@@ -0,0 +1,530 @@
+package services
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalStorage stores objects on the local filesystem. Clients upload and download
+// through signed URLs served by the API, so no object store is needed in development.
+//
+// Object content lives under root/objects/{key} and its metadata under root/meta/{key}.json.
+// Multipart uploads in progress keep their parts under root/uploads/{upload_id}/.
+type LocalStorage struct {
+	root   string
+	signer urlSigner
+}
+
+// localObjectMeta is the metadata kept next to each object
+type localObjectMeta struct {
+	ContentType string `json:"content_type"`
+	ETag        string `json:"etag"`
+}
+
+// NewLocalStorage creates a new LocalStorage rooted at dir.
+// baseURL is the public URL of the API, which serves the signed URLs.
+func NewLocalStorage(dir, baseURL string, secret []byte) (*LocalStorage, error) {
+	for _, sub := range []string{"objects", "meta", "uploads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create storage directory: %w", err)
+		}
+	}
+
+	return &LocalStorage{
+		root:   dir,
+		signer: urlSigner{baseURL: baseURL, secret: secret},
+	}, nil
+}
+
+// GenerateUploadURL creates a signed URL for uploading through the API
+func (s *LocalStorage) GenerateUploadURL(ctx context.Context, req UploadURLRequest) (*UploadURLResponse, error) {
+	storageKey := req.StorageKey
+	if _, err := s.objectPath(storageKey); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(uploadURLExpiry)
+	return &UploadURLResponse{
+		UploadURL:  s.signer.signedURL("PUT", storageKey, req.MimeType, req.Size, expiresAt),
+		StorageKey: storageKey,
+		ExpiresAt:  expiresAt.Unix(),
+	}, nil
+}
+
+// GenerateDownloadURL creates a signed URL for downloading through the API
+func (s *LocalStorage) GenerateDownloadURL(ctx context.Context, storageKey string) (*DownloadURLResponse, error) {
+	expiresAt := time.Now().Add(downloadURLExpiry)
+	return &DownloadURLResponse{
+		DownloadURL: s.signer.signedURL("GET", storageKey, "", 0, expiresAt),
+		ExpiresAt:   expiresAt.Unix(),
+	}, nil
+}
+
+// VerifySignedURL checks a request to a URL issued by this storage
+func (s *LocalStorage) VerifySignedURL(method, storageKey string, query url.Values) error {
+	return s.signer.verify(method, storageKey, query)
+}
+
+// PutObject writes an object to disk. Writes go through a temporary file so readers
+// never see a partially written object.
+func (s *LocalStorage) PutObject(ctx context.Context, storageKey string, body []byte, contentType string) error {
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return err
+	}
+
+	sum := md5.Sum(body)
+	meta, err := json.Marshal(localObjectMeta{ContentType: contentType, ETag: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(s.metaPath(storageKey), meta); err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	if err := writeFileAtomic(objectPath, body); err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+	return nil
+}
+
+// GetObject reads an object from disk
+func (s *LocalStorage) GetObject(ctx context.Context, storageKey string) ([]byte, error) {
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.ReadFile(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	return body, nil
+}
+
+// OpenObject opens an object's file
+func (s *LocalStorage) OpenObject(ctx context.Context, storageKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	info, err := s.HeadObject(ctx, storageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	f, err := os.Open(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, ErrObjectNotFound
+		}
+		return nil, nil, fmt.Errorf("failed to open object: %w", err)
+	}
+	return f, info, nil
+}
+
+// DeleteObject removes an object from disk. Deleting a missing object is not an error.
+func (s *LocalStorage) DeleteObject(ctx context.Context, storageKey string) error {
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range []string{objectPath, s.metaPath(storageKey)} {
+		if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to delete object: %w", err)
+		}
+	}
+	return nil
+}
+
+// HeadObject returns an object's metadata
+func (s *LocalStorage) HeadObject(ctx context.Context, storageKey string) (*ObjectInfo, error) {
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return nil, err
+	}
+
+	stat, err := os.Stat(objectPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to head object: %w", err)
+	}
+
+	info := &ObjectInfo{
+		Key:          storageKey,
+		Size:         stat.Size(),
+		LastModified: stat.ModTime(),
+	}
+
+	var meta localObjectMeta
+	if data, err := os.ReadFile(s.metaPath(storageKey)); err == nil && json.Unmarshal(data, &meta) == nil {
+		info.ContentType = meta.ContentType
+		info.ETag = meta.ETag
+	}
+	return info, nil
+}
+
+// ListObjects lists the objects whose keys start with prefix
+func (s *LocalStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objectsDir := filepath.Join(s.root, "objects")
+
+	var objects []ObjectInfo
+	err := filepath.WalkDir(objectsDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempFilePrefix) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(objectsDir, p)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := s.HeadObject(ctx, key)
+		if err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				return nil // deleted while listing
+			}
+			return err
+		}
+		objects = append(objects, *info)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	return objects, nil
+}
+
+// localUpload is the manifest of a multipart upload, kept in its directory
+type localUpload struct {
+	StorageKey  string    `json:"storage_key"`
+	ContentType string    `json:"content_type"`
+	Initiated   time.Time `json:"initiated"`
+}
+
+// CreateMultipartUpload starts a multipart upload on disk
+func (s *LocalStorage) CreateMultipartUpload(ctx context.Context, storageKey, contentType string) (string, error) {
+	if _, err := s.objectPath(storageKey); err != nil {
+		return "", err
+	}
+
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err := json.Marshal(localUpload{StorageKey: storageKey, ContentType: contentType, Initiated: time.Now()})
+	if err != nil {
+		return "", err
+	}
+	if err := writeFileAtomic(filepath.Join(s.uploadDir(uploadID), "upload.json"), manifest); err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+	return uploadID, nil
+}
+
+// GeneratePartUploadURL creates a signed URL for uploading one part through the API
+func (s *LocalStorage) GeneratePartUploadURL(ctx context.Context, storageKey, uploadID string, partNumber int) (*UploadURLResponse, error) {
+	if _, err := s.upload(storageKey, uploadID); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(uploadURLExpiry)
+	return &UploadURLResponse{
+		UploadURL:  s.signer.signedPartURL(storageKey, uploadID, partNumber, expiresAt),
+		StorageKey: storageKey,
+		ExpiresAt:  expiresAt.Unix(),
+	}, nil
+}
+
+// UploadPart writes one part of a multipart upload to disk. Its ETag is written
+// after the content, so a part only counts as uploaded once both are in place.
+func (s *LocalStorage) UploadPart(ctx context.Context, storageKey, uploadID string, partNumber int, body []byte) (string, error) {
+	if _, err := s.upload(storageKey, uploadID); err != nil {
+		return "", err
+	}
+
+	sum := md5.Sum(body)
+	etag := hex.EncodeToString(sum[:])
+
+	partPath := s.partPath(uploadID, partNumber)
+	if err := writeFileAtomic(partPath, body); err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", err)
+	}
+	if err := writeFileAtomic(partPath+".etag", []byte(etag)); err != nil {
+		return "", fmt.Errorf("failed to upload part: %w", err)
+	}
+	return etag, nil
+}
+
+// ListParts lists the parts written to a multipart upload, in part number order
+func (s *LocalStorage) ListParts(ctx context.Context, storageKey, uploadID string) ([]UploadedPart, error) {
+	if _, err := s.upload(storageKey, uploadID); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(s.uploadDir(uploadID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list parts: %w", err)
+	}
+
+	// Entries are sorted by name and part files are zero-padded, so parts come out in order
+	var parts []UploadedPart
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".part")
+		if !ok {
+			continue
+		}
+		partNumber, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+
+		partPath := filepath.Join(s.uploadDir(uploadID), entry.Name())
+		etag, err := os.ReadFile(partPath + ".etag")
+		if err != nil {
+			continue // still being written
+		}
+		stat, err := os.Stat(partPath)
+		if err != nil {
+			continue
+		}
+
+		parts = append(parts, UploadedPart{
+			PartNumber:   partNumber,
+			ETag:         string(etag),
+			Size:         stat.Size(),
+			LastModified: stat.ModTime(),
+		})
+	}
+	return parts, nil
+}
+
+// CompleteMultipartUpload concatenates the parts into the object and removes the upload.
+// The object gets the same ETag S3 would give it.
+func (s *LocalStorage) CompleteMultipartUpload(ctx context.Context, storageKey, uploadID string, completed []CompletedPart) error {
+	upload, err := s.upload(storageKey, uploadID)
+	if err != nil {
+		return err
+	}
+	uploaded, err := s.ListParts(ctx, storageKey, uploadID)
+	if err != nil {
+		return err
+	}
+	parts, err := checkCompletedParts(completed, partsByNumber(uploaded))
+	if err != nil {
+		return err
+	}
+
+	partETags := make([]string, len(parts))
+	for i, part := range parts {
+		partETags[i] = part.ETag
+	}
+	etag, err := multipartETag(partETags)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(localObjectMeta{ContentType: upload.ContentType, ETag: etag})
+	if err != nil {
+		return err
+	}
+
+	objectPath, err := s.objectPath(storageKey)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(s.metaPath(storageKey), meta); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	if err := s.concatenateParts(objectPath, uploadID, parts); err != nil {
+		return fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to remove completed upload: %w", err)
+	}
+	return nil
+}
+
+// AbortMultipartUpload removes a multipart upload and its parts from disk
+func (s *LocalStorage) AbortMultipartUpload(ctx context.Context, storageKey, uploadID string) error {
+	if _, err := s.upload(storageKey, uploadID); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(s.uploadDir(uploadID)); err != nil {
+		return fmt.Errorf("failed to abort multipart upload: %w", err)
+	}
+	return nil
+}
+
+// ListMultipartUploads lists the multipart uploads in progress on disk
+func (s *LocalStorage) ListMultipartUploads(ctx context.Context) ([]MultipartUploadInfo, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, "uploads"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+
+	var uploads []MultipartUploadInfo
+	for _, entry := range entries {
+		upload, err := s.readUpload(entry.Name())
+		if err != nil {
+			continue // removed while listing, or not an upload
+		}
+		uploads = append(uploads, MultipartUploadInfo{
+			StorageKey: upload.StorageKey,
+			UploadID:   entry.Name(),
+			Initiated:  upload.Initiated,
+		})
+	}
+	return uploads, nil
+}
+
+// upload loads the manifest of a multipart upload, checking it belongs to storageKey
+func (s *LocalStorage) upload(storageKey, uploadID string) (*localUpload, error) {
+	upload, err := s.readUpload(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.StorageKey != storageKey {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (s *LocalStorage) readUpload(uploadID string) (*localUpload, error) {
+	// Upload IDs are generated by newUploadID; anything else could escape the directory
+	if _, err := hex.DecodeString(uploadID); err != nil || uploadID == "" {
+		return nil, ErrUploadNotFound
+	}
+
+	data, err := os.ReadFile(filepath.Join(s.uploadDir(uploadID), "upload.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrUploadNotFound
+		}
+		return nil, fmt.Errorf("failed to read multipart upload: %w", err)
+	}
+
+	var upload localUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("failed to read multipart upload: %w", err)
+	}
+	return &upload, nil
+}
+
+// concatenateParts writes the parts, in order, to a temporary file renamed to name
+func (s *LocalStorage) concatenateParts(name, uploadID string, parts []UploadedPart) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, part := range parts {
+		if err := appendFile(tmp, s.partPath(uploadID, part.PartNumber)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+func appendFile(w io.Writer, name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (s *LocalStorage) uploadDir(uploadID string) string {
+	return filepath.Join(s.root, "uploads", uploadID)
+}
+
+func (s *LocalStorage) partPath(uploadID string, partNumber int) string {
+	return filepath.Join(s.uploadDir(uploadID), fmt.Sprintf("%05d.part", partNumber))
+}
+
+// newUploadID returns a random multipart upload ID
+func newUploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// objectPath maps a storage key to its file, rejecting keys that would escape the root
+func (s *LocalStorage) objectPath(storageKey string) (string, error) {
+	if storageKey == "" || strings.HasPrefix(storageKey, "/") || path.Clean(storageKey) != storageKey ||
+		strings.HasPrefix(storageKey, "../") || storageKey == ".." {
+		return "", fmt.Errorf("invalid storage key %q", storageKey)
+	}
+	return filepath.Join(s.root, "objects", filepath.FromSlash(storageKey)), nil
+}
+
+func (s *LocalStorage) metaPath(storageKey string) string {
+	return filepath.Join(s.root, "meta", filepath.FromSlash(storageKey)+".json")
+}
+
+// tempFilePrefix marks in-progress writes, which listings skip
+const tempFilePrefix = ".tmp-"
+
+// writeFileAtomic writes data to a temporary file next to name and renames it into place
+func writeFileAtomic(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), tempFilePrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
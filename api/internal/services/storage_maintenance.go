@@ -0,0 +1,467 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// StorageMaintenanceService runs operational jobs over stored objects: key layout
+// migrations and reconciliation of storage with the database
+type StorageMaintenanceService struct {
+	db      *sql.DB
+	storage Storage
+	logger  *slog.Logger
+}
+
+// NewStorageMaintenanceService creates a new StorageMaintenanceService
+func NewStorageMaintenanceService(db *sql.DB, storage Storage, logger *slog.Logger) *StorageMaintenanceService {
+	return &StorageMaintenanceService{
+		db:      db,
+		storage: storage,
+		logger:  logger.With("component", "storage-maintenance"),
+	}
+}
+
+// KeyMigrationReport summarizes a storage key layout migration. In a dry run the
+// moved counts are the objects that would have been moved.
+type KeyMigrationReport struct {
+	Photos          int // originals moved
+	GeneratedPhotos int // generated photos moved
+	Derivatives     int // derivatives moved along with their parent
+	Missing         int // rows whose object could not be found, left untouched
+	Failed          int // rows that could not be moved because of other errors
+}
+
+// keyMove relocates the object of one photo or generated photo, with its derivatives
+type keyMove struct {
+	table       string // "photos" or "generated_photos"
+	id          string
+	oldKey      string
+	newKey      string
+	derivatives []derivativeMove
+}
+
+type derivativeMove struct {
+	id     string
+	oldKey string
+	newKey string
+}
+
+// MigrateKeyLayout moves originals and generated photos stored under legacy keys
+// (uploads/..., generated/..., derivatives/...) to the per-user layout described
+// in storage_keys.go, rewriting storage_key columns as it goes.
+//
+// Every object is copied before its row is updated and the old copy is only deleted
+// afterwards, so the migration can be interrupted and re-run at any time. With dryRun
+// set, moves are only logged.
+func (s *StorageMaintenanceService) MigrateKeyLayout(ctx context.Context, dryRun bool) (*KeyMigrationReport, error) {
+	moves, err := s.legacyPhotoMoves(ctx)
+	if err != nil {
+		return nil, err
+	}
+	generatedMoves, err := s.legacyGeneratedPhotoMoves(ctx)
+	if err != nil {
+		return nil, err
+	}
+	moves = append(moves, generatedMoves...)
+
+	report := &KeyMigrationReport{}
+	for _, move := range moves {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+
+		logger := s.logger.With("table", move.table, "id", move.id, "from", move.oldKey, "to", move.newKey)
+		if dryRun {
+			logger.Info("Would move object", "derivatives", len(move.derivatives))
+		} else if err := s.applyMove(ctx, move); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				logger.Warn("Object is missing, leaving row as is")
+				report.Missing++
+				continue
+			}
+			logger.Error("Failed to move object", "error", err)
+			report.Failed++
+			continue
+		} else {
+			logger.Info("Moved object", "derivatives", len(move.derivatives))
+		}
+
+		if move.table == "photos" {
+			report.Photos++
+		} else {
+			report.GeneratedPhotos++
+		}
+		report.Derivatives += len(move.derivatives)
+	}
+
+	return report, nil
+}
+
+// legacyPhotoMoves plans the moves of originals not yet under users/
+func (s *StorageMaintenanceService) legacyPhotoMoves(ctx context.Context) ([]keyMove, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, user_id, album_id, storage_key, mime_type
+		 FROM photos WHERE storage_key NOT LIKE 'users/%'
+		 ORDER BY created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moves []keyMove
+	for rows.Next() {
+		var id, userID, albumID, storageKey string
+		var mimeType sql.NullString
+		if err := rows.Scan(&id, &userID, &albumID, &storageKey, &mimeType); err != nil {
+			return nil, err
+		}
+		if !mimeType.Valid {
+			mimeType.String = mimeTypeForKey(storageKey)
+		}
+		moves = append(moves, keyMove{
+			table:  "photos",
+			id:     id,
+			oldKey: storageKey,
+			newKey: OriginalKey(userID, albumID, id, mimeType.String),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return moves, s.planDerivatives(ctx, "photo_id", moves)
+}
+
+// legacyGeneratedPhotoMoves plans the moves of completed generated photos not yet under users/
+func (s *StorageMaintenanceService) legacyGeneratedPhotoMoves(ctx context.Context) ([]keyMove, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT g.id, p.user_id, p.album_id, g.theme_id, g.storage_key
+		 FROM generated_photos g
+		 JOIN photos p ON p.id = g.original_photo_id
+		 WHERE g.status = 'completed' AND g.storage_key NOT LIKE 'users/%'
+		 ORDER BY g.created_at`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var moves []keyMove
+	for rows.Next() {
+		var id, userID, albumID, themeID, storageKey string
+		if err := rows.Scan(&id, &userID, &albumID, &themeID, &storageKey); err != nil {
+			return nil, err
+		}
+		moves = append(moves, keyMove{
+			table:  "generated_photos",
+			id:     id,
+			oldKey: storageKey,
+			newKey: GeneratedKey(userID, albumID, themeID, id, mimeTypeForKey(storageKey)),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return moves, s.planDerivatives(ctx, "generated_photo_id", moves)
+}
+
+// planDerivatives fills in the derivatives that move along with each parent
+func (s *StorageMaintenanceService) planDerivatives(ctx context.Context, column string, moves []keyMove) error {
+	for i := range moves {
+		rows, err := s.db.QueryContext(ctx,
+			`SELECT id, storage_key, size, format FROM derivatives WHERE `+column+` = $1`,
+			moves[i].id,
+		)
+		if err != nil {
+			return err
+		}
+
+		for rows.Next() {
+			var d derivativeMove
+			var size int
+			var format string
+			if err := rows.Scan(&d.id, &d.oldKey, &size, &format); err != nil {
+				rows.Close()
+				return err
+			}
+			d.newKey = DerivativeKey(moves[i].newKey, size, format)
+			moves[i].derivatives = append(moves[i].derivatives, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMove copies the objects, points the rows at the new keys and removes the old objects
+func (s *StorageMaintenanceService) applyMove(ctx context.Context, move keyMove) error {
+	if err := s.copyObject(ctx, move.oldKey, move.newKey); err != nil {
+		return err
+	}
+
+	// Derivatives can be regenerated, so a missing one is dropped rather than failing the move
+	var movedDerivatives []derivativeMove
+	for _, d := range move.derivatives {
+		if err := s.copyObject(ctx, d.oldKey, d.newKey); err != nil {
+			if errors.Is(err, ErrObjectNotFound) {
+				continue
+			}
+			return err
+		}
+		movedDerivatives = append(movedDerivatives, d)
+	}
+
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		// The old key is part of the condition so a concurrent change isn't overwritten
+		result, err := tx.ExecContext(ctx,
+			`UPDATE `+move.table+` SET storage_key = $1 WHERE id = $2 AND storage_key = $3`,
+			move.newKey, move.id, move.oldKey,
+		)
+		if err != nil {
+			return err
+		}
+		if err := expectOneRow(result, "row changed during migration"); err != nil {
+			return err
+		}
+
+		for _, d := range movedDerivatives {
+			_, err := tx.ExecContext(ctx,
+				`UPDATE derivatives SET storage_key = $1 WHERE id = $2`,
+				d.newKey, d.id,
+			)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Leftovers from a failed delete are unreferenced and cleaned up by garbage collection
+	for _, key := range append([]string{move.oldKey}, oldKeys(movedDerivatives)...) {
+		if err := s.storage.DeleteObject(ctx, key); err != nil {
+			s.logger.Warn("Failed to delete migrated object", "key", key, "error", err)
+		}
+	}
+	return nil
+}
+
+// copyObject copies an object to a new key, keeping its content type
+func (s *StorageMaintenanceService) copyObject(ctx context.Context, fromKey, toKey string) error {
+	info, err := s.storage.HeadObject(ctx, fromKey)
+	if err != nil {
+		return err
+	}
+	body, err := s.storage.GetObject(ctx, fromKey)
+	if err != nil {
+		return err
+	}
+	if err := s.storage.PutObject(ctx, toKey, body, info.ContentType); err != nil {
+		return fmt.Errorf("copy %s: %w", fromKey, err)
+	}
+	return nil
+}
+
+func oldKeys(moves []derivativeMove) []string {
+	keys := make([]string, len(moves))
+	for i, d := range moves {
+		keys[i] = d.oldKey
+	}
+	return keys
+}
+
+// mimeTypeForKey guesses a content type from a storage key's extension
+func mimeTypeForKey(storageKey string) string {
+	ext := strings.ToLower(path.Ext(storageKey))
+	if ext == ".jpg" {
+		return "image/jpeg" // not registered on every system
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// ReconcileOptions controls a storage reconciliation run
+type ReconcileOptions struct {
+	// GracePeriod protects recent objects, whose row may not be committed yet
+	GracePeriod time.Duration
+	// DeleteOrphans deletes unreferenced objects instead of only reporting them
+	DeleteOrphans bool
+}
+
+// ReconcileReport summarizes a storage reconciliation run
+type ReconcileReport struct {
+	Objects                int   // objects in storage
+	Orphans                int   // unreferenced objects older than the grace period
+	OrphanBytes            int64 // their total size
+	Deleted                int   // orphans deleted
+	MissingPhotos          int   // photos flagged because their object is missing
+	MissingGeneratedPhotos int   // generated photos flagged because their object is missing
+}
+
+// Reconcile diffs the objects in storage against the keys referenced by photos, generated
+// photos, derivatives and pending uploads. Objects nothing references are orphans, left
+// behind by deleted rows or failed uploads; rows whose object is gone are flagged with
+// object_missing_at, which is cleared again if the object reappears.
+//
+// Storage is listed before the database is read, so an object uploaded during the run is
+// either referenced or within the grace period.
+func (s *StorageMaintenanceService) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	objects, err := s.storage.ListObjects(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		stored[obj.Key] = true
+	}
+
+	referenced, err := s.referencedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{Objects: len(objects)}
+	for _, obj := range objects {
+		if isReferenced(referenced, obj.Key) || obj.LastModified.After(cutoff) {
+			continue
+		}
+
+		report.Orphans++
+		report.OrphanBytes += obj.Size
+		if !opts.DeleteOrphans {
+			s.logger.Info("Found orphaned object", "key", obj.Key, "size", obj.Size, "last_modified", obj.LastModified)
+			continue
+		}
+
+		if err := s.storage.DeleteObject(ctx, obj.Key); err != nil {
+			s.logger.Error("Failed to delete orphaned object", "key", obj.Key, "error", err)
+			continue
+		}
+		s.logger.Info("Deleted orphaned object", "key", obj.Key, "size", obj.Size, "last_modified", obj.LastModified)
+		report.Deleted++
+	}
+
+	report.MissingPhotos, err = s.flagMissingObjects(ctx,
+		`SELECT id, storage_key FROM photos WHERE created_at < $1`,
+		"photos", stored, cutoff,
+	)
+	if err != nil {
+		return report, err
+	}
+
+	report.MissingGeneratedPhotos, err = s.flagMissingObjects(ctx,
+		`SELECT id, storage_key FROM generated_photos
+		 WHERE status = 'completed' AND storage_key <> '' AND COALESCE(completed_at, created_at) < $1`,
+		"generated_photos", stored, cutoff,
+	)
+	if err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// referencedKeys returns every storage key a row points at, and the prefix of the
+// transformed copies of each. Pending uploads count until they expire, so objects
+// uploaded but not yet turned into photos are kept.
+func (s *StorageMaintenanceService) referencedKeys(ctx context.Context) (map[string]bool, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT storage_key FROM photos
+		 UNION SELECT display_storage_key FROM photos WHERE display_storage_key IS NOT NULL
+		 UNION SELECT storage_key FROM generated_photos WHERE storage_key <> ''
+		 UNION SELECT storage_key FROM derivatives
+		 UNION SELECT storage_key FROM pending_uploads WHERE consumed_at IS NULL AND expires_at > NOW()`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		referenced[key] = true
+		// Transformed copies live and die with their parent; prefixes end in a slash,
+		// so they can't be mistaken for object keys
+		referenced[TransformKeyPrefix(key)] = true
+	}
+	return referenced, rows.Err()
+}
+
+// isReferenced reports whether key is referenced by a row, directly or as a transformed
+// copy of a photo or generated photo
+func isReferenced(referenced map[string]bool, key string) bool {
+	if referenced[key] {
+		return true
+	}
+	prefix, ok := transformParentPrefix(key)
+	return ok && referenced[prefix]
+}
+
+// flagMissingObjects sets object_missing_at on the rows selected by query whose object is
+// not in stored, clears it on the others, and returns how many rows are missing their object
+func (s *StorageMaintenanceService) flagMissingObjects(ctx context.Context, query, table string, stored map[string]bool, cutoff time.Time) (int, error) {
+	rows, err := s.db.QueryContext(ctx, query, cutoff)
+	if err != nil {
+		return 0, err
+	}
+
+	var missing, present []string
+	for rows.Next() {
+		var id, key string
+		if err := rows.Scan(&id, &key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if stored[key] {
+			present = append(present, id)
+		} else {
+			s.logger.Warn("Object is missing", "table", table, "id", id, "key", key)
+			missing = append(missing, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE `+table+` SET object_missing_at = NOW()
+		 WHERE id = ANY($1) AND object_missing_at IS NULL`,
+		pq.Array(missing),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE `+table+` SET object_missing_at = NULL
+		 WHERE id = ANY($1) AND object_missing_at IS NOT NULL`,
+		pq.Array(present),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(missing), nil
+}
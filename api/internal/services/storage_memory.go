@@ -0,0 +1,316 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage keeps objects in memory, for tests and throwaway environments.
+// Like LocalStorage, its signed URLs are served by the API.
+type MemoryStorage struct {
+	signer urlSigner
+
+	mu      sync.RWMutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryUpload struct {
+	storageKey  string
+	contentType string
+	initiated   time.Time
+	parts       map[int]memoryObject
+}
+
+type memoryObject struct {
+	body        []byte
+	contentType string
+	etag        string
+	modified    time.Time
+}
+
+// NewMemoryStorage creates a new, empty MemoryStorage
+func NewMemoryStorage(baseURL string, secret []byte) *MemoryStorage {
+	return &MemoryStorage{
+		signer:  urlSigner{baseURL: baseURL, secret: secret},
+		objects: make(map[string]memoryObject),
+		uploads: make(map[string]*memoryUpload),
+	}
+}
+
+// GenerateUploadURL creates a signed URL for uploading through the API
+func (s *MemoryStorage) GenerateUploadURL(ctx context.Context, req UploadURLRequest) (*UploadURLResponse, error) {
+	storageKey := req.StorageKey
+	expiresAt := time.Now().Add(uploadURLExpiry)
+	return &UploadURLResponse{
+		UploadURL:  s.signer.signedURL("PUT", storageKey, req.MimeType, req.Size, expiresAt),
+		StorageKey: storageKey,
+		ExpiresAt:  expiresAt.Unix(),
+	}, nil
+}
+
+// GenerateDownloadURL creates a signed URL for downloading through the API
+func (s *MemoryStorage) GenerateDownloadURL(ctx context.Context, storageKey string) (*DownloadURLResponse, error) {
+	expiresAt := time.Now().Add(downloadURLExpiry)
+	return &DownloadURLResponse{
+		DownloadURL: s.signer.signedURL("GET", storageKey, "", 0, expiresAt),
+		ExpiresAt:   expiresAt.Unix(),
+	}, nil
+}
+
+// VerifySignedURL checks a request to a URL issued by this storage
+func (s *MemoryStorage) VerifySignedURL(method, storageKey string, query url.Values) error {
+	return s.signer.verify(method, storageKey, query)
+}
+
+// PutObject stores a copy of body
+func (s *MemoryStorage) PutObject(ctx context.Context, storageKey string, body []byte, contentType string) error {
+	sum := md5.Sum(body)
+	obj := memoryObject{
+		body:        append([]byte(nil), body...),
+		contentType: contentType,
+		etag:        hex.EncodeToString(sum[:]),
+		modified:    time.Now(),
+	}
+
+	s.mu.Lock()
+	s.objects[storageKey] = obj
+	s.mu.Unlock()
+	return nil
+}
+
+// GetObject returns a copy of an object's content
+func (s *MemoryStorage) GetObject(ctx context.Context, storageKey string) ([]byte, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[storageKey]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return append([]byte(nil), obj.body...), nil
+}
+
+// OpenObject returns a reader over an object's content
+func (s *MemoryStorage) OpenObject(ctx context.Context, storageKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[storageKey]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, nil, ErrObjectNotFound
+	}
+
+	// Objects are replaced rather than modified, so the body can be read without copying
+	info := obj.info(storageKey)
+	return nopCloser{bytes.NewReader(obj.body)}, &info, nil
+}
+
+// nopCloser adds a no-op Close to a ReadSeeker
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error { return nil }
+
+// DeleteObject removes an object. Deleting a missing object is not an error.
+func (s *MemoryStorage) DeleteObject(ctx context.Context, storageKey string) error {
+	s.mu.Lock()
+	delete(s.objects, storageKey)
+	s.mu.Unlock()
+	return nil
+}
+
+// HeadObject returns an object's metadata
+func (s *MemoryStorage) HeadObject(ctx context.Context, storageKey string) (*ObjectInfo, error) {
+	s.mu.RLock()
+	obj, ok := s.objects[storageKey]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	info := obj.info(storageKey)
+	return &info, nil
+}
+
+// ListObjects lists the objects whose keys start with prefix, in key order
+func (s *MemoryStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	s.mu.RLock()
+	var objects []ObjectInfo
+	for key, obj := range s.objects {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, obj.info(key))
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+	return objects, nil
+}
+
+// CreateMultipartUpload starts a multipart upload in memory
+func (s *MemoryStorage) CreateMultipartUpload(ctx context.Context, storageKey, contentType string) (string, error) {
+	uploadID, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	s.uploads[uploadID] = &memoryUpload{
+		storageKey:  storageKey,
+		contentType: contentType,
+		initiated:   time.Now(),
+		parts:       make(map[int]memoryObject),
+	}
+	s.mu.Unlock()
+	return uploadID, nil
+}
+
+// GeneratePartUploadURL creates a signed URL for uploading one part through the API
+func (s *MemoryStorage) GeneratePartUploadURL(ctx context.Context, storageKey, uploadID string, partNumber int) (*UploadURLResponse, error) {
+	s.mu.RLock()
+	_, err := s.upload(storageKey, uploadID)
+	s.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(uploadURLExpiry)
+	return &UploadURLResponse{
+		UploadURL:  s.signer.signedPartURL(storageKey, uploadID, partNumber, expiresAt),
+		StorageKey: storageKey,
+		ExpiresAt:  expiresAt.Unix(),
+	}, nil
+}
+
+// UploadPart stores a copy of one part of a multipart upload
+func (s *MemoryStorage) UploadPart(ctx context.Context, storageKey, uploadID string, partNumber int, body []byte) (string, error) {
+	sum := md5.Sum(body)
+	part := memoryObject{
+		body:     append([]byte(nil), body...),
+		etag:     hex.EncodeToString(sum[:]),
+		modified: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, err := s.upload(storageKey, uploadID)
+	if err != nil {
+		return "", err
+	}
+	upload.parts[partNumber] = part
+	return part.etag, nil
+}
+
+// ListParts lists the parts of a multipart upload, in part number order
+func (s *MemoryStorage) ListParts(ctx context.Context, storageKey, uploadID string) ([]UploadedPart, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	upload, err := s.upload(storageKey, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return upload.uploadedParts(), nil
+}
+
+// CompleteMultipartUpload concatenates the parts into the object and removes the upload.
+// The object gets the same ETag S3 would give it.
+func (s *MemoryStorage) CompleteMultipartUpload(ctx context.Context, storageKey, uploadID string, completed []CompletedPart) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, err := s.upload(storageKey, uploadID)
+	if err != nil {
+		return err
+	}
+	parts, err := checkCompletedParts(completed, partsByNumber(upload.uploadedParts()))
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	partETags := make([]string, len(parts))
+	for i, part := range parts {
+		body.Write(upload.parts[part.PartNumber].body)
+		partETags[i] = part.ETag
+	}
+	etag, err := multipartETag(partETags)
+	if err != nil {
+		return err
+	}
+
+	s.objects[storageKey] = memoryObject{
+		body:        body.Bytes(),
+		contentType: upload.contentType,
+		etag:        etag,
+		modified:    time.Now(),
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *MemoryStorage) AbortMultipartUpload(ctx context.Context, storageKey, uploadID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.upload(storageKey, uploadID); err != nil {
+		return err
+	}
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// ListMultipartUploads lists the multipart uploads in progress
+func (s *MemoryStorage) ListMultipartUploads(ctx context.Context) ([]MultipartUploadInfo, error) {
+	s.mu.RLock()
+	var uploads []MultipartUploadInfo
+	for uploadID, upload := range s.uploads {
+		uploads = append(uploads, MultipartUploadInfo{
+			StorageKey: upload.storageKey,
+			UploadID:   uploadID,
+			Initiated:  upload.initiated,
+		})
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(uploads, func(i, j int) bool { return uploads[i].Initiated.Before(uploads[j].Initiated) })
+	return uploads, nil
+}
+
+// upload returns a multipart upload of storageKey; callers hold s.mu
+func (s *MemoryStorage) upload(storageKey, uploadID string) (*memoryUpload, error) {
+	upload, ok := s.uploads[uploadID]
+	if !ok || upload.storageKey != storageKey {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (u *memoryUpload) uploadedParts() []UploadedPart {
+	parts := make([]UploadedPart, 0, len(u.parts))
+	for partNumber, part := range u.parts {
+		parts = append(parts, UploadedPart{
+			PartNumber:   partNumber,
+			ETag:         part.etag,
+			Size:         int64(len(part.body)),
+			LastModified: part.modified,
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts
+}
+
+func (o memoryObject) info(key string) ObjectInfo {
+	return ObjectInfo{
+		Key:          key,
+		Size:         int64(len(o.body)),
+		ContentType:  o.contentType,
+		ETag:         o.etag,
+		LastModified: o.modified,
+	}
+}
@@ -0,0 +1,526 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// S3Storage stores objects in an S3-compatible bucket (AWS S3, MinIO, R2, ...)
+type S3Storage struct {
+	client *s3.Client
+	bucket string
+}
+
+// NewS3Storage creates a new S3Storage
+func NewS3Storage(endpoint, accessKey, secretKey, bucket string, useSSL bool) (*S3Storage, error) {
+	// Create custom resolver for S3-compatible storage (MinIO, R2, etc.)
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		scheme := "http"
+		if useSSL {
+			scheme = "https"
+		}
+		return aws.Endpoint{
+			URL:               fmt.Sprintf("%s://%s", scheme, endpoint),
+			HostnameImmutable: true,
+			Source:            aws.EndpointSourceCustom,
+		}, nil
+	})
+
+	cfg, err := config.LoadDefaultConfig(context.Background(),
+		config.WithRegion("auto"),
+		config.WithEndpointResolverWithOptions(resolver),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKey, secretKey, "")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.UsePathStyle = true // Required for MinIO
+	})
+
+	return &S3Storage{
+		client: client,
+		bucket: bucket,
+	}, nil
+}
+
+// GenerateUploadURL creates a presigned URL for direct upload to S3
+func (s *S3Storage) GenerateUploadURL(ctx context.Context, req UploadURLRequest) (*UploadURLResponse, error) {
+	storageKey := req.StorageKey
+
+	// Create presigned URL for PUT
+	presignClient := s3.NewPresignClient(s.client)
+	
+	putInput := &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(storageKey),
+		ContentType: aws.String(req.MimeType),
+	}
+
+	// Set content length if provided
+	if req.Size > 0 {
+		putInput.ContentLength = aws.Int64(req.Size)
+	}
+
+	presignedReq, err := presignClient.PresignPutObject(ctx, putInput,
+		s3.WithPresignExpires(uploadURLExpiry),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create presigned URL: %w", err)
+	}
+
+	return &UploadURLResponse{
+		UploadURL:  presignedReq.URL,
+		StorageKey: storageKey,
+		ExpiresAt:  time.Now().Add(uploadURLExpiry).Unix(),
+	}, nil
+}
+
+// GenerateDownloadURL creates a presigned URL for downloading from S3
+func (s *S3Storage) GenerateDownloadURL(ctx context.Context, storageKey string) (*DownloadURLResponse, error) {
+	presignClient := s3.NewPresignClient(s.client)
+
+	getInput := &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+	}
+
+	presignedReq, err := presignClient.PresignGetObject(ctx, getInput,
+		s3.WithPresignExpires(downloadURLExpiry),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create presigned URL: %w", err)
+	}
+
+	return &DownloadURLResponse{
+		DownloadURL: presignedReq.URL,
+		ExpiresAt:   time.Now().Add(downloadURLExpiry).Unix(),
+	}, nil
+}
+
+// DeleteObject deletes an object from S3
+func (s *S3Storage) DeleteObject(ctx context.Context, storageKey string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete object: %w", err)
+	}
+
+	return nil
+}
+
+// PutObject uploads an object to S3
+func (s *S3Storage) PutObject(ctx context.Context, storageKey string, body []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(storageKey),
+		Body:          bytes.NewReader(body),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(int64(len(body))),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put object: %w", err)
+	}
+
+	return nil
+}
+
+// GetObject downloads an object from S3
+func (s *S3Storage) GetObject(ctx context.Context, storageKey string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to get object: %w", err)
+	}
+	defer out.Body.Close()
+
+	body, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read object: %w", err)
+	}
+
+	return body, nil
+}
+
+// OpenObject returns a reader over an object in S3. Each read after a seek starts a ranged
+// GET from the new offset, so serving a range doesn't download the whole object.
+func (s *S3Storage) OpenObject(ctx context.Context, storageKey string) (io.ReadSeekCloser, *ObjectInfo, error) {
+	info, err := s.HeadObject(ctx, storageKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &s3ObjectReader{
+		ctx:     ctx,
+		storage: s,
+		key:     storageKey,
+		etag:    info.ETag,
+		size:    info.Size,
+	}, info, nil
+}
+
+// s3ObjectReader reads an S3 object through ranged GETs
+type s3ObjectReader struct {
+	ctx     context.Context
+	storage *S3Storage
+	key     string
+	etag    string // ranges are only read from this version of the object
+	size    int64
+	offset  int64
+	body    io.ReadCloser // open from offset, or nil
+}
+
+func (r *s3ObjectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	if r.body == nil {
+		out, err := r.storage.client.GetObject(r.ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(r.storage.bucket),
+			Key:     aws.String(r.key),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-", r.offset)),
+			IfMatch: aws.String(`"` + r.etag + `"`),
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to get object: %w", err)
+		}
+		r.body = out.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	return n, err
+}
+
+func (r *s3ObjectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *s3ObjectReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// HeadObject returns an object's metadata from S3
+func (s *S3Storage) HeadObject(ctx context.Context, storageKey string) (*ObjectInfo, error) {
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(storageKey),
+	})
+	if err != nil {
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, fmt.Errorf("failed to head object: %w", err)
+	}
+
+	return &ObjectInfo{
+		Key:          storageKey,
+		Size:         aws.ToInt64(out.ContentLength),
+		ContentType:  aws.ToString(out.ContentType),
+		ETag:         strings.Trim(aws.ToString(out.ETag), `"`),
+		LastModified: aws.ToTime(out.LastModified),
+	}, nil
+}
+
+// ListObjects lists the objects under a prefix in S3
+func (s *S3Storage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []ObjectInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(obj.Key),
+				Size:         aws.ToInt64(obj.Size),
+				ETag:         strings.Trim(aws.ToString(obj.ETag), `"`),
+				LastModified: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+
+	return objects, nil
+}
+
+// CreateMultipartUpload starts a multipart upload in S3
+func (s *S3Storage) CreateMultipartUpload(ctx context.Context, storageKey, contentType string) (string, error) {
+	out, err := s.client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(storageKey),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create multipart upload: %w", err)
+	}
+
+	return aws.ToString(out.UploadId), nil
+}
+
+// GeneratePartUploadURL creates a presigned URL for uploading one part directly to S3
+func (s *S3Storage) GeneratePartUploadURL(ctx context.Context, storageKey, uploadID string, partNumber int) (*UploadURLResponse, error) {
+	presignClient := s3.NewPresignClient(s.client)
+
+	presignedReq, err := presignClient.PresignUploadPart(ctx, &s3.UploadPartInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(storageKey),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int32(int32(partNumber)),
+	}, s3.WithPresignExpires(uploadURLExpiry))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create presigned URL: %w", err)
+	}
+
+	return &UploadURLResponse{
+		UploadURL:  presignedReq.URL,
+		StorageKey: storageKey,
+		ExpiresAt:  time.Now().Add(uploadURLExpiry).Unix(),
+	}, nil
+}
+
+// ListParts lists the parts uploaded to a multipart upload in S3
+func (s *S3Storage) ListParts(ctx context.Context, storageKey, uploadID string) ([]UploadedPart, error) {
+	paginator := s3.NewListPartsPaginator(s.client, &s3.ListPartsInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(storageKey),
+		UploadId: aws.String(uploadID),
+	})
+
+	var parts []UploadedPart
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, multipartError("list parts", err)
+		}
+		for _, part := range page.Parts {
+			parts = append(parts, UploadedPart{
+				PartNumber:   int(aws.ToInt32(part.PartNumber)),
+				ETag:         strings.Trim(aws.ToString(part.ETag), `"`),
+				Size:         aws.ToInt64(part.Size),
+				LastModified: aws.ToTime(part.LastModified),
+			})
+		}
+	}
+
+	return parts, nil
+}
+
+// CompleteMultipartUpload assembles the parts of a multipart upload in S3.
+// The parts are checked first so S3 and the served backends reject the same requests.
+func (s *S3Storage) CompleteMultipartUpload(ctx context.Context, storageKey, uploadID string, parts []CompletedPart) error {
+	uploaded, err := s.ListParts(ctx, storageKey, uploadID)
+	if err != nil {
+		return err
+	}
+	if _, err := checkCompletedParts(parts, partsByNumber(uploaded)); err != nil {
+		return err
+	}
+
+	completed := make([]types.CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = types.CompletedPart{
+			PartNumber: aws.Int32(int32(part.PartNumber)),
+			ETag:       aws.String(part.ETag),
+		}
+	}
+
+	_, err = s.client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(s.bucket),
+		Key:             aws.String(storageKey),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	if err != nil {
+		return multipartError("complete multipart upload", err)
+	}
+
+	return nil
+}
+
+// AbortMultipartUpload aborts a multipart upload in S3, freeing its parts
+func (s *S3Storage) AbortMultipartUpload(ctx context.Context, storageKey, uploadID string) error {
+	_, err := s.client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.bucket),
+		Key:      aws.String(storageKey),
+		UploadId: aws.String(uploadID),
+	})
+	if err != nil {
+		return multipartError("abort multipart upload", err)
+	}
+
+	return nil
+}
+
+// ListMultipartUploads lists the multipart uploads in progress in the bucket
+func (s *S3Storage) ListMultipartUploads(ctx context.Context) ([]MultipartUploadInfo, error) {
+	paginator := s3.NewListMultipartUploadsPaginator(s.client, &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.bucket),
+	})
+
+	var uploads []MultipartUploadInfo
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+		}
+		for _, upload := range page.Uploads {
+			uploads = append(uploads, MultipartUploadInfo{
+				StorageKey: aws.ToString(upload.Key),
+				UploadID:   aws.ToString(upload.UploadId),
+				Initiated:  aws.ToTime(upload.Initiated),
+			})
+		}
+	}
+
+	return uploads, nil
+}
+
+// multipartError maps S3's NoSuchUpload to ErrUploadNotFound
+func multipartError(action string, err error) error {
+	var noSuchUpload *types.NoSuchUpload
+	if errors.As(err, &noSuchUpload) {
+		return ErrUploadNotFound
+	}
+	return fmt.Errorf("failed to %s: %w", action, err)
+}
+
+// EnsureBucket creates the bucket if it doesn't exist and makes sure it isn't public.
+// Objects are only served through presigned URLs and the API's media endpoint.
+func (s *S3Storage) EnsureBucket(ctx context.Context) error {
+	// Check if bucket exists
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err == nil {
+		return s.removePublicReadPolicy(ctx)
+	}
+
+	// Create bucket
+	_, err = s.client.CreateBucket(ctx, &s3.CreateBucketInput{
+		Bucket: aws.String(s.bucket),
+		CreateBucketConfiguration: &types.CreateBucketConfiguration{
+			LocationConstraint: types.BucketLocationConstraintEuWest1,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	return nil
+}
+
+// removePublicReadPolicy deletes the anonymous read policy that buckets used to be created
+// with. Any other bucket policy is left alone.
+func (s *S3Storage) removePublicReadPolicy(ctx context.Context) error {
+	out, err := s.client.GetBucketPolicy(ctx, &s3.GetBucketPolicyInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		var apiErr interface{ ErrorCode() string }
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchBucketPolicy" {
+			return nil
+		}
+		return fmt.Errorf("failed to get bucket policy: %w", err)
+	}
+
+	if !isPublicReadPolicy(aws.ToString(out.Policy), s.bucket) {
+		return nil
+	}
+
+	_, err = s.client.DeleteBucketPolicy(ctx, &s3.DeleteBucketPolicyInput{
+		Bucket: aws.String(s.bucket),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete public bucket policy: %w", err)
+	}
+	return nil
+}
+
+// isPublicReadPolicy reports whether policy is exactly the anonymous s3:GetObject grant on
+// every object of bucket. Stores normalize policies differently (MinIO returns
+// {"AWS": ["*"]} for "*"), so single values and lists are treated alike.
+func isPublicReadPolicy(policy, bucket string) bool {
+	var doc struct {
+		Statement []struct {
+			Effect    string
+			Principal json.RawMessage
+			Action    json.RawMessage
+			Resource  json.RawMessage
+		}
+	}
+	if err := json.Unmarshal([]byte(policy), &doc); err != nil || len(doc.Statement) != 1 {
+		return false
+	}
+	stmt := doc.Statement[0]
+
+	var principal struct {
+		AWS json.RawMessage
+	}
+	anyone := slices.Equal(policyValues(stmt.Principal), []string{"*"}) ||
+		(json.Unmarshal(stmt.Principal, &principal) == nil && slices.Equal(policyValues(principal.AWS), []string{"*"}))
+
+	return stmt.Effect == "Allow" && anyone &&
+		slices.Equal(policyValues(stmt.Action), []string{"s3:GetObject"}) &&
+		slices.Equal(policyValues(stmt.Resource), []string{"arn:aws:s3:::" + bucket + "/*"})
+}
+
+// policyValues decodes a policy element that may be a string or a list of strings
+func policyValues(raw json.RawMessage) []string {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return []string{single}
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return list
+	}
+	return nil
+}
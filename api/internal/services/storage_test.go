@@ -0,0 +1,166 @@
+package services
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseSignedURL splits a URL issued by urlSigner into its storage key and query
+func parseSignedURL(t *testing.T, rawURL string) (string, url.Values) {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parse %q: %v", rawURL, err)
+	}
+	key, ok := strings.CutPrefix(u.Path, ObjectRoutePrefix)
+	if !ok {
+		t.Fatalf("signed URL %q is not under %s", rawURL, ObjectRoutePrefix)
+	}
+	return key, u.Query()
+}
+
+func TestSignedURLVerify(t *testing.T) {
+	signer := urlSigner{baseURL: "http://api.test/", secret: []byte("secret")}
+	const storageKey = "users/u1/albums/a1/originals/p1 (copy).jpg"
+	expiresAt := time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		tamper  func(key string, query url.Values) (string, url.Values)
+		wantErr bool
+	}{
+		{
+			name:   "upload",
+			method: "PUT",
+			url:    signer.signedURL("PUT", storageKey, "image/jpeg", 1234, expiresAt),
+		},
+		{
+			name:   "download",
+			method: "GET",
+			url:    signer.signedURL("GET", storageKey, "", 0, expiresAt),
+		},
+		{
+			name:   "part",
+			method: "PUT",
+			url:    signer.signedPartURL(storageKey, "upload-1", 3, expiresAt),
+		},
+		{
+			name:    "download URL used to upload",
+			method:  "PUT",
+			url:     signer.signedURL("GET", storageKey, "", 0, expiresAt),
+			wantErr: true,
+		},
+		{
+			name:    "expired",
+			method:  "GET",
+			url:     signer.signedURL("GET", storageKey, "", 0, time.Now().Add(-time.Second)),
+			wantErr: true,
+		},
+		{
+			name:    "signed with another secret",
+			method:  "GET",
+			url:     urlSigner{baseURL: "http://api.test", secret: []byte("other")}.signedURL("GET", storageKey, "", 0, expiresAt),
+			wantErr: true,
+		},
+		{
+			name:   "other key",
+			method: "PUT",
+			url:    signer.signedURL("PUT", storageKey, "image/jpeg", 1234, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				return "users/u2/albums/a2/originals/p2.jpg", query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "content type changed",
+			method: "PUT",
+			url:    signer.signedURL("PUT", storageKey, "image/jpeg", 1234, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Set("content_type", "text/html")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "size changed",
+			method: "PUT",
+			url:    signer.signedURL("PUT", storageKey, "image/jpeg", 1234, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Set("size", "104857600")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "size removed",
+			method: "PUT",
+			url:    signer.signedURL("PUT", storageKey, "image/jpeg", 1234, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Del("size")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "expiry extended",
+			method: "GET",
+			url:    signer.signedURL("GET", storageKey, "", 0, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Set("expires", "99999999999")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "part number changed",
+			method: "PUT",
+			url:    signer.signedPartURL(storageKey, "upload-1", 3, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Set("part_number", "4")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "part URL used for the whole object",
+			method: "PUT",
+			url:    signer.signedPartURL(storageKey, "upload-1", 3, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Del("upload_id")
+				query.Del("part_number")
+				return key, query
+			},
+			wantErr: true,
+		},
+		{
+			name:   "missing signature",
+			method: "GET",
+			url:    signer.signedURL("GET", storageKey, "", 0, expiresAt),
+			tamper: func(key string, query url.Values) (string, url.Values) {
+				query.Del("signature")
+				return key, query
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, query := parseSignedURL(t, tt.url)
+			if key != storageKey {
+				t.Fatalf("signed URL path has key %q, want %q", key, storageKey)
+			}
+			if tt.tamper != nil {
+				key, query = tt.tamper(key, query)
+			}
+
+			err := signer.verify(tt.method, key, query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,6 +19,7 @@ type Theme struct {
 	Description    *string         `json:"description,omitempty"`
 	CSSTokens      json.RawMessage `json:"css_tokens,omitempty"`
 	PromptTemplate *string         `json:"prompt_template,omitempty"`
+	PromptParams   []PromptParam   `json:"prompt_params,omitempty"`
 	IsPublic       bool            `json:"is_public"`
 	UserID         *string         `json:"user_id,omitempty"`
 	Status         string          `json:"status"`
@@ -32,6 +34,7 @@ type CreateThemeInput struct {
 	Description    *string         `json:"description,omitempty"`
 	CSSTokens      json.RawMessage `json:"css_tokens,omitempty"`
 	PromptTemplate *string         `json:"prompt_template,omitempty"`
+	PromptParams   []PromptParam   `json:"prompt_params,omitempty"`
 	IsPublic       bool            `json:"is_public"`
 }
 
@@ -42,6 +45,7 @@ type UpdateThemeInput struct {
 	Description    *string         `json:"description,omitempty"`
 	CSSTokens      json.RawMessage `json:"css_tokens,omitempty"`
 	PromptTemplate *string         `json:"prompt_template,omitempty"`
+	PromptParams   []PromptParam   `json:"prompt_params,omitempty"` // nil leaves params unchanged
 	IsPublic       *bool           `json:"is_public,omitempty"`
 }
 
@@ -57,6 +61,10 @@ func NewThemeService(db *sql.DB) *ThemeService {
 
 // Create creates a new theme with staging pattern
 func (s *ThemeService) Create(ctx context.Context, input CreateThemeInput) (*Theme, error) {
+	if err := validatePromptTemplate(input.PromptTemplate, input.PromptParams); err != nil {
+		return nil, err
+	}
+
 	groupID := uuid.New().String()
 	themeID := uuid.New().String()
 	now := time.Now()
@@ -68,17 +76,23 @@ func (s *ThemeService) Create(ctx context.Context, input CreateThemeInput) (*The
 		Description:    input.Description,
 		CSSTokens:      input.CSSTokens,
 		PromptTemplate: input.PromptTemplate,
+		PromptParams:   input.PromptParams,
 		IsPublic:       input.IsPublic,
 		UserID:         &input.UserID,
 		Status:         "staged",
 		CreatedAt:      now,
 	}
 
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO themes (id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+	promptParams, err := encodePromptParams(theme.PromptParams)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`INSERT INTO themes (id, group_id, name, description, css_tokens, prompt_template, prompt_params, is_public, user_id, status, created_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
 		theme.ID, theme.GroupID, theme.Name, theme.Description, theme.CSSTokens,
-		theme.PromptTemplate, theme.IsPublic, theme.UserID, theme.Status, theme.CreatedAt,
+		theme.PromptTemplate, promptParams, theme.IsPublic, theme.UserID, theme.Status, theme.CreatedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -91,16 +105,16 @@ func (s *ThemeService) Create(ctx context.Context, input CreateThemeInput) (*The
 func (s *ThemeService) GetByID(ctx context.Context, id string) (*Theme, error) {
 	theme := &Theme{}
 	var description, promptTemplate, userID sql.NullString
-	var cssTokens []byte
+	var cssTokens, promptParams []byte
 	var confirmedAt sql.NullTime
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at
+		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at, prompt_params
 		 FROM themes WHERE id = $1 AND status != 'deleted'`,
 		id,
 	).Scan(
 		&theme.ID, &theme.GroupID, &theme.Name, &description, &cssTokens,
-		&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt,
+		&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt, &promptParams,
 	)
 
 	if err != nil {
@@ -125,6 +139,9 @@ func (s *ThemeService) GetByID(ctx context.Context, id string) (*Theme, error) {
 	if len(cssTokens) > 0 {
 		theme.CSSTokens = cssTokens
 	}
+	if theme.PromptParams, err = decodePromptParams(promptParams); err != nil {
+		return nil, err
+	}
 
 	return theme, nil
 }
@@ -133,16 +150,16 @@ func (s *ThemeService) GetByID(ctx context.Context, id string) (*Theme, error) {
 func (s *ThemeService) GetByGroupID(ctx context.Context, groupID string) (*Theme, error) {
 	theme := &Theme{}
 	var description, promptTemplate, userID sql.NullString
-	var cssTokens []byte
+	var cssTokens, promptParams []byte
 	var confirmedAt sql.NullTime
 
 	err := s.db.QueryRowContext(ctx,
-		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at
+		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at, prompt_params
 		 FROM themes WHERE group_id = $1 AND status = 'confirmed' ORDER BY confirmed_at DESC NULLS LAST LIMIT 1`,
 		groupID,
 	).Scan(
 		&theme.ID, &theme.GroupID, &theme.Name, &description, &cssTokens,
-		&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt,
+		&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt, &promptParams,
 	)
 
 	if err != nil {
@@ -167,6 +184,9 @@ func (s *ThemeService) GetByGroupID(ctx context.Context, groupID string) (*Theme
 	if len(cssTokens) > 0 {
 		theme.CSSTokens = cssTokens
 	}
+	if theme.PromptParams, err = decodePromptParams(promptParams); err != nil {
+		return nil, err
+	}
 
 	return theme, nil
 }
@@ -174,7 +194,7 @@ func (s *ThemeService) GetByGroupID(ctx context.Context, groupID string) (*Theme
 // ListByUser lists all themes for a user (owned or public)
 func (s *ThemeService) ListByUser(ctx context.Context, userID string) ([]Theme, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at
+		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at, prompt_params
 		 FROM themes 
 		 WHERE status != 'deleted' AND (user_id = $1 OR is_public = true)
 		 ORDER BY created_at DESC`,
@@ -189,12 +209,12 @@ func (s *ThemeService) ListByUser(ctx context.Context, userID string) ([]Theme,
 	for rows.Next() {
 		var theme Theme
 		var description, promptTemplate, uid sql.NullString
-		var cssTokens []byte
+		var cssTokens, promptParams []byte
 		var confirmedAt sql.NullTime
 
 		err := rows.Scan(
 			&theme.ID, &theme.GroupID, &theme.Name, &description, &cssTokens,
-			&promptTemplate, &theme.IsPublic, &uid, &theme.Status, &theme.CreatedAt, &confirmedAt,
+			&promptTemplate, &theme.IsPublic, &uid, &theme.Status, &theme.CreatedAt, &confirmedAt, &promptParams,
 		)
 		if err != nil {
 			return nil, err
@@ -215,6 +235,9 @@ func (s *ThemeService) ListByUser(ctx context.Context, userID string) ([]Theme,
 		if len(cssTokens) > 0 {
 			theme.CSSTokens = cssTokens
 		}
+		if theme.PromptParams, err = decodePromptParams(promptParams); err != nil {
+			return nil, err
+		}
 
 		themes = append(themes, theme)
 	}
@@ -225,7 +248,7 @@ func (s *ThemeService) ListByUser(ctx context.Context, userID string) ([]Theme,
 // ListPublic lists all public themes
 func (s *ThemeService) ListPublic(ctx context.Context) ([]Theme, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at
+		`SELECT id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at, prompt_params
 		 FROM themes 
 		 WHERE is_public = true AND status = 'confirmed'
 		 ORDER BY created_at DESC`,
@@ -239,12 +262,12 @@ func (s *ThemeService) ListPublic(ctx context.Context) ([]Theme, error) {
 	for rows.Next() {
 		var theme Theme
 		var description, promptTemplate, userID sql.NullString
-		var cssTokens []byte
+		var cssTokens, promptParams []byte
 		var confirmedAt sql.NullTime
 
 		err := rows.Scan(
 			&theme.ID, &theme.GroupID, &theme.Name, &description, &cssTokens,
-			&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt,
+			&promptTemplate, &theme.IsPublic, &userID, &theme.Status, &theme.CreatedAt, &confirmedAt, &promptParams,
 		)
 		if err != nil {
 			return nil, err
@@ -265,6 +288,9 @@ func (s *ThemeService) ListPublic(ctx context.Context) ([]Theme, error) {
 		if len(cssTokens) > 0 {
 			theme.CSSTokens = cssTokens
 		}
+		if theme.PromptParams, err = decodePromptParams(promptParams); err != nil {
+			return nil, err
+		}
 
 		themes = append(themes, theme)
 	}
@@ -306,14 +332,25 @@ func (s *ThemeService) updateInPlace(ctx context.Context, current *Theme, input
 	if input.PromptTemplate != nil {
 		promptTemplate = input.PromptTemplate
 	}
+	promptParams := current.PromptParams
+	if input.PromptParams != nil {
+		promptParams = input.PromptParams
+	}
+	if err := validatePromptTemplate(promptTemplate, promptParams); err != nil {
+		return nil, err
+	}
+	encodedParams, err := encodePromptParams(promptParams)
+	if err != nil {
+		return nil, err
+	}
 	isPublic := current.IsPublic
 	if input.IsPublic != nil {
 		isPublic = *input.IsPublic
 	}
 
-	_, err := s.db.ExecContext(ctx,
-		`UPDATE themes SET name = $1, description = $2, css_tokens = $3, prompt_template = $4, is_public = $5 WHERE id = $6`,
-		name, description, cssTokens, promptTemplate, isPublic, current.ID,
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE themes SET name = $1, description = $2, css_tokens = $3, prompt_template = $4, prompt_params = $5, is_public = $6 WHERE id = $7`,
+		name, description, cssTokens, promptTemplate, encodedParams, isPublic, current.ID,
 	)
 	if err != nil {
 		return nil, err
@@ -342,13 +379,24 @@ func (s *ThemeService) createNewVersion(ctx context.Context, current *Theme, inp
 	if input.PromptTemplate != nil {
 		promptTemplate = input.PromptTemplate
 	}
+	promptParams := current.PromptParams
+	if input.PromptParams != nil {
+		promptParams = input.PromptParams
+	}
+	if err := validatePromptTemplate(promptTemplate, promptParams); err != nil {
+		return nil, err
+	}
+	encodedParams, err := encodePromptParams(promptParams)
+	if err != nil {
+		return nil, err
+	}
 	isPublic := current.IsPublic
 	if input.IsPublic != nil {
 		isPublic = *input.IsPublic
 	}
 
 	// Mark old as deleted
-	_, err := s.db.ExecContext(ctx,
+	_, err = s.db.ExecContext(ctx,
 		`UPDATE themes SET status = 'deleted' WHERE id = $1`,
 		current.ID,
 	)
@@ -364,6 +412,7 @@ func (s *ThemeService) createNewVersion(ctx context.Context, current *Theme, inp
 		Description:    description,
 		CSSTokens:      cssTokens,
 		PromptTemplate: promptTemplate,
+		PromptParams:   promptParams,
 		IsPublic:       isPublic,
 		UserID:         current.UserID,
 		Status:         "confirmed",
@@ -372,10 +421,10 @@ func (s *ThemeService) createNewVersion(ctx context.Context, current *Theme, inp
 	}
 
 	_, err = s.db.ExecContext(ctx,
-		`INSERT INTO themes (id, group_id, name, description, css_tokens, prompt_template, is_public, user_id, status, created_at, confirmed_at)
-		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		`INSERT INTO themes (id, group_id, name, description, css_tokens, prompt_template, prompt_params, is_public, user_id, status, created_at, confirmed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
 		theme.ID, theme.GroupID, theme.Name, theme.Description, theme.CSSTokens,
-		theme.PromptTemplate, theme.IsPublic, theme.UserID, theme.Status, theme.CreatedAt, theme.ConfirmedAt,
+		theme.PromptTemplate, encodedParams, theme.IsPublic, theme.UserID, theme.Status, theme.CreatedAt, theme.ConfirmedAt,
 	)
 	if err != nil {
 		return nil, err
@@ -428,3 +477,48 @@ func (s *ThemeService) CanUserModify(ctx context.Context, themeID, userID string
 
 	return ownerID.String == userID, nil
 }
+
+// RenderPrompt renders the theme's prompt template for a photo. Themes without a
+// template use a generic "redraw in this style" prompt.
+func (s *ThemeService) RenderPrompt(theme *Theme, vars PromptVariables) (string, error) {
+	src := defaultPromptTemplate
+	if theme.PromptTemplate != nil && strings.TrimSpace(*theme.PromptTemplate) != "" {
+		src = *theme.PromptTemplate
+	}
+
+	tmpl, err := ParsePromptTemplate(src, theme.PromptParams)
+	if err != nil {
+		return "", err
+	}
+
+	vars.Theme = theme
+	return tmpl.Render(vars)
+}
+
+// validatePromptTemplate checks that a theme's template parses against its params
+func validatePromptTemplate(promptTemplate *string, params []PromptParam) error {
+	src := ""
+	if promptTemplate != nil {
+		src = *promptTemplate
+	}
+	_, err := ParsePromptTemplate(src, params)
+	return err
+}
+
+func encodePromptParams(params []PromptParam) ([]byte, error) {
+	if params == nil {
+		params = []PromptParam{}
+	}
+	return json.Marshal(params)
+}
+
+func decodePromptParams(data []byte) ([]PromptParam, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	var params []PromptParam
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// newSecretToken returns a random token for a client to present later, along with the
+// hash that is stored in its place
+func newSecretToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, hashSecretToken(token), nil
+}
+
+// hashSecretToken is how secret tokens are looked up. They carry enough entropy that an
+// unsalted SHA-256 can't be reversed, and it keeps the lookup a single indexed query.
+func hashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC's 8-digit codes, cut to their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfcSecret, tt.unix/30)
+		if err != nil {
+			t.Fatalf("totpCode(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode(%d) = %q, want %q", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestTOTPCodeInvalidSecret(t *testing.T) {
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted a secret that isn't base32")
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / 30
+	code := func(step int64) string {
+		c, err := totpCode(rfcSecret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(step), step, true},
+		{"previous step", code(step - 1), step - 1, true},
+		{"next step", code(step + 1), step + 1, true},
+		{"two steps ago", code(step - 2), 0, false},
+		{"two steps ahead", code(step + 2), 0, false},
+		{"too short", code(step)[:5], 0, false},
+		{"too long", code(step) + "0", 0, false},
+		{"empty", "", 0, false},
+		{"wrong code", "000000", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, gotOK := matchTOTP(rfcSecret, tt.code, now)
+			if gotOK != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("matchTOTP(%q) = %d, %v, want %d, %v", tt.code, gotStep, gotOK, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestNormalizeCode(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"123456", "123456"},
+		{"123 456", "123456"},
+		{" 123-456 ", "123456"},
+		{"abcd-efgh-ijkl-mnop", "abcdefghijklmnop"},
+		{"ABCD-EFGH-IJKL-MNOP", "abcdefghijklmnop"},
+		{"abcd efgh ijkl mnop", "abcdefghijklmnop"},
+	}
+	for _, tt := range tests {
+		if got := normalizeCode(tt.code); got != tt.want {
+			t.Errorf("normalizeCode(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+// Recovery codes are shown in groups of four but stored as the hash of the raw code, so
+// however the user types one it has to normalize back to what was hashed
+func TestRecoveryCodeHashMatchesTypedCode(t *testing.T) {
+	raw := recoveryCodeEncoding.EncodeToString([]byte("0123456789"))
+	shown := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+	stored := hashSecretToken(raw)
+
+	tests := []struct {
+		typed string
+		match bool
+	}{
+		{shown, true},
+		{raw, true},
+		{" " + shown + " ", true},
+		{"  " + raw[0:4] + " " + raw[4:8] + " " + raw[8:12] + " " + raw[12:16], true},
+		{strings.ToUpper(shown), true},
+		{shown[:len(shown)-1], false},
+		{shown + "a", false},
+	}
+	for _, tt := range tests {
+		if got := hashSecretToken(normalizeCode(tt.typed)) == stored; got != tt.match {
+			t.Errorf("typed %q: match = %v, want %v", tt.typed, got, tt.match)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"redrawn/internal/imaging"
+)
+
+// ErrInvalidTransformSignature is returned for transform URLs that weren't issued by the API
+// or whose parameters were changed
+var ErrInvalidTransformSignature = errors.New("invalid transform signature")
+
+// Transform parameter defaults
+const (
+	DefaultTransformFit     = imaging.FitContain
+	DefaultTransformFormat  = imaging.FormatWebP
+	DefaultTransformQuality = imaging.DefaultQuality
+)
+
+// TransformParams describe a resized, re-encoded copy of an image. A width or height of 0
+// is derived from the aspect ratio.
+type TransformParams struct {
+	Width   int    `json:"w" validate:"min=0"`
+	Height  int    `json:"h" validate:"min=0"`
+	Fit     string `json:"fit,omitempty"`
+	Format  string `json:"fmt,omitempty"`
+	Quality int    `json:"q,omitempty"`
+}
+
+// String is the canonical form of the parameters, used in storage keys and signatures
+func (p TransformParams) String() string {
+	return fmt.Sprintf("%dx%d_%s_q%d.%s", p.Width, p.Height, p.Fit, p.Quality, p.Format)
+}
+
+// Query encodes the parameters as the query string of a transform URL
+func (p TransformParams) Query() url.Values {
+	query := url.Values{}
+	query.Set("w", strconv.Itoa(p.Width))
+	query.Set("h", strconv.Itoa(p.Height))
+	query.Set("fit", p.Fit)
+	query.Set("fmt", p.Format)
+	query.Set("q", strconv.Itoa(p.Quality))
+	return query
+}
+
+// TransformService resizes and re-encodes images on request. Transform URLs are signed so
+// clients can only request the sizes the API hands out, and outputs are cached in storage.
+type TransformService struct {
+	storage      Storage
+	baseURL      string
+	secret       []byte
+	maxDimension int
+	maxPixels    int
+}
+
+// NewTransformService creates a new TransformService. Widths and heights are limited to
+// maxDimension, and sources with more than maxPixels pixels are refused.
+func NewTransformService(storage Storage, baseURL string, secret []byte, maxDimension, maxPixels int) *TransformService {
+	return &TransformService{
+		storage:      storage,
+		baseURL:      baseURL,
+		secret:       secret,
+		maxDimension: maxDimension,
+		maxPixels:    maxPixels,
+	}
+}
+
+// Normalize fills in defaults and validates the parameters against the allowed values
+func (s *TransformService) Normalize(p TransformParams) (TransformParams, error) {
+	if p.Fit == "" {
+		p.Fit = DefaultTransformFit
+	}
+	if p.Format == "" {
+		p.Format = DefaultTransformFormat
+	}
+	if p.Quality == 0 {
+		p.Quality = DefaultTransformQuality
+	}
+
+	if p.Width < 0 || p.Height < 0 || (p.Width == 0 && p.Height == 0) {
+		return p, errors.New("a positive width or height is required")
+	}
+	if p.Width > s.maxDimension || p.Height > s.maxDimension {
+		return p, fmt.Errorf("width and height must be at most %d", s.maxDimension)
+	}
+	switch p.Fit {
+	case imaging.FitContain, imaging.FitCover, imaging.FitFill:
+	default:
+		return p, fmt.Errorf("unknown fit %q", p.Fit)
+	}
+	switch p.Format {
+	case imaging.FormatWebP, imaging.FormatJPEG, imaging.FormatPNG:
+	default:
+		return p, fmt.Errorf("unknown format %q", p.Format)
+	}
+	if p.Quality < 1 || p.Quality > 100 {
+		return p, errors.New("quality must be between 1 and 100")
+	}
+	// Quality only affects JPEG; pinning it for the others keeps one cached copy per size
+	if p.Format != imaging.FormatJPEG {
+		p.Quality = DefaultTransformQuality
+	}
+	return p, nil
+}
+
+// ParseQuery reads and normalizes the parameters of a transform URL
+func (s *TransformService) ParseQuery(query url.Values) (TransformParams, error) {
+	var p TransformParams
+	for name, dst := range map[string]*int{"w": &p.Width, "h": &p.Height, "q": &p.Quality} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid %s parameter", name)
+		}
+		*dst = n
+	}
+	p.Fit = query.Get("fit")
+	p.Format = query.Get("fmt")
+	return s.Normalize(p)
+}
+
+// SignedURL returns the transform URL of a photo or generated photo. p must be normalized.
+func (s *TransformService) SignedURL(mediaID string, p TransformParams) string {
+	query := p.Query()
+	query.Set("sig", s.signature(mediaID, p))
+	return strings.TrimSuffix(s.baseURL, "/") + "/media/" + url.PathEscape(mediaID) + "/transform?" + query.Encode()
+}
+
+// Verify checks the signature of a transform URL against its normalized parameters
+func (s *TransformService) Verify(mediaID string, p TransformParams, signature string) error {
+	if !hmac.Equal([]byte(s.signature(mediaID, p)), []byte(signature)) {
+		return ErrInvalidTransformSignature
+	}
+	return nil
+}
+
+func (s *TransformService) signature(mediaID string, p TransformParams) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%s", mediaID, p)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Transform resizes and re-encodes the object at sourceKey, stores the output under its
+// TransformKey and returns it with its content type
+func (s *TransformService) Transform(ctx context.Context, sourceKey string, p TransformParams) ([]byte, string, error) {
+	data, err := s.storage.GetObject(ctx, sourceKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := imaging.Probe(data, s.maxPixels); err != nil {
+		return nil, "", err
+	}
+
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return nil, "", err
+	}
+	img, err = imaging.Resize(img, p.Width, p.Height, p.Fit)
+	if err != nil {
+		return nil, "", err
+	}
+	encoded, contentType, err := imaging.EncodeQuality(img, p.Format, p.Quality)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := s.storage.PutObject(ctx, TransformKey(sourceKey, p), encoded, contentType); err != nil {
+		return nil, "", err
+	}
+	return encoded, contentType, nil
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"redrawn/internal/imaging"
+)
+
+func newTestTransformService(secret string) *TransformService {
+	return NewTransformService(NewMemoryStorage("http://api.test", []byte(secret)), "http://api.test", []byte(secret), 2048, 40_000_000)
+}
+
+func TestTransformNormalize(t *testing.T) {
+	s := newTestTransformService("secret")
+
+	tests := []struct {
+		name    string
+		params  TransformParams
+		want    TransformParams
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			params: TransformParams{Width: 256},
+			want:   TransformParams{Width: 256, Fit: DefaultTransformFit, Format: DefaultTransformFormat, Quality: DefaultTransformQuality},
+		},
+		{
+			name:   "jpeg keeps its quality",
+			params: TransformParams{Height: 100, Format: imaging.FormatJPEG, Quality: 60},
+			want:   TransformParams{Height: 100, Fit: DefaultTransformFit, Format: imaging.FormatJPEG, Quality: 60},
+		},
+		{
+			name:   "other formats pin the quality",
+			params: TransformParams{Width: 100, Format: imaging.FormatPNG, Quality: 60},
+			want:   TransformParams{Width: 100, Fit: DefaultTransformFit, Format: imaging.FormatPNG, Quality: DefaultTransformQuality},
+		},
+		{name: "no size", params: TransformParams{}, wantErr: true},
+		{name: "negative width", params: TransformParams{Width: -1, Height: 10}, wantErr: true},
+		{name: "too wide", params: TransformParams{Width: 2049}, wantErr: true},
+		{name: "unknown fit", params: TransformParams{Width: 10, Fit: "stretch"}, wantErr: true},
+		{name: "unknown format", params: TransformParams{Width: 10, Format: "gif"}, wantErr: true},
+		{name: "quality too high", params: TransformParams{Width: 10, Format: imaging.FormatJPEG, Quality: 101}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Normalize(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Normalize() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformSignedURLVerify(t *testing.T) {
+	s := newTestTransformService("secret")
+	const mediaID = "photo-1"
+	params, err := s.Normalize(TransformParams{Width: 256, Height: 256, Fit: imaging.FitCover})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		service *TransformService
+		tamper  func(mediaID string, query url.Values) (string, url.Values)
+		wantErr error
+	}{
+		{name: "as issued"},
+		{
+			name: "width changed",
+			tamper: func(mediaID string, query url.Values) (string, url.Values) {
+				query.Set("w", "2048")
+				return mediaID, query
+			},
+			wantErr: ErrInvalidTransformSignature,
+		},
+		{
+			name: "format changed",
+			tamper: func(mediaID string, query url.Values) (string, url.Values) {
+				query.Set("fmt", imaging.FormatPNG)
+				return mediaID, query
+			},
+			wantErr: ErrInvalidTransformSignature,
+		},
+		{
+			name: "other media",
+			tamper: func(mediaID string, query url.Values) (string, url.Values) {
+				return "photo-2", query
+			},
+			wantErr: ErrInvalidTransformSignature,
+		},
+		{
+			name: "signature removed",
+			tamper: func(mediaID string, query url.Values) (string, url.Values) {
+				query.Del("sig")
+				return mediaID, query
+			},
+			wantErr: ErrInvalidTransformSignature,
+		},
+		{
+			// Quality doesn't affect WebP, so it is normalized away and the URL still matches
+			name: "quality of a webp changed",
+			tamper: func(mediaID string, query url.Values) (string, url.Values) {
+				query.Set("q", "10")
+				return mediaID, query
+			},
+		},
+		{
+			name:    "verified with another secret",
+			service: newTestTransformService("other"),
+			wantErr: ErrInvalidTransformSignature,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(s.SignedURL(mediaID, params))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasSuffix(u.Path, "/transform") {
+				t.Fatalf("signed URL path %q is not a transform URL", u.Path)
+			}
+			id, query := path.Base(path.Dir(u.Path)), u.Query()
+			if id != mediaID {
+				t.Fatalf("signed URL has media ID %q, want %q", id, mediaID)
+			}
+			if tt.tamper != nil {
+				id, query = tt.tamper(id, query)
+			}
+			verifier := s
+			if tt.service != nil {
+				verifier = tt.service
+			}
+
+			p, err := verifier.ParseQuery(query)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if err := verifier.Verify(id, p, query.Get("sig")); err != tt.wantErr {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
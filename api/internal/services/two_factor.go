@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time
+const recoveryCodeCount = 10
+
+// recoveryCodeEncoding spells recovery codes in lowercase base32, which has no characters
+// that are easy to confuse when copied by hand
+var recoveryCodeEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+var (
+	// ErrTwoFactorNotEnabled is returned for actions that need 2FA turned on
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	// ErrTwoFactorAlreadyEnabled is returned when enrolling while 2FA is already on
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnrolled is returned when enabling 2FA before enrolling
+	ErrTwoFactorNotEnrolled = errors.New("start enrollment before enabling two-factor authentication")
+	// ErrInvalidTwoFactorCode is returned for wrong, expired or already used codes
+	ErrInvalidTwoFactorCode = errors.New("invalid authentication code")
+)
+
+// TwoFactorStatus describes a user's two-factor authentication
+type TwoFactorStatus struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorEnrollment is a new TOTP secret to add to an authenticator app
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`      // for typing in by hand
+	URI    string `json:"otpauth_uri"` // for showing as a QR code
+}
+
+// TwoFactorService handles TOTP two-factor authentication and recovery codes
+type TwoFactorService struct {
+	db     *sql.DB
+	issuer string // shown next to the account in authenticator apps
+}
+
+// NewTwoFactorService creates a new TwoFactorService
+func NewTwoFactorService(db *sql.DB, issuer string) *TwoFactorService {
+	return &TwoFactorService{db: db, issuer: issuer}
+}
+
+// Status returns whether a user has 2FA on and how many recovery codes they have left
+func (s *TwoFactorService) Status(ctx context.Context, userID string) (*TwoFactorStatus, error) {
+	status := &TwoFactorStatus{}
+	var enabledAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT u.totp_enabled_at,
+		        (SELECT COUNT(*) FROM recovery_codes r WHERE r.user_id = u.id AND r.used_at IS NULL)
+		 FROM users u
+		 WHERE u.id = $1`,
+		userID,
+	).Scan(&enabledAt, &status.RecoveryCodesRemaining)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		return nil, err
+	}
+	if enabledAt.Valid {
+		status.Enabled = true
+		status.EnabledAt = &enabledAt.Time
+	}
+	return status, nil
+}
+
+// IsEnabled reports whether a user has 2FA on
+func (s *TwoFactorService) IsEnabled(ctx context.Context, userID string) (bool, error) {
+	status, err := s.Status(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return status.Enabled, nil
+}
+
+// BeginEnrollment generates a TOTP secret for a user. It's only used once Enable confirms
+// the user's app produces the right codes; enrolling again replaces it.
+func (s *TwoFactorService) BeginEnrollment(ctx context.Context, user *User) (*TwoFactorEnrollment, error) {
+	secret, err := newTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE users SET totp_secret = $2, totp_last_step = NULL, updated_at = NOW()
+		 WHERE id = $1 AND totp_enabled_at IS NULL`,
+		user.ID, secret,
+	)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	return &TwoFactorEnrollment{Secret: secret, URI: totpURI(s.issuer, user.Email, secret)}, nil
+}
+
+// Enable turns 2FA on with a code from the secret of BeginEnrollment, and returns the
+// user's recovery codes, which aren't shown again
+func (s *TwoFactorService) Enable(ctx context.Context, userID, code string) ([]string, error) {
+	var codes []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		var secret sql.NullString
+		var enabled bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`,
+			userID,
+		).Scan(&secret, &enabled)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if enabled {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if !secret.Valid {
+			return ErrTwoFactorNotEnrolled
+		}
+
+		step, ok := matchTOTP(secret.String, normalizeCode(code), time.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE users SET totp_enabled_at = NOW(), totp_last_step = $2, updated_at = NOW() WHERE id = $1`,
+			userID, step,
+		)
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodesTx(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disable turns 2FA off, given a current code or a recovery code
+func (s *TwoFactorService) Disable(ctx context.Context, userID, code string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.verifyTx(ctx, tx, userID, code); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL, updated_at = NOW()
+			 WHERE id = $1`,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+// RegenerateRecoveryCodes replaces a user's recovery codes, given a current code or a
+// recovery code, and returns the new ones
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, userID, code string) ([]string, error) {
+	var codes []string
+	err := withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.verifyTx(ctx, tx, userID, code); err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodesTx(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Verify checks the second factor of a sign-in: a code from the user's authenticator app,
+// which can't be used twice, or one of their recovery codes, which is used up
+func (s *TwoFactorService) Verify(ctx context.Context, userID, code string) error {
+	return withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return s.verifyTx(ctx, tx, userID, code)
+	})
+}
+
+func (s *TwoFactorService) verifyTx(ctx context.Context, tx *sql.Tx, userID, code string) error {
+	var secret sql.NullString
+	var lastStep sql.NullInt64
+	var enabled bool
+	err := tx.QueryRowContext(ctx,
+		`SELECT totp_secret, totp_last_step, totp_enabled_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE`,
+		userID,
+	).Scan(&secret, &lastStep, &enabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	if !enabled || !secret.Valid {
+		return ErrTwoFactorNotEnabled
+	}
+
+	code = normalizeCode(code)
+	if step, ok := matchTOTP(secret.String, code, time.Now()); ok {
+		if lastStep.Valid && step <= lastStep.Int64 {
+			return ErrInvalidTwoFactorCode
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE users SET totp_last_step = $2 WHERE id = $1`, userID, step)
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = NOW() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashSecretToken(code),
+	)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// replaceRecoveryCodesTx deletes a user's recovery codes inside tx and returns a new set.
+// Codes carry 80 random bits, so storing them as unsalted hashes is safe.
+func replaceRecoveryCodesTx(ctx context.Context, tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := recoveryCodeEncoding.EncodeToString(b)
+		codes[i] = raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO recovery_codes (id, user_id, code_hash) VALUES ($1, $2, $3)`,
+			uuid.New().String(), userID, hashSecretToken(raw),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// normalizeCode strips the spaces and dashes users type in codes, and lowercases recovery
+// codes
+func normalizeCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
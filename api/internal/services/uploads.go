@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// pendingUploadTTL is how long an issued upload key can be turned into a photo
+const pendingUploadTTL = 24 * time.Hour
+
+// PendingUpload is an upload key issued to a user for one album, waiting to become a photo
+type PendingUpload struct {
+	StorageKey string     `json:"storage_key"`
+	PhotoID    string     `json:"photo_id"` // ID the photo will get, which is part of the key
+	UserID     string     `json:"user_id"`
+	AlbumID    string     `json:"album_id"`
+	Filename   string     `json:"filename"`
+	MimeType   string     `json:"mime_type"`
+	SizeBytes  int64      `json:"size_bytes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	ConsumedAt *time.Time `json:"consumed_at,omitempty"`
+}
+
+// CreateUploadInput holds data for issuing an upload URL
+type CreateUploadInput struct {
+	UserID   string
+	AlbumID  string
+	Filename string
+	MimeType string
+	Size     int64
+}
+
+// UploadService issues upload URLs and verifies uploads before they become photos
+type UploadService struct {
+	db      *sql.DB
+	storage Storage
+	usage   *UsageService
+}
+
+// NewUploadService creates a new UploadService
+func NewUploadService(db *sql.DB, storage Storage, usage *UsageService) *UploadService {
+	return &UploadService{db: db, storage: storage, usage: usage}
+}
+
+// CreateUploadURL issues an upload URL and records its key as pending for the user and album.
+// The key is derived from a freshly allocated photo ID, never from the filename. The declared
+// size is reserved against the user's storage quota until the key is used or expires.
+func (s *UploadService) CreateUploadURL(ctx context.Context, input CreateUploadInput) (*UploadURLResponse, error) {
+	photoID := uuid.New().String()
+
+	result, err := s.storage.GenerateUploadURL(ctx, UploadURLRequest{
+		StorageKey: OriginalKey(input.UserID, input.AlbumID, photoID, input.MimeType),
+		MimeType:   input.MimeType,
+		Size:       input.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		return s.reserveUploadTx(ctx, tx, result.StorageKey, photoID, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// CheckWritable returns an error unless storageKey was issued for an upload that hasn't
+// become a photo yet. Once a photo was created from a key, its object was validated, so
+// signed URLs still within their lifetime must not be able to replace it.
+func (s *UploadService) CheckWritable(ctx context.Context, storageKey string) error {
+	var consumedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT consumed_at FROM pending_uploads WHERE storage_key = $1`,
+		storageKey,
+	).Scan(&consumedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("unknown upload key")
+		}
+		return err
+	}
+	if consumedAt.Valid {
+		return errors.New("upload key has already been used")
+	}
+	return nil
+}
+
+// reserveUploadTx checks the upload fits in the user's quota and records storageKey as
+// issued to the user for the album
+func (s *UploadService) reserveUploadTx(ctx context.Context, tx *sql.Tx, storageKey, photoID string, input CreateUploadInput) error {
+	if err := s.usage.checkQuotaTx(ctx, tx, input.UserID, input.Size, ""); err != nil {
+		return err
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO pending_uploads (storage_key, photo_id, user_id, album_id, filename, mime_type, size_bytes, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), $8)`,
+		storageKey, photoID, input.UserID, input.AlbumID, SanitizeFilename(input.Filename), input.MimeType, input.Size,
+		time.Now().Add(pendingUploadTTL),
+	)
+	return err
+}
+
+// consumeTx verifies that storageKey was issued to the user for the album, has not been
+// used yet, and that the uploaded object matches the declared size and content type.
+// The key is then marked as used inside tx, so it can back exactly one photo.
+func (s *UploadService) consumeTx(ctx context.Context, tx *sql.Tx, userID, albumID, storageKey string) (*PendingUpload, error) {
+	upload := &PendingUpload{}
+	var photoID sql.NullString
+	var consumedAt sql.NullTime
+
+	err := tx.QueryRowContext(ctx,
+		`SELECT storage_key, photo_id, user_id, album_id, filename, mime_type, size_bytes, created_at, expires_at, consumed_at
+		 FROM pending_uploads WHERE storage_key = $1
+		 FOR UPDATE`,
+		storageKey,
+	).Scan(
+		&upload.StorageKey, &photoID, &upload.UserID, &upload.AlbumID, &upload.Filename, &upload.MimeType,
+		&upload.SizeBytes, &upload.CreatedAt, &upload.ExpiresAt, &consumedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("unknown upload key")
+		}
+		return nil, err
+	}
+
+	// Keys issued to someone else are reported like unknown ones, so they can't be probed
+	if upload.UserID != userID {
+		return nil, errors.New("unknown upload key")
+	}
+	if upload.AlbumID != albumID {
+		return nil, errors.New("upload key was issued for a different album")
+	}
+	if consumedAt.Valid {
+		return nil, errors.New("upload key has already been used")
+	}
+	if time.Now().After(upload.ExpiresAt) {
+		return nil, errors.New("upload key has expired")
+	}
+
+	// Keys issued before photo IDs were allocated up front get a new ID
+	upload.PhotoID = photoID.String
+	if !photoID.Valid {
+		upload.PhotoID = uuid.New().String()
+	}
+
+	info, err := s.storage.HeadObject(ctx, storageKey)
+	if err != nil {
+		if errors.Is(err, ErrObjectNotFound) {
+			return nil, errors.New("file has not been uploaded")
+		}
+		return nil, err
+	}
+	if info.Size != upload.SizeBytes {
+		return nil, fmt.Errorf("uploaded file is %d bytes but %d were declared", info.Size, upload.SizeBytes)
+	}
+	if info.ContentType != upload.MimeType {
+		return nil, fmt.Errorf("uploaded file has content type %q but %q was declared", info.ContentType, upload.MimeType)
+	}
+
+	// The quota may have shrunk, or generated photos grown, since the key was issued
+	if err := s.usage.checkQuotaTx(ctx, tx, userID, info.Size, storageKey); err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE pending_uploads SET consumed_at = NOW() WHERE storage_key = $1`,
+		storageKey,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return upload, nil
+}
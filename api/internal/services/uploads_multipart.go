@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MultipartPartSize is the part size suggested to clients. Smaller parts mean less to
+// resend when a part fails; every part but the last must be at least MinPartSize.
+const MultipartPartSize = 8 << 20
+
+// MultipartUpload is a multipart upload of an original, backing one pending upload key
+type MultipartUpload struct {
+	UploadID   string    `json:"upload_id"`
+	StorageKey string    `json:"storage_key"`
+	PartSize   int64     `json:"part_size"`
+	PartCount  int       `json:"part_count"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// CreateMultipartUpload starts a multipart upload and records its key as pending for the
+// user and album, like CreateUploadURL. Once completed, the key is used to create the photo.
+func (s *UploadService) CreateMultipartUpload(ctx context.Context, input CreateUploadInput) (*MultipartUpload, error) {
+	partCount := int((input.Size + MultipartPartSize - 1) / MultipartPartSize)
+	if partCount > MaxParts {
+		return nil, errors.New("file is too large")
+	}
+
+	photoID := uuid.New().String()
+	storageKey := OriginalKey(input.UserID, input.AlbumID, photoID, input.MimeType)
+
+	uploadID, err := s.storage.CreateMultipartUpload(ctx, storageKey, input.MimeType)
+	if err != nil {
+		return nil, err
+	}
+
+	err = withTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := s.reserveUploadTx(ctx, tx, storageKey, photoID, input); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO multipart_uploads (upload_id, storage_key, user_id, status, created_at, updated_at)
+			 VALUES ($1, $2, $3, 'uploading', NOW(), NOW())`,
+			uploadID, storageKey, input.UserID,
+		)
+		return err
+	})
+	if err != nil {
+		// Don't leave parts nobody can complete; the sweeper catches this if the abort fails too
+		_ = s.storage.AbortMultipartUpload(ctx, storageKey, uploadID)
+		return nil, err
+	}
+
+	return &MultipartUpload{
+		UploadID:   uploadID,
+		StorageKey: storageKey,
+		PartSize:   MultipartPartSize,
+		PartCount:  partCount,
+		ExpiresAt:  time.Now().Add(pendingUploadTTL),
+	}, nil
+}
+
+// GeneratePartUploadURL returns a URL for uploading one part of the user's multipart upload
+func (s *UploadService) GeneratePartUploadURL(ctx context.Context, userID, uploadID string, partNumber int) (*UploadURLResponse, error) {
+	if partNumber < 1 || partNumber > MaxParts {
+		return nil, fmt.Errorf("part number must be between 1 and %d", MaxParts)
+	}
+
+	storageKey, err := s.activeMultipartUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.GeneratePartUploadURL(ctx, storageKey, uploadID, partNumber)
+}
+
+// ListParts returns the parts of the user's multipart upload uploaded so far,
+// so an interrupted upload can resume with the missing ones
+func (s *UploadService) ListParts(ctx context.Context, userID, uploadID string) ([]UploadedPart, error) {
+	storageKey, err := s.activeMultipartUpload(ctx, userID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.storage.ListParts(ctx, storageKey, uploadID)
+}
+
+// CompleteMultipartUpload assembles the user's multipart upload and returns the storage key
+// to create the photo with
+func (s *UploadService) CompleteMultipartUpload(ctx context.Context, userID, uploadID string, parts []CompletedPart) (string, error) {
+	storageKey, err := s.activeMultipartUpload(ctx, userID, uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := s.storage.CompleteMultipartUpload(ctx, storageKey, uploadID, parts); err != nil {
+		return "", err
+	}
+
+	if err := s.setMultipartStatus(ctx, uploadID, "completed"); err != nil {
+		return "", err
+	}
+	return storageKey, nil
+}
+
+// AbortMultipartUpload discards the user's multipart upload and its parts
+func (s *UploadService) AbortMultipartUpload(ctx context.Context, userID, uploadID string) error {
+	storageKey, err := s.activeMultipartUpload(ctx, userID, uploadID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.storage.AbortMultipartUpload(ctx, storageKey, uploadID); err != nil && !errors.Is(err, ErrUploadNotFound) {
+		return err
+	}
+
+	if err := s.setMultipartStatus(ctx, uploadID, "aborted"); err != nil {
+		return err
+	}
+
+	// Expire the key so its size no longer counts against the quota
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE pending_uploads SET expires_at = NOW() WHERE storage_key = $1 AND consumed_at IS NULL`,
+		storageKey,
+	)
+	return err
+}
+
+// AbortStaleMultipartUploads aborts multipart uploads started more than olderThan ago,
+// freeing the storage their parts use. Uploads the database doesn't know about, left by a
+// failed CreateMultipartUpload, are aborted too. It returns the number of uploads aborted.
+func (s *UploadService) AbortStaleMultipartUploads(ctx context.Context, olderThan time.Duration) (int, error) {
+	cutoff := time.Now().Add(-olderThan)
+
+	uploads, err := s.storage.ListMultipartUploads(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	aborted := 0
+	for _, upload := range uploads {
+		if upload.Initiated.After(cutoff) {
+			continue
+		}
+		err := s.storage.AbortMultipartUpload(ctx, upload.StorageKey, upload.UploadID)
+		if err != nil && !errors.Is(err, ErrUploadNotFound) {
+			return aborted, err
+		}
+		aborted++
+	}
+
+	// Rows whose upload is already gone from storage, e.g. aborted above
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE multipart_uploads SET status = 'aborted', updated_at = NOW()
+		 WHERE status = 'uploading' AND created_at < $1`,
+		cutoff,
+	)
+	if err != nil {
+		return aborted, err
+	}
+
+	return aborted, nil
+}
+
+// activeMultipartUpload returns the storage key of a multipart upload the user started,
+// checking it's still in progress and its key hasn't expired
+func (s *UploadService) activeMultipartUpload(ctx context.Context, userID, uploadID string) (string, error) {
+	var storageKey, ownerID, status string
+	var expiresAt time.Time
+
+	err := s.db.QueryRowContext(ctx,
+		`SELECT m.storage_key, m.user_id, m.status, p.expires_at
+		 FROM multipart_uploads m
+		 JOIN pending_uploads p ON p.storage_key = m.storage_key
+		 WHERE m.upload_id = $1`,
+		uploadID,
+	).Scan(&storageKey, &ownerID, &status, &expiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", errors.New("unknown upload")
+		}
+		return "", err
+	}
+
+	// Uploads started by someone else are reported like unknown ones
+	if ownerID != userID {
+		return "", errors.New("unknown upload")
+	}
+	if status != "uploading" {
+		return "", fmt.Errorf("upload has been %s", status)
+	}
+	if time.Now().After(expiresAt) {
+		return "", errors.New("upload has expired")
+	}
+
+	return storageKey, nil
+}
+
+func (s *UploadService) setMultipartStatus(ctx context.Context, uploadID, status string) error {
+	result, err := s.db.ExecContext(ctx,
+		`UPDATE multipart_uploads SET status = $2, updated_at = NOW()
+		 WHERE upload_id = $1 AND status = 'uploading'`,
+		uploadID, status,
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "upload is no longer in progress")
+}
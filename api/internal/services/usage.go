@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrQuotaExceeded is returned when an upload would take a user over their storage quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
+// QuotaTier is a storage quota granted from a number of credits purchased over a user's lifetime
+type QuotaTier struct {
+	Name                string `json:"name"`
+	MinCreditsPurchased int    `json:"min_credits_purchased"`
+	QuotaBytes          int64  `json:"quota_bytes"`
+}
+
+// StorageUsage breaks down the bytes stored for a user or an album
+type StorageUsage struct {
+	OriginalsBytes   int64 `json:"originals_bytes"`
+	GeneratedBytes   int64 `json:"generated_bytes"`
+	DerivativesBytes int64 `json:"derivatives_bytes"`
+	TotalBytes       int64 `json:"total_bytes"`
+}
+
+// AlbumUsage is the storage a user uses in one album
+type AlbumUsage struct {
+	AlbumID string `json:"album_id"`
+	StorageUsage
+}
+
+// UserUsage is a user's storage usage and quota. Generated photos and derivatives belong to
+// the owner of the original photo. Uploads in progress are reserved against the quota.
+type UserUsage struct {
+	StorageUsage
+	ReservedBytes    int64        `json:"reserved_bytes"`
+	QuotaBytes       int64        `json:"quota_bytes"`
+	Tier             QuotaTier    `json:"tier"`
+	NextTier         *QuotaTier   `json:"next_tier,omitempty"`
+	CreditsPurchased int          `json:"credits_purchased"`
+	Albums           []AlbumUsage `json:"albums"`
+}
+
+// UsageService accounts for the storage users use and enforces their quotas
+type UsageService struct {
+	db    *sql.DB
+	tiers []QuotaTier
+}
+
+// NewUsageService creates a new UsageService. tiers must include one starting at 0 credits.
+func NewUsageService(db *sql.DB, tiers []QuotaTier) *UsageService {
+	tiers = append([]QuotaTier(nil), tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinCreditsPurchased < tiers[j].MinCreditsPurchased })
+	return &UsageService{db: db, tiers: tiers}
+}
+
+// usageByAlbumQuery sums, per album, the bytes of a user's originals (with their display
+// copies), of the photos generated from them, and of the derivatives of both
+const usageByAlbumQuery = `
+	WITH owned AS (
+		SELECT id, album_id, (COALESCE(size_bytes, 0) + COALESCE(display_size_bytes, 0))::BIGINT AS size_bytes
+			FROM photos WHERE user_id = $1
+	), generated AS (
+		SELECT g.id, o.album_id, COALESCE(g.size_bytes, 0) AS size_bytes
+		FROM generated_photos g JOIN owned o ON o.id = g.original_photo_id
+		WHERE g.status = 'completed'
+	), derived AS (
+		SELECT o.album_id, d.size_bytes FROM derivatives d JOIN owned o ON o.id = d.photo_id
+		UNION ALL
+		SELECT g.album_id, d.size_bytes FROM derivatives d JOIN generated g ON g.id = d.generated_photo_id
+	)
+	SELECT album_id, SUM(originals), SUM(generated), SUM(derived) FROM (
+		SELECT album_id, size_bytes AS originals, 0 AS generated, 0 AS derived FROM owned
+		UNION ALL SELECT album_id, 0, size_bytes, 0 FROM generated
+		UNION ALL SELECT album_id, 0, 0, size_bytes FROM derived
+	) usage
+	GROUP BY album_id
+	ORDER BY album_id`
+
+// GetUsage returns a user's storage usage, per album and in total, with their quota
+func (s *UsageService) GetUsage(ctx context.Context, userID string) (*UserUsage, error) {
+	albums, err := albumUsage(ctx, s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &UserUsage{Albums: albums}
+	for _, album := range albums {
+		usage.OriginalsBytes += album.OriginalsBytes
+		usage.GeneratedBytes += album.GeneratedBytes
+		usage.DerivativesBytes += album.DerivativesBytes
+		usage.TotalBytes += album.TotalBytes
+	}
+
+	usage.ReservedBytes, err = reservedBytes(ctx, s.db, userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	usage.CreditsPurchased, err = creditsPurchased(ctx, s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	usage.Tier, usage.NextTier = s.tierFor(usage.CreditsPurchased)
+	usage.QuotaBytes = usage.Tier.QuotaBytes
+
+	return usage, nil
+}
+
+// checkQuotaTx returns ErrQuotaExceeded if storing size more bytes would take the user over
+// their quota, counting uploads in progress other than excludeKey. The user's row is locked
+// for the rest of tx so concurrent uploads are checked one after the other.
+func (s *UsageService) checkQuotaTx(ctx context.Context, tx *sql.Tx, userID string, size int64, excludeKey string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("user not found")
+		}
+		return err
+	}
+
+	albums, err := albumUsage(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	var used int64
+	for _, album := range albums {
+		used += album.TotalBytes
+	}
+
+	reserved, err := reservedBytes(ctx, tx, userID, excludeKey)
+	if err != nil {
+		return err
+	}
+
+	purchased, err := creditsPurchased(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+	tier, _ := s.tierFor(purchased)
+
+	if used+reserved+size > tier.QuotaBytes {
+		return fmt.Errorf("%w: %d of %d bytes used", ErrQuotaExceeded, used+reserved, tier.QuotaBytes)
+	}
+	return nil
+}
+
+// tierFor returns the highest tier the purchased credits qualify for, and the one after it
+func (s *UsageService) tierFor(creditsPurchased int) (QuotaTier, *QuotaTier) {
+	var tier QuotaTier
+	for i, t := range s.tiers {
+		if creditsPurchased < t.MinCreditsPurchased {
+			next := s.tiers[i]
+			return tier, &next
+		}
+		tier = t
+	}
+	return tier, nil
+}
+
+// albumUsage returns the storage a user uses in each album
+func albumUsage(ctx context.Context, q queryer, userID string) ([]AlbumUsage, error) {
+	rows, err := q.QueryContext(ctx, usageByAlbumQuery, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []AlbumUsage{}
+	for rows.Next() {
+		var album AlbumUsage
+		if err := rows.Scan(&album.AlbumID, &album.OriginalsBytes, &album.GeneratedBytes, &album.DerivativesBytes); err != nil {
+			return nil, err
+		}
+		album.TotalBytes = album.OriginalsBytes + album.GeneratedBytes + album.DerivativesBytes
+		albums = append(albums, album)
+	}
+	return albums, rows.Err()
+}
+
+// reservedBytes sums the declared sizes of uploads issued to a user that haven't become photos
+// yet, other than excludeKey
+func reservedBytes(ctx context.Context, q queryer, userID, excludeKey string) (int64, error) {
+	var reserved int64
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(size_bytes), 0) FROM pending_uploads
+		 WHERE user_id = $1 AND consumed_at IS NULL AND expires_at > NOW() AND storage_key <> $2`,
+		userID, excludeKey,
+	).Scan(&reserved)
+	return reserved, err
+}
+
+// creditsPurchased sums the credits a user has ever bought
+func creditsPurchased(ctx context.Context, q queryer, userID string) (int, error) {
+	var purchased int
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(amount), 0) FROM credit_transactions WHERE user_id = $1 AND type = 'purchase'`,
+		userID,
+	).Scan(&purchased)
+	return purchased, err
+}
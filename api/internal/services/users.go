@@ -12,12 +12,13 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id"`
-	Email     string    `json:"email"`
-	Name      string    `json:"name"`
-	Status    string    `json:"status"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID            string    `json:"id"`
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	EmailVerified bool      `json:"email_verified"` // unverified accounts can't buy credits
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
 }
 
 // CreateUserInput holds data for creating a user
@@ -33,6 +34,9 @@ type UserWithPassword struct {
 	PasswordHash string
 }
 
+// ErrUserNotFound is returned when looking up a user that doesn't exist
+var ErrUserNotFound = errors.New("user not found")
+
 // UserService handles user business logic
 type UserService struct {
 	db *sql.DB
@@ -91,13 +95,13 @@ func (s *UserService) Create(ctx context.Context, input CreateUserInput) (*User,
 func (s *UserService) GetByID(ctx context.Context, id string) (*User, error) {
 	user := &User{}
 	err := s.db.QueryRowContext(ctx,
-		"SELECT id, email, name, status, created_at, updated_at FROM users WHERE id = $1",
+		"SELECT id, email, name, status, email_verified_at IS NOT NULL, created_at, updated_at FROM users WHERE id = $1",
 		id,
-	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
 	
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -109,13 +113,13 @@ func (s *UserService) GetByID(ctx context.Context, id string) (*User, error) {
 func (s *UserService) GetByEmail(ctx context.Context, email string) (*User, error) {
 	user := &User{}
 	err := s.db.QueryRowContext(ctx,
-		"SELECT id, email, name, status, created_at, updated_at FROM users WHERE email = $1",
+		"SELECT id, email, name, status, email_verified_at IS NOT NULL, created_at, updated_at FROM users WHERE email = $1",
 		email,
-	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.EmailVerified, &user.CreatedAt, &user.UpdatedAt)
 	
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
@@ -127,16 +131,86 @@ func (s *UserService) GetByEmail(ctx context.Context, email string) (*User, erro
 func (s *UserService) GetByEmailWithPassword(ctx context.Context, email string) (*UserWithPassword, error) {
 	user := &UserWithPassword{}
 	err := s.db.QueryRowContext(ctx,
-		"SELECT id, email, name, status, password_hash, created_at, updated_at FROM users WHERE email = $1",
+		"SELECT id, email, name, status, email_verified_at IS NOT NULL, COALESCE(password_hash, ''), created_at, updated_at FROM users WHERE email = $1",
 		email,
-	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Email, &user.Name, &user.Status, &user.EmailVerified, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
 	
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, errors.New("user not found")
+			return nil, ErrUserNotFound
 		}
 		return nil, err
 	}
 
 	return user, nil
 }
+
+// setPasswordTx replaces a user's password inside tx
+func (s *UserService) setPasswordTx(ctx context.Context, tx *sql.Tx, userID, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx,
+		`UPDATE users SET password_hash = $2, updated_at = NOW() WHERE id = $1`,
+		userID, string(hash),
+	)
+	if err != nil {
+		return err
+	}
+	return expectOneRow(result, "user not found")
+}
+
+// markEmailVerifiedTx records inside tx that a user proved they own their email address
+func (s *UserService) markEmailVerifiedTx(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, NOW()), updated_at = NOW() WHERE id = $1`,
+		userID,
+	)
+	return err
+}
+
+// clearLockoutTx resets a user's failed sign-ins inside tx, unlocking their account
+func (s *UserService) clearLockoutTx(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1`,
+		userID,
+	)
+	return err
+}
+
+// clearPasswordTx removes a user's password inside tx, so they can only sign in through an
+// identity provider until they reset it
+func (s *UserService) clearPasswordTx(ctx context.Context, tx *sql.Tx, userID string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET password_hash = NULL, updated_at = NOW() WHERE id = $1`,
+		userID,
+	)
+	return err
+}
+
+// createVerifiedTx creates a user without a password inside tx, for a sign-in through an
+// identity provider that vouched for the email address
+func (s *UserService) createVerifiedTx(ctx context.Context, tx *sql.Tx, email, name string) (*User, error) {
+	now := time.Now()
+	user := &User{
+		ID:            uuid.New().String(),
+		Email:         email,
+		Name:          name,
+		Status:        "active",
+		EmailVerified: true,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO users (id, email, name, status, email_verified_at, created_at, updated_at)
+		 VALUES ($1, $2, $3, $4, $5, $5, $5)`,
+		user.ID, user.Email, user.Name, user.Status, now,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
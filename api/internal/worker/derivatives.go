@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"redrawn/internal/imaging"
+	"redrawn/internal/services"
+)
+
+// displayJPEGQuality is used when an original has to be re-encoded to be served upright
+const displayJPEGQuality = 92
+
+// displayFormats maps the content types of originals to the format their display copy
+// is re-encoded in
+var displayFormats = map[string]string{
+	"image/jpeg": imaging.FormatJPEG,
+	"image/png":  imaging.FormatPNG,
+	"image/webp": imaging.FormatWebP,
+}
+
+// storeDisplayCopy stores the copy of an original photo that is served in its place:
+// upright and without EXIF, XMP or IPTC metadata. Originals that are only carrying
+// metadata are stripped losslessly; rotated ones are re-encoded. It returns the key and
+// size of the copy, or an empty key when the original can be served as is.
+func (w *Worker) storeDisplayCopy(ctx context.Context, photo *services.Photo, data []byte, mimeType string, orientation int, upright image.Image) (string, int64, error) {
+	display, stripped, err := imaging.StripMetadata(data, mimeType)
+	if err == nil && !stripped && orientation <= 1 {
+		return "", 0, nil
+	}
+
+	// Containers too unusual to strip safely are re-encoded like rotated images
+	if err != nil || orientation > 1 {
+		format, ok := displayFormats[mimeType]
+		if !ok {
+			format = imaging.FormatPNG
+		}
+		display, mimeType, err = imaging.EncodeQuality(upright, format, displayJPEGQuality)
+		if err != nil {
+			return "", 0, fmt.Errorf("encode display copy: %w", err)
+		}
+	}
+
+	key := services.DisplayKey(photo.StorageKey, mimeType)
+	if err := w.app.Storage.PutObject(ctx, key, display, mimeType); err != nil {
+		return "", 0, fmt.Errorf("upload display copy: %w", err)
+	}
+	return key, int64(len(display)), nil
+}
+
+// derivePhoto stores the resized copies of an original photo
+func (w *Worker) derivePhoto(ctx context.Context, photo *services.Photo, img image.Image) error {
+	return w.derive(ctx, img, func(size int, format string) services.Derivative {
+		return services.Derivative{
+			PhotoID:    &photo.ID,
+			Size:       size,
+			Format:     format,
+			StorageKey: services.DerivativeKey(photo.StorageKey, size, format),
+		}
+	})
+}
+
+// deriveGeneratedPhoto stores the resized copies of a generated photo stored at storageKey
+// and computes its placeholder
+func (w *Worker) deriveGeneratedPhoto(ctx context.Context, generatedPhotoID, storageKey string, data []byte) (imaging.Placeholder, error) {
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return imaging.Placeholder{}, err
+	}
+	placeholder := imaging.ComputePlaceholder(img)
+	return placeholder, w.derive(ctx, img, func(size int, format string) services.Derivative {
+		return services.Derivative{
+			GeneratedPhotoID: &generatedPhotoID,
+			Size:             size,
+			Format:           format,
+			StorageKey:       services.DerivativeKey(storageKey, size, format),
+		}
+	})
+}
+
+// derive encodes img at every derivative size and format, uploads each copy and records it.
+// Sizes above the image's own size are skipped, apart from the first one, which holds
+// the image at full resolution, so there is always at least one variant.
+func (w *Worker) derive(ctx context.Context, img image.Image, newDerivative func(size int, format string) services.Derivative) error {
+	bounds := img.Bounds()
+	longestEdge := max(bounds.Dx(), bounds.Dy())
+
+	for _, size := range services.DerivativeSizes {
+		resized := imaging.Fit(img, size)
+
+		for _, format := range services.DerivativeFormats {
+			data, mimeType, err := imaging.Encode(resized, format)
+			if err != nil {
+				return fmt.Errorf("encode %s at %d: %w", format, size, err)
+			}
+
+			d := newDerivative(size, format)
+			d.Width = resized.Bounds().Dx()
+			d.Height = resized.Bounds().Dy()
+			d.SizeBytes = int64(len(data))
+
+			if err := w.app.Storage.PutObject(ctx, d.StorageKey, data, mimeType); err != nil {
+				return fmt.Errorf("upload derivative: %w", err)
+			}
+			if err := w.app.DerivativeService.Record(ctx, d); err != nil {
+				return fmt.Errorf("record derivative: %w", err)
+			}
+		}
+
+		if size >= longestEdge {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package worker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"redrawn/internal/imaging"
+	"redrawn/internal/services"
+)
+
+// ingestLoop claims uploaded photos and validates them until ctx is cancelled
+func (w *Worker) ingestLoop(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		photo, err := w.app.PhotoService.ClaimNextUploaded(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Error("Failed to claim upload", "error", err)
+			}
+			w.sleep(ctx)
+			continue
+		}
+		if photo == nil {
+			w.sleep(ctx)
+			continue
+		}
+
+		w.ingest(photo)
+	}
+}
+
+// ingest validates a single uploaded photo and records its real metadata.
+// Like process, it runs detached from the Run context so shutdown doesn't strand the photo.
+func (w *Worker) ingest(photo *services.Photo) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.app.Config.Worker.JobTimeout)
+	defer cancel()
+
+	logger := w.logger.With("photo_id", photo.ID)
+
+	data, info, err := w.probe(ctx, photo)
+	if err != nil {
+		var rejected *rejectedUploadError
+		if !errors.As(err, &rejected) {
+			// Storage or transient failure: leave the photo in processing so the
+			// stale requeue retries it, up to the configured attempts
+			logger.Error("Failed to ingest photo", "error", err)
+			return
+		}
+
+		logger.Warn("Rejected upload", "reason", rejected.reason)
+		if markErr := w.app.PhotoService.MarkError(context.Background(), photo.ID, rejected.reason); markErr != nil {
+			logger.Error("Failed to mark photo as rejected", "error", markErr)
+		}
+		return
+	}
+
+	// An upload identical to a photo already in the album is pointed at that photo rather
+	// than ingested again
+	sum := sha256.Sum256(data)
+	contentSHA256 := hex.EncodeToString(sum[:])
+	if err := w.app.PhotoService.RejectDuplicate(ctx, photo.ID, contentSHA256); err != nil {
+		if errors.Is(err, services.ErrDuplicatePhoto) {
+			logger.Warn("Rejected duplicate upload", "content_sha256", contentSHA256)
+		} else {
+			logger.Error("Failed to check for duplicates", "error", err)
+		}
+		return
+	}
+
+	exif := imaging.ReadMetadata(data, info.MimeType)
+	upright := imaging.Orient(info.Image, exif.Orientation)
+
+	metadata := services.PhotoMetadata{
+		MimeType:       info.MimeType,
+		SizeBytes:      info.SizeBytes,
+		Width:          upright.Bounds().Dx(),
+		Height:         upright.Bounds().Dy(),
+		TakenAt:        exif.TakenAt,
+		CameraMake:     exif.CameraMake,
+		CameraModel:    exif.CameraModel,
+		Orientation:    exif.Orientation,
+		ContentSHA256:  contentSHA256,
+		PerceptualHash: imaging.PerceptualHash(upright),
+		Placeholder:    imaging.ComputePlaceholder(upright),
+	}
+
+	keepLocation, err := w.app.AlbumService.KeepsLocation(ctx, photo.AlbumID)
+	if err != nil {
+		logger.Error("Failed to load album settings", "error", err)
+		return
+	}
+	if keepLocation {
+		metadata.Latitude, metadata.Longitude = exif.Latitude, exif.Longitude
+	}
+
+	// Originals are kept byte for byte; what gets served is a copy without metadata
+	metadata.DisplayStorageKey, metadata.DisplaySizeBytes, err = w.storeDisplayCopy(ctx, photo, data, info.MimeType, exif.Orientation, upright)
+	if err != nil {
+		logger.Error("Failed to store display copy", "error", err)
+		return
+	}
+
+	// Derivatives are written before the photo turns ready so galleries never see it
+	// without thumbnails; a failure here is retried like any other storage error
+	if err := w.derivePhoto(ctx, photo, upright); err != nil {
+		logger.Error("Failed to generate derivatives", "error", err)
+		return
+	}
+
+	if err := w.app.PhotoService.MarkReady(context.Background(), photo.ID, metadata); err != nil {
+		// An identical upload of the same album finished ingesting first
+		if errors.Is(err, services.ErrDuplicatePhoto) {
+			logger.Warn("Rejected duplicate upload", "content_sha256", contentSHA256)
+			return
+		}
+		logger.Error("Failed to mark photo as ready", "error", err)
+		return
+	}
+
+	logger.Info("Photo ingested", "mime_type", info.MimeType, "width", metadata.Width, "height", metadata.Height,
+		"orientation", exif.Orientation, "display_copy", metadata.DisplayStorageKey != "")
+}
+
+// rejectedUploadError is a problem with the uploaded content itself, which retrying can't fix
+type rejectedUploadError struct {
+	reason string
+}
+
+func (e *rejectedUploadError) Error() string {
+	return e.reason
+}
+
+// probe downloads the photo's object and inspects it
+func (w *Worker) probe(ctx context.Context, photo *services.Photo) ([]byte, *imaging.Info, error) {
+	data, err := w.app.Storage.GetObject(ctx, photo.StorageKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("download upload: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, nil, &rejectedUploadError{reason: "uploaded file is empty"}
+	}
+
+	info, err := imaging.Probe(data, w.app.Config.Ingestion.MaxPixels)
+	if err != nil {
+		switch {
+		case errors.Is(err, imaging.ErrUnsupportedType):
+			return nil, nil, &rejectedUploadError{reason: "unsupported file type; upload a JPEG, PNG, GIF or WebP image"}
+		case errors.Is(err, imaging.ErrTooLarge):
+			return nil, nil, &rejectedUploadError{reason: fmt.Sprintf("image is too large; the limit is %d pixels", w.app.Config.Ingestion.MaxPixels)}
+		default:
+			return nil, nil, &rejectedUploadError{reason: "image is corrupt or truncated"}
+		}
+	}
+	return data, info, nil
+}
@@ -0,0 +1,336 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"redrawn/internal/app"
+	"redrawn/internal/services"
+)
+
+// Worker claims queued generation jobs and uploaded photos from Postgres and processes them
+type Worker struct {
+	app    *app.App
+	logger *slog.Logger
+}
+
+// New creates a new Worker
+func New(a *app.App) *Worker {
+	return &Worker{
+		app:    a,
+		logger: a.Logger.With("component", "worker"),
+	}
+}
+
+// Run starts the configured number of job loops and blocks until ctx is cancelled.
+// In-flight jobs are allowed to finish before Run returns.
+func (w *Worker) Run(ctx context.Context) error {
+	cfg := w.app.Config.Worker
+	concurrency := cfg.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	ingestConcurrency := w.app.Config.Ingestion.Concurrency
+	if ingestConcurrency < 1 {
+		ingestConcurrency = 1
+	}
+
+	w.logger.Info("Starting generation worker", "concurrency", concurrency, "ingest_concurrency", ingestConcurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.loop(ctx)
+		}()
+	}
+	for i := 0; i < ingestConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.ingestLoop(ctx)
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.requeueLoop(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.sweepLoop(ctx)
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		w.reconcileLoop(ctx)
+	}()
+
+	wg.Wait()
+	w.logger.Info("Generation worker stopped")
+	return nil
+}
+
+// loop claims and processes jobs until ctx is cancelled, sleeping when the queue is empty
+func (w *Worker) loop(ctx context.Context) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		job, err := w.app.GeneratedPhotoService.ClaimNext(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				w.logger.Error("Failed to claim job", "error", err)
+			}
+			w.sleep(ctx)
+			continue
+		}
+		if job == nil {
+			w.sleep(ctx)
+			continue
+		}
+
+		w.process(job)
+	}
+}
+
+// requeueLoop periodically recovers jobs and uploads left in processing by crashed workers
+func (w *Worker) requeueLoop(ctx context.Context) {
+	cfg := w.app.Config.Worker
+	ticker := time.NewTicker(cfg.JobTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Allow a grace period on top of the job timeout before assuming the worker died
+			n, err := w.app.GeneratedPhotoService.RequeueStale(ctx, 2*cfg.JobTimeout, cfg.MaxAttempts)
+			if err != nil {
+				w.logger.Error("Failed to requeue stale jobs", "error", err)
+				continue
+			}
+			if n > 0 {
+				w.logger.Warn("Requeued stale jobs", "count", n)
+			}
+
+			n, err = w.app.PhotoService.RequeueStaleIngestion(ctx, 2*cfg.JobTimeout, cfg.MaxAttempts)
+			if err != nil {
+				w.logger.Error("Failed to requeue stale uploads", "error", err)
+				continue
+			}
+			if n > 0 {
+				w.logger.Warn("Requeued stale uploads", "count", n)
+			}
+		}
+	}
+}
+
+// sweepLoop periodically aborts multipart uploads that were never completed
+func (w *Worker) sweepLoop(ctx context.Context) {
+	cfg := w.app.Config.Uploads
+	ticker := time.NewTicker(cfg.SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := w.app.UploadService.AbortStaleMultipartUploads(ctx, cfg.MultipartMaxAge)
+			if err != nil {
+				w.logger.Error("Failed to abort stale multipart uploads", "error", err)
+			}
+			if n > 0 {
+				w.logger.Info("Aborted stale multipart uploads", "count", n)
+			}
+		}
+	}
+}
+
+// reconcileLoop periodically reconciles storage with the database, reporting or deleting
+// orphaned objects and flagging rows whose object is missing
+func (w *Worker) reconcileLoop(ctx context.Context) {
+	cfg := w.app.Config.Storage
+	ticker := time.NewTicker(cfg.ReconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report, err := w.app.StorageMaintenance.Reconcile(ctx, services.ReconcileOptions{
+				GracePeriod:   cfg.OrphanGracePeriod,
+				DeleteOrphans: cfg.DeleteOrphans,
+			})
+			if err != nil {
+				w.logger.Error("Failed to reconcile storage", "error", err)
+				continue
+			}
+			w.logger.Info("Reconciled storage",
+				"objects", report.Objects,
+				"orphans", report.Orphans,
+				"orphan_bytes", report.OrphanBytes,
+				"deleted", report.Deleted,
+				"missing_photos", report.MissingPhotos,
+				"missing_generated_photos", report.MissingGeneratedPhotos,
+			)
+		}
+	}
+}
+
+func (w *Worker) sleep(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(w.app.Config.Worker.PollInterval):
+	}
+}
+
+// process runs a single job and records its outcome. It deliberately does not use the
+// Run context so that a shutdown signal lets the current job finish instead of orphaning it.
+func (w *Worker) process(job *services.GeneratedPhoto) {
+	ctx, cancel := context.WithTimeout(context.Background(), w.app.Config.Worker.JobTimeout)
+	defer cancel()
+
+	logger := w.logger.With("generated_photo_id", job.ID)
+	logger.Info("Processing generation job")
+
+	// The same image with the same theme version was already generated: copy the result
+	// instead of paying for it again
+	source, err := w.app.GeneratedPhotoService.FindReusable(ctx, job)
+	if err != nil {
+		logger.Warn("Failed to look for a reusable generation", "error", err)
+	}
+	if source != nil {
+		storageKey, data, err := w.copyGeneration(ctx, job, source)
+		if err == nil {
+			placeholder, err := w.deriveGeneratedPhoto(ctx, job.ID, storageKey, data)
+			if err != nil {
+				logger.Warn("Failed to generate derivatives", "error", err)
+			}
+			if err := w.app.GeneratedPhotoService.MarkReused(context.Background(), job.ID, storageKey, int64(len(data)), placeholder, source.ID); err != nil {
+				logger.Error("Failed to mark job as completed", "error", err)
+				return
+			}
+			logger.Info("Generation job completed from an earlier result", "source_id", source.ID, "storage_key", storageKey)
+			return
+		}
+		logger.Warn("Failed to reuse earlier generation, generating instead", "source_id", source.ID, "error", err)
+	}
+
+	storageKey, result, err := w.generate(ctx, job)
+	if err != nil {
+		logger.Error("Generation job failed", "error", err)
+		if markErr := w.app.GeneratedPhotoService.MarkFailed(context.Background(), job.ID, err.Error()); markErr != nil {
+			logger.Error("Failed to mark job as failed", "error", markErr)
+		}
+		return
+	}
+
+	// The generation is already paid for, so missing thumbnails don't fail the job;
+	// clients fall back to the full-size image
+	placeholder, err := w.deriveGeneratedPhoto(ctx, job.ID, storageKey, result.Image)
+	if err != nil {
+		logger.Warn("Failed to generate derivatives", "error", err)
+	}
+
+	if err := w.app.GeneratedPhotoService.MarkCompleted(context.Background(), job.ID, storageKey, int64(len(result.Image)), placeholder, result.Metadata); err != nil {
+		logger.Error("Failed to mark job as completed", "error", err)
+		return
+	}
+
+	logger.Info("Generation job completed", "storage_key", storageKey)
+}
+
+// generate produces the themed image for a job and stores it.
+// It returns the storage key of the result along with the result itself.
+func (w *Worker) generate(ctx context.Context, job *services.GeneratedPhoto) (string, *services.ImageGenerationResult, error) {
+	photo, err := w.app.PhotoService.GetByID(ctx, job.OriginalPhotoID)
+	if err != nil {
+		return "", nil, fmt.Errorf("load original photo: %w", err)
+	}
+
+	prompt, err := w.jobPrompt(ctx, job, photo)
+	if err != nil {
+		return "", nil, fmt.Errorf("render prompt: %w", err)
+	}
+
+	// The display copy is upright and carries no location for the provider to see
+	original, err := w.app.Storage.GetObject(ctx, photo.ServedStorageKey())
+	if err != nil {
+		return "", nil, fmt.Errorf("download original: %w", err)
+	}
+
+	mimeType := "image/png"
+	if photo.MimeType != nil {
+		mimeType = *photo.MimeType
+	}
+
+	result, err := w.app.ImageGenerator.Generate(ctx, services.ImageGenerationInput{
+		Image:    original,
+		MimeType: mimeType,
+		Prompt:   prompt,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	storageKey := services.GeneratedKey(photo.UserID, photo.AlbumID, job.ThemeID, job.ID, result.MimeType)
+	if err := w.app.Storage.PutObject(ctx, storageKey, result.Image, result.MimeType); err != nil {
+		return "", nil, fmt.Errorf("upload result: %w", err)
+	}
+
+	return storageKey, result, nil
+}
+
+// copyGeneration stores a copy of an earlier generation's result as the result of job,
+// returning its storage key and content
+func (w *Worker) copyGeneration(ctx context.Context, job, source *services.GeneratedPhoto) (string, []byte, error) {
+	photo, err := w.app.PhotoService.GetByID(ctx, job.OriginalPhotoID)
+	if err != nil {
+		return "", nil, fmt.Errorf("load original photo: %w", err)
+	}
+
+	data, err := w.app.Storage.GetObject(ctx, source.StorageKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("download earlier result: %w", err)
+	}
+
+	mimeType := http.DetectContentType(data)
+	storageKey := services.GeneratedKey(photo.UserID, photo.AlbumID, job.ThemeID, job.ID, mimeType)
+	if err := w.app.Storage.PutObject(ctx, storageKey, data, mimeType); err != nil {
+		return "", nil, fmt.Errorf("upload result: %w", err)
+	}
+	return storageKey, data, nil
+}
+
+// jobPrompt returns the prompt rendered when the job was queued. Jobs queued before
+// prompts were stored are rendered from the theme with default parameters.
+func (w *Worker) jobPrompt(ctx context.Context, job *services.GeneratedPhoto, photo *services.Photo) (string, error) {
+	if job.Prompt != nil {
+		return *job.Prompt, nil
+	}
+
+	theme, err := w.app.ThemeService.GetByID(ctx, job.ThemeID)
+	if err != nil {
+		return "", err
+	}
+	album, err := w.app.AlbumService.GetByID(ctx, photo.AlbumID)
+	if err != nil {
+		return "", err
+	}
+	return w.app.ThemeService.RenderPrompt(theme, services.PromptVariables{Photo: photo, Album: album})
+}
@@ -0,0 +1,9 @@
+-- Migration: Generation worker bookkeeping
+-- Tracks when a job was claimed and how often it was attempted so stuck jobs can be re-queued
+
+ALTER TABLE generated_photos ADD COLUMN started_at TIMESTAMPTZ;
+ALTER TABLE generated_photos ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+
+-- Partial index keeps the claim query cheap as the table grows
+CREATE INDEX idx_generated_photos_queue ON generated_photos(created_at) WHERE status = 'queued';
+CREATE INDEX idx_generated_photos_processing ON generated_photos(started_at) WHERE status = 'processing';
@@ -0,0 +1,4 @@
+-- Migration: Record which image generator produced each result
+-- Provider, model and provider specific details (e.g. revised prompt) for auditing and debugging
+
+ALTER TABLE generated_photos ADD COLUMN provider_metadata JSONB;
@@ -0,0 +1,16 @@
+-- Migration: Credit holds for generation jobs
+-- Credits are debited when a job is queued and either committed on success or refunded on failure
+
+CREATE TABLE credit_holds (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    amount INTEGER NOT NULL CHECK (amount > 0),
+    status TEXT NOT NULL DEFAULT 'held' CHECK (status IN ('held', 'committed', 'released')),
+    related_entity_type TEXT NOT NULL,
+    related_entity_id TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    settled_at TIMESTAMPTZ
+);
+
+CREATE UNIQUE INDEX idx_credit_holds_entity ON credit_holds(related_entity_type, related_entity_id);
+CREATE INDEX idx_credit_holds_user ON credit_holds(user_id, status);
@@ -0,0 +1,6 @@
+-- Migration: Typed prompt templates
+-- Themes declare the user-supplied parameters their template can reference;
+-- generations store the prompt rendered when they were queued
+
+ALTER TABLE themes ADD COLUMN prompt_params JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE generated_photos ADD COLUMN prompt TEXT;
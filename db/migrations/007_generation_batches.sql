@@ -0,0 +1,20 @@
+-- Migration: Batch generation
+-- Applying a theme to an album queues one generated photo per ready photo under a batch,
+-- so clients can poll the aggregate progress
+
+CREATE TABLE generation_batches (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    album_id TEXT NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+    theme_id TEXT NOT NULL REFERENCES themes(id) ON DELETE CASCADE,
+    photo_count INTEGER NOT NULL,
+    skipped_count INTEGER NOT NULL DEFAULT 0,
+    credits_reserved INTEGER NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_generation_batches_user ON generation_batches(user_id, created_at DESC);
+
+ALTER TABLE generated_photos ADD COLUMN batch_id TEXT REFERENCES generation_batches(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_generated_photos_batch ON generated_photos(batch_id);
@@ -0,0 +1,86 @@
+-- Migration: Real-time event notifications
+-- Triggers publish changes on the redrawn_events channel so every API replica can
+-- relay them to the affected users' Server-Sent Events streams.
+-- Payload: {"type": ..., "user_ids": [...], "data": {...}}
+
+-- Generation status transitions go to the photo owner and to whoever paid for the job.
+-- Deferred so the credit hold inserted later in the same transaction is visible.
+CREATE FUNCTION notify_generated_photo_event() RETURNS trigger AS $$
+DECLARE
+    recipients TEXT[];
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.status IS NOT DISTINCT FROM OLD.status THEN
+        RETURN NULL;
+    END IF;
+
+    SELECT ARRAY(
+        SELECT user_id FROM photos WHERE id = NEW.original_photo_id
+        UNION
+        SELECT user_id FROM credit_holds
+        WHERE related_entity_type = 'generated_photo' AND related_entity_id = NEW.id
+    ) INTO recipients;
+
+    PERFORM pg_notify('redrawn_events', json_build_object(
+        'type', 'generated_photo.status',
+        'user_ids', recipients,
+        'data', json_build_object(
+            'id', NEW.id,
+            'original_photo_id', NEW.original_photo_id,
+            'theme_id', NEW.theme_id,
+            'batch_id', NEW.batch_id,
+            'status', NEW.status,
+            'error_message', left(NEW.error_message, 500)
+        )
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER generated_photos_notify
+    AFTER INSERT OR UPDATE OF status ON generated_photos
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION notify_generated_photo_event();
+
+-- Photo processing status goes to the uploader
+CREATE FUNCTION notify_photo_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.status IS NOT DISTINCT FROM OLD.status THEN
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_notify('redrawn_events', json_build_object(
+        'type', 'photo.status',
+        'user_ids', ARRAY[NEW.user_id],
+        'data', json_build_object(
+            'id', NEW.id,
+            'album_id', NEW.album_id,
+            'status', NEW.status
+        )
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER photos_notify
+    AFTER INSERT OR UPDATE OF status ON photos
+    FOR EACH ROW EXECUTE FUNCTION notify_photo_event();
+
+-- Credit balance changes go to the account holder
+CREATE FUNCTION notify_credit_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'UPDATE' AND NEW.balance IS NOT DISTINCT FROM OLD.balance THEN
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_notify('redrawn_events', json_build_object(
+        'type', 'credits.balance',
+        'user_ids', ARRAY[NEW.user_id],
+        'data', json_build_object('balance', NEW.balance)
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER credits_notify
+    AFTER INSERT OR UPDATE OF balance ON credits
+    FOR EACH ROW EXECUTE FUNCTION notify_credit_event();
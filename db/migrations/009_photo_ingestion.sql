@@ -0,0 +1,10 @@
+-- Migration: Photo ingestion
+-- Uploaded photos are validated by the worker, which reads the real content type and
+-- dimensions from the stored object and moves the photo to ready or error
+
+ALTER TABLE photos ADD COLUMN error_message TEXT;
+ALTER TABLE photos ADD COLUMN processing_started_at TIMESTAMPTZ;
+ALTER TABLE photos ADD COLUMN ingest_attempts INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX idx_photos_ingest_queue ON photos(created_at) WHERE status = 'uploaded';
+CREATE INDEX idx_photos_ingest_processing ON photos(processing_started_at) WHERE status = 'processing';
@@ -0,0 +1,20 @@
+-- Migration: Image derivatives
+-- Resized WebP and JPEG copies of originals and completed generated photos,
+-- so galleries don't have to download full-size images
+
+CREATE TABLE derivatives (
+    id TEXT PRIMARY KEY,
+    photo_id TEXT REFERENCES photos(id) ON DELETE CASCADE,
+    generated_photo_id TEXT REFERENCES generated_photos(id) ON DELETE CASCADE,
+    size INTEGER NOT NULL,
+    format TEXT NOT NULL CHECK (format IN ('webp', 'jpeg')),
+    storage_key TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK ((photo_id IS NULL) <> (generated_photo_id IS NULL))
+);
+
+CREATE UNIQUE INDEX idx_derivatives_photo ON derivatives(photo_id, size, format) WHERE photo_id IS NOT NULL;
+CREATE UNIQUE INDEX idx_derivatives_generated_photo ON derivatives(generated_photo_id, size, format) WHERE generated_photo_id IS NOT NULL;
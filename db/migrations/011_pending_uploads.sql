@@ -0,0 +1,18 @@
+-- Migration: Pending uploads
+-- Storage keys handed out by the upload URL endpoint are bound to the requesting user
+-- and album, and can back exactly one photo
+
+CREATE TABLE pending_uploads (
+    storage_key TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    album_id TEXT NOT NULL REFERENCES albums(id) ON DELETE CASCADE,
+    filename TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    consumed_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_pending_uploads_user ON pending_uploads(user_id);
+CREATE INDEX idx_pending_uploads_expires ON pending_uploads(expires_at) WHERE consumed_at IS NULL;
@@ -0,0 +1,6 @@
+-- Migration: Storage key layout
+-- Objects are now stored under users/{user_id}/albums/{album_id}/..., with the photo ID
+-- allocated when the upload URL is issued. Existing objects are moved by the
+-- migrate-storage-keys command (see README).
+
+ALTER TABLE pending_uploads ADD COLUMN photo_id TEXT;
@@ -0,0 +1,15 @@
+-- Migration: Multipart uploads
+-- Large originals can be uploaded in parts. Each multipart upload backs one pending
+-- upload key; uploads left unfinished are aborted by the worker's sweeper.
+
+CREATE TABLE multipart_uploads (
+    upload_id TEXT PRIMARY KEY,
+    storage_key TEXT NOT NULL REFERENCES pending_uploads(storage_key) ON DELETE CASCADE,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    status TEXT NOT NULL DEFAULT 'uploading' CHECK (status IN ('uploading', 'completed', 'aborted')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_multipart_uploads_storage_key ON multipart_uploads(storage_key);
+CREATE INDEX idx_multipart_uploads_uploading ON multipart_uploads(created_at) WHERE status = 'uploading';
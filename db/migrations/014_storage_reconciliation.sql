@@ -0,0 +1,9 @@
+-- Migration: Storage reconciliation
+-- The reconciliation job flags rows whose object is missing from storage, and clears the
+-- flag if the object reappears
+
+ALTER TABLE photos ADD COLUMN object_missing_at TIMESTAMPTZ;
+ALTER TABLE generated_photos ADD COLUMN object_missing_at TIMESTAMPTZ;
+
+CREATE INDEX idx_photos_object_missing ON photos(object_missing_at) WHERE object_missing_at IS NOT NULL;
+CREATE INDEX idx_generated_photos_object_missing ON generated_photos(object_missing_at) WHERE object_missing_at IS NOT NULL;
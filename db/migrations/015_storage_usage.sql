@@ -0,0 +1,5 @@
+-- Migration: Storage usage
+-- Generated photos record their size so storage usage can be accounted per user and album.
+-- Photos generated before this migration count as 0 bytes.
+
+ALTER TABLE generated_photos ADD COLUMN size_bytes BIGINT;
@@ -0,0 +1,15 @@
+-- Migration: Photo metadata
+-- Ingestion reads EXIF from originals: capture time (camera wall-clock time, so no time zone),
+-- camera, orientation and, for albums that keep it, location. Originals carrying metadata or
+-- needing rotation get an upright, metadata-free display copy, which is what the API serves.
+
+ALTER TABLE photos ADD COLUMN taken_at TIMESTAMP;
+ALTER TABLE photos ADD COLUMN camera_make TEXT;
+ALTER TABLE photos ADD COLUMN camera_model TEXT;
+ALTER TABLE photos ADD COLUMN orientation SMALLINT;
+ALTER TABLE photos ADD COLUMN latitude DOUBLE PRECISION;
+ALTER TABLE photos ADD COLUMN longitude DOUBLE PRECISION;
+ALTER TABLE photos ADD COLUMN display_storage_key TEXT;
+ALTER TABLE photos ADD COLUMN display_size_bytes BIGINT;
+
+ALTER TABLE albums ADD COLUMN keep_location BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,12 @@
+-- Migration: Photo hashes
+-- Ingestion records the SHA-256 of each original and a 64-bit perceptual hash of the
+-- upright image. An upload identical to a ready photo of the same album is rejected and
+-- points at that photo, and generations of identical images with the same theme version
+-- reuse the earlier result.
+
+ALTER TABLE photos ADD COLUMN content_sha256 TEXT;
+ALTER TABLE photos ADD COLUMN perceptual_hash BIGINT;
+ALTER TABLE photos ADD COLUMN duplicate_of TEXT REFERENCES photos(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_photos_album_sha256 ON photos(album_id, content_sha256) WHERE status = 'ready';
+CREATE INDEX idx_photos_sha256 ON photos(content_sha256);
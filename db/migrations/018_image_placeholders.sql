@@ -0,0 +1,10 @@
+-- Migration: Image placeholders
+-- A BlurHash and a dominant color are computed when a photo is ingested or a generation
+-- completes, so grids can paint something while images load. Rows from before this
+-- migration keep NULL until they are re-ingested or regenerated.
+
+ALTER TABLE photos ADD COLUMN blurhash TEXT;
+ALTER TABLE photos ADD COLUMN dominant_color TEXT;
+
+ALTER TABLE generated_photos ADD COLUMN blurhash TEXT;
+ALTER TABLE generated_photos ADD COLUMN dominant_color TEXT;
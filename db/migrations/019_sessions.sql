@@ -0,0 +1,21 @@
+-- Migration: Sessions
+-- Each sign-in starts a session holding a refresh token, stored as a SHA-256 hash and
+-- rotated on every refresh. Access tokens are short-lived and name their session, so
+-- revoking a session logs its device out. Presenting a refresh token that was already
+-- rotated revokes the session, as the token must have been copied.
+
+CREATE TABLE sessions (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    refresh_token_hash TEXT NOT NULL UNIQUE,
+    previous_token_hash TEXT,
+    user_agent TEXT,
+    ip_address TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_sessions_user_active ON sessions(user_id, last_used_at) WHERE revoked_at IS NULL;
+CREATE INDEX idx_sessions_previous_token_hash ON sessions(previous_token_hash);
@@ -0,0 +1,19 @@
+-- Migration: Account tokens
+-- Password resets and email verification send a single-use link. Only a SHA-256 hash of
+-- the token in the link is stored; redeeming it sets used_at, and issuing a new token for
+-- the same purpose invalidates the user's earlier ones. Accounts created before this
+-- migration start unverified and can ask for a verification email.
+
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMPTZ;
+
+CREATE TABLE account_tokens (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose TEXT NOT NULL CHECK (purpose IN ('password_reset', 'email_verification')),
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_account_tokens_user_purpose ON account_tokens(user_id, purpose) WHERE used_at IS NULL;
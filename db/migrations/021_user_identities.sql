@@ -0,0 +1,30 @@
+-- Migration: User identities
+-- Users can sign in with an account at an external provider (Google, GitHub or any OIDC
+-- issuer). Each link is keyed by the provider's stable subject ID, not the email, which
+-- providers may let users change. Sign-ins in progress keep their PKCE verifier and nonce
+-- in oidc_login_states, found by a SHA-256 hash of the state parameter; rows are deleted
+-- when the provider sends the user back.
+
+CREATE TABLE user_identities (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user ON user_identities(user_id);
+
+CREATE TABLE oidc_login_states (
+    state_hash TEXT PRIMARY KEY,
+    provider TEXT NOT NULL,
+    code_verifier TEXT NOT NULL,
+    nonce TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_oidc_login_states_expires ON oidc_login_states(expires_at);
@@ -0,0 +1,19 @@
+-- Migration: API keys
+-- Users create API keys to script the API. A key acts as its user but only on routes its
+-- scopes cover. Only a SHA-256 hash of the key is stored, along with its first characters
+-- so users can tell their keys apart. last_used_at is updated at most once a minute.
+
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_keys_user ON api_keys(user_id, created_at DESC) WHERE revoked_at IS NULL;
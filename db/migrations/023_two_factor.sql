@@ -0,0 +1,19 @@
+-- Migration: Two-factor authentication
+-- Users can require a TOTP code (RFC 6238) after their password. Enrolling stores the secret
+-- with totp_enabled_at still NULL until a code from it is verified. totp_last_step is the
+-- time step of the last code accepted, so a code can't be replayed. Recovery codes are
+-- single-use and stored as SHA-256 hashes; enabling 2FA or generating a new set replaces
+-- the old ones.
+
+ALTER TABLE users ADD COLUMN totp_secret TEXT;
+ALTER TABLE users ADD COLUMN totp_enabled_at TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN totp_last_step BIGINT;
+
+CREATE TABLE recovery_codes (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    code_hash TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    used_at TIMESTAMPTZ,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,23 @@
+-- Migration: Rate limits and login lockout
+-- rate_limit_buckets holds the token buckets of the Postgres rate limit store, shared by
+-- every API replica. A bucket is refilled lazily when a request takes from it, and can be
+-- deleted once full_at has passed, since it would be full again by then.
+-- failed_login_count counts failed passwords and two-factor codes in a row; from the
+-- configured threshold on, each failure locks the account until locked_until, for twice as
+-- long each time. The first lock emails an account_unlock link that resets both.
+
+CREATE TABLE rate_limit_buckets (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    full_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_rate_limit_buckets_full_at ON rate_limit_buckets(full_at);
+
+ALTER TABLE users ADD COLUMN failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN locked_until TIMESTAMPTZ;
+
+ALTER TABLE account_tokens DROP CONSTRAINT account_tokens_purpose_check;
+ALTER TABLE account_tokens ADD CONSTRAINT account_tokens_purpose_check
+    CHECK (purpose IN ('password_reset', 'email_verification', 'account_unlock'));
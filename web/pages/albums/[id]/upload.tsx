@@ -75,8 +75,6 @@ export default function UploadPhotosPage() {
           album_id: albumId,
           storage_key: `uploads/${albumId}/${uploadFile.file.name}`,
           filename: uploadFile.file.name,
-          mime_type: uploadFile.file.type,
-          size_bytes: uploadFile.file.size,
         }).unwrap()
 
         setFiles(prev => prev.map(f => 
@@ -0,0 +1,83 @@
+import { useEffect, useRef, useState } from 'react'
+import { useRouter } from 'next/router'
+import Head from 'next/head'
+import Link from 'next/link'
+import { useDispatch } from 'react-redux'
+import { MFA_TOKEN_KEY, OIDC_STATE_KEY, setCredentials } from '@/services/authSlice'
+import type { AppDispatch } from '@/services/store'
+
+export default function AuthCallback() {
+  const router = useRouter()
+  const dispatch = useDispatch<AppDispatch>()
+  const [error, setError] = useState('')
+  const started = useRef(false)
+
+  useEffect(() => {
+    if (!router.isReady || started.current) return
+    started.current = true
+
+    const { code, state, error: providerError } = router.query
+    const expectedState = sessionStorage.getItem(OIDC_STATE_KEY)
+    sessionStorage.removeItem(OIDC_STATE_KEY)
+
+    if (providerError) {
+      setError('Sign-in was cancelled at the provider')
+      return
+    }
+    // A state this browser didn't start means someone else's sign-in was forwarded here
+    if (typeof code !== 'string' || typeof state !== 'string' || state !== expectedState) {
+      setError('This sign-in link is invalid or was already used')
+      return
+    }
+
+    const finish = async () => {
+      try {
+        const response = await fetch(`${process.env.NEXT_PUBLIC_API_URL}/auth/oidc/callback`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify({ code, state }),
+        })
+        if (!response.ok) {
+          const body = await response.json().catch(() => ({}))
+          throw new Error(body.detail || 'Sign-in failed')
+        }
+
+        const data = await response.json()
+        // Users with two-factor authentication enter their code on the login page
+        if (data.mfa_required) {
+          sessionStorage.setItem(MFA_TOKEN_KEY, data.mfa_token)
+          router.replace('/auth/login')
+          return
+        }
+        dispatch(setCredentials(data))
+        router.replace('/dashboard')
+      } catch (err) {
+        setError(err instanceof Error ? err.message : 'Sign-in failed')
+      }
+    }
+    finish()
+  }, [router, dispatch])
+
+  return (
+    <>
+      <Head>
+        <title>Signing in - Redrawn</title>
+      </Head>
+
+      <div className="min-h-screen bg-gray-50 flex flex-col justify-center py-12 sm:px-6 lg:px-8">
+        <div className="sm:mx-auto sm:w-full sm:max-w-md text-center">
+          {error ? (
+            <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+              {error}.{' '}
+              <Link href="/auth/login" className="font-medium text-primary-600 hover:text-primary-500">
+                Back to sign in
+              </Link>
+            </div>
+          ) : (
+            <p className="text-gray-600">Signing you in...</p>
+          )}
+        </div>
+      </div>
+    </>
+  )
+}
@@ -1,9 +1,9 @@
-import { useState } from 'react'
+import { useEffect, useState } from 'react'
 import { useRouter } from 'next/router'
 import Head from 'next/head'
 import Link from 'next/link'
 import { useDispatch } from 'react-redux'
-import { setCredentials } from '@/services/authSlice'
+import { MFA_TOKEN_KEY, OIDC_STATE_KEY, setCredentials } from '@/services/authSlice'
 import type { AppDispatch } from '@/services/store'
 
 export default function Login() {
@@ -13,6 +13,45 @@ export default function Login() {
   const [password, setPassword] = useState('')
   const [error, setError] = useState('')
   const [loading, setLoading] = useState(false)
+  const [providers, setProviders] = useState<string[]>([])
+  // Set once the password (or provider) is accepted for a user with two-factor authentication
+  const [mfaToken, setMfaToken] = useState('')
+  const [code, setCode] = useState('')
+
+  useEffect(() => {
+    const pending = sessionStorage.getItem(MFA_TOKEN_KEY)
+    if (pending) {
+      sessionStorage.removeItem(MFA_TOKEN_KEY)
+      setMfaToken(pending)
+    }
+  }, [])
+
+  useEffect(() => {
+    fetch(`${process.env.NEXT_PUBLIC_API_URL}/auth/oidc/providers`)
+      .then((response) => (response.ok ? response.json() : { providers: [] }))
+      .then((data) => setProviders(data.providers ?? []))
+      .catch(() => setProviders([]))
+  }, [])
+
+  const signInWith = async (provider: string) => {
+    setError('')
+    try {
+      const response = await fetch(
+        `${process.env.NEXT_PUBLIC_API_URL}/auth/oidc/${encodeURIComponent(provider)}/authorize`,
+        { method: 'POST' }
+      )
+      if (!response.ok) {
+        throw new Error('Could not start sign-in')
+      }
+
+      const data = await response.json()
+      // The callback page only accepts the state this browser started with
+      sessionStorage.setItem(OIDC_STATE_KEY, data.state)
+      window.location.assign(data.authorization_url)
+    } catch (err) {
+      setError(`Could not sign in with ${provider}`)
+    }
+  }
 
   const handleSubmit = async (e: React.FormEvent) => {
     e.preventDefault()
@@ -31,8 +70,12 @@ export default function Login() {
       }
 
       const data = await response.json()
+      if (data.mfa_required) {
+        setMfaToken(data.mfa_token)
+        return
+      }
       // Store token in Redux
-      dispatch(setCredentials({ user: data.user, token: data.token }))
+      dispatch(setCredentials(data))
       router.push('/dashboard')
     } catch (err) {
       setError('Invalid email or password')
@@ -41,6 +84,37 @@ export default function Login() {
     }
   }
 
+  const handleCodeSubmit = async (e: React.FormEvent) => {
+    e.preventDefault()
+    setError('')
+    setLoading(true)
+
+    try {
+      const response = await fetch(`${process.env.NEXT_PUBLIC_API_URL}/auth/login/mfa`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ mfa_token: mfaToken, code }),
+      })
+
+      if (!response.ok) {
+        const body = await response.json().catch(() => ({}))
+        // An expired challenge means starting over with the password
+        if (body.detail !== 'invalid authentication code') {
+          setMfaToken('')
+        }
+        throw new Error(body.detail || 'Sign-in failed')
+      }
+
+      dispatch(setCredentials(await response.json()))
+      router.push('/dashboard')
+    } catch (err) {
+      setError(err instanceof Error ? err.message : 'Sign-in failed')
+    } finally {
+      setCode('')
+      setLoading(false)
+    }
+  }
+
   return (
     <>
       <Head>
@@ -56,57 +130,114 @@ export default function Login() {
 
         <div className="mt-8 sm:mx-auto sm:w-full sm:max-w-md">
           <div className="bg-white py-8 px-4 shadow sm:rounded-lg sm:px-10">
-            <form className="space-y-6" onSubmit={handleSubmit}>
-              {error && (
-                <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
-                  {error}
+            {mfaToken ? (
+              <form className="space-y-6" onSubmit={handleCodeSubmit}>
+                {error && (
+                  <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+                    {error}
+                  </div>
+                )}
+
+                <div>
+                  <label htmlFor="code" className="block text-sm font-medium text-gray-700">
+                    Authentication code
+                  </label>
+                  <p className="mt-1 text-sm text-gray-500">
+                    Enter the code from your authenticator app, or one of your recovery codes.
+                  </p>
+                  <div className="mt-1">
+                    <input
+                      id="code"
+                      name="code"
+                      type="text"
+                      autoComplete="one-time-code"
+                      autoFocus
+                      required
+                      value={code}
+                      onChange={(e) => setCode(e.target.value)}
+                      className="appearance-none block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm placeholder-gray-400 focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+                    />
+                  </div>
                 </div>
-              )}
-
-              <div>
-                <label htmlFor="email" className="block text-sm font-medium text-gray-700">
-                  Email address
-                </label>
-                <div className="mt-1">
-                  <input
-                    id="email"
-                    name="email"
-                    type="email"
-                    required
-                    value={email}
-                    onChange={(e) => setEmail(e.target.value)}
-                    className="appearance-none block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm placeholder-gray-400 focus:outline-none focus:ring-primary-500 focus:border-primary-500"
-                  />
+
+                <div>
+                  <button
+                    type="submit"
+                    disabled={loading}
+                    className="w-full flex justify-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50"
+                  >
+                    {loading ? 'Verifying...' : 'Verify'}
+                  </button>
                 </div>
-              </div>
+              </form>
+            ) : (
+              <form className="space-y-6" onSubmit={handleSubmit}>
+                {error && (
+                  <div className="bg-red-50 border border-red-200 text-red-700 px-4 py-3 rounded">
+                    {error}
+                  </div>
+                )}
 
-              <div>
-                <label htmlFor="password" className="block text-sm font-medium text-gray-700">
-                  Password
-                </label>
-                <div className="mt-1">
-                  <input
-                    id="password"
-                    name="password"
-                    type="password"
-                    required
-                    value={password}
-                    onChange={(e) => setPassword(e.target.value)}
-                    className="appearance-none block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm placeholder-gray-400 focus:outline-none focus:ring-primary-500 focus:border-primary-500"
-                  />
+                <div>
+                  <label htmlFor="email" className="block text-sm font-medium text-gray-700">
+                    Email address
+                  </label>
+                  <div className="mt-1">
+                    <input
+                      id="email"
+                      name="email"
+                      type="email"
+                      required
+                      value={email}
+                      onChange={(e) => setEmail(e.target.value)}
+                      className="appearance-none block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm placeholder-gray-400 focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+                    />
+                  </div>
                 </div>
-              </div>
 
-              <div>
-                <button
-                  type="submit"
-                  disabled={loading}
-                  className="w-full flex justify-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50"
-                >
-                  {loading ? 'Signing in...' : 'Sign in'}
-                </button>
+                <div>
+                  <label htmlFor="password" className="block text-sm font-medium text-gray-700">
+                    Password
+                  </label>
+                  <div className="mt-1">
+                    <input
+                      id="password"
+                      name="password"
+                      type="password"
+                      required
+                      value={password}
+                      onChange={(e) => setPassword(e.target.value)}
+                      className="appearance-none block w-full px-3 py-2 border border-gray-300 rounded-md shadow-sm placeholder-gray-400 focus:outline-none focus:ring-primary-500 focus:border-primary-500"
+                    />
+                  </div>
+                </div>
+
+                <div>
+                  <button
+                    type="submit"
+                    disabled={loading}
+                    className="w-full flex justify-center py-2 px-4 border border-transparent rounded-md shadow-sm text-sm font-medium text-white bg-primary-600 hover:bg-primary-700 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500 disabled:opacity-50"
+                  >
+                    {loading ? 'Signing in...' : 'Sign in'}
+                  </button>
+                </div>
+              </form>
+            )}
+
+            {!mfaToken && providers.length > 0 && (
+              <div className="mt-6 space-y-3">
+                {providers.map((provider) => (
+                  <button
+                    key={provider}
+                    type="button"
+                    onClick={() => signInWith(provider)}
+                    className="w-full flex justify-center py-2 px-4 border border-gray-300 rounded-md shadow-sm text-sm font-medium text-gray-700 bg-white hover:bg-gray-50 focus:outline-none focus:ring-2 focus:ring-offset-2 focus:ring-primary-500"
+                  >
+                    Continue with {provider.charAt(0).toUpperCase() + provider.slice(1)}
+                  </button>
+                ))}
               </div>
-            </form>
+            )}
 
             <div className="mt-6">
               <div className="relative">
@@ -33,7 +33,7 @@ export default function Register() {
 
       const data = await response.json()
       // Store token in Redux
-      dispatch(setCredentials({ user: data.user, token: data.token }))
+      dispatch(setCredentials(data))
       router.push('/dashboard')
     } catch (err) {
       setError('Registration failed. Please try again.')
@@ -78,8 +78,6 @@ export default function ApplyThemePage() {
         await createGeneratedPhoto({
           original_photo_id: photo.id,
           theme_id: selectedTheme,
-          storage_key: `generated/${photo.albumId}/${selectedTheme}/${photo.id}`,
-          credits_used: 1,
         }).unwrap()
       }
       
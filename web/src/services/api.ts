@@ -41,13 +41,14 @@ export interface Photo {
   group_id: string
   album_id: string
   user_id: string
-  storage_key: string
   filename?: string
   mime_type?: string
   size_bytes?: number
   width?: number
   height?: number
   status: 'uploaded' | 'processing' | 'ready' | 'error'
+  error_message?: string
+  variants?: Record<string, string>
   version: number
   created_at: string
   updated_at: string
@@ -57,16 +58,10 @@ export interface CreatePhotoRequest {
   album_id: string
   storage_key: string
   filename?: string
-  mime_type?: string
-  size_bytes?: number
-  width?: number
-  height?: number
 }
 
 export interface UpdatePhotoRequest {
   filename?: string
-  width?: number
-  height?: number
 }
 
 // Theme types
@@ -124,6 +119,7 @@ export interface GeneratedPhoto {
   status: 'queued' | 'processing' | 'completed' | 'error'
   credits_used: number
   error_message?: string
+  variants?: Record<string, string>
   created_at: string
   updated_at: string
 }
@@ -131,8 +127,6 @@ export interface GeneratedPhoto {
 export interface CreateGeneratedPhotoRequest {
   original_photo_id: string
   theme_id: string
-  storage_key: string
-  credits_used?: number
 }
 
 export interface ListGeneratedPhotosResponse {
@@ -141,6 +135,7 @@ export interface ListGeneratedPhotosResponse {
 
 // Storage types
 export interface GetUploadURLRequest {
+  album_id: string
   filename: string
   mime_type: string
   size: number
@@ -152,15 +147,6 @@ export interface GetUploadURLResponse {
   expires_at: number
 }
 
-export interface GetDownloadURLRequest {
-  storage_key: string
-}
-
-export interface GetDownloadURLResponse {
-  download_url: string
-  expires_at: number
-}
-
 // Extended API
 export const api = emptyApi.injectEndpoints({
   endpoints: (builder) => ({
@@ -299,18 +285,12 @@ export const api = emptyApi.injectEndpoints({
         body,
       }),
     }),
-    getDownloadURL: builder.mutation<GetDownloadURLResponse, GetDownloadURLRequest>({
-      query: (body) => ({
-        url: '/storage/download-url',
-        method: 'POST',
-        body,
-      }),
-    }),
     deleteFile: builder.mutation<{ status: string }, string>({
-      query: (storageKey) => ({
-        url: `/storage/${storageKey}`,
+      query: (photoId) => ({
+        url: `/storage/photos/${encodeURIComponent(photoId)}`,
         method: 'DELETE',
       }),
+      invalidatesTags: ['Photo'],
     }),
   }),
 })
@@ -344,6 +324,5 @@ export const {
   useCreateGeneratedPhotoMutation,
   // Storage hooks
   useGetUploadURLMutation,
-  useGetDownloadURLMutation,
   useDeleteFileMutation,
 } = api
@@ -10,12 +10,29 @@ interface User {
 interface AuthState {
   user: User | null
   token: string | null
+  refreshToken: string | null
+  expiresAt: string | null // when the access token expires
   isAuthenticated: boolean
 }
 
+// Tokens issued by login, registration and refresh
+export interface Tokens {
+  token: string
+  refresh_token: string
+  expires_at: string
+}
+
+// sessionStorage key of the state of a sign-in in progress at an identity provider
+export const OIDC_STATE_KEY = 'oidc_state'
+
+// sessionStorage key of the MFA token of a provider sign-in waiting for a two-factor code
+export const MFA_TOKEN_KEY = 'mfa_token'
+
 const initialState: AuthState = {
   user: null,
   token: null,
+  refreshToken: null,
+  expiresAt: null,
   isAuthenticated: false,
 }
 
@@ -25,19 +42,28 @@ const authSlice = createSlice({
   reducers: {
     setCredentials: (
       state,
-      action: PayloadAction<{ user: User; token: string }>
+      action: PayloadAction<{ user: User } & Tokens>
     ) => {
       state.user = action.payload.user
       state.token = action.payload.token
+      state.refreshToken = action.payload.refresh_token
+      state.expiresAt = action.payload.expires_at
       state.isAuthenticated = true
     },
+    tokensRefreshed: (state, action: PayloadAction<Tokens>) => {
+      state.token = action.payload.token
+      state.refreshToken = action.payload.refresh_token
+      state.expiresAt = action.payload.expires_at
+    },
     logout: (state) => {
       state.user = null
       state.token = null
+      state.refreshToken = null
+      state.expiresAt = null
       state.isAuthenticated = false
     },
   },
 })
 
-export const { setCredentials, logout } = authSlice.actions
+export const { setCredentials, tokensRefreshed, logout } = authSlice.actions
 export default authSlice.reducer
@@ -1,18 +1,54 @@
 import { createApi, fetchBaseQuery } from '@reduxjs/toolkit/query/react'
+import type { BaseQueryFn, FetchArgs, FetchBaseQueryError } from '@reduxjs/toolkit/query'
+import { logout, tokensRefreshed, type Tokens } from './authSlice'
+
+const rawBaseQuery = fetchBaseQuery({
+  baseUrl: process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080',
+  prepareHeaders: (headers, { getState }) => {
+    // Add auth token if available
+    const token = (getState() as any).auth?.token
+    if (token) {
+      headers.set('authorization', `Bearer ${token}`)
+    }
+    return headers
+  },
+})
+
+// Refresh tokens are single-use, so concurrent requests share one refresh
+let refreshing: Promise<unknown> | null = null
+
+// Access tokens are short-lived: renew one that is about to expire before sending the request
+const baseQuery: BaseQueryFn<string | FetchArgs, unknown, FetchBaseQueryError> = async (
+  args,
+  api,
+  extraOptions
+) => {
+  const auth = (api.getState() as any).auth
+  if (auth?.refreshToken && auth.expiresAt && Date.parse(auth.expiresAt) - Date.now() < 30_000) {
+    refreshing ??= rawBaseQuery(
+      { url: '/auth/refresh', method: 'POST', body: { refresh_token: auth.refreshToken } },
+      api,
+      extraOptions
+    )
+      .then((result) => {
+        if (result.data) {
+          api.dispatch(tokensRefreshed(result.data as Tokens))
+        } else if (result.error?.status === 401) {
+          // The session was revoked or has expired
+          api.dispatch(logout())
+        }
+      })
+      .finally(() => {
+        refreshing = null
+      })
+    await refreshing
+  }
+  return rawBaseQuery(args, api, extraOptions)
+}
 
 // Base API configuration
 export const emptyApi = createApi({
-  baseQuery: fetchBaseQuery({
-    baseUrl: process.env.NEXT_PUBLIC_API_URL || 'http://localhost:8080',
-    prepareHeaders: (headers, { getState }) => {
-      // Add auth token if available
-      const token = (getState() as any).auth?.token
-      if (token) {
-        headers.set('authorization', `Bearer ${token}`)
-      }
-      return headers
-    },
-  }),
+  baseQuery,
   endpoints: () => ({}),
   tagTypes: ['User', 'Album', 'Photo', 'Theme', 'Credit', 'GeneratedPhoto'],
 })